@@ -0,0 +1,539 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+
+	"github.com/tvolodi/ai-bpms-backend/shared/common/models"
+	"github.com/tvolodi/ai-bpms-backend/shared/engine"
+	"github.com/tvolodi/ai-bpms-backend/shared/storage"
+)
+
+// errFileTooLarge is returned while streaming an upload once it passes the size limit
+var errFileTooLarge = errors.New("file is too large")
+
+// limitedReader fails with errFileTooLarge when more than max bytes are read
+type limitedReader struct {
+	r   io.Reader
+	n   int64
+	max int64
+}
+
+func (l *limitedReader) Read(p []byte) (int, error) {
+	n, err := l.r.Read(p)
+	l.n += int64(n)
+	if l.n > l.max {
+		return n, errFileTooLarge
+	}
+	return n, err
+}
+
+// contentType returns the type of an upload sniffed from its first bytes. Office
+// documents are zip containers, so for those the declared type is kept.
+func contentType(head []byte, declared string) string {
+	sniffed, _, _ := mime.ParseMediaType(http.DetectContentType(head))
+	declared, _, _ = mime.ParseMediaType(declared)
+	if sniffed == "application/zip" &&
+		(strings.HasPrefix(declared, "application/vnd.openxmlformats-officedocument.") ||
+			strings.HasPrefix(declared, "application/vnd.oasis.opendocument.")) {
+		return declared
+	}
+	return sniffed
+}
+
+// defaultMaxSize limits uploads when no size limit is configured
+const defaultMaxSize = 25 << 20
+
+// maxSize returns the largest accepted upload in bytes
+func (h *handler) maxSize() int64 {
+	if h.storageCfg.MaxSize > 0 {
+		return h.storageCfg.MaxSize
+	}
+	return defaultMaxSize
+}
+
+// allowedType reports whether uploads of a content type are accepted
+func (h *handler) allowedType(contentType string) bool {
+	if len(h.storageCfg.AllowedTypes) == 0 {
+		return true
+	}
+	for _, allowed := range h.storageCfg.AllowedTypes {
+		if strings.EqualFold(allowed, contentType) {
+			return true
+		}
+	}
+	return false
+}
+
+// canAccessInstance reports whether a user takes part in a process instance:
+// they started it, or one of its tasks is assigned to, owned by, completed by
+// or offered to them
+func (h *handler) canAccessInstance(userID, instanceID uuid.UUID) (bool, error) {
+	groups, err := userGroups(h.db, userID)
+	if err != nil {
+		return false, err
+	}
+	tasks := h.db.Model(&models.TaskInstance{}).
+		Select("1").
+		Where("task_instances.process_instance_id = process_instances.id").
+		Where(h.db.Where("task_instances.assignee_id = ? OR task_instances.owner_id = ? OR task_instances.completed_by = ?", userID, userID, userID).
+			Or(candidateOf(h.db, groups)))
+
+	var count int64
+	err = h.db.Model(&models.ProcessInstance{}).
+		Where("id = ?", instanceID).
+		Where(h.db.Where("started_by = ?", userID).Or("EXISTS (?)", tasks)).
+		Count(&count).Error
+	return count > 0, err
+}
+
+// requireInstanceAccess checks that the caller takes part in a process
+// instance, writing an error response when they do not
+func (h *handler) requireInstanceAccess(c *gin.Context, instanceID uuid.UUID) (uuid.UUID, bool) {
+	userID, ok := requireUser(c)
+	if !ok {
+		return uuid.Nil, false
+	}
+	allowed, err := h.canAccessInstance(userID, instanceID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return uuid.Nil, false
+	}
+	if !allowed {
+		c.JSON(http.StatusForbidden, gin.H{"error": "You do not take part in this process instance"})
+		return uuid.Nil, false
+	}
+	return userID, true
+}
+
+// upload streams the "file" part of a multipart request into the attachment
+// storage, checking its size and type and computing its checksum on the way
+func (h *handler) upload(c *gin.Context, instanceID uuid.UUID, taskID *uuid.UUID) {
+	userID, ok := h.requireInstanceAccess(c, instanceID)
+	if !ok {
+		return
+	}
+
+	// The body also carries the multipart framing, so allow some room for it
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, h.maxSize()+1<<20)
+	reader, err := c.Request.MultipartReader()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Expected a multipart/form-data upload"})
+		return
+	}
+	var part io.Reader
+	var fileName, declared string
+	for part == nil {
+		p, err := reader.NextPart()
+		if errors.Is(err, io.EOF) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "The upload has no file part"})
+			return
+		}
+		if err != nil {
+			respondUploadError(c, err)
+			return
+		}
+		if p.FormName() == "file" {
+			part = p
+			fileName = filepath.Base(strings.ReplaceAll(p.FileName(), `\`, "/"))
+			declared = p.Header.Get("Content-Type")
+		}
+	}
+	if fileName == "" || fileName == "." || fileName == "/" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "The file has no name"})
+		return
+	}
+
+	head := make([]byte, 512)
+	n, err := io.ReadFull(part, head)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, io.EOF) {
+		respondUploadError(c, err)
+		return
+	}
+	head = head[:n]
+	kind := contentType(head, declared)
+	if !h.allowedType(kind) {
+		c.JSON(http.StatusUnsupportedMediaType, gin.H{"error": fmt.Sprintf("Files of type %s are not accepted", kind)})
+		return
+	}
+
+	hash := sha256.New()
+	counter := &limitedReader{r: io.MultiReader(bytes.NewReader(head), part), max: h.maxSize()}
+	attachment := models.Attachment{
+		ProcessInstanceID: instanceID,
+		TaskInstanceID:    taskID,
+		FileName:          fileName,
+		ContentType:       kind,
+		UploadedBy:        &userID,
+	}
+	attachment.ID = uuid.New()
+	attachment.StorageKey = fmt.Sprintf("attachments/%s/%s", instanceID, attachment.ID)
+
+	ctx := c.Request.Context()
+	if err := h.storage.Put(ctx, attachment.StorageKey, io.TeeReader(counter, hash), -1, kind); err != nil {
+		respondUploadError(c, err)
+		return
+	}
+	attachment.Size = counter.n
+	attachment.Checksum = hex.EncodeToString(hash.Sum(nil))
+	if err := h.db.Create(&attachment).Error; err != nil {
+		_ = h.storage.Delete(ctx, attachment.StorageKey)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusCreated, attachment)
+}
+
+// respondUploadError maps an error reading or storing an upload to an HTTP response
+func respondUploadError(c *gin.Context, err error) {
+	var maxBytes *http.MaxBytesError
+	switch {
+	case errors.Is(err, errFileTooLarge), errors.As(err, &maxBytes):
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "The file exceeds the size limit"})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	}
+}
+
+// Upload instance attachment
+// @Summary Upload instance attachment
+// @Description Attach a file to a process instance. The file is sent as the "file" part of a multipart/form-data request and streamed to storage.
+// @Tags instances
+// @Accept multipart/form-data
+// @Produce json
+// @Param id path string true "Process instance ID"
+// @Param file formData file true "File"
+// @Success 201 {object} models.Attachment
+// @Failure 403 {object} map[string]interface{}
+// @Failure 413 {object} map[string]interface{}
+// @Failure 415 {object} map[string]interface{}
+// @Router /instances/{id}/attachments [post]
+func (h *handler) uploadInstanceAttachment(c *gin.Context) {
+	id, ok := parseID(c, "id")
+	if !ok {
+		return
+	}
+
+	var inst models.ProcessInstance
+	if err := h.db.Select("id").First(&inst, "id = ?", id).Error; err != nil {
+		respondDBError(c, err)
+		return
+	}
+
+	h.upload(c, inst.ID, nil)
+}
+
+// Upload task attachment
+// @Summary Upload task attachment
+// @Description Attach a file to an open task, for example to reference it from a file field of the task's form
+// @Tags tasks
+// @Accept multipart/form-data
+// @Produce json
+// @Param id path string true "Task ID"
+// @Param file formData file true "File"
+// @Success 201 {object} models.Attachment
+// @Failure 403 {object} map[string]interface{}
+// @Failure 409 {object} map[string]interface{}
+// @Failure 413 {object} map[string]interface{}
+// @Failure 415 {object} map[string]interface{}
+// @Router /tasks/{id}/attachments [post]
+func (h *handler) uploadTaskAttachment(c *gin.Context) {
+	id, ok := parseID(c, "id")
+	if !ok {
+		return
+	}
+
+	var task models.TaskInstance
+	if err := h.db.Select("id", "process_instance_id", "status").First(&task, "id = ?", id).Error; err != nil {
+		respondDBError(c, err)
+		return
+	}
+	if task.Status != engine.TaskCreated && task.Status != engine.TaskAssigned {
+		respondEngineError(c, engine.ErrTaskNotActive)
+		return
+	}
+
+	h.upload(c, task.ProcessInstanceID, &task.ID)
+}
+
+// List instance attachments
+// @Summary List instance attachments
+// @Description List the files attached to a process instance and its tasks
+// @Tags instances
+// @Produce json
+// @Param id path string true "Process instance ID"
+// @Success 200 {object} map[string]interface{}
+// @Failure 403 {object} map[string]interface{}
+// @Router /instances/{id}/attachments [get]
+func (h *handler) listInstanceAttachments(c *gin.Context) {
+	id, ok := parseID(c, "id")
+	if !ok {
+		return
+	}
+	if _, ok := h.requireInstanceAccess(c, id); !ok {
+		return
+	}
+
+	var attachments []models.Attachment
+	if err := h.db.Where("process_instance_id = ?", id).Order("created_at").Find(&attachments).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": attachments, "total": len(attachments)})
+}
+
+// List task attachments
+// @Summary List task attachments
+// @Description List the files attached to a task
+// @Tags tasks
+// @Produce json
+// @Param id path string true "Task ID"
+// @Success 200 {object} map[string]interface{}
+// @Failure 403 {object} map[string]interface{}
+// @Router /tasks/{id}/attachments [get]
+func (h *handler) listTaskAttachments(c *gin.Context) {
+	id, ok := parseID(c, "id")
+	if !ok {
+		return
+	}
+
+	var task models.TaskInstance
+	if err := h.db.Select("id", "process_instance_id").First(&task, "id = ?", id).Error; err != nil {
+		respondDBError(c, err)
+		return
+	}
+	if _, ok := h.requireInstanceAccess(c, task.ProcessInstanceID); !ok {
+		return
+	}
+
+	var attachments []models.Attachment
+	if err := h.db.Where("task_instance_id = ?", id).Order("created_at").Find(&attachments).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": attachments, "total": len(attachments)})
+}
+
+// accessibleAttachment loads an attachment the caller may read, writing an
+// error response when it does not exist or they do not take part in its instance
+func (h *handler) accessibleAttachment(c *gin.Context) (*models.Attachment, bool) {
+	id, ok := parseID(c, "id")
+	if !ok {
+		return nil, false
+	}
+
+	var attachment models.Attachment
+	if err := h.db.First(&attachment, "id = ?", id).Error; err != nil {
+		respondDBError(c, err)
+		return nil, false
+	}
+	if _, ok := h.requireInstanceAccess(c, attachment.ProcessInstanceID); !ok {
+		return nil, false
+	}
+	return &attachment, true
+}
+
+// Get attachment
+// @Summary Get attachment
+// @Description Get the metadata of an attachment, including its SHA-256 checksum
+// @Tags attachments
+// @Produce json
+// @Param id path string true "Attachment ID"
+// @Success 200 {object} models.Attachment
+// @Failure 403 {object} map[string]interface{}
+// @Failure 404 {object} map[string]interface{}
+// @Router /attachments/{id} [get]
+func (h *handler) getAttachment(c *gin.Context) {
+	attachment, ok := h.accessibleAttachment(c)
+	if !ok {
+		return
+	}
+
+	c.JSON(http.StatusOK, attachment)
+}
+
+// Get attachment download URL
+// @Summary Get attachment download URL
+// @Description Get a short-lived URL the attachment can be downloaded from without further authentication
+// @Tags attachments
+// @Produce json
+// @Param id path string true "Attachment ID"
+// @Success 200 {object} map[string]interface{}
+// @Failure 403 {object} map[string]interface{}
+// @Failure 404 {object} map[string]interface{}
+// @Router /attachments/{id}/url [get]
+func (h *handler) getAttachmentURL(c *gin.Context) {
+	attachment, ok := h.accessibleAttachment(c)
+	if !ok {
+		return
+	}
+
+	expiry := h.storageCfg.URLExpiry
+	if expiry <= 0 {
+		expiry = 5 * time.Minute
+	}
+	expiresAt := time.Now().Add(expiry).UTC().Truncate(time.Second)
+
+	var url string
+	if presigner, ok := h.storage.(storage.Presigner); ok {
+		presigned, err := presigner.PresignGet(attachment.StorageKey, attachment.FileName, expiry)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		url = presigned
+	} else {
+		expires := strconv.FormatInt(expiresAt.Unix(), 10)
+		url = fmt.Sprintf("/api/v1/attachments/%s/content?expires=%s&signature=%s",
+			attachment.ID, expires, h.downloadSignature(attachment.ID, expires))
+	}
+
+	c.JSON(http.StatusOK, gin.H{"url": url, "expires_at": expiresAt})
+}
+
+// downloadSignature signs the download URL of an attachment valid until expires
+func (h *handler) downloadSignature(id uuid.UUID, expires string) string {
+	mac := hmac.New(sha256.New, []byte(h.storageCfg.URLSecret))
+	mac.Write([]byte(id.String() + ":" + expires))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Download attachment
+// @Summary Download attachment
+// @Description Stream the content of an attachment. The request must either be made by a participant of its process instance or carry the signature of a download URL.
+// @Tags attachments
+// @Produce octet-stream
+// @Param id path string true "Attachment ID"
+// @Param expires query string false "Expiry of a signed download URL"
+// @Param signature query string false "Signature of a signed download URL"
+// @Success 200 {file} file
+// @Failure 403 {object} map[string]interface{}
+// @Failure 404 {object} map[string]interface{}
+// @Router /attachments/{id}/content [get]
+func (h *handler) downloadAttachment(c *gin.Context) {
+	var attachment *models.Attachment
+	if signature := c.Query("signature"); signature != "" {
+		id, ok := parseID(c, "id")
+		if !ok {
+			return
+		}
+		expires := c.Query("expires")
+		unix, err := strconv.ParseInt(expires, 10, 64)
+		valid := err == nil && time.Now().Unix() <= unix &&
+			hmac.Equal([]byte(signature), []byte(h.downloadSignature(id, expires)))
+		if !valid {
+			c.JSON(http.StatusForbidden, gin.H{"error": "The download URL is invalid or has expired"})
+			return
+		}
+		attachment = &models.Attachment{}
+		if err := h.db.First(attachment, "id = ?", id).Error; err != nil {
+			respondDBError(c, err)
+			return
+		}
+	} else {
+		var ok bool
+		if attachment, ok = h.accessibleAttachment(c); !ok {
+			return
+		}
+	}
+
+	content, err := h.storage.Get(c.Request.Context(), attachment.StorageKey)
+	if errors.Is(err, storage.ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Attachment content not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	defer content.Close()
+
+	c.DataFromReader(http.StatusOK, attachment.Size, attachment.ContentType, content, map[string]string{
+		"Content-Disposition": mime.FormatMediaType("attachment", map[string]string{"filename": attachment.FileName}),
+		"X-Checksum-SHA256":   attachment.Checksum,
+		"Cache-Control":       "private, no-store",
+	})
+}
+
+// Delete attachment
+// @Summary Delete attachment
+// @Description Delete an attachment and its content. Only the uploader may delete it, and not once a completed task's form refers to it.
+// @Tags attachments
+// @Param id path string true "Attachment ID"
+// @Success 204
+// @Failure 403 {object} map[string]interface{}
+// @Failure 404 {object} map[string]interface{}
+// @Failure 409 {object} map[string]interface{}
+// @Router /attachments/{id} [delete]
+func (h *handler) deleteAttachment(c *gin.Context) {
+	userID, ok := requireUser(c)
+	if !ok {
+		return
+	}
+	id, ok := parseID(c, "id")
+	if !ok {
+		return
+	}
+
+	var attachment models.Attachment
+	if err := h.db.First(&attachment, "id = ?", id).Error; err != nil {
+		respondDBError(c, err)
+		return
+	}
+	if attachment.UploadedBy == nil || *attachment.UploadedBy != userID {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Only the uploader can delete an attachment"})
+		return
+	}
+	if attachment.FormField != "" && attachment.TaskInstanceID != nil {
+		var task models.TaskInstance
+		err := h.db.Select("status").First(&task, "id = ?", *attachment.TaskInstanceID).Error
+		if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		if task.Status == engine.TaskCompleted {
+			c.JSON(http.StatusConflict, gin.H{"error": "The attachment is part of a completed task's form"})
+			return
+		}
+	}
+
+	err := h.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Delete(&models.Attachment{}, "id = ?", attachment.ID).Error; err != nil {
+			return err
+		}
+		return auditLog(c, tx, "delete", "attachment", attachment.ID, gin.H{
+			"process_instance_id": attachment.ProcessInstanceID,
+			"task_instance_id":    attachment.TaskInstanceID,
+			"file_name":           attachment.FileName,
+			"checksum":            attachment.Checksum,
+		})
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if err := h.storage.Delete(c.Request.Context(), attachment.StorageKey); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+
+	"github.com/tvolodi/ai-bpms-backend/shared/engine"
+)
+
+// maxBulkTasks is the most tasks one bulk operation may cover
+const maxBulkTasks = 500
+
+// Bulk task operations
+const (
+	bulkClaim       = "claim"
+	bulkAssign      = "assign"
+	bulkComplete    = "complete"
+	bulkSetPriority = "set_priority"
+)
+
+// leadRoles are the roles allowed to reassign tasks, one at a time or in bulk,
+// and to select the tasks of every user with scope any
+var leadRoles = []string{"admin", "manager"}
+
+// bulkTaskRequest applies one operation to a list of tasks or to the tasks
+// matching a filter
+type bulkTaskRequest struct {
+	Operation string                 `json:"operation" binding:"required"`
+	TaskIDs   []uuid.UUID            `json:"task_ids"`
+	Filter    *taskFilter            `json:"filter"`
+	UserID    *uuid.UUID             `json:"user_id"`   // assign; null returns the tasks to their groups
+	Priority  *int                   `json:"priority"`  // set_priority
+	FormData  map[string]interface{} `json:"form_data"` // complete; submitted for every task
+}
+
+// bulkTaskResult is the outcome of a bulk operation for one task
+type bulkTaskResult struct {
+	TaskID  uuid.UUID   `json:"task_id"`
+	Success bool        `json:"success"`
+	Error   string      `json:"error,omitempty"`
+	Code    string      `json:"code,omitempty"`
+	Details interface{} `json:"details,omitempty"`
+}
+
+// Bulk task operation
+// @Summary Bulk task operation
+// @Description Claim, assign, complete with the same form data or set the priority of up to 500 tasks, given by ID or selected by a filter with the fields of the task inbox. Assigning and scope any, which selects tasks of every user, are reserved to the admin and manager roles. Each task is updated in its own transaction and the result is reported per task. The operation is recorded as one audit log entry.
+// @Tags tasks
+// @Accept json
+// @Produce json
+// @Success 200 {object} map[string]interface{}
+// @Failure 400 {object} map[string]interface{}
+// @Failure 403 {object} map[string]interface{}
+// @Failure 422 {object} map[string]interface{}
+// @Router /tasks/bulk [post]
+func (h *handler) bulkTasks(c *gin.Context) {
+	userID, ok := requireUser(c)
+	if !ok {
+		return
+	}
+
+	var req bulkTaskRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	switch req.Operation {
+	case bulkClaim, bulkComplete:
+	case bulkAssign:
+		if req.UserID != nil && !h.activeUser(c, *req.UserID) {
+			return
+		}
+	case bulkSetPriority:
+		if req.Priority == nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "priority is required"})
+			return
+		}
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid operation"})
+		return
+	}
+	if (len(req.TaskIDs) > 0) == (req.Filter != nil) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Either task_ids or filter is required"})
+		return
+	}
+
+	if req.Operation == bulkAssign || (req.Filter != nil && req.Filter.Scope == "any") {
+		lead, err := hasRole(h.db, userID, leadRoles...)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		if !lead {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Only team leads can assign tasks in bulk or select the tasks of every user"})
+			return
+		}
+	}
+
+	groups, err := userGroups(h.db, userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	taskIDs := uniqueIDs(req.TaskIDs)
+	if req.Filter != nil {
+		q, err := req.Filter.apply(h.db, userID, groups)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		err = q.Order("task_instances.created_at, task_instances.id").
+			Limit(maxBulkTasks+1).
+			Pluck("task_instances.id", &taskIDs).Error
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	if len(taskIDs) > maxBulkTasks {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": fmt.Sprintf("A bulk operation covers at most %d tasks", maxBulkTasks)})
+		return
+	}
+
+	ctx := c.Request.Context()
+	results := make([]bulkTaskResult, 0, len(taskIDs))
+	failed := 0
+	for _, id := range taskIDs {
+		var err error
+		switch req.Operation {
+		case bulkClaim:
+			_, err = h.engine.ClaimTask(ctx, id, userID, groups)
+		case bulkAssign:
+			_, err = h.engine.ReassignTask(ctx, id, req.UserID, &userID)
+		case bulkComplete:
+			_, err = h.engine.CompleteTask(ctx, id, engine.CompleteOptions{
+				FormData:    copyFormData(req.FormData),
+				CompletedBy: &userID,
+				Groups:      groups,
+			})
+		case bulkSetPriority:
+			_, err = h.engine.SetTaskPriority(ctx, id, *req.Priority, &userID)
+		}
+
+		result := bulkTaskResult{TaskID: id, Success: err == nil}
+		if err != nil {
+			failed++
+			_, body := engineErrorResponse(err)
+			result.Error, _ = body["error"].(string)
+			result.Code, _ = body["code"].(string)
+			result.Details = body["details"]
+		}
+		results = append(results, result)
+	}
+
+	entry, err := auditEntry(c, "bulk-"+req.Operation, "task", nil, gin.H{
+		"task_ids": req.TaskIDs,
+		"filter":   req.Filter,
+		"user_id":  req.UserID,
+		"priority": req.Priority,
+		"items":    results,
+	})
+	if err == nil {
+		if failed > 0 {
+			entry.Success = false
+			entry.ErrorMessage = fmt.Sprintf("%d of %d tasks failed", failed, len(results))
+		}
+		err = h.db.Omit("User").Create(&entry).Error
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"operation": req.Operation,
+		"total":     len(results),
+		"succeeded": len(results) - failed,
+		"failed":    failed,
+		"results":   results,
+	})
+}
+
+// uniqueIDs returns the IDs without repetitions, in their original order
+func uniqueIDs(ids []uuid.UUID) []uuid.UUID {
+	seen := make(map[uuid.UUID]bool, len(ids))
+	unique := make([]uuid.UUID, 0, len(ids))
+	for _, id := range ids {
+		if !seen[id] {
+			seen[id] = true
+			unique = append(unique, id)
+		}
+	}
+	return unique
+}
+
+// copyFormData returns a shallow copy of submitted form data, so that one task
+// of a bulk completion does not see changes made while completing another
+func copyFormData(data map[string]interface{}) map[string]interface{} {
+	if data == nil {
+		return nil
+	}
+	copied := make(map[string]interface{}, len(data))
+	for k, v := range data {
+		copied[k] = v
+	}
+	return copied
+}
@@ -0,0 +1,253 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+
+	"github.com/tvolodi/ai-bpms-backend/shared/calendar"
+	"github.com/tvolodi/ai-bpms-backend/shared/common/middleware"
+	"github.com/tvolodi/ai-bpms-backend/shared/common/models"
+	"github.com/tvolodi/ai-bpms-backend/shared/timer"
+)
+
+// calendarRequest is the payload accepted when creating or replacing a business calendar
+type calendarRequest struct {
+	Name                  string                       `json:"name" binding:"required"`
+	Description           string                       `json:"description"`
+	Region                string                       `json:"region"`
+	TimeZone              string                       `json:"time_zone"`
+	WorkingHours          map[string][]calendar.Period `json:"working_hours" binding:"required"`
+	Holidays              []calendar.Holiday           `json:"holidays"`
+	ProcessDefinitionKeys []string                     `json:"process_definition_keys"`
+	Departments           []string                     `json:"departments"`
+	IsDefault             bool                         `json:"is_default"`
+}
+
+// apply copies the request onto a calendar and checks that it parses
+func (req *calendarRequest) apply(cal *models.BusinessCalendar) error {
+	hours, err := json.Marshal(req.WorkingHours)
+	if err != nil {
+		return err
+	}
+	holidays := []byte("[]")
+	if req.Holidays != nil {
+		if holidays, err = json.Marshal(req.Holidays); err != nil {
+			return err
+		}
+	}
+	if req.TimeZone == "" {
+		req.TimeZone = "UTC"
+	}
+	if _, err := calendar.Parse(req.TimeZone, string(hours), string(holidays)); err != nil {
+		return err
+	}
+
+	cal.Name = req.Name
+	cal.Description = req.Description
+	cal.Region = req.Region
+	cal.TimeZone = req.TimeZone
+	cal.WorkingHours = string(hours)
+	cal.Holidays = string(holidays)
+	cal.ProcessDefinitionKeys = req.ProcessDefinitionKeys
+	cal.Departments = req.Departments
+	cal.IsDefault = req.IsDefault
+	return nil
+}
+
+// saveCalendar stores a calendar; a new default calendar replaces the previous one
+func (h *handler) saveCalendar(cal *models.BusinessCalendar) error {
+	return h.db.Transaction(func(tx *gorm.DB) error {
+		if cal.IsDefault {
+			err := tx.Model(&models.BusinessCalendar{}).
+				Where("is_default = ? AND id <> ?", true, cal.ID).
+				Update("is_default", false).Error
+			if err != nil {
+				return err
+			}
+		}
+		return tx.Save(cal).Error
+	})
+}
+
+// List business calendars
+// @Summary List business calendars
+// @Description List the business calendars with their working hours, holidays and assignments
+// @Tags admin
+// @Produce json
+// @Param region query string false "Region"
+// @Success 200 {object} map[string]interface{}
+// @Router /admin/calendars [get]
+func (h *handler) listCalendars(c *gin.Context) {
+	q := h.db.Model(&models.BusinessCalendar{})
+	if region := c.Query("region"); region != "" {
+		q = q.Where("region = ?", region)
+	}
+
+	var calendars []models.BusinessCalendar
+	if err := q.Order("name").Find(&calendars).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": calendars, "total": len(calendars)})
+}
+
+// Create business calendar
+// @Summary Create business calendar
+// @Description Define the working hours and holidays of a region and the process definitions and departments they apply to
+// @Tags admin
+// @Accept json
+// @Produce json
+// @Success 201 {object} models.BusinessCalendar
+// @Failure 409 {object} map[string]interface{}
+// @Failure 422 {object} map[string]interface{}
+// @Router /admin/calendars [post]
+func (h *handler) createCalendar(c *gin.Context) {
+	var req calendarRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	cal := models.BusinessCalendar{CreatedBy: middleware.CurrentUserID(c)}
+	if err := req.apply(&cal); err != nil {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+		return
+	}
+	if err := h.saveCalendar(&cal); err != nil {
+		respondCalendarError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, cal)
+}
+
+// Update business calendar
+// @Summary Update business calendar
+// @Description Replace a business calendar. Due dates already computed keep their value.
+// @Tags admin
+// @Accept json
+// @Produce json
+// @Param id path string true "Calendar ID"
+// @Success 200 {object} models.BusinessCalendar
+// @Failure 404 {object} map[string]interface{}
+// @Failure 422 {object} map[string]interface{}
+// @Router /admin/calendars/{id} [put]
+func (h *handler) updateCalendar(c *gin.Context) {
+	id, ok := parseID(c, "id")
+	if !ok {
+		return
+	}
+
+	var req calendarRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var cal models.BusinessCalendar
+	if err := h.db.First(&cal, "id = ?", id).Error; err != nil {
+		respondDBError(c, err)
+		return
+	}
+	if err := req.apply(&cal); err != nil {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+		return
+	}
+	cal.UpdatedBy = middleware.CurrentUserID(c)
+	if err := h.saveCalendar(&cal); err != nil {
+		respondCalendarError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, cal)
+}
+
+// Delete business calendar
+// @Summary Delete business calendar
+// @Description Delete a business calendar. Timers and tasks that refer to it by name raise an incident until it is replaced.
+// @Tags admin
+// @Param id path string true "Calendar ID"
+// @Success 204
+// @Failure 404 {object} map[string]interface{}
+// @Router /admin/calendars/{id} [delete]
+func (h *handler) deleteCalendar(c *gin.Context) {
+	id, ok := parseID(c, "id")
+	if !ok {
+		return
+	}
+
+	result := h.db.Delete(&models.BusinessCalendar{}, "id = ?", id)
+	if result.Error != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": result.Error.Error()})
+		return
+	}
+	if result.RowsAffected == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Business calendar not found"})
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// Compute due date
+// @Summary Compute due date
+// @Description Compute the point in time an ISO 8601 duration of business time after a start, in a business calendar
+// @Tags admin
+// @Produce json
+// @Param id path string true "Calendar ID"
+// @Param duration query string true "ISO 8601 duration, e.g. P3D or PT8H"
+// @Param from query string false "Start (RFC3339), defaults to now"
+// @Success 200 {object} map[string]interface{}
+// @Failure 400 {object} map[string]interface{}
+// @Router /admin/calendars/{id}/due-date [get]
+func (h *handler) computeDueDate(c *gin.Context) {
+	id, ok := parseID(c, "id")
+	if !ok {
+		return
+	}
+
+	duration, err := timer.ParseDuration(c.Query("duration"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	from := time.Now().UTC()
+	if raw := c.Query("from"); raw != "" {
+		if from, err = time.Parse(time.RFC3339, raw); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid from"})
+			return
+		}
+	}
+
+	var cal models.BusinessCalendar
+	if err := h.db.First(&cal, "id = ?", id).Error; err != nil {
+		respondDBError(c, err)
+		return
+	}
+	parsed, err := calendar.Parse(cal.TimeZone, cal.WorkingHours, cal.Holidays)
+	if err != nil {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"from":     from,
+		"duration": c.Query("duration"),
+		"due_date": parsed.Add(from, duration),
+	})
+}
+
+// respondCalendarError maps an error storing a business calendar to an HTTP response
+func respondCalendarError(c *gin.Context, err error) {
+	if errors.Is(err, gorm.ErrDuplicatedKey) {
+		c.JSON(http.StatusConflict, gin.H{"error": "A business calendar with this name already exists"})
+		return
+	}
+	c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+}
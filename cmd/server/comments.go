@@ -0,0 +1,523 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+
+	"github.com/tvolodi/ai-bpms-backend/shared/common/models"
+)
+
+// mentionPattern matches an @mention of a user by email address, as in "@jane.doe@example.com"
+var mentionPattern = regexp.MustCompile(`(?:^|[^\w.])@([\w.%+-]+@[\w-]+(?:\.[\w-]+)*\.[A-Za-z]{2,})`)
+
+// commentRequest is the payload accepted when writing a comment. Users can be
+// mentioned in the body by email address or listed by ID in mentions.
+type commentRequest struct {
+	Body     string      `json:"body" binding:"required"`
+	TaskID   *uuid.UUID  `json:"task_id"`
+	Mentions []uuid.UUID `json:"mentions"`
+}
+
+// timelineEntry is a comment or a history event in the activity timeline of an instance
+type timelineEntry struct {
+	ID             uuid.UUID       `json:"id"`
+	Kind           string          `json:"kind"` // comment, event
+	Type           string          `json:"type"`
+	TaskInstanceID *uuid.UUID      `json:"task_instance_id"`
+	UserID         *uuid.UUID      `json:"user_id"`
+	UserName       string          `json:"user_name,omitempty"`
+	Body           string          `json:"body,omitempty"`
+	Detail         json.RawMessage `json:"detail,omitempty"`
+	EditedAt       *time.Time      `json:"edited_at,omitempty"`
+	CreatedAt      time.Time       `json:"created_at"`
+}
+
+// timelineRow is a timeline entry as read from the database
+type timelineRow struct {
+	ID             uuid.UUID
+	Kind           string
+	Type           string
+	TaskInstanceID *uuid.UUID
+	UserID         *uuid.UUID
+	UserName       string
+	Body           string
+	Detail         string
+	EditedAt       *time.Time
+	CreatedAt      time.Time
+}
+
+// mentionedUsers resolves the users mentioned in a comment body or listed by ID
+func mentionedUsers(db *gorm.DB, body string, ids []uuid.UUID) ([]models.User, error) {
+	var emails []string
+	for _, match := range mentionPattern.FindAllStringSubmatch(body, -1) {
+		emails = append(emails, strings.ToLower(match[1]))
+	}
+	if len(emails) == 0 && len(ids) == 0 {
+		return nil, nil
+	}
+
+	q := db.Model(&models.User{}).Where("is_active = ?", true)
+	switch {
+	case len(emails) > 0 && len(ids) > 0:
+		q = q.Where("LOWER(email) IN ? OR id IN ?", emails, ids)
+	case len(emails) > 0:
+		q = q.Where("LOWER(email) IN ?", emails)
+	default:
+		q = q.Where("id IN ?", ids)
+	}
+	var users []models.User
+	err := q.Order("email").Find(&users).Error
+	return users, err
+}
+
+// notifyMentions tells the users mentioned in a comment about it, except its author
+func notifyMentions(tx *gorm.DB, comment *models.Comment, users []models.User) error {
+	resource, resourceID := "process_instance", comment.ProcessInstanceID
+	if comment.TaskInstanceID != nil {
+		resource, resourceID = "task", *comment.TaskInstanceID
+	}
+
+	var notifications []models.Notification
+	for i := range users {
+		if users[i].ID == comment.AuthorID {
+			continue
+		}
+		notifications = append(notifications, models.Notification{
+			UserID:     &users[i].ID,
+			Type:       "mention",
+			Title:      "You were mentioned in a comment",
+			Message:    comment.Body,
+			Resource:   resource,
+			ResourceID: &resourceID,
+		})
+	}
+	if len(notifications) == 0 {
+		return nil
+	}
+	return tx.Create(&notifications).Error
+}
+
+// addComment stores a comment on a process instance, or on one of its tasks,
+// and notifies the users it mentions
+func (h *handler) addComment(c *gin.Context, instanceID uuid.UUID, req commentRequest) {
+	authorID, ok := requireUser(c)
+	if !ok {
+		return
+	}
+	if strings.TrimSpace(req.Body) == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Comment body is empty"})
+		return
+	}
+	mentions, err := mentionedUsers(h.db, req.Body, req.Mentions)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	comment := models.Comment{
+		ProcessInstanceID: instanceID,
+		TaskInstanceID:    req.TaskID,
+		AuthorID:          authorID,
+		Body:              req.Body,
+		Mentions:          mentions,
+	}
+	err = h.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Omit("Author", "Mentions.*").Create(&comment).Error; err != nil {
+			return err
+		}
+		return notifyMentions(tx, &comment, mentions)
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if comment.Mentions == nil {
+		comment.Mentions = []models.User{}
+	}
+
+	c.JSON(http.StatusCreated, comment)
+}
+
+// listComments writes the comments matching a query, oldest first
+func (h *handler) listComments(c *gin.Context, q *gorm.DB) {
+	var comments []models.Comment
+	err := q.Preload("Author").
+		Preload("Mentions").
+		Order("created_at, id").
+		Find(&comments).Error
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": comments, "total": len(comments)})
+}
+
+// List instance comments
+// @Summary List instance comments
+// @Description List the comments on a process instance and its tasks, oldest first
+// @Tags instances
+// @Produce json
+// @Param id path string true "Process instance ID"
+// @Success 200 {object} map[string]interface{}
+// @Router /instances/{id}/comments [get]
+func (h *handler) listInstanceComments(c *gin.Context) {
+	id, ok := parseID(c, "id")
+	if !ok {
+		return
+	}
+	h.listComments(c, h.db.Where("process_instance_id = ?", id))
+}
+
+// Add instance comment
+// @Summary Add instance comment
+// @Description Leave a comment on a process instance, or on one of its tasks with task_id. Users mentioned as @email or listed in mentions are notified.
+// @Tags instances
+// @Accept json
+// @Produce json
+// @Param id path string true "Process instance ID"
+// @Success 201 {object} models.Comment
+// @Failure 404 {object} map[string]interface{}
+// @Failure 422 {object} map[string]interface{}
+// @Router /instances/{id}/comments [post]
+func (h *handler) createInstanceComment(c *gin.Context) {
+	id, ok := parseID(c, "id")
+	if !ok {
+		return
+	}
+
+	var req commentRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var inst models.ProcessInstance
+	if err := h.db.Select("id").First(&inst, "id = ?", id).Error; err != nil {
+		respondDBError(c, err)
+		return
+	}
+	if req.TaskID != nil {
+		var count int64
+		err := h.db.Model(&models.TaskInstance{}).
+			Where("id = ? AND process_instance_id = ?", *req.TaskID, id).
+			Count(&count).Error
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		if count == 0 {
+			c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "Task does not belong to the process instance"})
+			return
+		}
+	}
+
+	h.addComment(c, id, req)
+}
+
+// List task comments
+// @Summary List task comments
+// @Description List the comments on a task, oldest first
+// @Tags tasks
+// @Produce json
+// @Param id path string true "Task ID"
+// @Success 200 {object} map[string]interface{}
+// @Router /tasks/{id}/comments [get]
+func (h *handler) listTaskComments(c *gin.Context) {
+	id, ok := parseID(c, "id")
+	if !ok {
+		return
+	}
+	h.listComments(c, h.db.Where("task_instance_id = ?", id))
+}
+
+// Add task comment
+// @Summary Add task comment
+// @Description Leave a comment on a task. Users mentioned as @email or listed in mentions are notified.
+// @Tags tasks
+// @Accept json
+// @Produce json
+// @Param id path string true "Task ID"
+// @Success 201 {object} models.Comment
+// @Failure 404 {object} map[string]interface{}
+// @Router /tasks/{id}/comments [post]
+func (h *handler) createTaskComment(c *gin.Context) {
+	id, ok := parseID(c, "id")
+	if !ok {
+		return
+	}
+
+	var req commentRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var task models.TaskInstance
+	if err := h.db.Select("id", "process_instance_id").First(&task, "id = ?", id).Error; err != nil {
+		respondDBError(c, err)
+		return
+	}
+	req.TaskID = &task.ID
+
+	h.addComment(c, task.ProcessInstanceID, req)
+}
+
+// authoredComment loads a comment for a change by its author, writing an error
+// response when it does not exist or belongs to someone else
+func (h *handler) authoredComment(c *gin.Context) (*models.Comment, bool) {
+	userID, ok := requireUser(c)
+	if !ok {
+		return nil, false
+	}
+	id, ok := parseID(c, "id")
+	if !ok {
+		return nil, false
+	}
+
+	var comment models.Comment
+	if err := h.db.Preload("Mentions").First(&comment, "id = ?", id).Error; err != nil {
+		respondDBError(c, err)
+		return nil, false
+	}
+	if comment.AuthorID != userID {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Only the author can change a comment"})
+		return nil, false
+	}
+	return &comment, true
+}
+
+// Update comment
+// @Summary Update comment
+// @Description Edit a comment. Only its author may edit it; the previous text is kept in the audit log and newly mentioned users are notified.
+// @Tags comments
+// @Accept json
+// @Produce json
+// @Param id path string true "Comment ID"
+// @Success 200 {object} models.Comment
+// @Failure 403 {object} map[string]interface{}
+// @Failure 404 {object} map[string]interface{}
+// @Router /comments/{id} [put]
+func (h *handler) updateComment(c *gin.Context) {
+	var req commentRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if strings.TrimSpace(req.Body) == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Comment body is empty"})
+		return
+	}
+
+	comment, ok := h.authoredComment(c)
+	if !ok {
+		return
+	}
+	mentions, err := mentionedUsers(h.db, req.Body, req.Mentions)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	mentioned := make(map[uuid.UUID]bool, len(comment.Mentions))
+	for _, user := range comment.Mentions {
+		mentioned[user.ID] = true
+	}
+	var added []models.User
+	for _, user := range mentions {
+		if !mentioned[user.ID] {
+			added = append(added, user)
+		}
+	}
+
+	previous := comment.Body
+	now := time.Now().UTC()
+	comment.Body = req.Body
+	comment.EditedAt = &now
+	err = h.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(comment).Select("body", "edited_at").Updates(comment).Error; err != nil {
+			return err
+		}
+		if err := tx.Model(comment).Omit("Mentions.*").Association("Mentions").Replace(mentions); err != nil {
+			return err
+		}
+		if err := notifyMentions(tx, comment, added); err != nil {
+			return err
+		}
+		return auditLog(c, tx, "update", "comment", comment.ID, gin.H{
+			"previous_body": previous,
+			"body":          comment.Body,
+		})
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	comment.Mentions = mentions
+	if comment.Mentions == nil {
+		comment.Mentions = []models.User{}
+	}
+
+	c.JSON(http.StatusOK, comment)
+}
+
+// Delete comment
+// @Summary Delete comment
+// @Description Delete a comment. Only its author may delete it; its text is kept in the audit log.
+// @Tags comments
+// @Param id path string true "Comment ID"
+// @Success 204
+// @Failure 403 {object} map[string]interface{}
+// @Failure 404 {object} map[string]interface{}
+// @Router /comments/{id} [delete]
+func (h *handler) deleteComment(c *gin.Context) {
+	comment, ok := h.authoredComment(c)
+	if !ok {
+		return
+	}
+
+	err := h.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Delete(&models.Comment{}, "id = ?", comment.ID).Error; err != nil {
+			return err
+		}
+		return auditLog(c, tx, "delete", "comment", comment.ID, gin.H{
+			"process_instance_id": comment.ProcessInstanceID,
+			"task_instance_id":    comment.TaskInstanceID,
+			"body":                comment.Body,
+		})
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// Get instance timeline
+// @Summary Get instance timeline
+// @Description The activity of a process instance in order: comments interleaved with status changes, assignments and variable updates. Pass next_cursor back as cursor for the next page.
+// @Tags instances
+// @Produce json
+// @Param id path string true "Process instance ID"
+// @Param task_id query string false "Only the activity of one task"
+// @Param limit query int false "Page size (default 100, max 500)"
+// @Param cursor query string false "Cursor from the previous page"
+// @Success 200 {object} map[string]interface{}
+// @Failure 404 {object} map[string]interface{}
+// @Router /instances/{id}/timeline [get]
+func (h *handler) getTimeline(c *gin.Context) {
+	id, ok := parseID(c, "id")
+	if !ok {
+		return
+	}
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "100"))
+	if err != nil || limit < 1 || limit > 500 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid limit"})
+		return
+	}
+
+	var inst models.ProcessInstance
+	if err := h.db.Select("id").First(&inst, "id = ?", id).Error; err != nil {
+		respondDBError(c, err)
+		return
+	}
+
+	events := h.db.Table("history_events").
+		Select("id, 'event' AS kind, type, task_instance_id, user_id, '' AS body, detail::text AS detail, NULL::timestamptz AS edited_at, created_at").
+		Where("process_instance_id = ? AND deleted_at IS NULL", id)
+	comments := h.db.Table("comments").
+		Select("id, 'comment' AS kind, 'comment' AS type, task_instance_id, author_id AS user_id, body, '' AS detail, edited_at, created_at").
+		Where("process_instance_id = ? AND deleted_at IS NULL", id)
+	q := h.db.Table("(?) AS timeline", h.db.Raw("? UNION ALL ?", events, comments)).
+		Select("timeline.*, TRIM(COALESCE(users.first_name, '') || ' ' || COALESCE(users.last_name, '')) AS user_name").
+		Joins("LEFT JOIN users ON users.id = timeline.user_id")
+
+	if raw := c.Query("task_id"); raw != "" {
+		taskID, err := uuid.Parse(raw)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid task_id"})
+			return
+		}
+		q = q.Where("timeline.task_instance_id = ?", taskID)
+	}
+	if raw := c.Query("cursor"); raw != "" {
+		after, afterID, err := decodeTimelineCursor(raw)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid cursor"})
+			return
+		}
+		q = q.Where("(timeline.created_at, timeline.id) > (?, ?)", after, afterID)
+	}
+
+	var rows []timelineRow
+	if err := q.Order("timeline.created_at, timeline.id").Limit(limit + 1).Scan(&rows).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	hasMore := len(rows) > limit
+	if hasMore {
+		rows = rows[:limit]
+	}
+	entries := make([]timelineEntry, len(rows))
+	for i, row := range rows {
+		entries[i] = timelineEntry{
+			ID:             row.ID,
+			Kind:           row.Kind,
+			Type:           row.Type,
+			TaskInstanceID: row.TaskInstanceID,
+			UserID:         row.UserID,
+			UserName:       row.UserName,
+			Body:           row.Body,
+			EditedAt:       row.EditedAt,
+			CreatedAt:      row.CreatedAt,
+		}
+		if row.Detail != "" {
+			entries[i].Detail = json.RawMessage(row.Detail)
+		}
+	}
+	var nextCursor string
+	if hasMore {
+		last := rows[len(rows)-1]
+		nextCursor = encodeTimelineCursor(last.CreatedAt, last.ID)
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": entries, "next_cursor": nextCursor, "has_more": hasMore})
+}
+
+// encodeTimelineCursor returns the opaque cursor of the position after a timeline entry
+func encodeTimelineCursor(createdAt time.Time, id uuid.UUID) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(createdAt.UTC().Format(time.RFC3339Nano) + "|" + id.String()))
+}
+
+// decodeTimelineCursor reads a cursor made by encodeTimelineCursor
+func decodeTimelineCursor(raw string) (time.Time, uuid.UUID, error) {
+	data, err := base64.RawURLEncoding.DecodeString(raw)
+	if err != nil {
+		return time.Time{}, uuid.Nil, err
+	}
+	value, rawID, found := strings.Cut(string(data), "|")
+	if !found {
+		return time.Time{}, uuid.Nil, errors.New("malformed cursor")
+	}
+	createdAt, err := time.Parse(time.RFC3339Nano, value)
+	if err != nil {
+		return time.Time{}, uuid.Nil, fmt.Errorf("malformed cursor: %w", err)
+	}
+	id, err := uuid.Parse(rawID)
+	if err != nil {
+		return time.Time{}, uuid.Nil, fmt.Errorf("malformed cursor: %w", err)
+	}
+	return createdAt, id, nil
+}
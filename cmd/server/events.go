@@ -0,0 +1,83 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/tvolodi/ai-bpms-backend/shared/engine"
+)
+
+// messageRequest is the payload accepted by correlateMessage
+type messageRequest struct {
+	Name            string                 `json:"name" binding:"required"`
+	BusinessKey     string                 `json:"business_key"`
+	CorrelationKeys map[string]interface{} `json:"correlation_keys"`
+	Variables       map[string]interface{} `json:"variables"`
+	All             bool                   `json:"all"`
+}
+
+// signalRequest is the payload accepted by broadcastSignal
+type signalRequest struct {
+	Name      string                 `json:"name" binding:"required"`
+	Variables map[string]interface{} `json:"variables"`
+}
+
+// Correlate message
+// @Summary Correlate message
+// @Description Deliver a message to the process instance waiting for it, matched by business key and correlation keys, or start a process with a matching message start event
+// @Tags events
+// @Accept json
+// @Produce json
+// @Success 200 {object} map[string]interface{}
+// @Failure 404 {object} map[string]interface{}
+// @Failure 409 {object} map[string]interface{}
+// @Router /messages [post]
+func (h *handler) correlateMessage(c *gin.Context) {
+	var req messageRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	results, err := h.engine.CorrelateMessage(c.Request.Context(), engine.MessageOptions{
+		Name:            req.Name,
+		BusinessKey:     req.BusinessKey,
+		CorrelationKeys: req.CorrelationKeys,
+		Variables:       req.Variables,
+		All:             req.All,
+	})
+	if err != nil {
+		respondEngineError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": results, "total": len(results)})
+}
+
+// Broadcast signal
+// @Summary Broadcast signal
+// @Description Wake every process instance waiting for the signal and start every process with a matching signal start event
+// @Tags events
+// @Accept json
+// @Produce json
+// @Success 200 {object} map[string]interface{}
+// @Router /signals [post]
+func (h *handler) broadcastSignal(c *gin.Context) {
+	var req signalRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	results, err := h.engine.BroadcastSignal(c.Request.Context(), engine.SignalOptions{
+		Name:      req.Name,
+		Variables: req.Variables,
+	})
+	if err != nil {
+		respondEngineError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": results, "total": len(results)})
+}
@@ -0,0 +1,319 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"reflect"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"github.com/tvolodi/ai-bpms-backend/shared/common/middleware"
+	"github.com/tvolodi/ai-bpms-backend/shared/common/models"
+	"github.com/tvolodi/ai-bpms-backend/shared/engine"
+	"github.com/tvolodi/ai-bpms-backend/shared/forms"
+)
+
+// errFormImmutable is returned when an update tries to change the schema of a published version
+var errFormImmutable = errors.New("form schema versions are immutable, publish a new version instead")
+
+// formRequest is the payload accepted when publishing a form schema
+type formRequest struct {
+	Name        string          `json:"name" binding:"required"`
+	Key         string          `json:"key" binding:"required"`
+	Description string          `json:"description"`
+	JSONSchema  json.RawMessage `json:"json_schema" binding:"required"`
+	UISchema    json.RawMessage `json:"ui_schema"`
+	Category    string          `json:"category"`
+	Tags        []string        `json:"tags"`
+	IsActive    *bool           `json:"is_active"`
+}
+
+// formUpdateRequest is the payload accepted when updating the metadata of a
+// form schema version. The schemas of a published version cannot change.
+type formUpdateRequest struct {
+	Name        *string         `json:"name"`
+	Description *string         `json:"description"`
+	JSONSchema  json.RawMessage `json:"json_schema"`
+	UISchema    json.RawMessage `json:"ui_schema"`
+	Category    *string         `json:"category"`
+	Tags        []string        `json:"tags"`
+	IsActive    *bool           `json:"is_active"`
+}
+
+// taskFormResponse is the form schema version a task was created with and the
+// versions its unversioned $refs are pinned to, by form key
+type taskFormResponse struct {
+	TaskID     uuid.UUID       `json:"task_id"`
+	References json.RawMessage `json:"references"`
+	models.FormSchema
+}
+
+// validateFormRequest is the payload accepted when validating form data, against
+// a stored form by key, and optionally version, or against an inline schema
+// and UI schema
+type validateFormRequest struct {
+	FormKey  string                 `json:"form_key"`
+	Version  int                    `json:"version"` // 0 for the latest active version
+	Schema   json.RawMessage        `json:"schema"`
+	UISchema json.RawMessage        `json:"ui_schema"`
+	Data     map[string]interface{} `json:"data"`
+}
+
+// sameJSON reports whether a schema in a request is the stored one, ignoring formatting
+func sameJSON(raw json.RawMessage, stored string) bool {
+	var a, b interface{}
+	if json.Unmarshal(raw, &a) != nil || json.Unmarshal([]byte(stored), &b) != nil {
+		return false
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+// Publish form schema
+// @Summary Publish form schema
+// @Description Check the JSON Schema and the form logic of the UI schema (its x-logic keyword) and store them as the next version of its key. Published versions are immutable; user tasks bind the latest active version of their form key unless the process pins one with formVersion, and the versions their $refs resolve to when they are created. Refer to "key@version" to pin a referenced form to a version instead.
+// @Tags forms
+// @Accept json
+// @Produce json
+// @Success 201 {object} models.FormSchema
+// @Failure 409 {object} map[string]interface{}
+// @Failure 422 {object} map[string]interface{}
+// @Router /forms [post]
+func (h *handler) createForm(c *gin.Context) {
+	var req formRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if _, err := h.engine.CompileSchema(c.Request.Context(), req.Key, string(req.JSONSchema), string(req.UISchema)); err != nil {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error(), "code": "FORM_SCHEMA_INVALID"})
+		return
+	}
+
+	form := models.FormSchema{
+		Name:        req.Name,
+		Key:         req.Key,
+		Description: req.Description,
+		JSONSchema:  string(req.JSONSchema),
+		UISchema:    string(req.UISchema),
+		Category:    req.Category,
+		Tags:        req.Tags,
+		IsActive:    req.IsActive == nil || *req.IsActive,
+		CreatedBy:   middleware.CurrentUserID(c),
+	}
+	if form.UISchema == "" {
+		form.UISchema = "{}"
+	}
+	err := h.db.Transaction(func(tx *gorm.DB) error {
+		var latest models.FormSchema
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("key = ?", req.Key).
+			Order("version DESC").
+			Limit(1).
+			Find(&latest).Error
+		if err != nil {
+			return err
+		}
+		form.Version = latest.Version + 1
+		return tx.Create(&form).Error
+	})
+	if err != nil {
+		if errors.Is(err, gorm.ErrDuplicatedKey) {
+			c.JSON(http.StatusConflict, gin.H{"error": "Another version of this form was published at the same time"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusCreated, form)
+}
+
+// Update form schema
+// @Summary Update form schema
+// @Description Update the metadata of a form schema version or activate and deactivate it. The JSON Schema and UI schema of a version are immutable; publish a new version to change them.
+// @Tags forms
+// @Accept json
+// @Produce json
+// @Param id path string true "Form schema ID"
+// @Success 200 {object} models.FormSchema
+// @Failure 409 {object} map[string]interface{}
+// @Router /forms/{id} [put]
+func (h *handler) updateForm(c *gin.Context) {
+	id, ok := parseID(c, "id")
+	if !ok {
+		return
+	}
+
+	var req formUpdateRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var form models.FormSchema
+	err := h.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.First(&form, "id = ?", id).Error; err != nil {
+			return err
+		}
+		if len(req.JSONSchema) > 0 && !sameJSON(req.JSONSchema, form.JSONSchema) ||
+			len(req.UISchema) > 0 && !sameJSON(req.UISchema, form.UISchema) {
+			return errFormImmutable
+		}
+
+		if req.Name != nil {
+			form.Name = *req.Name
+		}
+		if req.Description != nil {
+			form.Description = *req.Description
+		}
+		if req.Category != nil {
+			form.Category = *req.Category
+		}
+		if req.Tags != nil {
+			form.Tags = req.Tags
+		}
+		if req.IsActive != nil {
+			form.IsActive = *req.IsActive
+		}
+		form.UpdatedBy = middleware.CurrentUserID(c)
+		return tx.Save(&form).Error
+	})
+	if err != nil {
+		if errors.Is(err, errFormImmutable) {
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+			return
+		}
+		respondDBError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, form)
+}
+
+// List form schema versions
+// @Summary List form schema versions
+// @Description List every version of a form key, newest first
+// @Tags forms
+// @Produce json
+// @Param key path string true "Form key"
+// @Success 200 {object} map[string]interface{}
+// @Failure 404 {object} map[string]interface{}
+// @Router /forms/{key}/versions [get]
+func (h *handler) listFormVersions(c *gin.Context) {
+	key := c.Param("id")
+
+	var versions []models.FormSchema
+	if err := h.db.Where("key = ?", key).Order("version DESC").Find(&versions).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if len(versions) == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Form schema not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": versions, "total": len(versions)})
+}
+
+// Get task form schema
+// @Summary Get task form schema
+// @Description Get the JSON Schema and UI schema of the form version a task was created with, even when newer versions have been published since, and the versions of the forms its $refs resolved to then. A $ref without a version, such as "address" rather than "address@2", stays on the version it resolved to when the task was created.
+// @Tags forms
+// @Produce json
+// @Param id path string true "Task ID"
+// @Success 200 {object} taskFormResponse
+// @Failure 403 {object} map[string]interface{}
+// @Failure 404 {object} map[string]interface{}
+// @Router /forms/schema/{id} [get]
+func (h *handler) getFormSchema(c *gin.Context) {
+	id, ok := parseID(c, "id")
+	if !ok {
+		return
+	}
+
+	var task models.TaskInstance
+	if err := h.db.Select("id", "process_instance_id", "form_refs").First(&task, "id = ?", id).Error; err != nil {
+		respondDBError(c, err)
+		return
+	}
+	if _, ok := h.requireInstanceAccess(c, task.ProcessInstanceID); !ok {
+		return
+	}
+
+	form, err := h.engine.TaskForm(c.Request.Context(), id)
+	if errors.Is(err, engine.ErrFormNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		respondEngineError(c, err)
+		return
+	}
+
+	refs := json.RawMessage(task.FormRefs)
+	if len(refs) == 0 {
+		refs = json.RawMessage("{}")
+	}
+	c.JSON(http.StatusOK, taskFormResponse{TaskID: id, References: refs, FormSchema: *form})
+}
+
+// Validate form data
+// @Summary Validate form data
+// @Description Validate data against the JSON Schema (draft 2020-12) and the form logic of a stored form or an inline schema, without submitting it. Every invalid value is reported with its JSON pointer. The response also carries the data with computed fields filled in and hidden fields dropped, and the visibility, requirement and dropdown options of every field the form logic applies to, so clients can re-render as answers change.
+// @Tags forms
+// @Accept json
+// @Produce json
+// @Success 200 {object} map[string]interface{}
+// @Failure 400 {object} map[string]interface{}
+// @Failure 404 {object} map[string]interface{}
+// @Failure 422 {object} map[string]interface{}
+// @Router /forms/validate [post]
+func (h *handler) validateForm(c *gin.Context) {
+	var req validateFormRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if (req.FormKey == "") == (len(req.Schema) == 0) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Exactly one of form_key and schema is required"})
+		return
+	}
+
+	var (
+		schema *forms.Schema
+		err    error
+	)
+	if req.FormKey != "" {
+		schema, err = h.engine.CompileForm(c.Request.Context(), req.FormKey, req.Version)
+	} else {
+		schema, err = h.engine.CompileSchema(c.Request.Context(), "", string(req.Schema), string(req.UISchema))
+	}
+	switch {
+	case errors.Is(err, forms.ErrInvalidSchema):
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error(), "code": "FORM_SCHEMA_INVALID"})
+		return
+	case errors.Is(err, engine.ErrFormNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	case err != nil:
+		respondEngineError(c, err)
+		return
+	}
+
+	data, fields := schema.Apply(req.Data)
+	formErrs := forms.ValidationErrors{}
+	if err := schema.Validate(req.Data); err != nil && !errors.As(err, &formErrs) {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"valid":  len(formErrs) == 0,
+		"errors": formErrs,
+		"data":   data,
+		"fields": fields,
+	})
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+
+	"github.com/tvolodi/ai-bpms-backend/shared/common/config"
+	"github.com/tvolodi/ai-bpms-backend/shared/common/middleware"
+	"github.com/tvolodi/ai-bpms-backend/shared/common/models"
+	"github.com/tvolodi/ai-bpms-backend/shared/engine"
+	"github.com/tvolodi/ai-bpms-backend/shared/forms"
+	"github.com/tvolodi/ai-bpms-backend/shared/storage"
+)
+
+// handler holds the dependencies shared by the API handlers
+type handler struct {
+	db         *gorm.DB
+	engine     *engine.Engine
+	storage    storage.Storage
+	storageCfg config.StorageConfig
+}
+
+// newHandler creates the API handler set
+func newHandler(db *gorm.DB, eng *engine.Engine, store storage.Storage, storageCfg config.StorageConfig) *handler {
+	return &handler{db: db, engine: eng, storage: store, storageCfg: storageCfg}
+}
+
+// parseID reads a UUID path parameter, writing a 400 response if it is malformed
+func parseID(c *gin.Context, param string) (uuid.UUID, bool) {
+	id, err := uuid.Parse(c.Param(param))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid " + param})
+		return uuid.Nil, false
+	}
+	return id, true
+}
+
+// requireUser returns the authenticated user's ID, writing a 401 response for anonymous requests
+func requireUser(c *gin.Context) (uuid.UUID, bool) {
+	userID := middleware.CurrentUserID(c)
+	if userID == nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Authentication required"})
+		return uuid.Nil, false
+	}
+	return *userID, true
+}
+
+// auditLog records an action of the current user on a resource in the audit log
+func auditLog(c *gin.Context, tx *gorm.DB, action, resource string, resourceID uuid.UUID, details interface{}) error {
+	entry, err := auditEntry(c, action, resource, &resourceID, details)
+	if err != nil {
+		return err
+	}
+	return tx.Omit("User").Create(&entry).Error
+}
+
+// auditEntry builds a successful audit log entry for an action of the current user
+func auditEntry(c *gin.Context, action, resource string, resourceID *uuid.UUID, details interface{}) (models.AuditLog, error) {
+	encoded, err := json.Marshal(details)
+	if err != nil {
+		return models.AuditLog{}, err
+	}
+	return models.AuditLog{
+		Timestamp:  time.Now().UTC(),
+		UserID:     middleware.CurrentUserID(c),
+		Action:     action,
+		Resource:   resource,
+		ResourceID: resourceID,
+		Details:    string(encoded),
+		IPAddress:  c.ClientIP(),
+		UserAgent:  c.Request.UserAgent(),
+		Success:    true,
+	}, nil
+}
+
+// hasRole reports whether a user holds one of the named roles
+func hasRole(db *gorm.DB, userID uuid.UUID, names ...string) (bool, error) {
+	var count int64
+	err := db.Table("user_roles").
+		Joins("JOIN roles ON roles.id = user_roles.role_id").
+		Where("user_roles.user_id = ? AND roles.deleted_at IS NULL AND roles.name IN ?", userID, names).
+		Count(&count).Error
+	return count > 0, err
+}
+
+// respondDBError maps a database lookup error to an HTTP response
+func respondDBError(c *gin.Context, err error) {
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Not found"})
+		return
+	}
+	c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+}
+
+// taskErrorCodes are the machine-readable codes returned with task conflicts,
+// most specific first
+var taskErrorCodes = []struct {
+	err  error
+	code string
+}{
+	{engine.ErrTaskCompleted, "TASK_ALREADY_COMPLETED"},
+	{engine.ErrTaskCancelled, "TASK_CANCELLED"},
+	{engine.ErrTaskNotActive, "TASK_NOT_ACTIVE"},
+	{engine.ErrTaskClaimed, "TASK_ALREADY_CLAIMED"},
+	{engine.ErrTaskDelegated, "TASK_DELEGATED"},
+	{engine.ErrTaskNotDelegate, "TASK_NOT_DELEGATED"},
+	{engine.ErrInstanceNotActive, "INSTANCE_NOT_ACTIVE"},
+}
+
+// respondEngineError maps a process engine error to an HTTP response
+func respondEngineError(c *gin.Context, err error) {
+	c.JSON(engineErrorResponse(err))
+}
+
+// engineErrorResponse returns the HTTP status and body a process engine error is reported with
+func engineErrorResponse(err error) (int, gin.H) {
+	body := gin.H{"error": err.Error()}
+	for _, known := range taskErrorCodes {
+		if errors.Is(err, known.err) {
+			body["code"] = known.code
+			break
+		}
+	}
+
+	var formErrs forms.ValidationErrors
+	switch {
+	case errors.As(err, &formErrs):
+		return http.StatusUnprocessableEntity, gin.H{
+			"error":   "Invalid form data",
+			"code":    "FORM_INVALID",
+			"details": formErrs,
+		}
+	case errors.Is(err, engine.ErrDefinitionNotFound),
+		errors.Is(err, engine.ErrInstanceNotFound),
+		errors.Is(err, engine.ErrTaskNotFound),
+		errors.Is(err, engine.ErrIncidentNotFound),
+		errors.Is(err, engine.ErrMessageNotCorrelated):
+		return http.StatusNotFound, body
+	case errors.Is(err, engine.ErrDefinitionInactive),
+		errors.Is(err, engine.ErrTaskNotActive),
+		errors.Is(err, engine.ErrTaskCompleted),
+		errors.Is(err, engine.ErrTaskCancelled),
+		errors.Is(err, engine.ErrAmbiguousCorrelation),
+		errors.Is(err, engine.ErrInstanceNotActive),
+		errors.Is(err, engine.ErrInstanceNotSuspended),
+		errors.Is(err, engine.ErrInstanceEnded),
+		errors.Is(err, engine.ErrIncidentNotOpen),
+		errors.Is(err, engine.ErrTokenNotFailed),
+		errors.Is(err, engine.ErrTokenStillFailed),
+		errors.Is(err, engine.ErrTaskClaimed),
+		errors.Is(err, engine.ErrTaskDelegated),
+		errors.Is(err, engine.ErrTaskNotDelegate):
+		return http.StatusConflict, body
+	case errors.Is(err, engine.ErrNotCandidate),
+		errors.Is(err, engine.ErrNotAssignee):
+		return http.StatusForbidden, body
+	case errors.Is(err, engine.ErrFormNotFound),
+		errors.Is(err, forms.ErrInvalidSchema):
+		return http.StatusUnprocessableEntity, body
+	case errors.Is(err, engine.ErrEventNameRequired),
+		errors.Is(err, engine.ErrFlowRequired),
+		errors.Is(err, engine.ErrFlowNotOutgoing),
+		errors.Is(err, engine.ErrInvalidPriority):
+		return http.StatusBadRequest, body
+	default:
+		return http.StatusInternalServerError, body
+	}
+}
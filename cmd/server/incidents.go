@@ -0,0 +1,196 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+
+	"github.com/tvolodi/ai-bpms-backend/shared/common/middleware"
+	"github.com/tvolodi/ai-bpms-backend/shared/common/models"
+	"github.com/tvolodi/ai-bpms-backend/shared/engine"
+)
+
+// incidentRequest is the payload accepted by the incident actions. FlowID is
+// only used when skipping.
+type incidentRequest struct {
+	Variables map[string]interface{} `json:"variables"`
+	FlowID    string                 `json:"flow_id"`
+	Note      string                 `json:"note"`
+}
+
+// List incidents
+// @Summary List incidents
+// @Description List incidents, by default the open ones with the newest first
+// @Tags admin
+// @Produce json
+// @Param status query string false "Incident status (open, resolved)"
+// @Param type query string false "Incident type"
+// @Param process_instance_id query string false "Process instance ID"
+// @Param element_id query string false "Element ID"
+// @Param limit query int false "Page size"
+// @Param offset query int false "Page offset"
+// @Success 200 {object} map[string]interface{}
+// @Router /admin/incidents [get]
+func (h *handler) listIncidents(c *gin.Context) {
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "50"))
+	if err != nil || limit < 1 || limit > 500 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid limit"})
+		return
+	}
+	offset, err := strconv.Atoi(c.DefaultQuery("offset", "0"))
+	if err != nil || offset < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid offset"})
+		return
+	}
+
+	q := h.db.Model(&models.Incident{}).Where("status = ?", c.DefaultQuery("status", engine.IncidentOpen))
+	if incidentType := c.Query("type"); incidentType != "" {
+		q = q.Where("type = ?", incidentType)
+	}
+	if elementID := c.Query("element_id"); elementID != "" {
+		q = q.Where("element_id = ?", elementID)
+	}
+	if raw := c.Query("process_instance_id"); raw != "" {
+		instanceID, err := uuid.Parse(raw)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid process_instance_id"})
+			return
+		}
+		q = q.Where("process_instance_id = ?", instanceID)
+	}
+
+	var total int64
+	if err := q.Count(&total).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	var incidents []models.Incident
+	if err := q.Order("created_at DESC").Limit(limit).Offset(offset).Find(&incidents).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": incidents, "total": total})
+}
+
+// Get incident
+// @Summary Get incident
+// @Description Get an incident together with the failed token and its task, if any
+// @Tags admin
+// @Produce json
+// @Param id path string true "Incident ID"
+// @Success 200 {object} map[string]interface{}
+// @Failure 404 {object} map[string]interface{}
+// @Router /admin/incidents/{id} [get]
+func (h *handler) getIncident(c *gin.Context) {
+	id, ok := parseID(c, "id")
+	if !ok {
+		return
+	}
+
+	var incident models.Incident
+	if err := h.db.First(&incident, "id = ?", id).Error; err != nil {
+		respondDBError(c, err)
+		return
+	}
+
+	var execution *models.Execution
+	if incident.ExecutionID != nil {
+		execution = &models.Execution{}
+		if err := h.db.First(execution, "id = ?", *incident.ExecutionID).Error; err != nil {
+			respondDBError(c, err)
+			return
+		}
+	}
+	var task *models.TaskInstance
+	if incident.TaskInstanceID != nil {
+		task = &models.TaskInstance{}
+		if err := h.db.First(task, "id = ?", *incident.TaskInstanceID).Error; err != nil {
+			respondDBError(c, err)
+			return
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{"incident": incident, "execution": execution, "task": task})
+}
+
+// Retry incident
+// @Summary Retry incident
+// @Description Resolve an incident and run the failed element again, optionally with changed variables
+// @Tags admin
+// @Accept json
+// @Produce json
+// @Param id path string true "Incident ID"
+// @Success 200 {object} models.Incident
+// @Failure 404 {object} map[string]interface{}
+// @Failure 409 {object} map[string]interface{}
+// @Router /admin/incidents/{id}/retry [post]
+func (h *handler) retryIncident(c *gin.Context) {
+	h.incidentAction(c, h.engine.RetryIncident)
+}
+
+// Skip incident
+// @Summary Skip incident
+// @Description Resolve an incident and move the token on as if the failed element had completed
+// @Tags admin
+// @Accept json
+// @Produce json
+// @Param id path string true "Incident ID"
+// @Success 200 {object} models.Incident
+// @Failure 400 {object} map[string]interface{}
+// @Failure 404 {object} map[string]interface{}
+// @Failure 409 {object} map[string]interface{}
+// @Router /admin/incidents/{id}/skip [post]
+func (h *handler) skipIncident(c *gin.Context) {
+	h.incidentAction(c, h.engine.SkipIncident)
+}
+
+// Resolve incident
+// @Summary Resolve incident
+// @Description Mark an incident as resolved without moving its token, once the token is no longer failed because it was moved or its instance cancelled. Failed tokens are retried or skipped instead.
+// @Tags admin
+// @Accept json
+// @Produce json
+// @Param id path string true "Incident ID"
+// @Success 200 {object} models.Incident
+// @Failure 404 {object} map[string]interface{}
+// @Failure 409 {object} map[string]interface{}
+// @Router /admin/incidents/{id}/resolve [post]
+func (h *handler) resolveIncident(c *gin.Context) {
+	h.incidentAction(c, h.engine.ResolveIncident)
+}
+
+// incidentOperation is one of the engine's incident actions
+type incidentOperation func(ctx context.Context, id uuid.UUID, opts engine.ResolveOptions) (*models.Incident, error)
+
+// incidentAction runs an incident operation with the options of the request
+func (h *handler) incidentAction(c *gin.Context, operation incidentOperation) {
+	id, ok := parseID(c, "id")
+	if !ok {
+		return
+	}
+
+	var req incidentRequest
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	incident, err := operation(c.Request.Context(), id, engine.ResolveOptions{
+		Variables:  req.Variables,
+		FlowID:     req.FlowID,
+		Note:       req.Note,
+		ResolvedBy: middleware.CurrentUserID(c),
+	})
+	if err != nil {
+		respondEngineError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, incident)
+}
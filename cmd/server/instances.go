@@ -0,0 +1,289 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+
+	"github.com/tvolodi/ai-bpms-backend/shared/common/middleware"
+	"github.com/tvolodi/ai-bpms-backend/shared/common/models"
+	"github.com/tvolodi/ai-bpms-backend/shared/engine"
+)
+
+// startInstanceRequest is the payload accepted by startInstance. Either the
+// definition ID or its key must be given; a key starts the given version, or
+// the latest active version when none is given.
+type startInstanceRequest struct {
+	ProcessDefinitionID *uuid.UUID             `json:"process_definition_id"`
+	ProcessKey          string                 `json:"process_key"`
+	Version             int                    `json:"version"`
+	BusinessKey         string                 `json:"business_key"`
+	Variables           map[string]interface{} `json:"variables"`
+}
+
+// Start process instance
+// @Summary Start process instance
+// @Description Create a process instance and run it until it waits on user tasks or completes
+// @Tags instances
+// @Accept json
+// @Produce json
+// @Success 201 {object} models.ProcessInstance
+// @Failure 404 {object} map[string]interface{}
+// @Router /instances [post]
+func (h *handler) startInstance(c *gin.Context) {
+	var req startInstanceRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var definitionID uuid.UUID
+	switch {
+	case req.ProcessDefinitionID != nil:
+		definitionID = *req.ProcessDefinitionID
+	case req.ProcessKey != "":
+		var def models.ProcessDefinition
+		q := h.db.Where("key = ?", req.ProcessKey)
+		if req.Version > 0 {
+			q = q.Where("version = ?", req.Version)
+		} else {
+			q = q.Where("is_active = ?", true)
+		}
+		err := q.Order("version DESC").First(&def).Error
+		if err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				c.JSON(http.StatusNotFound, gin.H{"error": "Process definition not found"})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		definitionID = def.ID
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "process_definition_id or process_key is required"})
+		return
+	}
+
+	inst, err := h.engine.StartInstance(c.Request.Context(), definitionID, engine.StartOptions{
+		BusinessKey: req.BusinessKey,
+		Variables:   req.Variables,
+		StartedBy:   middleware.CurrentUserID(c),
+	})
+	if err != nil {
+		respondEngineError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, inst)
+}
+
+// instanceNode is a process instance together with the instances its call activities started
+type instanceNode struct {
+	models.ProcessInstance
+	Children []*instanceNode `json:"children"`
+}
+
+// Get process instance
+// @Summary Get process instance
+// @Description Get a process instance with its open incidents and the tree of instances started by its call activities
+// @Tags instances
+// @Produce json
+// @Param id path string true "Process instance ID"
+// @Success 200 {object} map[string]interface{}
+// @Failure 404 {object} map[string]interface{}
+// @Router /instances/{id} [get]
+func (h *handler) getInstance(c *gin.Context) {
+	id, ok := parseID(c, "id")
+	if !ok {
+		return
+	}
+
+	root := &instanceNode{Children: []*instanceNode{}}
+	err := h.db.Preload("ProcessDefinition").
+		Preload("Incidents", "status = ?", engine.IncidentOpen).
+		First(&root.ProcessInstance, "id = ?", id).Error
+	if err != nil {
+		respondDBError(c, err)
+		return
+	}
+
+	// Load the tree one level at a time
+	level := map[uuid.UUID]*instanceNode{root.ID: root}
+	for len(level) > 0 {
+		parentIDs := make([]uuid.UUID, 0, len(level))
+		for parentID := range level {
+			parentIDs = append(parentIDs, parentID)
+		}
+
+		var children []models.ProcessInstance
+		err := h.db.Preload("ProcessDefinition").
+			Preload("Incidents", "status = ?", engine.IncidentOpen).
+			Where("parent_instance_id IN ?", parentIDs).
+			Order("started_at").
+			Find(&children).Error
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		next := make(map[uuid.UUID]*instanceNode, len(children))
+		for _, child := range children {
+			node := &instanceNode{ProcessInstance: child, Children: []*instanceNode{}}
+			parent := level[*child.ParentInstanceID]
+			parent.Children = append(parent.Children, node)
+			next[child.ID] = node
+		}
+		level = next
+	}
+
+	c.JSON(http.StatusOK, root)
+}
+
+// terminateRequest is the payload accepted by terminateInstance
+type terminateRequest struct {
+	Reason string `json:"reason"`
+}
+
+// updateInstanceRequest is the payload accepted by updateInstance
+type updateInstanceRequest struct {
+	Status string `json:"status" binding:"required"`
+	Reason string `json:"reason"`
+}
+
+// Suspend process instance
+// @Summary Suspend process instance
+// @Description Suspend an active process instance and the instances it called: its timers are paused and its tasks cannot be completed
+// @Tags instances
+// @Produce json
+// @Param id path string true "Process instance ID"
+// @Success 200 {object} models.ProcessInstance
+// @Failure 404 {object} map[string]interface{}
+// @Failure 409 {object} map[string]interface{}
+// @Router /instances/{id}/suspend [post]
+func (h *handler) suspendInstance(c *gin.Context) {
+	id, ok := parseID(c, "id")
+	if !ok {
+		return
+	}
+
+	inst, err := h.engine.SuspendInstance(c.Request.Context(), id)
+	if err != nil {
+		respondEngineError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, inst)
+}
+
+// Resume process instance
+// @Summary Resume process instance
+// @Description Resume a suspended process instance and the instances it called that were suspended along with it. Called instances suspended on their own stay suspended.
+// @Tags instances
+// @Produce json
+// @Param id path string true "Process instance ID"
+// @Success 200 {object} models.ProcessInstance
+// @Failure 404 {object} map[string]interface{}
+// @Failure 409 {object} map[string]interface{}
+// @Router /instances/{id}/resume [post]
+func (h *handler) resumeInstance(c *gin.Context) {
+	id, ok := parseID(c, "id")
+	if !ok {
+		return
+	}
+
+	inst, err := h.engine.ResumeInstance(c.Request.Context(), id)
+	if err != nil {
+		respondEngineError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, inst)
+}
+
+// Terminate process instance
+// @Summary Terminate process instance
+// @Description Terminate a process instance, cancelling its open tasks and the instances it called
+// @Tags instances
+// @Accept json
+// @Produce json
+// @Param id path string true "Process instance ID"
+// @Success 200 {object} models.ProcessInstance
+// @Failure 404 {object} map[string]interface{}
+// @Failure 409 {object} map[string]interface{}
+// @Router /instances/{id}/terminate [post]
+func (h *handler) terminateInstance(c *gin.Context) {
+	id, ok := parseID(c, "id")
+	if !ok {
+		return
+	}
+
+	var req terminateRequest
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	inst, err := h.engine.TerminateInstance(c.Request.Context(), id, engine.TerminateOptions{
+		Reason:       req.Reason,
+		TerminatedBy: middleware.CurrentUserID(c),
+	})
+	if err != nil {
+		respondEngineError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, inst)
+}
+
+// Update process instance
+// @Summary Update process instance
+// @Description Change the status of a process instance to suspended, active or terminated
+// @Tags instances
+// @Accept json
+// @Produce json
+// @Param id path string true "Process instance ID"
+// @Success 200 {object} models.ProcessInstance
+// @Failure 400 {object} map[string]interface{}
+// @Failure 404 {object} map[string]interface{}
+// @Failure 409 {object} map[string]interface{}
+// @Router /instances/{id} [put]
+func (h *handler) updateInstance(c *gin.Context) {
+	id, ok := parseID(c, "id")
+	if !ok {
+		return
+	}
+
+	var req updateInstanceRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var inst *models.ProcessInstance
+	var err error
+	switch req.Status {
+	case engine.InstanceSuspended:
+		inst, err = h.engine.SuspendInstance(c.Request.Context(), id)
+	case engine.InstanceActive:
+		inst, err = h.engine.ResumeInstance(c.Request.Context(), id)
+	case engine.InstanceTerminated:
+		inst, err = h.engine.TerminateInstance(c.Request.Context(), id, engine.TerminateOptions{
+			Reason:       req.Reason,
+			TerminatedBy: middleware.CurrentUserID(c),
+		})
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "status must be suspended, active or terminated"})
+		return
+	}
+	if err != nil {
+		respondEngineError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, inst)
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+
+	"github.com/tvolodi/ai-bpms-backend/shared/common/models"
+	"github.com/tvolodi/ai-bpms-backend/shared/engine"
+)
+
+// List scheduled jobs
+// @Summary List scheduled jobs
+// @Description List scheduler jobs, by default the pending ones in due order
+// @Tags admin
+// @Produce json
+// @Param status query string false "Job status (pending, completed, cancelled, failed)"
+// @Param type query string false "Job type"
+// @Param process_instance_id query string false "Process instance ID"
+// @Param limit query int false "Page size"
+// @Param offset query int false "Page offset"
+// @Success 200 {object} map[string]interface{}
+// @Router /admin/jobs [get]
+func (h *handler) listJobs(c *gin.Context) {
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "50"))
+	if err != nil || limit < 1 || limit > 500 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid limit"})
+		return
+	}
+	offset, err := strconv.Atoi(c.DefaultQuery("offset", "0"))
+	if err != nil || offset < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid offset"})
+		return
+	}
+
+	q := h.db.Model(&models.Job{}).Where("status = ?", c.DefaultQuery("status", engine.JobPending))
+	if jobType := c.Query("type"); jobType != "" {
+		q = q.Where("type = ?", jobType)
+	}
+	if raw := c.Query("process_instance_id"); raw != "" {
+		instanceID, err := uuid.Parse(raw)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid process_instance_id"})
+			return
+		}
+		q = q.Where("process_instance_id = ?", instanceID)
+	}
+
+	var total int64
+	if err := q.Count(&total).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	var jobs []models.Job
+	if err := q.Order("due_at").Limit(limit).Offset(offset).Find(&jobs).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": jobs, "total": total})
+}
@@ -14,10 +14,15 @@ import (
 	"github.com/sirupsen/logrus"
 	swaggerFiles "github.com/swaggo/files"
 	ginSwagger "github.com/swaggo/gin-swagger"
+	"gorm.io/gorm"
 
 	"github.com/tvolodi/ai-bpms-backend/shared/common/config"
 	"github.com/tvolodi/ai-bpms-backend/shared/common/middleware"
 	"github.com/tvolodi/ai-bpms-backend/shared/database"
+	"github.com/tvolodi/ai-bpms-backend/shared/engine"
+	"github.com/tvolodi/ai-bpms-backend/shared/scheduler"
+	"github.com/tvolodi/ai-bpms-backend/shared/sla"
+	"github.com/tvolodi/ai-bpms-backend/shared/storage"
 )
 
 // @title AI-BPMS Backend API
@@ -56,6 +61,38 @@ func main() {
 		logrus.Fatalf("Failed to connect to database: %v", err)
 	}
 
+	// Create process engine
+	eng := engine.New(db)
+
+	// Open attachment storage
+	store, err := storage.New(cfg.Storage)
+	if err != nil {
+		logrus.Fatalf("Failed to open attachment storage: %v", err)
+	}
+
+	// Start job scheduler
+	schedulerCtx, stopScheduler := context.WithCancel(context.Background())
+	schedulerDone := make(chan struct{})
+	if cfg.Scheduler.Enabled {
+		go func() {
+			defer close(schedulerDone)
+			scheduler.New(db, eng, cfg.Scheduler).Run(schedulerCtx)
+		}()
+	} else {
+		close(schedulerDone)
+	}
+
+	// Start SLA monitor
+	slaDone := make(chan struct{})
+	if cfg.SLA.Enabled {
+		go func() {
+			defer close(slaDone)
+			sla.New(eng, cfg.SLA).Run(schedulerCtx)
+		}()
+	} else {
+		close(slaDone)
+	}
+
 	// Setup Gin mode
 	if cfg.Logging.Level == "debug" {
 		gin.SetMode(gin.DebugMode)
@@ -70,7 +107,7 @@ func main() {
 	setupMiddleware(router, cfg)
 
 	// Setup routes
-	setupRoutes(router, cfg, db)
+	setupRoutes(router, cfg, db, eng, store)
 
 	// Create HTTP server
 	srv := &http.Server{
@@ -103,6 +140,11 @@ func main() {
 
 	logrus.Info("Server shutting down...")
 
+	// Let the job and escalation in progress finish before the database goes away
+	stopScheduler()
+	<-schedulerDone
+	<-slaDone
+
 	// Give server 30 seconds to shutdown gracefully
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 	defer cancel()
@@ -170,9 +212,21 @@ func setupMiddleware(router *gin.Engine, cfg *config.Config) {
 
 	// Request ID middleware
 	router.Use(middleware.RequestID())
+
+	// Authentication middleware
+	if cfg.Auth.TrustUserHeader {
+		logrus.Warn("Trusting the X-User-ID header for authentication; do not enable this outside development")
+	}
+	router.Use(middleware.Authentication(cfg.Auth))
 }
 
-func setupRoutes(router *gin.Engine, cfg *config.Config, db interface{}) {
+func setupRoutes(router *gin.Engine, cfg *config.Config, db *gorm.DB, eng *engine.Engine, store storage.Storage) {
+	storageCfg := cfg.Storage
+	if storageCfg.URLSecret == "" {
+		storageCfg.URLSecret = cfg.Auth.JWT.Secret
+	}
+	h := newHandler(db, eng, store, storageCfg)
+
 	// Health check endpoint
 	router.GET("/health", healthCheck)
 
@@ -201,10 +255,13 @@ func setupRoutes(router *gin.Engine, cfg *config.Config, db interface{}) {
 		// TODO: Add authentication middleware
 		{
 			processes.GET("", listProcesses)
-			processes.POST("", createProcess)
+			processes.POST("", h.createProcess)
 			processes.GET("/:id", getProcess)
-			processes.PUT("/:id", updateProcess)
+			processes.PUT("/:id", h.updateProcess)
 			processes.DELETE("/:id", deleteProcess)
+			processes.GET("/:id/versions", h.listProcessVersions) // :id is the process key
+			processes.POST("/:id/instances/suspend", h.suspendProcessInstances)
+			processes.POST("/:id/instances/resume", h.resumeProcessInstances)
 		}
 
 		// Process instance routes
@@ -212,28 +269,91 @@ func setupRoutes(router *gin.Engine, cfg *config.Config, db interface{}) {
 		// TODO: Add authentication middleware
 		{
 			instances.GET("", listInstances)
-			instances.POST("", startInstance)
-			instances.GET("/:id", getInstance)
-			instances.PUT("/:id", updateInstance)
-			instances.DELETE("/:id", cancelInstance)
+			instances.POST("", h.startInstance)
+			instances.GET("/:id", h.getInstance)
+			instances.PUT("/:id", h.updateInstance)
+			instances.DELETE("/:id", h.terminateInstance)
+			instances.POST("/:id/suspend", h.suspendInstance)
+			instances.POST("/:id/resume", h.resumeInstance)
+			instances.POST("/:id/terminate", h.terminateInstance)
+			instances.GET("/:id/comments", h.listInstanceComments)
+			instances.POST("/:id/comments", h.createInstanceComment)
+			instances.GET("/:id/timeline", h.getTimeline)
+			instances.GET("/:id/attachments", h.listInstanceAttachments)
+			instances.POST("/:id/attachments", h.uploadInstanceAttachment)
 		}
 
+		// Instance migration routes
+		migrations := v1.Group("/migrations")
+		// TODO: Add authentication and admin role middleware
+		{
+			migrations.POST("", h.migrateInstances)
+			migrations.POST("/validate", h.validateMigration)
+		}
+
+		// Message and signal routes
+		// TODO: Add authentication middleware
+		v1.POST("/messages", h.correlateMessage)
+		v1.POST("/signals", h.broadcastSignal)
+
 		// Task routes
 		tasks := v1.Group("/tasks")
 		// TODO: Add authentication middleware
 		{
-			tasks.GET("", listTasks)
+			tasks.GET("", h.listTasks)
+			tasks.POST("/bulk", middleware.Authorization("task:bulk"), h.bulkTasks)
 			tasks.GET("/:id", getTask)
-			tasks.POST("/:id/complete", completeTask)
-			tasks.POST("/:id/assign", assignTask)
+			tasks.POST("/:id/complete", h.completeTask)
+			tasks.POST("/:id/claim", h.claimTask)
+			tasks.POST("/:id/unclaim", h.unclaimTask)
+			tasks.POST("/:id/delegate", middleware.Authorization("task:delegate"), h.delegateTask)
+			tasks.POST("/:id/resolve", h.resolveTask)
+			tasks.POST("/:id/assign", middleware.Authorization("task:assign"), h.assignTask)
+			tasks.GET("/:id/escalations", h.listTaskEscalations)
+			tasks.GET("/:id/comments", h.listTaskComments)
+			tasks.POST("/:id/comments", h.createTaskComment)
+			tasks.GET("/:id/attachments", h.listTaskAttachments)
+			tasks.POST("/:id/attachments", h.uploadTaskAttachment)
+		}
+
+		// Comment routes
+		comments := v1.Group("/comments")
+		// TODO: Add authentication middleware
+		{
+			comments.PUT("/:id", h.updateComment)
+			comments.DELETE("/:id", h.deleteComment)
+		}
+
+		// Attachment routes
+		attachments := v1.Group("/attachments")
+		// TODO: Add authentication middleware
+		{
+			attachments.GET("/:id", h.getAttachment)
+			attachments.GET("/:id/url", h.getAttachmentURL)
+			attachments.GET("/:id/content", h.downloadAttachment)
+			attachments.DELETE("/:id", h.deleteAttachment)
+		}
+
+		// Out-of-office substitution routes
+		substitutions := v1.Group("/substitutions")
+		// TODO: Add authentication middleware
+		{
+			substitutions.GET("", h.listSubstitutions)
+			substitutions.POST("", h.createSubstitution)
+			substitutions.PUT("/:id", h.updateSubstitution)
+			substitutions.DELETE("/:id", h.deleteSubstitution)
+			substitutions.GET("/:id/tasks", h.listSubstitutedTasks)
 		}
 
 		// Form schema routes
 		forms := v1.Group("/forms")
 		// TODO: Add authentication middleware
 		{
-			forms.GET("/schema/:id", getFormSchema)
-			forms.POST("/validate", validateForm)
+			forms.POST("", h.createForm)
+			forms.PUT("/:id", h.updateForm)
+			forms.GET("/:id/versions", h.listFormVersions) // :id is the form key
+			forms.GET("/schema/:id", h.getFormSchema)      // :id is the task ID
+			forms.POST("/validate", h.validateForm)
 		}
 
 		// Business rules routes
@@ -259,7 +379,7 @@ func setupRoutes(router *gin.Engine, cfg *config.Config, db interface{}) {
 		analytics := v1.Group("/analytics")
 		// TODO: Add authentication middleware
 		{
-			analytics.GET("/dashboard", getDashboard)
+			analytics.GET("/dashboard", h.getDashboard)
 			analytics.GET("/processes", getProcessAnalytics)
 			analytics.GET("/instances", getInstanceAnalytics)
 		}
@@ -273,6 +393,21 @@ func setupRoutes(router *gin.Engine, cfg *config.Config, db interface{}) {
 			admin.PUT("/users/:id", updateUser)
 			admin.DELETE("/users/:id", deleteUser)
 			admin.PUT("/users/:id/roles", updateUserRoles)
+			admin.GET("/jobs", h.listJobs)
+			admin.GET("/incidents", h.listIncidents)
+			admin.GET("/incidents/:id", h.getIncident)
+			admin.POST("/incidents/:id/retry", h.retryIncident)
+			admin.POST("/incidents/:id/skip", h.skipIncident)
+			admin.POST("/incidents/:id/resolve", h.resolveIncident)
+			admin.GET("/sla-policies", h.listSLAPolicies)
+			admin.POST("/sla-policies", h.createSLAPolicy)
+			admin.PUT("/sla-policies/:id", h.updateSLAPolicy)
+			admin.DELETE("/sla-policies/:id", h.deleteSLAPolicy)
+			admin.GET("/calendars", h.listCalendars)
+			admin.POST("/calendars", h.createCalendar)
+			admin.PUT("/calendars/:id", h.updateCalendar)
+			admin.DELETE("/calendars/:id", h.deleteCalendar)
+			admin.GET("/calendars/:id/due-date", h.computeDueDate)
 		}
 	}
 
@@ -327,18 +462,10 @@ func listProcesses(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"message": "List processes - TODO: Implement"})
 }
 
-func createProcess(c *gin.Context) {
-	c.JSON(http.StatusOK, gin.H{"message": "Create process - TODO: Implement"})
-}
-
 func getProcess(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"message": "Get process - TODO: Implement"})
 }
 
-func updateProcess(c *gin.Context) {
-	c.JSON(http.StatusOK, gin.H{"message": "Update process - TODO: Implement"})
-}
-
 func deleteProcess(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"message": "Delete process - TODO: Implement"})
 }
@@ -347,46 +474,10 @@ func listInstances(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"message": "List instances - TODO: Implement"})
 }
 
-func startInstance(c *gin.Context) {
-	c.JSON(http.StatusOK, gin.H{"message": "Start instance - TODO: Implement"})
-}
-
-func getInstance(c *gin.Context) {
-	c.JSON(http.StatusOK, gin.H{"message": "Get instance - TODO: Implement"})
-}
-
-func updateInstance(c *gin.Context) {
-	c.JSON(http.StatusOK, gin.H{"message": "Update instance - TODO: Implement"})
-}
-
-func cancelInstance(c *gin.Context) {
-	c.JSON(http.StatusOK, gin.H{"message": "Cancel instance - TODO: Implement"})
-}
-
-func listTasks(c *gin.Context) {
-	c.JSON(http.StatusOK, gin.H{"message": "List tasks - TODO: Implement"})
-}
-
 func getTask(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"message": "Get task - TODO: Implement"})
 }
 
-func completeTask(c *gin.Context) {
-	c.JSON(http.StatusOK, gin.H{"message": "Complete task - TODO: Implement"})
-}
-
-func assignTask(c *gin.Context) {
-	c.JSON(http.StatusOK, gin.H{"message": "Assign task - TODO: Implement"})
-}
-
-func getFormSchema(c *gin.Context) {
-	c.JSON(http.StatusOK, gin.H{"message": "Get form schema - TODO: Implement"})
-}
-
-func validateForm(c *gin.Context) {
-	c.JSON(http.StatusOK, gin.H{"message": "Validate form - TODO: Implement"})
-}
-
 func listRules(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"message": "List rules - TODO: Implement"})
 }
@@ -415,10 +506,6 @@ func aiOptimizeProcess(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"message": "AI optimize process - TODO: Implement"})
 }
 
-func getDashboard(c *gin.Context) {
-	c.JSON(http.StatusOK, gin.H{"message": "Get dashboard - TODO: Implement"})
-}
-
 func getProcessAnalytics(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"message": "Get process analytics - TODO: Implement"})
 }
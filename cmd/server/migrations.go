@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+
+	"github.com/tvolodi/ai-bpms-backend/shared/engine"
+)
+
+// migrationRequest is the payload accepted by the migration endpoints. Elements
+// without a mapping keep their ID in the target version.
+type migrationRequest struct {
+	SourceDefinitionID uuid.UUID         `json:"source_definition_id" binding:"required"`
+	TargetDefinitionID uuid.UUID         `json:"target_definition_id" binding:"required"`
+	Mappings           []activityMapping `json:"mappings"`
+	InstanceIDs        []uuid.UUID       `json:"instance_ids"`
+}
+
+// activityMapping maps an element of the source version to one of the target version
+type activityMapping struct {
+	Source string `json:"source" binding:"required"`
+	Target string `json:"target" binding:"required"`
+}
+
+// Validate migration
+// @Summary Validate migration
+// @Description Dry-run a migration plan and report which instances cannot be migrated and why. Only administrators can run migrations.
+// @Tags migrations
+// @Accept json
+// @Produce json
+// @Success 200 {object} map[string]interface{}
+// @Failure 403 {object} map[string]interface{}
+// @Failure 404 {object} map[string]interface{}
+// @Failure 422 {object} map[string]interface{}
+// @Router /migrations/validate [post]
+func (h *handler) validateMigration(c *gin.Context) {
+	h.runMigration(c, h.engine.ValidateMigration)
+}
+
+// Migrate instances
+// @Summary Migrate instances
+// @Description Move running instances to another version of their process using an activity mapping plan. Each instance is migrated on its own; those that cannot be migrated stay on their version and are reported. Only administrators can run migrations.
+// @Tags migrations
+// @Accept json
+// @Produce json
+// @Success 200 {object} map[string]interface{}
+// @Failure 403 {object} map[string]interface{}
+// @Failure 404 {object} map[string]interface{}
+// @Failure 422 {object} map[string]interface{}
+// @Router /migrations [post]
+func (h *handler) migrateInstances(c *gin.Context) {
+	h.runMigration(c, h.engine.MigrateInstances)
+}
+
+// runMigration builds the migration plan of the request and runs it with the given engine operation
+func (h *handler) runMigration(c *gin.Context, operation func(ctx context.Context, plan engine.MigrationPlan) ([]engine.MigrationResult, error)) {
+	userID, ok := requireUser(c)
+	if !ok {
+		return
+	}
+	admin, err := hasRole(h.db, userID, "admin")
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if !admin {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Only administrators can migrate process instances"})
+		return
+	}
+
+	var req migrationRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	mappings := make(map[string]string, len(req.Mappings))
+	for _, m := range req.Mappings {
+		if _, ok := mappings[m.Source]; ok {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Element " + m.Source + " is mapped more than once"})
+			return
+		}
+		mappings[m.Source] = m.Target
+	}
+
+	results, err := operation(c.Request.Context(), engine.MigrationPlan{
+		SourceDefinitionID: req.SourceDefinitionID,
+		TargetDefinitionID: req.TargetDefinitionID,
+		Mappings:           mappings,
+		InstanceIDs:        req.InstanceIDs,
+		MigratedBy:         &userID,
+	})
+	if err != nil {
+		if errors.Is(err, engine.ErrInvalidMigrationPlan) {
+			c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+			return
+		}
+		respondEngineError(c, err)
+		return
+	}
+
+	failed := 0
+	for _, result := range results {
+		if len(result.Problems) > 0 {
+			failed++
+		}
+	}
+	c.JSON(http.StatusOK, gin.H{"data": results, "total": len(results), "failed": failed})
+}
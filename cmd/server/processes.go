@@ -0,0 +1,279 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"github.com/tvolodi/ai-bpms-backend/shared/bpmn"
+	"github.com/tvolodi/ai-bpms-backend/shared/common/middleware"
+	"github.com/tvolodi/ai-bpms-backend/shared/common/models"
+	"github.com/tvolodi/ai-bpms-backend/shared/engine"
+)
+
+// errDefinitionImmutable is returned when an update tries to change the BPMN of a stored version
+var errDefinitionImmutable = errors.New("process definition versions are immutable, deploy a new version instead")
+
+// processRequest is the payload accepted when deploying a process definition
+type processRequest struct {
+	Name        string   `json:"name" binding:"required"`
+	Key         string   `json:"key" binding:"required"`
+	Description string   `json:"description"`
+	BPMN        string   `json:"bpmn" binding:"required"`
+	Category    string   `json:"category"`
+	Tags        []string `json:"tags"`
+	IsActive    *bool    `json:"is_active"`
+}
+
+// validateBPMN parses the definition XML and writes a 422 response listing
+// the offending element IDs when it is not a valid, executable diagram.
+func validateBPMN(c *gin.Context, xml string) bool {
+	if _, err := bpmn.ParseString(xml); err != nil {
+		var verrs bpmn.ValidationErrors
+		if errors.As(err, &verrs) {
+			c.JSON(http.StatusUnprocessableEntity, gin.H{
+				"error":   "Invalid BPMN definition",
+				"details": verrs,
+			})
+			return false
+		}
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+		return false
+	}
+	return true
+}
+
+// processUpdateRequest is the payload accepted when updating the metadata of a
+// process definition version. The BPMN of a stored version cannot change.
+type processUpdateRequest struct {
+	Name        *string  `json:"name"`
+	Description *string  `json:"description"`
+	BPMN        *string  `json:"bpmn"`
+	Category    *string  `json:"category"`
+	Tags        []string `json:"tags"`
+	IsActive    *bool    `json:"is_active"`
+}
+
+// processVersion is a process definition version with the number of its running instances
+type processVersion struct {
+	models.ProcessDefinition
+	ActiveInstances int64 `json:"active_instances"`
+}
+
+// Deploy process definition
+// @Summary Deploy process definition
+// @Description Validate the BPMN XML and store it as the next version of its key. New instances started by key use the latest active version.
+// @Tags processes
+// @Accept json
+// @Produce json
+// @Success 201 {object} models.ProcessDefinition
+// @Failure 409 {object} map[string]interface{}
+// @Failure 422 {object} map[string]interface{}
+// @Router /processes [post]
+func (h *handler) createProcess(c *gin.Context) {
+	var req processRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if !validateBPMN(c, req.BPMN) {
+		return
+	}
+
+	def := models.ProcessDefinition{
+		Name:        req.Name,
+		Key:         req.Key,
+		Description: req.Description,
+		BPMN:        req.BPMN,
+		Category:    req.Category,
+		Tags:        req.Tags,
+		IsActive:    req.IsActive == nil || *req.IsActive,
+		CreatedBy:   middleware.CurrentUserID(c),
+	}
+	err := h.db.Transaction(func(tx *gorm.DB) error {
+		var latest models.ProcessDefinition
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("key = ?", req.Key).
+			Order("version DESC").
+			Limit(1).
+			Find(&latest).Error
+		if err != nil {
+			return err
+		}
+		def.Version = latest.Version + 1
+
+		if err := tx.Create(&def).Error; err != nil {
+			return err
+		}
+		return h.engine.RegisterStartEvents(tx, def.Key)
+	})
+	if err != nil {
+		if errors.Is(err, gorm.ErrDuplicatedKey) {
+			c.JSON(http.StatusConflict, gin.H{"error": "Another version of this process was deployed at the same time"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusCreated, def)
+}
+
+// Update process definition
+// @Summary Update process definition
+// @Description Update the metadata of a process definition version or activate and deactivate it. The BPMN of a version is immutable; deploy a new version to change it.
+// @Tags processes
+// @Accept json
+// @Produce json
+// @Param id path string true "Process definition ID"
+// @Success 200 {object} models.ProcessDefinition
+// @Failure 409 {object} map[string]interface{}
+// @Router /processes/{id} [put]
+func (h *handler) updateProcess(c *gin.Context) {
+	id, ok := parseID(c, "id")
+	if !ok {
+		return
+	}
+
+	var req processUpdateRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var def models.ProcessDefinition
+	err := h.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.First(&def, "id = ?", id).Error; err != nil {
+			return err
+		}
+		if req.BPMN != nil && *req.BPMN != def.BPMN {
+			return errDefinitionImmutable
+		}
+
+		if req.Name != nil {
+			def.Name = *req.Name
+		}
+		if req.Description != nil {
+			def.Description = *req.Description
+		}
+		if req.Category != nil {
+			def.Category = *req.Category
+		}
+		if req.Tags != nil {
+			def.Tags = req.Tags
+		}
+		if req.IsActive != nil {
+			def.IsActive = *req.IsActive
+		}
+		def.UpdatedBy = middleware.CurrentUserID(c)
+		if err := tx.Save(&def).Error; err != nil {
+			return err
+		}
+		return h.engine.RegisterStartEvents(tx, def.Key)
+	})
+	if err != nil {
+		if errors.Is(err, errDefinitionImmutable) {
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+			return
+		}
+		respondDBError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, def)
+}
+
+// List process definition versions
+// @Summary List process definition versions
+// @Description List every version of a process key, newest first, with the number of instances still running on each
+// @Tags processes
+// @Produce json
+// @Param key path string true "Process definition key"
+// @Success 200 {object} map[string]interface{}
+// @Failure 404 {object} map[string]interface{}
+// @Router /processes/{key}/versions [get]
+func (h *handler) listProcessVersions(c *gin.Context) {
+	key := c.Param("id")
+
+	var defs []models.ProcessDefinition
+	if err := h.db.Where("key = ?", key).Order("version DESC").Find(&defs).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if len(defs) == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Process definition not found"})
+		return
+	}
+
+	var counts []struct {
+		ProcessDefinitionID uuid.UUID
+		Count               int64
+	}
+	err := h.db.Model(&models.ProcessInstance{}).
+		Select("process_definition_id, COUNT(*) AS count").
+		Joins("JOIN process_definitions ON process_definitions.id = process_instances.process_definition_id").
+		Where("process_definitions.key = ? AND process_instances.status IN ?", key, []string{engine.InstanceActive, engine.InstanceSuspended}).
+		Group("process_definition_id").
+		Scan(&counts).Error
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	active := make(map[uuid.UUID]int64, len(counts))
+	for _, row := range counts {
+		active[row.ProcessDefinitionID] = row.Count
+	}
+
+	versions := make([]processVersion, len(defs))
+	for i, def := range defs {
+		versions[i] = processVersion{ProcessDefinition: def, ActiveInstances: active[def.ID]}
+	}
+	c.JSON(http.StatusOK, gin.H{"data": versions, "total": len(versions)})
+}
+
+// Suspend process instances
+// @Summary Suspend process instances
+// @Description Suspend every active instance of a process definition
+// @Tags processes
+// @Produce json
+// @Param id path string true "Process definition ID"
+// @Success 200 {object} map[string]interface{}
+// @Failure 404 {object} map[string]interface{}
+// @Router /processes/{id}/instances/suspend [post]
+func (h *handler) suspendProcessInstances(c *gin.Context) {
+	h.bulkInstanceAction(c, h.engine.SuspendInstances)
+}
+
+// Resume process instances
+// @Summary Resume process instances
+// @Description Resume every suspended instance of a process definition
+// @Tags processes
+// @Produce json
+// @Param id path string true "Process definition ID"
+// @Success 200 {object} map[string]interface{}
+// @Failure 404 {object} map[string]interface{}
+// @Router /processes/{id}/instances/resume [post]
+func (h *handler) resumeProcessInstances(c *gin.Context) {
+	h.bulkInstanceAction(c, h.engine.ResumeInstances)
+}
+
+// bulkInstanceAction applies a lifecycle change to the instances of the requested definition
+func (h *handler) bulkInstanceAction(c *gin.Context, action func(ctx context.Context, definitionID uuid.UUID) (int, error)) {
+	id, ok := parseID(c, "id")
+	if !ok {
+		return
+	}
+
+	count, err := action(c.Request.Context(), id)
+	if err != nil {
+		respondEngineError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"process_definition_id": id, "count": count})
+}
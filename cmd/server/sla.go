@@ -0,0 +1,269 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+
+	"github.com/tvolodi/ai-bpms-backend/shared/common/middleware"
+	"github.com/tvolodi/ai-bpms-backend/shared/common/models"
+	"github.com/tvolodi/ai-bpms-backend/shared/engine"
+)
+
+// slaPolicyRequest is the payload accepted when creating or replacing an SLA policy
+type slaPolicyRequest struct {
+	ProcessDefinitionKey string             `json:"process_definition_key" binding:"required"`
+	TaskDefinitionKey    string             `json:"task_definition_key" binding:"required"`
+	Name                 string             `json:"name"`
+	TargetDuration       string             `json:"target_duration"`
+	WarningThreshold     string             `json:"warning_threshold"`
+	Calendar             string             `json:"calendar"`
+	Actions              []engine.SLAAction `json:"actions"`
+	IsActive             *bool              `json:"is_active"`
+}
+
+// processSLA counts the open tasks of a process and how many of them are at risk or overdue
+type processSLA struct {
+	ProcessDefinitionKey string `json:"process_definition_key"`
+	Name                 string `json:"name"`
+	OpenTasks            int64  `json:"open_tasks"`
+	AtRisk               int64  `json:"at_risk"`
+	Breached             int64  `json:"breached"`
+}
+
+// apply copies the request onto a policy and checks it
+func (req *slaPolicyRequest) apply(policy *models.SLAPolicy) error {
+	actions, err := json.Marshal(req.Actions)
+	if err != nil {
+		return err
+	}
+	if req.Actions == nil {
+		actions = []byte("[]")
+	}
+	policy.ProcessDefinitionKey = req.ProcessDefinitionKey
+	policy.TaskDefinitionKey = req.TaskDefinitionKey
+	policy.Name = req.Name
+	policy.TargetDuration = req.TargetDuration
+	policy.WarningThreshold = req.WarningThreshold
+	policy.Calendar = req.Calendar
+	policy.Actions = string(actions)
+	policy.IsActive = req.IsActive == nil || *req.IsActive
+	return engine.ValidateSLAPolicy(policy)
+}
+
+// List SLA policies
+// @Summary List SLA policies
+// @Description List the SLA policies of user task definitions
+// @Tags admin
+// @Produce json
+// @Param process_key query string false "Process definition key"
+// @Success 200 {object} map[string]interface{}
+// @Router /admin/sla-policies [get]
+func (h *handler) listSLAPolicies(c *gin.Context) {
+	q := h.db.Model(&models.SLAPolicy{})
+	if key := c.Query("process_key"); key != "" {
+		q = q.Where("process_definition_key = ?", key)
+	}
+
+	var policies []models.SLAPolicy
+	if err := q.Order("process_definition_key, task_definition_key").Find(&policies).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": policies, "total": len(policies)})
+}
+
+// Create SLA policy
+// @Summary Create SLA policy
+// @Description Set the target duration, warning threshold and escalation actions of a user task definition. Tasks created from then on are tracked under the policy.
+// @Tags admin
+// @Accept json
+// @Produce json
+// @Success 201 {object} models.SLAPolicy
+// @Failure 409 {object} map[string]interface{}
+// @Failure 422 {object} map[string]interface{}
+// @Router /admin/sla-policies [post]
+func (h *handler) createSLAPolicy(c *gin.Context) {
+	var req slaPolicyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	policy := models.SLAPolicy{CreatedBy: middleware.CurrentUserID(c)}
+	if err := req.apply(&policy); err != nil {
+		respondSLAPolicyError(c, err)
+		return
+	}
+	if err := h.db.Create(&policy).Error; err != nil {
+		respondSLAPolicyError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, policy)
+}
+
+// Update SLA policy
+// @Summary Update SLA policy
+// @Description Replace an SLA policy. Open tasks keep their due dates; new escalation actions apply to them from now on.
+// @Tags admin
+// @Accept json
+// @Produce json
+// @Param id path string true "SLA policy ID"
+// @Success 200 {object} models.SLAPolicy
+// @Failure 404 {object} map[string]interface{}
+// @Failure 422 {object} map[string]interface{}
+// @Router /admin/sla-policies/{id} [put]
+func (h *handler) updateSLAPolicy(c *gin.Context) {
+	id, ok := parseID(c, "id")
+	if !ok {
+		return
+	}
+
+	var req slaPolicyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var policy models.SLAPolicy
+	if err := h.db.First(&policy, "id = ?", id).Error; err != nil {
+		respondDBError(c, err)
+		return
+	}
+	if err := req.apply(&policy); err != nil {
+		respondSLAPolicyError(c, err)
+		return
+	}
+	policy.UpdatedBy = middleware.CurrentUserID(c)
+	if err := h.db.Save(&policy).Error; err != nil {
+		respondSLAPolicyError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, policy)
+}
+
+// Delete SLA policy
+// @Summary Delete SLA policy
+// @Description Delete an SLA policy. Its open tasks are no longer escalated.
+// @Tags admin
+// @Param id path string true "SLA policy ID"
+// @Success 204
+// @Failure 404 {object} map[string]interface{}
+// @Router /admin/sla-policies/{id} [delete]
+func (h *handler) deleteSLAPolicy(c *gin.Context) {
+	id, ok := parseID(c, "id")
+	if !ok {
+		return
+	}
+
+	result := h.db.Delete(&models.SLAPolicy{}, "id = ?", id)
+	if result.Error != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": result.Error.Error()})
+		return
+	}
+	if result.RowsAffected == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "SLA policy not found"})
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// respondSLAPolicyError maps an error storing an SLA policy to an HTTP response
+func respondSLAPolicyError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, engine.ErrInvalidSLAPolicy):
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+	case errors.Is(err, gorm.ErrDuplicatedKey):
+		c.JSON(http.StatusConflict, gin.H{"error": "The task definition already has an SLA policy"})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	}
+}
+
+// List task escalations
+// @Summary List task escalations
+// @Description List the escalation actions taken on a task, oldest first
+// @Tags tasks
+// @Produce json
+// @Param id path string true "Task ID"
+// @Success 200 {object} map[string]interface{}
+// @Router /tasks/{id}/escalations [get]
+func (h *handler) listTaskEscalations(c *gin.Context) {
+	id, ok := parseID(c, "id")
+	if !ok {
+		return
+	}
+
+	var escalations []models.TaskEscalation
+	if err := h.db.Where("task_instance_id = ?", id).Order("created_at").Find(&escalations).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": escalations, "total": len(escalations)})
+}
+
+// Get dashboard
+// @Summary Get dashboard
+// @Description Open tasks per process with the number at risk of missing their due date and the number overdue. The task totals count every process, including those past the limit.
+// @Tags analytics
+// @Produce json
+// @Param limit query int false "Number of processes"
+// @Success 200 {object} map[string]interface{}
+// @Router /analytics/dashboard [get]
+func (h *handler) getDashboard(c *gin.Context) {
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "50"))
+	if err != nil || limit < 1 || limit > 500 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid limit"})
+		return
+	}
+
+	now := time.Now().UTC()
+	counts := `COUNT(*) AS open_tasks,
+		COUNT(*) FILTER (WHERE task_instances.due_date > ? AND task_instances.sla_warning_at <= ?) AS at_risk,
+		COUNT(*) FILTER (WHERE task_instances.due_date <= ?) AS breached`
+	openTasks := func() *gorm.DB {
+		return h.db.Model(&models.TaskInstance{}).
+			Where("task_instances.status IN ?", []string{engine.TaskCreated, engine.TaskAssigned})
+	}
+
+	// The totals cover every process, not only those listed
+	var totals processSLA
+	if err := openTasks().Select(counts, now, now, now).Scan(&totals).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	var processes []processSLA
+	err = openTasks().
+		Select(`process_definitions.key AS process_definition_key,
+			MAX(process_definitions.name) AS name, `+counts, now, now, now).
+		Joins("JOIN process_instances ON process_instances.id = task_instances.process_instance_id").
+		Joins("JOIN process_definitions ON process_definitions.id = process_instances.process_definition_id").
+		Group("process_definitions.key").
+		Order("breached DESC, at_risk DESC, open_tasks DESC").
+		Limit(limit).
+		Scan(&processes).Error
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"tasks": gin.H{
+			"open":     totals.OpenTasks,
+			"at_risk":  totals.AtRisk,
+			"breached": totals.Breached,
+		},
+		"processes": processes,
+	})
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+
+	"github.com/tvolodi/ai-bpms-backend/shared/common/models"
+)
+
+// substitutionRequest is the payload accepted when creating or replacing an out-of-office rule
+type substitutionRequest struct {
+	SubstituteID uuid.UUID `json:"substitute_id" binding:"required"`
+	StartsAt     time.Time `json:"starts_at" binding:"required"`
+	EndsAt       time.Time `json:"ends_at" binding:"required"`
+	Category     string    `json:"category"` // process category; empty for every process
+	Reason       string    `json:"reason"`
+}
+
+// applySubstitution checks the request and copies it onto a rule of the user,
+// writing an error response if it is invalid
+func (h *handler) applySubstitution(c *gin.Context, req substitutionRequest, rule *models.Substitution) bool {
+	if !req.EndsAt.After(req.StartsAt) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "ends_at must be after starts_at"})
+		return false
+	}
+	if req.SubstituteID == rule.UserID {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "A user cannot substitute for themselves"})
+		return false
+	}
+	if !h.activeUser(c, req.SubstituteID) {
+		return false
+	}
+
+	rule.SubstituteID = req.SubstituteID
+	rule.StartsAt = req.StartsAt.UTC()
+	rule.EndsAt = req.EndsAt.UTC()
+	rule.Category = req.Category
+	rule.Reason = req.Reason
+	return true
+}
+
+// ownSubstitution loads an out-of-office rule of the caller, writing a 404 response otherwise
+func (h *handler) ownSubstitution(c *gin.Context, userID uuid.UUID) (models.Substitution, bool) {
+	var rule models.Substitution
+	id, ok := parseID(c, "id")
+	if !ok {
+		return rule, false
+	}
+	if err := h.db.First(&rule, "id = ? AND user_id = ?", id, userID).Error; err != nil {
+		respondDBError(c, err)
+		return rule, false
+	}
+	return rule, true
+}
+
+// List substitution rules
+// @Summary List substitution rules
+// @Description List the caller's out-of-office rules, latest first
+// @Tags substitutions
+// @Produce json
+// @Param current query bool false "Only rules in effect now or later"
+// @Success 200 {object} map[string]interface{}
+// @Failure 401 {object} map[string]interface{}
+// @Router /substitutions [get]
+func (h *handler) listSubstitutions(c *gin.Context) {
+	userID, ok := requireUser(c)
+	if !ok {
+		return
+	}
+
+	q := h.db.Where("user_id = ?", userID)
+	if c.Query("current") == "true" {
+		q = q.Where("ends_at > ?", time.Now().UTC())
+	}
+	var rules []models.Substitution
+	if err := q.Preload("Substitute").Order("starts_at DESC").Find(&rules).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": rules, "total": len(rules)})
+}
+
+// Create substitution rule
+// @Summary Create substitution rule
+// @Description Route tasks assigned to the caller to a substitute between starts_at and ends_at, for every process or for the processes of one category. Tasks already assigned stay where they are.
+// @Tags substitutions
+// @Accept json
+// @Produce json
+// @Success 201 {object} models.Substitution
+// @Failure 400 {object} map[string]interface{}
+// @Failure 422 {object} map[string]interface{}
+// @Router /substitutions [post]
+func (h *handler) createSubstitution(c *gin.Context) {
+	userID, ok := requireUser(c)
+	if !ok {
+		return
+	}
+
+	var req substitutionRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	rule := models.Substitution{UserID: userID}
+	if !h.applySubstitution(c, req, &rule) {
+		return
+	}
+	if err := h.db.Omit("Substitute").Create(&rule).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusCreated, rule)
+}
+
+// Update substitution rule
+// @Summary Update substitution rule
+// @Description Replace one of the caller's out-of-office rules
+// @Tags substitutions
+// @Accept json
+// @Produce json
+// @Param id path string true "Rule ID"
+// @Success 200 {object} models.Substitution
+// @Failure 404 {object} map[string]interface{}
+// @Failure 422 {object} map[string]interface{}
+// @Router /substitutions/{id} [put]
+func (h *handler) updateSubstitution(c *gin.Context) {
+	userID, ok := requireUser(c)
+	if !ok {
+		return
+	}
+
+	var req substitutionRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	rule, ok := h.ownSubstitution(c, userID)
+	if !ok {
+		return
+	}
+	if !h.applySubstitution(c, req, &rule) {
+		return
+	}
+	if err := h.db.Omit("Substitute").Save(&rule).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, rule)
+}
+
+// Delete substitution rule
+// @Summary Delete substitution rule
+// @Description Delete one of the caller's out-of-office rules. Tasks it already routed stay with the substitute.
+// @Tags substitutions
+// @Param id path string true "Rule ID"
+// @Success 204
+// @Failure 404 {object} map[string]interface{}
+// @Router /substitutions/{id} [delete]
+func (h *handler) deleteSubstitution(c *gin.Context) {
+	userID, ok := requireUser(c)
+	if !ok {
+		return
+	}
+
+	rule, ok := h.ownSubstitution(c, userID)
+	if !ok {
+		return
+	}
+	if err := h.db.Delete(&rule).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// List substituted tasks
+// @Summary List substituted tasks
+// @Description List the tasks one of the caller's out-of-office rules routed to the substitute, with their original assignee
+// @Tags substitutions
+// @Produce json
+// @Param id path string true "Rule ID"
+// @Success 200 {object} map[string]interface{}
+// @Failure 404 {object} map[string]interface{}
+// @Router /substitutions/{id}/tasks [get]
+func (h *handler) listSubstitutedTasks(c *gin.Context) {
+	userID, ok := requireUser(c)
+	if !ok {
+		return
+	}
+
+	rule, ok := h.ownSubstitution(c, userID)
+	if !ok {
+		return
+	}
+	var tasks []models.TaskInstance
+	err := h.db.Preload("Assignee").Preload("OriginalAssignee").
+		Where("substitution_id = ?", rule.ID).
+		Order("created_at DESC").
+		Find(&tasks).Error
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": tasks, "total": len(tasks)})
+}
@@ -0,0 +1,624 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+
+	"github.com/tvolodi/ai-bpms-backend/shared/common/models"
+	"github.com/tvolodi/ai-bpms-backend/shared/engine"
+)
+
+// taskSortColumns are the columns the inbox can be sorted by, and whether they may be NULL
+var taskSortColumns = map[string]bool{
+	"created_at": false,
+	"due_date":   true,
+	"priority":   false,
+	"name":       false,
+}
+
+// taskCursor is the position after the last task of an inbox page. Value is nil
+// when that task has no value in the sort column.
+type taskCursor struct {
+	Value interface{} `json:"v"`
+	ID    uuid.UUID   `json:"id"`
+}
+
+// userGroups returns the names of the user's roles and process groups, which
+// are matched against the candidate groups of tasks
+func userGroups(db *gorm.DB, userID uuid.UUID) ([]string, error) {
+	var groups []string
+	err := db.Table("roles").
+		Joins("JOIN user_roles ON user_roles.role_id = roles.id").
+		Where("user_roles.user_id = ? AND roles.deleted_at IS NULL", userID).
+		Pluck("roles.name", &groups).Error
+	if err != nil {
+		return nil, err
+	}
+
+	var processGroups sql.NullString
+	err = db.Model(&models.User{}).
+		Select("array_to_string(process_groups, ',')").
+		Where("id = ?", userID).
+		Row().
+		Scan(&processGroups)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, err
+	}
+	for _, group := range strings.Split(processGroups.String, ",") {
+		if group = strings.TrimSpace(group); group != "" {
+			groups = append(groups, group)
+		}
+	}
+	return groups, nil
+}
+
+// candidateOf restricts a task query to unassigned tasks offered to one of the groups.
+// CandidateGroup holds a comma-separated list.
+func candidateOf(q *gorm.DB, groups []string) *gorm.DB {
+	return q.Where("task_instances.assignee_id IS NULL AND EXISTS (SELECT 1 FROM unnest(string_to_array(task_instances.candidate_group, ',')) AS g(name) WHERE trim(g.name) IN ?)", groups)
+}
+
+// taskFilter selects tasks for the inbox and for bulk operations
+type taskFilter struct {
+	Scope       string     `json:"scope"` // assigned, candidate, all (default) or any
+	Status      []string   `json:"status"`
+	PriorityMin *int       `json:"priority_min"`
+	PriorityMax *int       `json:"priority_max"`
+	DueAfter    *time.Time `json:"due_after"`
+	DueBefore   *time.Time `json:"due_before"`
+	ProcessKey  string     `json:"process_key"`
+	Text        string     `json:"q"`
+	AssigneeID  *uuid.UUID `json:"assignee_id"`
+}
+
+// taskFilterFromQuery reads a task filter from the query parameters of a request
+func taskFilterFromQuery(c *gin.Context) (taskFilter, error) {
+	filter := taskFilter{
+		Scope:      c.Query("scope"),
+		ProcessKey: c.Query("process_key"),
+		Text:       c.Query("q"),
+	}
+	if raw := c.Query("status"); raw != "" {
+		filter.Status = strings.Split(raw, ",")
+	}
+	for param, target := range map[string]**int{
+		"priority_min": &filter.PriorityMin,
+		"priority_max": &filter.PriorityMax,
+	} {
+		if raw := c.Query(param); raw != "" {
+			v, err := strconv.Atoi(raw)
+			if err != nil {
+				return filter, fmt.Errorf("Invalid %s", param)
+			}
+			*target = &v
+		}
+	}
+	for param, target := range map[string]**time.Time{
+		"due_after":  &filter.DueAfter,
+		"due_before": &filter.DueBefore,
+	} {
+		if raw := c.Query(param); raw != "" {
+			at, err := time.Parse(time.RFC3339, raw)
+			if err != nil {
+				return filter, fmt.Errorf("Invalid %s", param)
+			}
+			*target = &at
+		}
+	}
+	if raw := c.Query("assignee_id"); raw != "" {
+		id, err := uuid.Parse(raw)
+		if err != nil {
+			return filter, fmt.Errorf("Invalid assignee_id")
+		}
+		filter.AssigneeID = &id
+	}
+	return filter, nil
+}
+
+// apply builds the task query selected by the filter for a user in the given
+// groups. Scope any selects tasks regardless of who may work on them.
+func (f taskFilter) apply(db *gorm.DB, userID uuid.UUID, groups []string) (*gorm.DB, error) {
+	q := db.Model(&models.TaskInstance{})
+	switch f.Scope {
+	case "assigned":
+		q = q.Where("task_instances.assignee_id = ?", userID)
+	case "candidate":
+		if len(groups) == 0 {
+			q = q.Where("FALSE")
+		} else {
+			q = candidateOf(q, groups)
+		}
+	case "", "all":
+		mine := db.Where("task_instances.assignee_id = ?", userID)
+		if len(groups) > 0 {
+			mine = mine.Or(candidateOf(db, groups))
+		}
+		q = q.Where(mine)
+	case "any":
+	default:
+		return nil, errors.New("Invalid scope")
+	}
+
+	statuses := f.Status
+	if len(statuses) == 0 {
+		statuses = []string{engine.TaskCreated, engine.TaskAssigned}
+	}
+	q = q.Where("task_instances.status IN ?", statuses)
+
+	if f.PriorityMin != nil {
+		q = q.Where("task_instances.priority >= ?", *f.PriorityMin)
+	}
+	if f.PriorityMax != nil {
+		q = q.Where("task_instances.priority <= ?", *f.PriorityMax)
+	}
+	if f.DueAfter != nil {
+		q = q.Where("task_instances.due_date >= ?", f.DueAfter.UTC())
+	}
+	if f.DueBefore != nil {
+		q = q.Where("task_instances.due_date < ?", f.DueBefore.UTC())
+	}
+	if f.AssigneeID != nil {
+		q = q.Where("task_instances.assignee_id = ?", *f.AssigneeID)
+	}
+	if f.ProcessKey != "" {
+		q = q.Joins("JOIN process_instances ON process_instances.id = task_instances.process_instance_id").
+			Joins("JOIN process_definitions ON process_definitions.id = process_instances.process_definition_id").
+			Where("process_definitions.key = ?", f.ProcessKey)
+	}
+	if f.Text != "" {
+		q = q.Where("task_instances.name ILIKE ?", "%"+escapeLike(f.Text)+"%")
+	}
+	return q, nil
+}
+
+// List tasks
+// @Summary List tasks
+// @Description The caller's task inbox: tasks assigned to them and unassigned tasks offered to one of their roles or process groups, with cursor pagination
+// @Tags tasks
+// @Produce json
+// @Param scope query string false "assigned, candidate or all (default)"
+// @Param status query string false "Comma-separated task statuses (default created,assigned)"
+// @Param priority_min query int false "Minimum priority"
+// @Param priority_max query int false "Maximum priority"
+// @Param due_after query string false "Due on or after (RFC 3339)"
+// @Param due_before query string false "Due before (RFC 3339)"
+// @Param process_key query string false "Process definition key"
+// @Param q query string false "Text contained in the task name"
+// @Param assignee_id query string false "Assignee"
+// @Param sort query string false "created_at (default), due_date, priority or name"
+// @Param order query string false "asc or desc (default)"
+// @Param limit query int false "Page size (default 50, at most 200)"
+// @Param cursor query string false "Cursor returned with the previous page"
+// @Success 200 {object} map[string]interface{}
+// @Failure 401 {object} map[string]interface{}
+// @Router /tasks [get]
+func (h *handler) listTasks(c *gin.Context) {
+	userID, ok := requireUser(c)
+	if !ok {
+		return
+	}
+
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "50"))
+	if err != nil || limit < 1 || limit > 200 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid limit"})
+		return
+	}
+	sortBy := c.DefaultQuery("sort", "created_at")
+	nullable, known := taskSortColumns[sortBy]
+	if !known {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid sort"})
+		return
+	}
+	order := c.DefaultQuery("order", "desc")
+	if order != "asc" && order != "desc" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid order"})
+		return
+	}
+
+	groups, err := userGroups(h.db, userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	filter, err := taskFilterFromQuery(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if filter.Scope == "any" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid scope"})
+		return
+	}
+	q, err := filter.apply(h.db, userID, groups)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if raw := c.Query("cursor"); raw != "" {
+		cursor, err := decodeTaskCursor(raw, sortBy)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid cursor"})
+			return
+		}
+		q = q.Where(afterCursor(h.db, "task_instances."+sortBy, order, nullable, cursor))
+	}
+
+	nulls := ""
+	if nullable {
+		nulls = " NULLS LAST"
+	}
+	var tasks []models.TaskInstance
+	err = q.Preload("Assignee").Preload("OriginalAssignee").
+		Order(fmt.Sprintf("task_instances.%s %s%s, task_instances.id %s", sortBy, order, nulls, order)).
+		Limit(limit + 1).
+		Find(&tasks).Error
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	var next *string
+	if len(tasks) > limit {
+		tasks = tasks[:limit]
+		cursor := encodeTaskCursor(tasks[limit-1], sortBy)
+		next = &cursor
+	}
+	c.JSON(http.StatusOK, gin.H{"data": tasks, "next_cursor": next, "has_more": next != nil})
+}
+
+// afterCursor selects the rows that sort after the cursor, by column and then ID.
+// NULL values sort last in both directions.
+func afterCursor(db *gorm.DB, column, order string, nullable bool, cursor taskCursor) *gorm.DB {
+	op := ">"
+	if order == "desc" {
+		op = "<"
+	}
+	if cursor.Value == nil {
+		return db.Where(column+" IS NULL AND task_instances.id "+op+" ?", cursor.ID)
+	}
+	after := db.Where(column+" "+op+" ?", cursor.Value).
+		Or(column+" = ? AND task_instances.id "+op+" ?", cursor.Value, cursor.ID)
+	if nullable {
+		after = after.Or(column + " IS NULL")
+	}
+	return after
+}
+
+// encodeTaskCursor returns the cursor positioned after the given task
+func encodeTaskCursor(task models.TaskInstance, sortBy string) string {
+	cursor := taskCursor{ID: task.ID}
+	switch sortBy {
+	case "created_at":
+		cursor.Value = task.CreatedAt.UTC().Format(time.RFC3339Nano)
+	case "due_date":
+		if task.DueDate != nil {
+			cursor.Value = task.DueDate.UTC().Format(time.RFC3339Nano)
+		}
+	case "priority":
+		cursor.Value = task.Priority
+	case "name":
+		cursor.Value = task.Name
+	}
+	data, _ := json.Marshal(cursor)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// decodeTaskCursor parses a cursor returned by encodeTaskCursor for the same sort column
+func decodeTaskCursor(raw, sortBy string) (taskCursor, error) {
+	var cursor taskCursor
+	data, err := base64.RawURLEncoding.DecodeString(raw)
+	if err != nil {
+		return cursor, err
+	}
+	if err := json.Unmarshal(data, &cursor); err != nil {
+		return cursor, err
+	}
+	if cursor.ID == uuid.Nil {
+		return cursor, fmt.Errorf("cursor has no task ID")
+	}
+	switch v := cursor.Value.(type) {
+	case nil:
+		if !taskSortColumns[sortBy] {
+			return cursor, fmt.Errorf("cursor has no %s", sortBy)
+		}
+	case float64:
+		if sortBy != "priority" {
+			return cursor, fmt.Errorf("cursor does not match sort %s", sortBy)
+		}
+		cursor.Value = int(v)
+	case string:
+		if sortBy == "priority" {
+			return cursor, fmt.Errorf("cursor does not match sort %s", sortBy)
+		}
+		if sortBy != "name" {
+			at, err := time.Parse(time.RFC3339Nano, v)
+			if err != nil {
+				return cursor, err
+			}
+			cursor.Value = at
+		}
+	default:
+		return cursor, fmt.Errorf("invalid cursor value")
+	}
+	return cursor, nil
+}
+
+// escapeLike escapes the wildcard characters of a LIKE pattern
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
+
+// taskAssigneeRequest names the user a task is delegated or reassigned to
+type taskAssigneeRequest struct {
+	UserID *uuid.UUID `json:"user_id"`
+}
+
+// Claim task
+// @Summary Claim task
+// @Description Assign an unassigned task to the caller, who must belong to one of its candidate groups. Only one of several concurrent claims succeeds.
+// @Tags tasks
+// @Produce json
+// @Param id path string true "Task ID"
+// @Success 200 {object} models.TaskInstance
+// @Failure 403 {object} map[string]interface{}
+// @Failure 409 {object} map[string]interface{}
+// @Router /tasks/{id}/claim [post]
+func (h *handler) claimTask(c *gin.Context) {
+	id, ok := parseID(c, "id")
+	if !ok {
+		return
+	}
+	userID, ok := requireUser(c)
+	if !ok {
+		return
+	}
+
+	groups, err := userGroups(h.db, userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	task, err := h.engine.ClaimTask(c.Request.Context(), id, userID, groups)
+	if err != nil {
+		respondEngineError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, task)
+}
+
+// Unclaim task
+// @Summary Unclaim task
+// @Description Release a task claimed by the caller back to its candidate groups
+// @Tags tasks
+// @Produce json
+// @Param id path string true "Task ID"
+// @Success 200 {object} models.TaskInstance
+// @Failure 403 {object} map[string]interface{}
+// @Failure 409 {object} map[string]interface{}
+// @Router /tasks/{id}/unclaim [post]
+func (h *handler) unclaimTask(c *gin.Context) {
+	id, ok := parseID(c, "id")
+	if !ok {
+		return
+	}
+	userID, ok := requireUser(c)
+	if !ok {
+		return
+	}
+
+	task, err := h.engine.UnclaimTask(c.Request.Context(), id, userID)
+	if err != nil {
+		respondEngineError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, task)
+}
+
+// Delegate task
+// @Summary Delegate task
+// @Description Hand a task assigned to the caller over to another user. The caller stays its owner and gets it back once the delegate resolves it.
+// @Tags tasks
+// @Accept json
+// @Produce json
+// @Param id path string true "Task ID"
+// @Success 200 {object} models.TaskInstance
+// @Failure 403 {object} map[string]interface{}
+// @Failure 409 {object} map[string]interface{}
+// @Router /tasks/{id}/delegate [post]
+func (h *handler) delegateTask(c *gin.Context) {
+	id, ok := parseID(c, "id")
+	if !ok {
+		return
+	}
+	userID, ok := requireUser(c)
+	if !ok {
+		return
+	}
+
+	var req taskAssigneeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.UserID == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "user_id is required"})
+		return
+	}
+	if *req.UserID == userID {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "A task cannot be delegated to its assignee"})
+		return
+	}
+	if !h.activeUser(c, *req.UserID) {
+		return
+	}
+
+	task, err := h.engine.DelegateTask(c.Request.Context(), id, userID, *req.UserID)
+	if err != nil {
+		respondEngineError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, task)
+}
+
+// Resolve task
+// @Summary Resolve task
+// @Description Return a task delegated to the caller to its owner
+// @Tags tasks
+// @Produce json
+// @Param id path string true "Task ID"
+// @Success 200 {object} models.TaskInstance
+// @Failure 403 {object} map[string]interface{}
+// @Failure 409 {object} map[string]interface{}
+// @Router /tasks/{id}/resolve [post]
+func (h *handler) resolveTask(c *gin.Context) {
+	id, ok := parseID(c, "id")
+	if !ok {
+		return
+	}
+	userID, ok := requireUser(c)
+	if !ok {
+		return
+	}
+
+	task, err := h.engine.ResolveTask(c.Request.Context(), id, userID)
+	if err != nil {
+		respondEngineError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, task)
+}
+
+// Reassign task
+// @Summary Reassign task
+// @Description Assign an open task to another user whoever holds it, or return it to its candidate groups when user_id is null. A pending delegation is dropped. Only team leads can reassign tasks.
+// @Tags tasks
+// @Accept json
+// @Produce json
+// @Param id path string true "Task ID"
+// @Success 200 {object} models.TaskInstance
+// @Failure 403 {object} map[string]interface{}
+// @Failure 404 {object} map[string]interface{}
+// @Failure 409 {object} map[string]interface{}
+// @Router /tasks/{id}/assign [post]
+func (h *handler) assignTask(c *gin.Context) {
+	id, ok := parseID(c, "id")
+	if !ok {
+		return
+	}
+	userID, ok := requireUser(c)
+	if !ok {
+		return
+	}
+
+	lead, err := hasRole(h.db, userID, leadRoles...)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if !lead {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Only team leads can reassign tasks"})
+		return
+	}
+
+	var req taskAssigneeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.UserID != nil && !h.activeUser(c, *req.UserID) {
+		return
+	}
+
+	task, err := h.engine.ReassignTask(c.Request.Context(), id, req.UserID, &userID)
+	if err != nil {
+		respondEngineError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, task)
+}
+
+// completeTaskRequest is the payload accepted when completing a task
+type completeTaskRequest struct {
+	FormData map[string]interface{} `json:"form_data"`
+}
+
+// Complete task
+// @Summary Complete task
+// @Description Validate the submitted form against the form schema the task is bound to, store it, merge the declared fields into the process variables and continue the process
+// @Tags tasks
+// @Accept json
+// @Produce json
+// @Param id path string true "Task ID"
+// @Success 200 {object} models.TaskInstance
+// @Failure 403 {object} map[string]interface{}
+// @Failure 404 {object} map[string]interface{}
+// @Failure 409 {object} map[string]interface{}
+// @Failure 422 {object} map[string]interface{}
+// @Router /tasks/{id}/complete [post]
+func (h *handler) completeTask(c *gin.Context) {
+	id, ok := parseID(c, "id")
+	if !ok {
+		return
+	}
+
+	userID, ok := requireUser(c)
+	if !ok {
+		return
+	}
+
+	var req completeTaskRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	groups, err := userGroups(h.db, userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	task, err := h.engine.CompleteTask(c.Request.Context(), id, engine.CompleteOptions{
+		FormData:    req.FormData,
+		CompletedBy: &userID,
+		Groups:      groups,
+	})
+	if err != nil {
+		respondEngineError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, task)
+}
+
+// activeUser checks that a task can be given to the user, writing a 422 response if not
+func (h *handler) activeUser(c *gin.Context, id uuid.UUID) bool {
+	var user models.User
+	err := h.db.Select("id", "is_active").First(&user, "id = ?", id).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) || (err == nil && !user.IsActive) {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "User not found or inactive"})
+		return false
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return false
+	}
+	return true
+}
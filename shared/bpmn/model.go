@@ -0,0 +1,315 @@
+package bpmn
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ElementType identifies the kind of a BPMN flow node
+type ElementType string
+
+// Supported flow node types
+const (
+	StartEvent       ElementType = "startEvent"
+	EndEvent         ElementType = "endEvent"
+	Task             ElementType = "task"
+	UserTask         ElementType = "userTask"
+	ServiceTask      ElementType = "serviceTask"
+	ScriptTask       ElementType = "scriptTask"
+	ExclusiveGateway ElementType = "exclusiveGateway"
+	ParallelGateway  ElementType = "parallelGateway"
+	InclusiveGateway ElementType = "inclusiveGateway"
+	SubProcess       ElementType = "subProcess"
+
+	IntermediateCatchEvent ElementType = "intermediateCatchEvent"
+	BoundaryEvent          ElementType = "boundaryEvent"
+	ReceiveTask            ElementType = "receiveTask"
+	CallActivity           ElementType = "callActivity"
+	IntermediateThrowEvent ElementType = "intermediateThrowEvent"
+)
+
+// EventDefinition identifies what triggers an event; events without a definition are "none" events
+type EventDefinition string
+
+// Supported event definitions
+const (
+	NoneEvent       EventDefinition = ""
+	TimerEvent      EventDefinition = "timer"
+	MessageEvent    EventDefinition = "message"
+	SignalEvent     EventDefinition = "signal"
+	ErrorEvent      EventDefinition = "error"
+	CompensateEvent EventDefinition = "compensate"
+	EscalationEvent EventDefinition = "escalation"
+)
+
+// TimerDefinition holds the ISO 8601 value of a timer event. Exactly one of Date,
+// Duration and Cycle is set; values may also be ${...} expressions evaluated against
+// the instance variables. Calendar, when set, counts durations in business time.
+type TimerDefinition struct {
+	Date     string
+	Duration string
+	Cycle    string
+	Calendar string
+}
+
+// IsGateway reports whether the type is one of the gateway types
+func (t ElementType) IsGateway() bool {
+	return t == ExclusiveGateway || t == ParallelGateway || t == InclusiveGateway
+}
+
+// IsActivity reports whether the type is a task or a sub-process
+func (t ElementType) IsActivity() bool {
+	switch t {
+	case Task, UserTask, ServiceTask, ScriptTask, ReceiveTask, SubProcess, CallActivity:
+		return true
+	}
+	return false
+}
+
+// Definitions is the parsed content of a BPMN document
+type Definitions struct {
+	ID        string
+	Processes []*Process
+}
+
+// Process is a container of flow nodes and sequence flows.
+// Embedded sub-processes are represented by a nested Process whose Parent is the sub-process node.
+type Process struct {
+	ID           string
+	Name         string
+	IsExecutable bool
+	Parent       *Node
+
+	Nodes []*Node
+	Flows []*SequenceFlow
+
+	nodes map[string]*Node
+	flows map[string]*SequenceFlow
+}
+
+// Node is a flow node (event, activity or gateway) of a process
+type Node struct {
+	ID      string
+	Name    string
+	Type    ElementType
+	Process *Process
+
+	Incoming []*SequenceFlow
+	Outgoing []*SequenceFlow
+
+	// Gateways and activities may declare a default outgoing flow
+	DefaultFlow string
+
+	// Event trigger. Message and signal triggers hold the resolved message or signal name;
+	// receive tasks use Message for the message they wait for.
+	Event   EventDefinition
+	Timer   *TimerDefinition
+	Message string
+	Signal  string
+
+	// Error events: the error code thrown or caught (empty catches every error) and
+	// the variables a catching boundary event stores the error in
+	ErrorCode            string
+	ErrorCodeVariable    string
+	ErrorMessageVariable string
+
+	// Escalation events: the escalation code caught (empty catches every escalation)
+	EscalationCode string
+
+	// Compensation: the activity a throw event compensates (empty for all of its scope),
+	// the handler a compensation boundary event is associated with, and whether an
+	// activity is such a handler
+	ActivityRef            string
+	CompensationHandlerRef string
+	CompensationHandler    *Node
+	IsForCompensation      bool
+
+	// Boundary events and the activities they are attached to
+	AttachedToRef  string
+	AttachedTo     *Node
+	CancelActivity bool
+	Boundaries     []*Node
+
+	// User task assignment, scheduling and form binding. FormVersion pins the
+	// version of the form, 0 binding the latest one. Calendar, when set, counts
+	// due and follow-up durations in business time: "business" selects the calendar
+	// assigned to the process, any other value names a calendar.
+	Assignee        string
+	CandidateGroups string
+	FormKey         string
+	FormVersion     int
+	DueDate         string
+	FollowUpDate    string
+	Calendar        string
+
+	// Automatic assignment of a user task offered to candidate groups: the name
+	// of the strategy that picks a candidate and its parameters, which may be
+	// ${...} expressions
+	AssignmentStrategy   string
+	AssignmentParameters map[string]string
+
+	// Loop characteristics of a multi-instance activity
+	MultiInstance *MultiInstance
+
+	// Service and script task execution. A failing service handler is retried
+	// Retries more times, RetryBackoff (an ISO 8601 duration) apart.
+	Implementation string
+	Retries        int
+	RetryBackoff   string
+	ScriptFormat   string
+	Script         string
+	ResultVariable string
+
+	// Called process of a call activity, by key and optionally version, and the
+	// variables passed into and out of it
+	CalledElement        string
+	CalledElementVersion int
+	Inputs               []VariableMapping
+	Outputs              []VariableMapping
+
+	// Embedded sub-process content
+	SubProcess *Process
+}
+
+// MultiInstance describes how an activity is repeated. The number of iterations
+// comes from Cardinality or from the items of Collection, each of which is exposed
+// to its iteration as ElementVariable. When OutputCollection is set, OutputElement
+// is evaluated as each iteration completes and the results are gathered, in loop
+// order, into a list variable of that name.
+type MultiInstance struct {
+	Sequential          bool
+	Cardinality         string
+	Collection          string
+	ElementVariable     string
+	CompletionCondition string
+	OutputCollection    string
+	OutputElement       string
+}
+
+// VariableMapping copies a variable, or the result of an expression, into a
+// variable of another scope. All copies every variable as is.
+type VariableMapping struct {
+	Source           string
+	SourceExpression string
+	Target           string
+	All              bool
+}
+
+// SequenceFlow connects two flow nodes of the same process
+type SequenceFlow struct {
+	ID        string
+	Name      string
+	SourceRef string
+	TargetRef string
+	Condition string
+
+	Source *Node
+	Target *Node
+}
+
+// ExecutableProcess returns the process the engine should run: the first
+// process marked isExecutable, or the first process if none is marked.
+func (d *Definitions) ExecutableProcess() *Process {
+	for _, p := range d.Processes {
+		if p.IsExecutable {
+			return p
+		}
+	}
+	if len(d.Processes) > 0 {
+		return d.Processes[0]
+	}
+	return nil
+}
+
+// Node looks up a flow node by ID in every process and nested sub-process
+func (d *Definitions) Node(id string) *Node {
+	for _, p := range d.Processes {
+		if n := p.FindNode(id); n != nil {
+			return n
+		}
+	}
+	return nil
+}
+
+// Node returns the flow node with the given ID declared directly in this process
+func (p *Process) Node(id string) *Node {
+	return p.nodes[id]
+}
+
+// Flow returns the sequence flow with the given ID declared directly in this process
+func (p *Process) Flow(id string) *SequenceFlow {
+	return p.flows[id]
+}
+
+// FindNode looks up a flow node by ID in this process and its nested sub-processes
+func (p *Process) FindNode(id string) *Node {
+	if n, ok := p.nodes[id]; ok {
+		return n
+	}
+	for _, n := range p.Nodes {
+		if n.SubProcess != nil {
+			if found := n.SubProcess.FindNode(id); found != nil {
+				return found
+			}
+		}
+	}
+	return nil
+}
+
+// StartEvents returns the start events declared directly in this process
+func (p *Process) StartEvents() []*Node {
+	var starts []*Node
+	for _, n := range p.Nodes {
+		if n.Type == StartEvent {
+			starts = append(starts, n)
+		}
+	}
+	return starts
+}
+
+// NoneStartEvent returns the start event used when an instance is started explicitly
+func (p *Process) NoneStartEvent() *Node {
+	for _, n := range p.StartEvents() {
+		if n.Event == NoneEvent {
+			return n
+		}
+	}
+	return nil
+}
+
+// Default returns the node's default outgoing flow, if one is declared
+func (n *Node) Default() *SequenceFlow {
+	if n.DefaultFlow == "" {
+		return nil
+	}
+	for _, f := range n.Outgoing {
+		if f.ID == n.DefaultFlow {
+			return f
+		}
+	}
+	return nil
+}
+
+// ValidationError describes a problem with a BPMN document, pointing at the offending element
+type ValidationError struct {
+	ElementID string `json:"element_id,omitempty"`
+	Message   string `json:"message"`
+}
+
+func (e ValidationError) Error() string {
+	if e.ElementID == "" {
+		return e.Message
+	}
+	return fmt.Sprintf("%s: %s", e.ElementID, e.Message)
+}
+
+// ValidationErrors is the list of problems found in a BPMN document
+type ValidationErrors []ValidationError
+
+func (e ValidationErrors) Error() string {
+	msgs := make([]string, len(e))
+	for i, err := range e {
+		msgs[i] = err.Error()
+	}
+	return "invalid BPMN: " + strings.Join(msgs, "; ")
+}
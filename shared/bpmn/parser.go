@@ -0,0 +1,485 @@
+package bpmn
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// xmlElement is a generic XML element. BPMN documents are decoded into this
+// tree first so that element order and vendor extension attributes are preserved.
+type xmlElement struct {
+	XMLName  xml.Name
+	Attrs    []xml.Attr   `xml:",any,attr"`
+	Content  string       `xml:",chardata"`
+	Children []xmlElement `xml:",any"`
+}
+
+// attr returns the value of the attribute with the given local name, ignoring its namespace
+func (e *xmlElement) attr(name string) string {
+	for _, a := range e.Attrs {
+		if a.Name.Local == name {
+			return strings.TrimSpace(a.Value)
+		}
+	}
+	return ""
+}
+
+// child returns the first child element with the given local name
+func (e *xmlElement) child(name string) *xmlElement {
+	for i := range e.Children {
+		if e.Children[i].XMLName.Local == name {
+			return &e.Children[i]
+		}
+	}
+	return nil
+}
+
+// text returns the trimmed character data of the element
+func (e *xmlElement) text() string {
+	return strings.TrimSpace(e.Content)
+}
+
+// ignoredElements are process children that carry no execution semantics
+var ignoredElements = map[string]bool{
+	"documentation":       true,
+	"extensionElements":   true,
+	"laneSet":             true,
+	"textAnnotation":      true,
+	"dataObject":          true,
+	"dataObjectReference": true,
+	"dataStoreReference":  true,
+	"ioSpecification":     true,
+	"property":            true,
+}
+
+// Parse reads a BPMN 2.0 XML document into an in-memory graph and validates it.
+// On failure the returned error is a ValidationErrors listing every problem found.
+func Parse(data []byte) (*Definitions, error) {
+	var root xmlElement
+	decoder := xml.NewDecoder(bytes.NewReader(data))
+	if err := decoder.Decode(&root); err != nil {
+		return nil, ValidationErrors{{Message: fmt.Sprintf("malformed XML: %v", err)}}
+	}
+	if root.XMLName.Local != "definitions" {
+		return nil, ValidationErrors{{Message: fmt.Sprintf("root element must be definitions, got %s", root.XMLName.Local)}}
+	}
+
+	b := &builder{ids: make(map[string]bool)}
+	defs := b.build(&root)
+
+	errs := append(b.errs, Validate(defs)...)
+	if len(errs) > 0 {
+		return defs, errs
+	}
+	return defs, nil
+}
+
+// ParseString is a convenience wrapper around Parse for XML stored as text
+func ParseString(data string) (*Definitions, error) {
+	return Parse([]byte(data))
+}
+
+// builder turns the generic XML tree into the typed graph, collecting
+// structural errors that can only be detected while resolving references.
+type builder struct {
+	ids  map[string]bool
+	errs ValidationErrors
+
+	// Root-level message, signal, error and escalation declarations, by ID
+	messages    map[string]string
+	signals     map[string]string
+	errors      map[string]string
+	escalations map[string]string
+}
+
+func (b *builder) errorf(id, format string, args ...interface{}) {
+	b.errs = append(b.errs, ValidationError{ElementID: id, Message: fmt.Sprintf(format, args...)})
+}
+
+func (b *builder) build(root *xmlElement) *Definitions {
+	defs := &Definitions{ID: root.attr("id")}
+	b.messages = b.declarations(root, "message")
+	b.signals = b.declarations(root, "signal")
+	b.errors = b.declarations(root, "error")
+	b.escalations = b.declarations(root, "escalation")
+	for i := range root.Children {
+		// Errors and escalations are referred to by their code
+		el := &root.Children[i]
+		if code := el.attr("errorCode"); el.XMLName.Local == "error" && code != "" {
+			b.errors[el.attr("id")] = code
+		}
+		if code := el.attr("escalationCode"); el.XMLName.Local == "escalation" && code != "" {
+			b.escalations[el.attr("id")] = code
+		}
+	}
+	for i := range root.Children {
+		el := &root.Children[i]
+		if el.XMLName.Local != "process" {
+			continue
+		}
+		p := b.process(el, nil)
+		p.IsExecutable = el.attr("isExecutable") == "true"
+		defs.Processes = append(defs.Processes, p)
+	}
+	return defs
+}
+
+// declarations collects the names of root-level message or signal elements by ID.
+// An element without a name is referred to by its ID.
+func (b *builder) declarations(root *xmlElement, kind string) map[string]string {
+	names := make(map[string]string)
+	for i := range root.Children {
+		el := &root.Children[i]
+		if el.XMLName.Local != kind {
+			continue
+		}
+		id, ok := b.register(el)
+		if !ok {
+			continue
+		}
+		names[id] = el.attr("name")
+		if names[id] == "" {
+			names[id] = id
+		}
+	}
+	return names
+}
+
+// reference resolves a messageRef or signalRef to the declared name
+func (b *builder) reference(n *Node, kind string, names map[string]string, ref string) string {
+	if ref == "" {
+		b.errorf(n.ID, "%s reference is missing", kind)
+		return ""
+	}
+	name, ok := names[ref]
+	if !ok {
+		b.errorf(n.ID, "%sRef %q does not reference a declared %s", kind, ref, kind)
+	}
+	return name
+}
+
+// register records an element ID and reports whether it is usable
+func (b *builder) register(el *xmlElement) (string, bool) {
+	id := el.attr("id")
+	if id == "" {
+		b.errorf("", "%s element is missing an id", el.XMLName.Local)
+		return "", false
+	}
+	if b.ids[id] {
+		b.errorf(id, "duplicate element id")
+		return id, false
+	}
+	b.ids[id] = true
+	return id, true
+}
+
+func (b *builder) process(el *xmlElement, parent *Node) *Process {
+	p := &Process{
+		ID:     el.attr("id"),
+		Name:   el.attr("name"),
+		Parent: parent,
+		nodes:  make(map[string]*Node),
+		flows:  make(map[string]*SequenceFlow),
+	}
+	if parent == nil {
+		b.register(el)
+	}
+
+	var associations []*xmlElement
+	for i := range el.Children {
+		child := &el.Children[i]
+		name := child.XMLName.Local
+		if ignoredElements[name] {
+			continue
+		}
+
+		if name == "association" {
+			associations = append(associations, child)
+			continue
+		}
+
+		if name == "sequenceFlow" {
+			id, ok := b.register(child)
+			if !ok {
+				continue
+			}
+			flow := &SequenceFlow{
+				ID:        id,
+				Name:      child.attr("name"),
+				SourceRef: child.attr("sourceRef"),
+				TargetRef: child.attr("targetRef"),
+			}
+			if cond := child.child("conditionExpression"); cond != nil {
+				flow.Condition = cond.text()
+			}
+			p.Flows = append(p.Flows, flow)
+			p.flows[id] = flow
+			continue
+		}
+
+		node := b.node(child, p)
+		if node == nil {
+			continue
+		}
+		p.Nodes = append(p.Nodes, node)
+		p.nodes[node.ID] = node
+	}
+
+	// Resolve sequence flow endpoints within this scope
+	for _, f := range p.Flows {
+		if f.SourceRef == "" {
+			b.errorf(f.ID, "sequence flow has no sourceRef")
+		} else if f.Source = p.nodes[f.SourceRef]; f.Source == nil {
+			b.errorf(f.ID, "sourceRef %q does not reference a flow node in the same process", f.SourceRef)
+		}
+		if f.TargetRef == "" {
+			b.errorf(f.ID, "sequence flow has no targetRef")
+		} else if f.Target = p.nodes[f.TargetRef]; f.Target == nil {
+			b.errorf(f.ID, "targetRef %q does not reference a flow node in the same process", f.TargetRef)
+		}
+		if f.Source != nil && f.Target != nil {
+			f.Source.Outgoing = append(f.Source.Outgoing, f)
+			f.Target.Incoming = append(f.Target.Incoming, f)
+		}
+	}
+
+	// Attach boundary events to their activities
+	for _, n := range p.Nodes {
+		if n.Type != BoundaryEvent {
+			continue
+		}
+		switch n.AttachedTo = p.nodes[n.AttachedToRef]; {
+		case n.AttachedToRef == "":
+			b.errorf(n.ID, "boundary event has no attachedToRef")
+		case n.AttachedTo == nil:
+			b.errorf(n.ID, "attachedToRef %q does not reference a flow node in the same process", n.AttachedToRef)
+		default:
+			n.AttachedTo.Boundaries = append(n.AttachedTo.Boundaries, n)
+		}
+	}
+
+	// Associations link compensation boundary events to their handlers
+	for _, a := range associations {
+		source := p.nodes[a.attr("sourceRef")]
+		if source == nil || source.Event != CompensateEvent || source.Type != BoundaryEvent {
+			continue
+		}
+		source.CompensationHandlerRef = a.attr("targetRef")
+		source.CompensationHandler = p.nodes[source.CompensationHandlerRef]
+	}
+
+	return p
+}
+
+func (b *builder) node(el *xmlElement, p *Process) *Node {
+	elementType := ElementType(el.XMLName.Local)
+	switch elementType {
+	case StartEvent, EndEvent, Task, UserTask, ServiceTask, ScriptTask,
+		ExclusiveGateway, ParallelGateway, InclusiveGateway, SubProcess,
+		IntermediateCatchEvent, IntermediateThrowEvent, BoundaryEvent, ReceiveTask, CallActivity:
+	default:
+		b.errorf(el.attr("id"), "unsupported element type %s", el.XMLName.Local)
+		return nil
+	}
+
+	id, ok := b.register(el)
+	if !ok {
+		return nil
+	}
+
+	n := &Node{
+		ID:          id,
+		Name:        el.attr("name"),
+		Type:        elementType,
+		Process:     p,
+		DefaultFlow: el.attr("default"),
+
+		IsForCompensation: el.attr("isForCompensation") == "true",
+	}
+
+	b.eventDefinition(el, n)
+	if loop := el.child("multiInstanceLoopCharacteristics"); loop != nil {
+		n.MultiInstance = multiInstance(loop)
+	}
+
+	switch elementType {
+	case BoundaryEvent:
+		n.AttachedToRef = el.attr("attachedToRef")
+		n.CancelActivity = el.attr("cancelActivity") != "false"
+	case UserTask:
+		n.Assignee = el.attr("assignee")
+		n.CandidateGroups = el.attr("candidateGroups")
+		n.FormKey = el.attr("formKey")
+		if version := el.attr("formVersion"); version != "" && version != "latest" {
+			v, err := strconv.Atoi(version)
+			if err != nil || v < 1 {
+				b.errorf(id, "formVersion %q is not a version number or \"latest\"", version)
+			}
+			n.FormVersion = v
+		}
+		n.DueDate = el.attr("dueDate")
+		n.FollowUpDate = el.attr("followUpDate")
+		n.Calendar = el.attr("calendar")
+		assignmentStrategy(el, n)
+	case ServiceTask:
+		n.Implementation = el.attr("implementation")
+		if n.Implementation == "" {
+			n.Implementation = el.attr("type")
+		}
+		if retries := el.attr("retries"); retries != "" {
+			v, err := strconv.Atoi(retries)
+			if err != nil || v < 0 {
+				b.errorf(id, "retries %q is not a non-negative number", retries)
+			}
+			n.Retries = v
+		}
+		n.RetryBackoff = el.attr("retryBackoff")
+	case ReceiveTask:
+		n.Message = b.reference(n, "message", b.messages, el.attr("messageRef"))
+	case ScriptTask:
+		n.ScriptFormat = el.attr("scriptFormat")
+		n.ResultVariable = el.attr("resultVariable")
+		if script := el.child("script"); script != nil {
+			n.Script = script.text()
+		}
+	case CallActivity:
+		n.CalledElement = el.attr("calledElement")
+		if version := el.attr("calledElementVersion"); version != "" {
+			v, err := strconv.Atoi(version)
+			if err != nil || v < 1 {
+				b.errorf(id, "calledElementVersion %q is not a version number", version)
+			}
+			n.CalledElementVersion = v
+		}
+		if ext := el.child("extensionElements"); ext != nil {
+			for i := range ext.Children {
+				child := &ext.Children[i]
+				mapping := VariableMapping{
+					Source:           child.attr("source"),
+					SourceExpression: child.attr("sourceExpression"),
+					Target:           child.attr("target"),
+					All:              child.attr("variables") == "all",
+				}
+				switch child.XMLName.Local {
+				case "in":
+					n.Inputs = append(n.Inputs, mapping)
+				case "out":
+					n.Outputs = append(n.Outputs, mapping)
+				}
+			}
+		}
+	case SubProcess:
+		n.SubProcess = b.process(el, n)
+		n.SubProcess.ID = id
+		n.SubProcess.Name = n.Name
+	}
+
+	return n
+}
+
+// assignmentStrategy reads the automatic assignment of a user task, given as an
+// assignmentStrategy attribute or as an extension element with parameters:
+//
+//	<assignmentStrategy name="attribute-match">
+//	  <parameter name="department" value="${department}"/>
+//	</assignmentStrategy>
+func assignmentStrategy(el *xmlElement, n *Node) {
+	n.AssignmentStrategy = el.attr("assignmentStrategy")
+	ext := el.child("extensionElements")
+	if ext == nil {
+		return
+	}
+	strategy := ext.child("assignmentStrategy")
+	if strategy == nil {
+		return
+	}
+	if name := strategy.attr("name"); name != "" {
+		n.AssignmentStrategy = name
+	}
+	for i := range strategy.Children {
+		param := &strategy.Children[i]
+		if param.XMLName.Local != "parameter" {
+			continue
+		}
+		if n.AssignmentParameters == nil {
+			n.AssignmentParameters = make(map[string]string)
+		}
+		n.AssignmentParameters[param.attr("name")] = param.attr("value")
+	}
+}
+
+// multiInstance reads loop characteristics. Both the Camunda (collection,
+// elementVariable) and Zeebe (inputCollection, inputElement) attribute names are accepted.
+func multiInstance(el *xmlElement) *MultiInstance {
+	mi := &MultiInstance{
+		Sequential:       el.attr("isSequential") == "true",
+		Collection:       el.attr("collection"),
+		ElementVariable:  el.attr("elementVariable"),
+		OutputCollection: el.attr("outputCollection"),
+		OutputElement:    el.attr("outputElement"),
+	}
+	if mi.Collection == "" {
+		mi.Collection = el.attr("inputCollection")
+	}
+	if mi.ElementVariable == "" {
+		mi.ElementVariable = el.attr("inputElement")
+	}
+	if v := el.child("loopCardinality"); v != nil {
+		mi.Cardinality = v.text()
+	}
+	if v := el.child("completionCondition"); v != nil {
+		mi.CompletionCondition = v.text()
+	}
+	return mi
+}
+
+// eventDefinition reads the trigger of an event element
+func (b *builder) eventDefinition(el *xmlElement, n *Node) {
+	for i := range el.Children {
+		child := &el.Children[i]
+		switch child.XMLName.Local {
+		case "timerEventDefinition":
+			n.Event = TimerEvent
+			n.Timer = &TimerDefinition{Calendar: child.attr("calendar")}
+			if v := child.child("timeDate"); v != nil {
+				n.Timer.Date = v.text()
+			}
+			if v := child.child("timeDuration"); v != nil {
+				n.Timer.Duration = v.text()
+			}
+			if v := child.child("timeCycle"); v != nil {
+				n.Timer.Cycle = v.text()
+			}
+		case "messageEventDefinition":
+			n.Event = MessageEvent
+			n.Message = b.reference(n, "message", b.messages, child.attr("messageRef"))
+		case "signalEventDefinition":
+			n.Event = SignalEvent
+			n.Signal = b.reference(n, "signal", b.signals, child.attr("signalRef"))
+		case "errorEventDefinition":
+			n.Event = ErrorEvent
+			n.ErrorCodeVariable = child.attr("errorCodeVariable")
+			n.ErrorMessageVariable = child.attr("errorMessageVariable")
+			// A catching error event without a reference catches every error
+			if ref := child.attr("errorRef"); ref != "" || n.Type != BoundaryEvent {
+				n.ErrorCode = b.reference(n, "error", b.errors, ref)
+			}
+		case "escalationEventDefinition":
+			n.Event = EscalationEvent
+			// A catching escalation event without a reference catches every escalation
+			if ref := child.attr("escalationRef"); ref != "" || n.Type != BoundaryEvent {
+				n.EscalationCode = b.reference(n, "escalation", b.escalations, ref)
+			}
+		case "compensateEventDefinition":
+			n.Event = CompensateEvent
+			n.ActivityRef = child.attr("activityRef")
+		default:
+			if strings.HasSuffix(child.XMLName.Local, "EventDefinition") {
+				b.errorf(n.ID, "unsupported event definition %s", child.XMLName.Local)
+			}
+		}
+	}
+}
@@ -0,0 +1,515 @@
+package bpmn
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// document wraps declarations and the elements of an executable process into
+// a BPMN definitions element
+func document(declarations, process string) string {
+	return fmt.Sprintf(`<?xml version="1.0" encoding="UTF-8"?>
+<definitions xmlns="http://www.omg.org/spec/BPMN/20100524/MODEL" id="defs">
+  %s
+  <process id="order" name="Order" isExecutable="true">
+    %s
+  </process>
+</definitions>`, declarations, process)
+}
+
+const straightThrough = `
+<startEvent id="start"/>
+<sequenceFlow id="f1" sourceRef="start" targetRef="end"/>
+<endEvent id="end"/>`
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name         string
+		declarations string
+		process      string
+		wantErr      string // substring of the validation error, empty for a valid document
+	}{
+		{
+			name:    "start to end",
+			process: straightThrough,
+		},
+		{
+			name: "exclusive gateway with conditions and a default flow",
+			process: `
+<startEvent id="start"/>
+<sequenceFlow id="f1" sourceRef="start" targetRef="gw"/>
+<exclusiveGateway id="gw" default="f3"/>
+<sequenceFlow id="f2" sourceRef="gw" targetRef="big"><conditionExpression>${amount > 100}</conditionExpression></sequenceFlow>
+<sequenceFlow id="f3" sourceRef="gw" targetRef="small"/>
+<endEvent id="big"/>
+<endEvent id="small"/>`,
+		},
+		{
+			name:    "no start event",
+			process: `<endEvent id="end"/>`,
+			wantErr: "process has no start event",
+		},
+		{
+			name: "two none start events",
+			process: straightThrough + `
+<startEvent id="start2"/>
+<sequenceFlow id="f2" sourceRef="start2" targetRef="end"/>`,
+			wantErr: "more than one start event without an event definition",
+		},
+		{
+			name: "duplicate id",
+			process: straightThrough + `
+<endEvent id="end"/>`,
+			wantErr: "end: duplicate element id",
+		},
+		{
+			name: "unreachable node",
+			process: straightThrough + `
+<userTask id="orphan"/>`,
+			wantErr: "orphan: element is not reachable from a start event",
+		},
+		{
+			name: "dangling sequence flow",
+			process: `
+<startEvent id="start"/>
+<sequenceFlow id="f1" sourceRef="start" targetRef="missing"/>`,
+			wantErr: `targetRef "missing" does not reference a flow node`,
+		},
+		{
+			name: "unsupported element",
+			process: straightThrough + `
+<complexGateway id="cg"/>`,
+			wantErr: "unsupported element type complexGateway",
+		},
+		{
+			name: "default flow with a condition",
+			process: `
+<startEvent id="start"/>
+<sequenceFlow id="f1" sourceRef="start" targetRef="gw"/>
+<exclusiveGateway id="gw" default="f2"/>
+<sequenceFlow id="f2" sourceRef="gw" targetRef="end"><conditionExpression>${ok}</conditionExpression></sequenceFlow>
+<endEvent id="end"/>`,
+			wantErr: "default flow must not have a condition expression",
+		},
+		{
+			name: "condition after a parallel gateway",
+			process: `
+<startEvent id="start"/>
+<sequenceFlow id="f1" sourceRef="start" targetRef="gw"/>
+<parallelGateway id="gw"/>
+<sequenceFlow id="f2" sourceRef="gw" targetRef="end"><conditionExpression>${ok}</conditionExpression></sequenceFlow>
+<endEvent id="end"/>`,
+			wantErr: "sequence flows leaving a parallel gateway cannot have conditions",
+		},
+		{
+			name: "invalid condition",
+			process: `
+<startEvent id="start"/>
+<sequenceFlow id="f1" sourceRef="start" targetRef="gw"/>
+<exclusiveGateway id="gw"/>
+<sequenceFlow id="f2" sourceRef="gw" targetRef="end"><conditionExpression>${amount >}</conditionExpression></sequenceFlow>
+<endEvent id="end"/>`,
+			wantErr: "invalid expression",
+		},
+		{
+			name: "timer with two values",
+			process: `
+<startEvent id="start"><timerEventDefinition><timeDuration>PT1H</timeDuration><timeCycle>R/PT1H</timeCycle></timerEventDefinition></startEvent>
+<sequenceFlow id="f1" sourceRef="start" targetRef="end"/>
+<endEvent id="end"/>`,
+			wantErr: "exactly one of timeDate, timeDuration or timeCycle",
+		},
+		{
+			name: "timer with an invalid duration",
+			process: `
+<startEvent id="start"><timerEventDefinition><timeDuration>1 hour</timeDuration></timerEventDefinition></startEvent>
+<sequenceFlow id="f1" sourceRef="start" targetRef="end"/>
+<endEvent id="end"/>`,
+			wantErr: `start: invalid ISO 8601 duration "1 HOUR"`,
+		},
+		{
+			name: "undeclared message",
+			process: `
+<startEvent id="start"/>
+<sequenceFlow id="f1" sourceRef="start" targetRef="wait"/>
+<receiveTask id="wait" messageRef="paid"/>
+<sequenceFlow id="f2" sourceRef="wait" targetRef="end"/>
+<endEvent id="end"/>`,
+			wantErr: `messageRef "paid" does not reference a declared message`,
+		},
+		{
+			name: "boundary event on a gateway",
+			process: `
+<startEvent id="start"/>
+<sequenceFlow id="f1" sourceRef="start" targetRef="gw"/>
+<exclusiveGateway id="gw"/>
+<sequenceFlow id="f2" sourceRef="gw" targetRef="end"/>
+<boundaryEvent id="late" attachedToRef="gw"><timerEventDefinition><timeDuration>PT1H</timeDuration></timerEventDefinition></boundaryEvent>
+<sequenceFlow id="f3" sourceRef="late" targetRef="end"/>
+<endEvent id="end"/>`,
+			wantErr: "boundary event must be attached to an activity",
+		},
+		{
+			name:         "non-interrupting error boundary event",
+			declarations: `<error id="failed" errorCode="FAILED"/>`,
+			process: `
+<startEvent id="start"/>
+<sequenceFlow id="f1" sourceRef="start" targetRef="pay"/>
+<serviceTask id="pay" implementation="payment"/>
+<sequenceFlow id="f2" sourceRef="pay" targetRef="end"/>
+<boundaryEvent id="err" attachedToRef="pay" cancelActivity="false"><errorEventDefinition errorRef="failed"/></boundaryEvent>
+<sequenceFlow id="f3" sourceRef="err" targetRef="end"/>
+<endEvent id="end"/>`,
+			wantErr: "error boundary events must interrupt the activity",
+		},
+		{
+			name: "compensation handler not marked for compensation",
+			process: `
+<startEvent id="start"/>
+<sequenceFlow id="f1" sourceRef="start" targetRef="book"/>
+<serviceTask id="book" implementation="booking"/>
+<sequenceFlow id="f2" sourceRef="book" targetRef="end"/>
+<boundaryEvent id="undo" attachedToRef="book"><compensateEventDefinition/></boundaryEvent>
+<serviceTask id="cancel" implementation="cancel-booking"/>
+<association id="a1" sourceRef="undo" targetRef="cancel"/>
+<endEvent id="end"/>`,
+			wantErr: "compensation handler cancel must be marked isForCompensation",
+		},
+		{
+			name: "script task without a script",
+			process: `
+<startEvent id="start"/>
+<sequenceFlow id="f1" sourceRef="start" targetRef="calc"/>
+<scriptTask id="calc"/>
+<sequenceFlow id="f2" sourceRef="calc" targetRef="end"/>
+<endEvent id="end"/>`,
+			wantErr: "script task has no script",
+		},
+		{
+			name: "call activity without a called element",
+			process: `
+<startEvent id="start"/>
+<sequenceFlow id="f1" sourceRef="start" targetRef="call"/>
+<callActivity id="call"/>
+<sequenceFlow id="f2" sourceRef="call" targetRef="end"/>
+<endEvent id="end"/>`,
+			wantErr: "call activity has no calledElement",
+		},
+		{
+			name: "multi-instance with a cardinality and a collection",
+			process: `
+<startEvent id="start"/>
+<sequenceFlow id="f1" sourceRef="start" targetRef="review"/>
+<userTask id="review"><multiInstanceLoopCharacteristics collection="${reviewers}"><loopCardinality>3</loopCardinality></multiInstanceLoopCharacteristics></userTask>
+<sequenceFlow id="f2" sourceRef="review" targetRef="end"/>
+<endEvent id="end"/>`,
+			wantErr: "must not have both a loopCardinality and a collection",
+		},
+		{
+			name: "assignment strategy without candidate groups",
+			process: `
+<startEvent id="start"/>
+<sequenceFlow id="f1" sourceRef="start" targetRef="review"/>
+<userTask id="review" assignmentStrategy="round-robin"/>
+<sequenceFlow id="f2" sourceRef="review" targetRef="end"/>
+<endEvent id="end"/>`,
+			wantErr: `assignment strategy "round-robin" needs candidateGroups`,
+		},
+		{
+			name: "form version without a form key",
+			process: `
+<startEvent id="start"/>
+<sequenceFlow id="f1" sourceRef="start" targetRef="review"/>
+<userTask id="review" formVersion="2"/>
+<sequenceFlow id="f2" sourceRef="review" targetRef="end"/>
+<endEvent id="end"/>`,
+			wantErr: "formVersion requires a formKey",
+		},
+		{
+			name: "invalid form version",
+			process: `
+<startEvent id="start"/>
+<sequenceFlow id="f1" sourceRef="start" targetRef="review"/>
+<userTask id="review" formKey="review" formVersion="newest"/>
+<sequenceFlow id="f2" sourceRef="review" targetRef="end"/>
+<endEvent id="end"/>`,
+			wantErr: `formVersion "newest" is not a version number or "latest"`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParseString(document(tt.declarations, tt.process))
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+			var errs ValidationErrors
+			if !errors.As(err, &errs) {
+				t.Fatalf("got %v, want validation errors", err)
+			}
+			if !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("got %q, want an error containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidateReportsEveryProblemOfANode(t *testing.T) {
+	tests := []struct {
+		name    string
+		process string
+		element string
+		want    []string
+	}{
+		{
+			name: "boundary event",
+			process: `
+<startEvent id="start"/>
+<sequenceFlow id="f1" sourceRef="start" targetRef="gw"/>
+<exclusiveGateway id="gw"/>
+<sequenceFlow id="f2" sourceRef="gw" targetRef="end"/>
+<sequenceFlow id="f3" sourceRef="gw" targetRef="late"/>
+<boundaryEvent id="late" attachedToRef="gw"/>
+<sequenceFlow id="f4" sourceRef="late" targetRef="end"/>
+<endEvent id="end"/>`,
+			element: "late",
+			want: []string{
+				"boundary event has no event definition",
+				"boundary event must not have incoming sequence flows",
+				"boundary event must be attached to an activity",
+			},
+		},
+		{
+			name: "start event",
+			process: `
+<startEvent id="start"/>
+<endEvent id="end"/>
+<sequenceFlow id="f1" sourceRef="end" targetRef="start"/>`,
+			element: "start",
+			want: []string{
+				"start event must not have incoming sequence flows",
+				"start event has no outgoing sequence flow",
+			},
+		},
+		{
+			name: "multi-instance",
+			process: `
+<startEvent id="start"/>
+<sequenceFlow id="f1" sourceRef="start" targetRef="pay"/>
+<serviceTask id="pay" implementation="payment"><multiInstanceLoopCharacteristics outputElement="${result}"/></serviceTask>
+<sequenceFlow id="f2" sourceRef="pay" targetRef="end"/>
+<endEvent id="end"/>`,
+			element: "pay",
+			want: []string{
+				"multi-instance is only supported on user tasks",
+				"multi-instance needs a loopCardinality or a collection",
+				"multi-instance outputElement needs an outputCollection",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParseString(document("", tt.process))
+			var errs ValidationErrors
+			if !errors.As(err, &errs) {
+				t.Fatalf("got %v, want validation errors", err)
+			}
+			var got []string
+			for _, e := range errs {
+				if e.ElementID == tt.element {
+					got = append(got, e.Message)
+				}
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got problems %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseDocument(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		wantErr string
+	}{
+		{name: "malformed XML", data: "<definitions", wantErr: "malformed XML"},
+		{name: "wrong root element", data: "<process id=\"p\"/>", wantErr: "root element must be definitions, got process"},
+		{name: "no process", data: "<definitions id=\"defs\"/>", wantErr: "document does not contain a process"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParseString(tt.data)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("got %v, want an error containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// parse parses a process that must be valid
+func parse(t *testing.T, declarations, process string) *Process {
+	t.Helper()
+	defs, err := ParseString(document(declarations, process))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	p := defs.ExecutableProcess()
+	if p == nil || p.ID != "order" {
+		t.Fatalf("got process %v, want order", p)
+	}
+	return p
+}
+
+func TestParseGraph(t *testing.T) {
+	p := parse(t, "", `
+<startEvent id="start"/>
+<sequenceFlow id="f1" sourceRef="start" targetRef="review"/>
+<userTask id="review" formKey="review" formVersion="latest" candidateGroups="managers"/>
+<sequenceFlow id="f2" sourceRef="review" targetRef="end"/>
+<endEvent id="end"/>`)
+
+	review := p.Node("review")
+	switch {
+	case review == nil || review.Type != UserTask:
+		t.Fatalf("got %v, want the user task review", review)
+	case review.CandidateGroups != "managers":
+		t.Errorf("got candidate groups %q, want managers", review.CandidateGroups)
+	case review.FormKey != "review" || review.FormVersion != 0:
+		t.Errorf("got form %s version %d, want review at the latest version", review.FormKey, review.FormVersion)
+	case len(review.Incoming) != 1 || review.Incoming[0].Source.ID != "start":
+		t.Errorf("got incoming flows %v, want one from start", review.Incoming)
+	case len(review.Outgoing) != 1 || review.Outgoing[0].Target.ID != "end":
+		t.Errorf("got outgoing flows %v, want one to end", review.Outgoing)
+	}
+}
+
+func TestParseBoundaryEvents(t *testing.T) {
+	p := parse(t, "", `
+<startEvent id="start"/>
+<sequenceFlow id="f1" sourceRef="start" targetRef="review"/>
+<userTask id="review"/>
+<sequenceFlow id="f2" sourceRef="review" targetRef="end"/>
+<boundaryEvent id="late" attachedToRef="review" cancelActivity="false"><timerEventDefinition><timeDuration>PT4H</timeDuration></timerEventDefinition></boundaryEvent>
+<sequenceFlow id="f3" sourceRef="late" targetRef="end"/>
+<endEvent id="end"/>`)
+
+	if start := p.NoneStartEvent(); start == nil || start.ID != "start" {
+		t.Errorf("got none start event %v, want start", start)
+	}
+	review, late := p.Node("review"), p.Node("late")
+	if len(review.Boundaries) != 1 || review.Boundaries[0] != late || late.AttachedTo != review {
+		t.Errorf("got boundaries %v, want late attached to review", review.Boundaries)
+	}
+	if late.CancelActivity || late.Event != TimerEvent || late.Timer == nil || late.Timer.Duration != "PT4H" {
+		t.Errorf("got boundary event %+v, want a non-interrupting four hour timer", late)
+	}
+}
+
+func TestParseMessageReference(t *testing.T) {
+	p := parse(t, `<message id="msg-paid" name="PaymentReceived"/><message id="msg-shipped"/>`, `
+<startEvent id="start"/>
+<sequenceFlow id="f1" sourceRef="start" targetRef="paid"/>
+<intermediateCatchEvent id="paid"><messageEventDefinition messageRef="msg-paid"/></intermediateCatchEvent>
+<sequenceFlow id="f2" sourceRef="paid" targetRef="shipped"/>
+<receiveTask id="shipped" messageRef="msg-shipped"/>
+<sequenceFlow id="f3" sourceRef="shipped" targetRef="end"/>
+<endEvent id="end"/>`)
+
+	tests := []struct {
+		node string
+		want string
+	}{
+		{"paid", "PaymentReceived"},
+		{"shipped", "msg-shipped"}, // a message without a name is referred to by its ID
+	}
+	for _, tt := range tests {
+		if got := p.Node(tt.node).Message; got != tt.want {
+			t.Errorf("%s: got message %q, want %q", tt.node, got, tt.want)
+		}
+	}
+}
+
+func TestParseMultiInstance(t *testing.T) {
+	tests := []struct {
+		name            string
+		loop            string
+		wantSequential  bool
+		wantCollection  string
+		wantElement     string
+		wantCardinality string
+	}{
+		{
+			name:           "Camunda attributes",
+			loop:           `<multiInstanceLoopCharacteristics collection="${reviewers}" elementVariable="reviewer"/>`,
+			wantCollection: "${reviewers}",
+			wantElement:    "reviewer",
+		},
+		{
+			name:           "Zeebe attributes",
+			loop:           `<multiInstanceLoopCharacteristics isSequential="true" inputCollection="${reviewers}" inputElement="reviewer"/>`,
+			wantSequential: true,
+			wantCollection: "${reviewers}",
+			wantElement:    "reviewer",
+		},
+		{
+			name:            "cardinality",
+			loop:            `<multiInstanceLoopCharacteristics><loopCardinality>3</loopCardinality></multiInstanceLoopCharacteristics>`,
+			wantCardinality: "3",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := parse(t, "", `
+<startEvent id="start"/>
+<sequenceFlow id="f1" sourceRef="start" targetRef="review"/>
+<userTask id="review">`+tt.loop+`</userTask>
+<sequenceFlow id="f2" sourceRef="review" targetRef="end"/>
+<endEvent id="end"/>`)
+
+			mi := p.Node("review").MultiInstance
+			switch {
+			case mi == nil:
+				t.Fatal("got no multi-instance loop")
+			case mi.Sequential != tt.wantSequential:
+				t.Errorf("got sequential %v, want %v", mi.Sequential, tt.wantSequential)
+			case mi.Collection != tt.wantCollection || mi.ElementVariable != tt.wantElement:
+				t.Errorf("got collection %q and element %q, want %q and %q", mi.Collection, mi.ElementVariable, tt.wantCollection, tt.wantElement)
+			case mi.Cardinality != tt.wantCardinality:
+				t.Errorf("got cardinality %q, want %q", mi.Cardinality, tt.wantCardinality)
+			}
+		})
+	}
+}
+
+func TestParseAssignmentStrategy(t *testing.T) {
+	p := parse(t, "", `
+<startEvent id="start"/>
+<sequenceFlow id="f1" sourceRef="start" targetRef="review"/>
+<userTask id="review" candidateGroups="managers" assignmentStrategy="round-robin">
+  <extensionElements>
+    <assignmentStrategy name="attribute-match">
+      <parameter name="department" value="${department}"/>
+    </assignmentStrategy>
+  </extensionElements>
+</userTask>
+<sequenceFlow id="f2" sourceRef="review" targetRef="end"/>
+<endEvent id="end"/>`)
+
+	review := p.Node("review")
+	if review.AssignmentStrategy != "attribute-match" {
+		t.Errorf("got strategy %q, want the extension element to win", review.AssignmentStrategy)
+	}
+	if got := review.AssignmentParameters["department"]; got != "${department}" {
+		t.Errorf("got department parameter %q, want ${department}", got)
+	}
+}
@@ -0,0 +1,357 @@
+package bpmn
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/tvolodi/ai-bpms-backend/shared/expression"
+	"github.com/tvolodi/ai-bpms-backend/shared/timer"
+)
+
+// Validate checks the structural rules the engine relies on: every process has
+// a start event, every node is reachable from one, events and gateways are
+// wired correctly and default flows point at outgoing flows.
+func Validate(defs *Definitions) ValidationErrors {
+	var errs ValidationErrors
+	if defs.ExecutableProcess() == nil {
+		return append(errs, ValidationError{Message: "document does not contain a process"})
+	}
+	for _, p := range defs.Processes {
+		errs = append(errs, validateProcess(p)...)
+	}
+	return errs
+}
+
+// supportedEvents lists the event definitions each event type may carry
+var supportedEvents = map[ElementType]map[EventDefinition]bool{
+	StartEvent:             {TimerEvent: true, MessageEvent: true, SignalEvent: true},
+	IntermediateCatchEvent: {TimerEvent: true, MessageEvent: true, SignalEvent: true},
+	BoundaryEvent:          {TimerEvent: true, MessageEvent: true, SignalEvent: true, ErrorEvent: true, CompensateEvent: true, EscalationEvent: true},
+	IntermediateThrowEvent: {CompensateEvent: true},
+	EndEvent:               {ErrorEvent: true, CompensateEvent: true},
+}
+
+func validateProcess(p *Process) ValidationErrors {
+	var errs ValidationErrors
+	add := func(id, format string, args ...interface{}) {
+		errs = append(errs, ValidationError{ElementID: id, Message: fmt.Sprintf(format, args...)})
+	}
+
+	starts := p.StartEvents()
+	switch {
+	case len(starts) == 0 && p.Parent == nil:
+		add(p.ID, "process has no start event")
+	case len(starts) == 0:
+		add(p.ID, "sub-process has no start event")
+	case len(starts) > 1 && p.Parent != nil:
+		add(p.ID, "sub-process must have exactly one start event")
+	}
+
+	noneStarts := 0
+	for _, n := range starts {
+		if n.Event == NoneEvent {
+			noneStarts++
+		} else if p.Parent != nil {
+			add(n.ID, "sub-process start event must not have an event definition")
+		}
+	}
+	if noneStarts > 1 {
+		add(p.ID, "process must not have more than one start event without an event definition")
+	}
+
+	for _, n := range p.Nodes {
+		validateFlowNode(p, n, add)
+
+		if n.Timer != nil {
+			if err := validateTimer(n.Timer); err != nil {
+				add(n.ID, "%v", err)
+			}
+		}
+
+		if n.RetryBackoff != "" {
+			if _, err := timer.ParseDuration(n.RetryBackoff); err != nil {
+				add(n.ID, "retryBackoff: %v", err)
+			}
+		}
+
+		if n.DefaultFlow != "" {
+			def := n.Default()
+			switch {
+			case def == nil:
+				add(n.ID, "default flow %q is not an outgoing sequence flow of this element", n.DefaultFlow)
+			case def.Condition != "":
+				add(def.ID, "default flow must not have a condition expression")
+			}
+		}
+
+		if n.Type == ScriptTask {
+			supported := n.ScriptFormat == "" || n.ScriptFormat == "expr"
+			if !supported {
+				add(n.ID, "unsupported script format %q, only expr is supported", n.ScriptFormat)
+			}
+			if n.Script == "" {
+				add(n.ID, "script task has no script")
+			} else if supported {
+				if _, err := expression.Compile(n.Script); err != nil {
+					add(n.ID, "%v", err)
+				}
+			}
+		}
+
+		if n.AssignmentStrategy != "" || n.AssignmentParameters != nil {
+			for _, err := range validateAssignment(n) {
+				add(n.ID, "%v", err)
+			}
+		}
+
+		if n.FormVersion > 0 && n.FormKey == "" {
+			add(n.ID, "formVersion requires a formKey")
+		}
+
+		if n.MultiInstance != nil {
+			for _, err := range validateMultiInstance(n) {
+				add(n.ID, "%v", err)
+			}
+		}
+
+		if n.Type == CallActivity {
+			if n.CalledElement == "" {
+				add(n.ID, "call activity has no calledElement")
+			}
+			for _, m := range append(append([]VariableMapping(nil), n.Inputs...), n.Outputs...) {
+				if err := validateMapping(m); err != nil {
+					add(n.ID, "%v", err)
+				}
+			}
+		}
+
+		if n.SubProcess != nil {
+			errs = append(errs, validateProcess(n.SubProcess)...)
+		}
+	}
+
+	for _, f := range p.Flows {
+		if f.Condition == "" {
+			continue
+		}
+		if f.Source != nil && f.Source.Type == ParallelGateway {
+			add(f.ID, "sequence flows leaving a parallel gateway cannot have conditions")
+		} else if _, err := expression.Compile(f.Condition); err != nil {
+			add(f.ID, "%v", err)
+		}
+	}
+
+	// Every node must be reachable from a start event of its own scope
+	reached := make(map[string]bool)
+	queue := append([]*Node(nil), starts...)
+	for len(queue) > 0 {
+		n := queue[0]
+		queue = queue[1:]
+		if reached[n.ID] {
+			continue
+		}
+		reached[n.ID] = true
+		for _, f := range n.Outgoing {
+			if f.Target != nil {
+				queue = append(queue, f.Target)
+			}
+		}
+		queue = append(queue, n.Boundaries...)
+		if n.CompensationHandler != nil {
+			queue = append(queue, n.CompensationHandler)
+		}
+	}
+	if len(starts) > 0 {
+		for _, n := range p.Nodes {
+			if !reached[n.ID] {
+				add(n.ID, "element is not reachable from a start event")
+			}
+		}
+	}
+
+	return errs
+}
+
+// validateFlowNode checks how a node is wired and which event definition it
+// carries, reporting every problem it finds
+func validateFlowNode(p *Process, n *Node, add func(id, format string, args ...interface{})) {
+	switch n.Type {
+	case StartEvent:
+		if len(n.Incoming) > 0 {
+			add(n.ID, "start event must not have incoming sequence flows")
+		}
+		if len(n.Outgoing) == 0 {
+			add(n.ID, "start event has no outgoing sequence flow")
+		}
+	case EndEvent:
+		if len(n.Outgoing) > 0 {
+			add(n.ID, "end event must not have outgoing sequence flows")
+		}
+	case IntermediateCatchEvent:
+		if n.Event == NoneEvent {
+			add(n.ID, "intermediate catch event has no event definition")
+		}
+	case BoundaryEvent:
+		validateBoundaryEvent(n, add)
+	}
+
+	if n.Type.IsGateway() && len(n.Outgoing) == 0 {
+		add(n.ID, "gateway has no outgoing sequence flow")
+	}
+	if n.Event != NoneEvent && !supportedEvents[n.Type][n.Event] {
+		add(n.ID, "%s event definitions are not supported on %s", n.Event, n.Type)
+	}
+	if n.IsForCompensation && (len(n.Incoming) > 0 || len(n.Outgoing) > 0) {
+		add(n.ID, "compensation handler must not have sequence flows")
+	}
+	if n.ActivityRef != "" && (p.Node(n.ActivityRef) == nil || !p.Node(n.ActivityRef).Type.IsActivity()) {
+		add(n.ID, "activityRef %q does not reference an activity in the same process", n.ActivityRef)
+	}
+}
+
+// validateBoundaryEvent checks where a boundary event is attached and what its
+// event definition requires
+func validateBoundaryEvent(n *Node, add func(id, format string, args ...interface{})) {
+	if n.Event == NoneEvent {
+		add(n.ID, "boundary event has no event definition")
+	}
+	if len(n.Incoming) > 0 {
+		add(n.ID, "boundary event must not have incoming sequence flows")
+	}
+	if n.AttachedTo != nil && !n.AttachedTo.Type.IsActivity() {
+		add(n.ID, "boundary event must be attached to an activity")
+	}
+
+	switch n.Event {
+	case ErrorEvent:
+		if !n.CancelActivity {
+			add(n.ID, "error boundary events must interrupt the activity")
+		}
+	case CompensateEvent:
+		if len(n.Outgoing) > 0 {
+			add(n.ID, "compensation boundary event must not have outgoing sequence flows")
+		}
+		if n.CompensationHandler == nil {
+			add(n.ID, "compensation boundary event is not associated with a compensation handler")
+		} else if !n.CompensationHandler.IsForCompensation {
+			add(n.ID, "compensation handler %s must be marked isForCompensation", n.CompensationHandler.ID)
+		}
+	}
+}
+
+// validateTimer checks that a timer has exactly one valid ISO 8601 value.
+// Expressions are only checked for syntax since they are resolved at runtime.
+func validateTimer(t *TimerDefinition) error {
+	set := 0
+	for _, v := range []string{t.Date, t.Duration, t.Cycle} {
+		if v != "" {
+			set++
+		}
+	}
+	if set != 1 {
+		return fmt.Errorf("timer must define exactly one of timeDate, timeDuration or timeCycle")
+	}
+
+	value := t.Date + t.Duration + t.Cycle
+	if expression.Strip(value) != value {
+		_, err := expression.Compile(value)
+		return err
+	}
+
+	var err error
+	switch {
+	case t.Date != "":
+		_, err = timer.ParseDate(t.Date)
+	case t.Duration != "":
+		_, err = timer.ParseDuration(t.Duration)
+	default:
+		_, err = timer.ParseCycle(t.Cycle)
+	}
+	return err
+}
+
+// validateMapping checks that a variable mapping names its source and target
+func validateMapping(m VariableMapping) error {
+	switch {
+	case m.All:
+		return nil
+	case m.Target == "":
+		return fmt.Errorf("variable mapping has no target")
+	case m.Source == "" && m.SourceExpression == "":
+		return fmt.Errorf("variable mapping for %q has no source or sourceExpression", m.Target)
+	case m.Source != "" && m.SourceExpression != "":
+		return fmt.Errorf("variable mapping for %q must not have both source and sourceExpression", m.Target)
+	case m.SourceExpression != "":
+		_, err := expression.Compile(m.SourceExpression)
+		return err
+	}
+	return nil
+}
+
+// validateAssignment checks that an automatic assignment has candidate groups
+// to pick from and well-formed parameters. Strategy names are resolved by the
+// engine, which may have custom strategies registered.
+func validateAssignment(n *Node) []error {
+	var errs []error
+	if n.AssignmentStrategy == "" {
+		errs = append(errs, fmt.Errorf("assignment parameters need an assignment strategy"))
+	} else {
+		if n.CandidateGroups == "" {
+			errs = append(errs, fmt.Errorf("assignment strategy %q needs candidateGroups", n.AssignmentStrategy))
+		}
+		if n.Assignee != "" {
+			errs = append(errs, fmt.Errorf("assignment strategy %q must not be combined with an assignee", n.AssignmentStrategy))
+		}
+	}
+	for _, name := range sortedKeys(n.AssignmentParameters) {
+		value := n.AssignmentParameters[name]
+		if name == "" {
+			errs = append(errs, fmt.Errorf("assignment parameter has no name"))
+			continue
+		}
+		if expression.Strip(value) == value {
+			continue
+		}
+		if _, err := expression.Compile(value); err != nil {
+			errs = append(errs, fmt.Errorf("assignment parameter %s: %w", name, err))
+		}
+	}
+	return errs
+}
+
+// validateMultiInstance checks the loop characteristics of a multi-instance activity
+func validateMultiInstance(n *Node) []error {
+	var errs []error
+	mi := n.MultiInstance
+	if n.Type != UserTask {
+		errs = append(errs, fmt.Errorf("multi-instance is only supported on user tasks"))
+	}
+	switch {
+	case mi.Cardinality == "" && mi.Collection == "":
+		errs = append(errs, fmt.Errorf("multi-instance needs a loopCardinality or a collection"))
+	case mi.Cardinality != "" && mi.Collection != "":
+		errs = append(errs, fmt.Errorf("multi-instance must not have both a loopCardinality and a collection"))
+	}
+	if mi.OutputElement != "" && mi.OutputCollection == "" {
+		errs = append(errs, fmt.Errorf("multi-instance outputElement needs an outputCollection"))
+	}
+	for _, source := range []string{mi.Cardinality, mi.Collection, mi.CompletionCondition, mi.OutputElement} {
+		if source == "" {
+			continue
+		}
+		if _, err := expression.Compile(source); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errs
+}
+
+// sortedKeys returns the keys of a map in order, so problems are reported in a stable order
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
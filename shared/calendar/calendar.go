@@ -0,0 +1,299 @@
+package calendar
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/tvolodi/ai-bpms-backend/shared/timer"
+)
+
+// searchDays bounds the search for working time, so a calendar whose holidays
+// cover every working day cannot loop forever
+const searchDays = 3 * 366
+
+// weekdays maps the day names used in working hour definitions to weekdays
+var weekdays = map[string]time.Weekday{
+	"sunday":    time.Sunday,
+	"monday":    time.Monday,
+	"tuesday":   time.Tuesday,
+	"wednesday": time.Wednesday,
+	"thursday":  time.Thursday,
+	"friday":    time.Friday,
+	"saturday":  time.Saturday,
+}
+
+// Period is a stretch of working time within a day, in "15:04" clock times
+type Period struct {
+	Start string `json:"start"`
+	End   string `json:"end"`
+}
+
+// Holiday is a day off, in "2006-01-02" form
+type Holiday struct {
+	Date string `json:"date"`
+	Name string `json:"name,omitempty"`
+}
+
+// span is a working period as offsets from midnight
+type span struct {
+	start, end time.Duration
+}
+
+// Calendar knows when work happens in one region: the working periods of each
+// weekday, in the calendar's time zone, and the holidays on which nobody works.
+// A nil calendar counts plain calendar time.
+type Calendar struct {
+	loc      *time.Location
+	hours    [7][]span
+	holidays map[string]bool
+}
+
+// Parse builds a calendar from a time zone name, the JSON working hours (weekday
+// name to list of periods) and the JSON list of holidays
+func Parse(timeZone, workingHours, holidays string) (*Calendar, error) {
+	if timeZone == "" {
+		timeZone = "UTC"
+	}
+	loc, err := time.LoadLocation(timeZone)
+	if err != nil {
+		return nil, fmt.Errorf("invalid time zone %q: %w", timeZone, err)
+	}
+	c := &Calendar{loc: loc, holidays: make(map[string]bool)}
+
+	var hours map[string][]Period
+	if err := json.Unmarshal([]byte(orEmpty(workingHours, "{}")), &hours); err != nil {
+		return nil, fmt.Errorf("invalid working hours: %w", err)
+	}
+	working := false
+	for name, periods := range hours {
+		day, ok := weekdays[strings.ToLower(name)]
+		if !ok {
+			return nil, fmt.Errorf("invalid working hours: unknown weekday %q", name)
+		}
+		for _, p := range periods {
+			s, err := clock(p.Start)
+			if err != nil {
+				return nil, fmt.Errorf("invalid working hours on %s: %w", name, err)
+			}
+			e, err := clock(p.End)
+			if err != nil {
+				return nil, fmt.Errorf("invalid working hours on %s: %w", name, err)
+			}
+			if e <= s {
+				return nil, fmt.Errorf("invalid working hours on %s: %s-%s ends before it starts", name, p.Start, p.End)
+			}
+			c.hours[day] = append(c.hours[day], span{start: s, end: e})
+		}
+		sort.Slice(c.hours[day], func(i, j int) bool { return c.hours[day][i].start < c.hours[day][j].start })
+		for i := 1; i < len(c.hours[day]); i++ {
+			if c.hours[day][i].start < c.hours[day][i-1].end {
+				return nil, fmt.Errorf("invalid working hours on %s: periods overlap", name)
+			}
+		}
+		working = working || len(periods) > 0
+	}
+	if !working {
+		return nil, fmt.Errorf("invalid working hours: no working time in the week")
+	}
+
+	var days []Holiday
+	if err := json.Unmarshal([]byte(orEmpty(holidays, "[]")), &days); err != nil {
+		return nil, fmt.Errorf("invalid holidays: %w", err)
+	}
+	for _, h := range days {
+		if _, err := time.Parse("2006-01-02", h.Date); err != nil {
+			return nil, fmt.Errorf("invalid holiday %q: %w", h.Date, err)
+		}
+		c.holidays[h.Date] = true
+	}
+	return c, nil
+}
+
+// Add returns the point in time a duration of working time after from. Days
+// count working days, keeping the time of day; the clock part counts working
+// hours. Years and months move the date and then wait for working time.
+func (c *Calendar) Add(from time.Time, d timer.Duration) time.Time {
+	if c == nil {
+		return d.AddTo(from)
+	}
+	t := from.In(c.loc)
+	if d.Years != 0 || d.Months != 0 {
+		t = t.AddDate(d.Years, d.Months, 0)
+	}
+	t = c.next(t)
+	for i := 0; i < d.Days; i++ {
+		day := c.midnight(t)
+		offset := t.Sub(day)
+		for n := 0; n < searchDays; n++ {
+			day = day.AddDate(0, 0, 1)
+			if c.isWorkingDay(day) {
+				break
+			}
+		}
+		t = c.next(day.Add(offset))
+	}
+
+	remaining := d.Clock
+	for n := 0; remaining > 0 && n < searchDays*4; n++ {
+		end := c.spanEnd(t)
+		available := end.Sub(t)
+		if remaining <= available {
+			return t.Add(remaining).UTC()
+		}
+		remaining -= available
+		t = c.next(end)
+	}
+	return t.UTC()
+}
+
+// Subtract returns the point in time a duration of working time before from
+func (c *Calendar) Subtract(from time.Time, d timer.Duration) time.Time {
+	if c == nil {
+		return d.SubtractFrom(from)
+	}
+	t := from.In(c.loc)
+	if d.Years != 0 || d.Months != 0 {
+		t = t.AddDate(-d.Years, -d.Months, 0)
+	}
+	t = c.previous(t)
+	for i := 0; i < d.Days; i++ {
+		day := c.midnight(t)
+		offset := t.Sub(day)
+		for n := 0; n < searchDays; n++ {
+			day = day.AddDate(0, 0, -1)
+			if c.isWorkingDay(day) {
+				break
+			}
+		}
+		t = c.previous(day.Add(offset))
+	}
+
+	remaining := d.Clock
+	for n := 0; remaining > 0 && n < searchDays*4; n++ {
+		start := c.spanStart(t)
+		available := t.Sub(start)
+		if remaining <= available {
+			return t.Add(-remaining).UTC()
+		}
+		remaining -= available
+		t = c.previous(start)
+	}
+	return t.UTC()
+}
+
+// IsWorkingTime reports whether work happens at t
+func (c *Calendar) IsWorkingTime(t time.Time) bool {
+	if c == nil {
+		return true
+	}
+	t = t.In(c.loc)
+	day := c.midnight(t)
+	for _, s := range c.spans(day) {
+		if !t.Before(day.Add(s.start)) && t.Before(day.Add(s.end)) {
+			return true
+		}
+	}
+	return false
+}
+
+// next returns the first working instant at or after t
+func (c *Calendar) next(t time.Time) time.Time {
+	day := c.midnight(t)
+	for n := 0; n < searchDays; n++ {
+		for _, s := range c.spans(day) {
+			if end := day.Add(s.end); t.Before(end) {
+				if start := day.Add(s.start); t.Before(start) {
+					return start
+				}
+				return t
+			}
+		}
+		day = day.AddDate(0, 0, 1)
+		t = day
+	}
+	return t
+}
+
+// previous returns the last working instant at or before t, where a period's
+// end counts as working so that working time can be taken back from it
+func (c *Calendar) previous(t time.Time) time.Time {
+	day := c.midnight(t)
+	for n := 0; n < searchDays; n++ {
+		spans := c.spans(day)
+		for i := len(spans) - 1; i >= 0; i-- {
+			if start := day.Add(spans[i].start); t.After(start) {
+				if end := day.Add(spans[i].end); t.After(end) {
+					return end
+				}
+				return t
+			}
+		}
+		t = day
+		day = day.AddDate(0, 0, -1)
+	}
+	return t
+}
+
+// spanEnd returns the end of the working period that contains t
+func (c *Calendar) spanEnd(t time.Time) time.Time {
+	day := c.midnight(t)
+	for _, s := range c.spans(day) {
+		if end := day.Add(s.end); t.Before(end) {
+			return end
+		}
+	}
+	return t
+}
+
+// spanStart returns the start of the working period that ends at or contains t
+func (c *Calendar) spanStart(t time.Time) time.Time {
+	day := c.midnight(t)
+	spans := c.spans(day)
+	for i := len(spans) - 1; i >= 0; i-- {
+		if start := day.Add(spans[i].start); t.After(start) {
+			return start
+		}
+	}
+	return t
+}
+
+// spans returns the working periods of a day, none on holidays
+func (c *Calendar) spans(day time.Time) []span {
+	if c.holidays[day.Format("2006-01-02")] {
+		return nil
+	}
+	return c.hours[day.Weekday()]
+}
+
+// isWorkingDay reports whether a day has any working time
+func (c *Calendar) isWorkingDay(day time.Time) bool {
+	return len(c.spans(day)) > 0
+}
+
+// midnight returns the start of the day of t in the calendar's time zone
+func (c *Calendar) midnight(t time.Time) time.Time {
+	y, m, d := t.In(c.loc).Date()
+	return time.Date(y, m, d, 0, 0, 0, 0, c.loc)
+}
+
+// clock parses a "15:04" time of day as an offset from midnight; "24:00" ends the day
+func clock(value string) (time.Duration, error) {
+	if value == "24:00" {
+		return 24 * time.Hour, nil
+	}
+	t, err := time.Parse("15:04", value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time %q", value)
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+func orEmpty(value, empty string) string {
+	if strings.TrimSpace(value) == "" {
+		return empty
+	}
+	return value
+}
@@ -0,0 +1,193 @@
+package calendar
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tvolodi/ai-bpms-backend/shared/timer"
+)
+
+// officeHours is a Monday to Friday week with a lunch break, seven working
+// hours a day
+const officeHours = `{
+	"monday":    [{"start": "09:00", "end": "12:00"}, {"start": "13:00", "end": "17:00"}],
+	"tuesday":   [{"start": "09:00", "end": "12:00"}, {"start": "13:00", "end": "17:00"}],
+	"wednesday": [{"start": "09:00", "end": "12:00"}, {"start": "13:00", "end": "17:00"}],
+	"thursday":  [{"start": "09:00", "end": "12:00"}, {"start": "13:00", "end": "17:00"}],
+	"friday":    [{"start": "09:00", "end": "12:00"}, {"start": "13:00", "end": "17:00"}]
+}`
+
+// Monday 6 January 2025 is a holiday, so the week of 3 January ends on a
+// three day weekend
+const newYearHolidays = `[{"date": "2025-01-06", "name": "Epiphany"}]`
+
+func office(t *testing.T) *Calendar {
+	t.Helper()
+	c, err := Parse("UTC", officeHours, newYearHolidays)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return c
+}
+
+// at returns a time on a day of January 2025, in UTC
+func at(day, hour, minute int) time.Time {
+	return time.Date(2025, time.January, day, hour, minute, 0, 0, time.UTC)
+}
+
+func duration(t *testing.T, value string) timer.Duration {
+	t.Helper()
+	d, err := timer.ParseDuration(value)
+	if err != nil {
+		t.Fatalf("ParseDuration(%q): %v", value, err)
+	}
+	return d
+}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name     string
+		timeZone string
+		hours    string
+		holidays string
+		wantErr  string
+	}{
+		{name: "office hours", hours: officeHours, holidays: newYearHolidays},
+		{name: "no holidays", timeZone: "UTC", hours: `{"Monday": [{"start": "00:00", "end": "24:00"}]}`},
+		{name: "unknown time zone", timeZone: "Mars/Olympus", hours: officeHours, wantErr: "invalid time zone"},
+		{name: "unknown weekday", hours: `{"funday": [{"start": "09:00", "end": "17:00"}]}`, wantErr: `unknown weekday "funday"`},
+		{name: "invalid time", hours: `{"monday": [{"start": "9am", "end": "17:00"}]}`, wantErr: `invalid time "9am"`},
+		{name: "period ends before it starts", hours: `{"monday": [{"start": "17:00", "end": "09:00"}]}`, wantErr: "ends before it starts"},
+		{name: "overlapping periods", hours: `{"monday": [{"start": "09:00", "end": "13:00"}, {"start": "12:00", "end": "17:00"}]}`, wantErr: "periods overlap"},
+		{name: "no working time", hours: `{"monday": []}`, wantErr: "no working time in the week"},
+		{name: "empty working hours", hours: "", wantErr: "no working time in the week"},
+		{name: "malformed working hours", hours: `[]`, wantErr: "invalid working hours"},
+		{name: "invalid holiday", hours: officeHours, holidays: `[{"date": "06/01/2025"}]`, wantErr: `invalid holiday "06/01/2025"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Parse(tt.timeZone, tt.hours, tt.holidays)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("got %v, want an error containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestAdd(t *testing.T) {
+	c := office(t)
+	tests := []struct {
+		name     string
+		from     time.Time
+		duration string
+		want     time.Time
+	}{
+		{name: "within a period", from: at(2, 9, 30), duration: "PT2H", want: at(2, 11, 30)},
+		{name: "up to the end of a period", from: at(2, 11, 0), duration: "PT1H", want: at(2, 12, 0)},
+		{name: "across the lunch break", from: at(2, 10, 0), duration: "PT4H", want: at(2, 15, 0)},
+		{name: "starting in the lunch break", from: at(2, 12, 30), duration: "PT30M", want: at(2, 13, 30)},
+		{name: "across the long weekend", from: at(3, 16, 0), duration: "PT2H", want: at(7, 10, 0)},
+		{name: "starting on the weekend", from: at(4, 10, 0), duration: "PT1H", want: at(7, 10, 0)},
+		{name: "a working day", from: at(2, 10, 0), duration: "P1D", want: at(3, 10, 0)},
+		{name: "a working day over the long weekend", from: at(3, 10, 0), duration: "P1D", want: at(7, 10, 0)},
+		{name: "a working day after hours", from: at(3, 17, 30), duration: "P1D", want: at(8, 9, 0)},
+		{name: "a week of working days", from: at(2, 10, 0), duration: "P5D", want: at(10, 10, 0)},
+		{name: "a month", from: at(31, 10, 0), duration: "P1M", want: time.Date(2025, time.March, 3, 10, 0, 0, 0, time.UTC)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := c.Add(tt.from, duration(t, tt.duration)); !got.Equal(tt.want) {
+				t.Errorf("%s after %s = %s, want %s", tt.duration, tt.from, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSubtract(t *testing.T) {
+	c := office(t)
+	tests := []struct {
+		name     string
+		from     time.Time
+		duration string
+		want     time.Time
+	}{
+		{name: "within a period", from: at(2, 16, 0), duration: "PT2H", want: at(2, 14, 0)},
+		{name: "across the lunch break", from: at(2, 15, 0), duration: "PT4H", want: at(2, 10, 0)},
+		{name: "back from the end of a period", from: at(2, 17, 0), duration: "PT4H", want: at(2, 13, 0)},
+		{name: "across the long weekend", from: at(7, 10, 0), duration: "PT2H", want: at(3, 16, 0)},
+		{name: "starting on the weekend", from: at(5, 10, 0), duration: "PT1H", want: at(3, 16, 0)},
+		{name: "a working day over the long weekend", from: at(7, 10, 0), duration: "P1D", want: at(3, 10, 0)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := c.Subtract(tt.from, duration(t, tt.duration)); !got.Equal(tt.want) {
+				t.Errorf("%s before %s = %s, want %s", tt.duration, tt.from, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIsWorkingTime(t *testing.T) {
+	c := office(t)
+	tests := []struct {
+		at   time.Time
+		want bool
+	}{
+		{at(2, 9, 0), true},
+		{at(2, 11, 59), true},
+		{at(2, 12, 0), false},
+		{at(2, 12, 30), false},
+		{at(2, 17, 0), false},
+		{at(2, 8, 59), false},
+		{at(4, 10, 0), false},
+		{at(6, 10, 0), false},
+		{at(7, 10, 0), true},
+	}
+
+	for _, tt := range tests {
+		if got := c.IsWorkingTime(tt.at); got != tt.want {
+			t.Errorf("IsWorkingTime(%s) = %v, want %v", tt.at, got, tt.want)
+		}
+	}
+}
+
+func TestTimeZone(t *testing.T) {
+	c, err := Parse("America/New_York", `{"thursday": [{"start": "09:00", "end": "17:00"}]}`, "")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// Nine in the morning in New York is two in the afternoon in UTC in winter
+	if c.IsWorkingTime(at(2, 13, 59)) || !c.IsWorkingTime(at(2, 14, 0)) {
+		t.Error("working hours are not counted in the calendar's time zone")
+	}
+	if got, want := c.Add(at(2, 12, 0), duration(t, "PT1H")), at(2, 15, 0); !got.Equal(want) {
+		t.Errorf("got %s, want %s", got, want)
+	}
+}
+
+func TestNilCalendar(t *testing.T) {
+	var c *Calendar
+	from := at(4, 10, 0)
+	d := duration(t, "P1DT2H")
+
+	if got, want := c.Add(from, d), at(5, 12, 0); !got.Equal(want) {
+		t.Errorf("Add = %s, want %s", got, want)
+	}
+	if got, want := c.Subtract(from, d), at(3, 8, 0); !got.Equal(want) {
+		t.Errorf("Subtract = %s, want %s", got, want)
+	}
+	if !c.IsWorkingTime(from) {
+		t.Error("a nil calendar counts every moment as working time")
+	}
+}
@@ -10,16 +10,19 @@ import (
 
 // Config holds all configuration for the application
 type Config struct {
-	Server   ServerConfig   `mapstructure:"server"`
-	Database DatabaseConfig `mapstructure:"database"`
-	Auth     AuthConfig     `mapstructure:"auth"`
-	NATS     NATSConfig     `mapstructure:"nats"`
-	Redis    RedisConfig    `mapstructure:"redis"`
-	AI       AIConfig       `mapstructure:"ai"`
-	Logging  LoggingConfig  `mapstructure:"logging"`
-	Cache    CacheConfig    `mapstructure:"cache"`
-	Security SecurityConfig `mapstructure:"security"`
-	Metrics  MetricsConfig  `mapstructure:"metrics"`
+	Server    ServerConfig    `mapstructure:"server"`
+	Database  DatabaseConfig  `mapstructure:"database"`
+	Auth      AuthConfig      `mapstructure:"auth"`
+	NATS      NATSConfig      `mapstructure:"nats"`
+	Redis     RedisConfig     `mapstructure:"redis"`
+	AI        AIConfig        `mapstructure:"ai"`
+	Logging   LoggingConfig   `mapstructure:"logging"`
+	Cache     CacheConfig     `mapstructure:"cache"`
+	Security  SecurityConfig  `mapstructure:"security"`
+	Metrics   MetricsConfig   `mapstructure:"metrics"`
+	Scheduler SchedulerConfig `mapstructure:"scheduler"`
+	SLA       SLAConfig       `mapstructure:"sla"`
+	Storage   StorageConfig   `mapstructure:"storage"`
 }
 
 // ServerConfig contains HTTP server configuration
@@ -60,6 +63,9 @@ type AuthConfig struct {
 	OIDC     OIDCConfig         `mapstructure:"oidc"`
 	BuiltIn  BuiltInConfig      `mapstructure:"built_in"`
 	Security AuthSecurityConfig `mapstructure:"security"`
+	// TrustUserHeader takes the caller's user ID from the unauthenticated
+	// X-User-ID header. For local development only.
+	TrustUserHeader bool `mapstructure:"trust_user_header"`
 }
 
 // JWTConfig contains JWT configuration
@@ -188,6 +194,43 @@ type MetricsConfig struct {
 	Port    int    `mapstructure:"port"`
 }
 
+// SchedulerConfig contains job scheduler configuration
+type SchedulerConfig struct {
+	Enabled      bool          `mapstructure:"enabled"`
+	PollInterval time.Duration `mapstructure:"poll_interval"`
+	BatchSize    int           `mapstructure:"batch_size"`
+	MaxAttempts  int           `mapstructure:"max_attempts"`
+	RetryBackoff time.Duration `mapstructure:"retry_backoff"`
+}
+
+// SLAConfig contains task SLA monitor configuration
+type SLAConfig struct {
+	Enabled      bool          `mapstructure:"enabled"`
+	PollInterval time.Duration `mapstructure:"poll_interval"`
+	BatchSize    int           `mapstructure:"batch_size"`
+}
+
+// StorageConfig contains attachment storage configuration
+type StorageConfig struct {
+	Backend      string        `mapstructure:"backend"` // local, s3
+	LocalPath    string        `mapstructure:"local_path"`
+	S3           S3Config      `mapstructure:"s3"`
+	MaxSize      int64         `mapstructure:"max_size"` // bytes
+	AllowedTypes []string      `mapstructure:"allowed_types"`
+	URLSecret    string        `mapstructure:"url_secret"`
+	URLExpiry    time.Duration `mapstructure:"url_expiry"`
+}
+
+// S3Config contains S3-compatible object storage configuration
+type S3Config struct {
+	Endpoint        string `mapstructure:"endpoint"`
+	Region          string `mapstructure:"region"`
+	Bucket          string `mapstructure:"bucket"`
+	AccessKeyID     string `mapstructure:"access_key_id"`
+	SecretAccessKey string `mapstructure:"secret_access_key"`
+	UsePathStyle    bool   `mapstructure:"use_path_style"`
+}
+
 // Load loads configuration from files and environment variables
 func Load() (*Config, error) {
 	viper.SetConfigName("config")
@@ -248,6 +291,7 @@ func setDefaults() {
 	viper.SetDefault("auth.jwt.issuer", "ai-bpms")
 	viper.SetDefault("auth.jwt.audience", "ai-bpms-users")
 	viper.SetDefault("auth.built_in.enabled", true)
+	viper.SetDefault("auth.trust_user_header", false)
 	viper.SetDefault("auth.security.session_timeout", "4h")
 	viper.SetDefault("auth.security.max_concurrent_sessions", 3)
 
@@ -290,6 +334,31 @@ func setDefaults() {
 	viper.SetDefault("metrics.enabled", true)
 	viper.SetDefault("metrics.path", "/metrics")
 	viper.SetDefault("metrics.port", 9090)
+
+	// Scheduler defaults
+	viper.SetDefault("scheduler.enabled", true)
+	viper.SetDefault("scheduler.poll_interval", "5s")
+	viper.SetDefault("scheduler.batch_size", 50)
+	viper.SetDefault("scheduler.max_attempts", 3)
+	viper.SetDefault("scheduler.retry_backoff", "30s")
+
+	// SLA monitor defaults
+	viper.SetDefault("sla.enabled", true)
+	viper.SetDefault("sla.poll_interval", "1m")
+	viper.SetDefault("sla.batch_size", 100)
+
+	// Storage defaults
+	viper.SetDefault("storage.backend", "local")
+	viper.SetDefault("storage.local_path", "./data/attachments")
+	viper.SetDefault("storage.s3.region", "us-east-1")
+	viper.SetDefault("storage.s3.use_path_style", true)
+	viper.SetDefault("storage.max_size", 25<<20)
+	viper.SetDefault("storage.allowed_types", []string{
+		"application/pdf", "image/png", "image/jpeg", "image/gif", "image/webp", "text/plain", "text/csv",
+		"application/zip", "application/vnd.openxmlformats-officedocument.wordprocessingml.document",
+		"application/vnd.openxmlformats-officedocument.spreadsheetml.sheet",
+	})
+	viper.SetDefault("storage.url_expiry", "5m")
 }
 
 // GetDSN returns database connection string
@@ -120,14 +120,36 @@ func RequestID() gin.HandlerFunc {
 	}
 }
 
+// UserIDKey is the context key holding the authenticated user's ID
+const UserIDKey = "user_id"
+
 // Authentication middleware (placeholder for now)
-func Authentication() gin.HandlerFunc {
+func Authentication(authConfig config.AuthConfig) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		// TODO: Implement JWT/OIDC authentication
+		// TODO: Implement JWT/OIDC authentication. Until then requests are
+		// anonymous unless the development-only header trust is enabled.
+		if authConfig.TrustUserHeader {
+			if id, err := uuid.Parse(c.Request.Header.Get("X-User-ID")); err == nil {
+				c.Set(UserIDKey, id)
+			}
+		}
 		c.Next()
 	}
 }
 
+// CurrentUserID returns the authenticated user's ID, or nil for anonymous requests
+func CurrentUserID(c *gin.Context) *uuid.UUID {
+	value, ok := c.Get(UserIDKey)
+	if !ok {
+		return nil
+	}
+	id, ok := value.(uuid.UUID)
+	if !ok {
+		return nil
+	}
+	return &id
+}
+
 // Authorization middleware (placeholder for now)
 func Authorization(requiredPermissions ...string) gin.HandlerFunc {
 	return func(c *gin.Context) {
@@ -0,0 +1,77 @@
+package models
+
+import (
+	"database/sql/driver"
+	"fmt"
+	"strings"
+)
+
+// StringArray is a Postgres text[] column. The pgx driver hands arrays to
+// database/sql as their text form, which a plain []string cannot scan.
+type StringArray []string
+
+// Scan parses the text form of a Postgres array, such as {a,"b c",NULL}
+func (a *StringArray) Scan(src interface{}) error {
+	var text string
+	switch v := src.(type) {
+	case nil:
+		*a = nil
+		return nil
+	case string:
+		text = v
+	case []byte:
+		text = string(v)
+	default:
+		return fmt.Errorf("cannot scan %T into StringArray", src)
+	}
+	if len(text) < 2 || text[0] != '{' || text[len(text)-1] != '}' {
+		return fmt.Errorf("invalid array %q", text)
+	}
+
+	items := StringArray{}
+	body := text[1 : len(text)-1]
+	for i := 0; i < len(body); {
+		var item strings.Builder
+		quoted := body[i] == '"'
+		if quoted {
+			i++
+			for ; i < len(body) && body[i] != '"'; i++ {
+				if body[i] == '\\' && i+1 < len(body) {
+					i++
+				}
+				item.WriteByte(body[i])
+			}
+			i++ // closing quote
+		} else {
+			for ; i < len(body) && body[i] != ','; i++ {
+				item.WriteByte(body[i])
+			}
+		}
+		// NULL elements have no string value and are skipped
+		if quoted || item.String() != "NULL" {
+			items = append(items, item.String())
+		}
+		i++ // separator
+	}
+	*a = items
+	return nil
+}
+
+// Value renders the array in Postgres text form with every element quoted
+func (a StringArray) Value() (driver.Value, error) {
+	if a == nil {
+		return nil, nil
+	}
+	var b strings.Builder
+	b.WriteByte('{')
+	for i, item := range a {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteByte('"')
+		b.WriteString(strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(item))
+		b.WriteByte('"')
+	}
+	b.WriteByte('}')
+	return b.String(), nil
+}
@@ -27,10 +27,10 @@ type User struct {
 	LastLogin       *time.Time `json:"last_login"`
 
 	// BPMS-specific fields
-	Roles         []Role   `gorm:"many2many:user_roles;" json:"roles"`
-	ProcessGroups []string `gorm:"type:text[]" json:"process_groups"`
-	Department    string   `gorm:"size:100" json:"department"`
-	Position      string   `gorm:"size:100" json:"position"`
+	Roles         []Role      `gorm:"many2many:user_roles;" json:"roles"`
+	ProcessGroups StringArray `gorm:"type:text[]" json:"process_groups"`
+	Department    string      `gorm:"size:100" json:"department"`
+	Position      string      `gorm:"size:100" json:"position"`
 
 	// Authentication settings
 	MFAEnabled bool   `gorm:"default:false" json:"mfa_enabled"`
@@ -59,12 +59,13 @@ type Permission struct {
 	Action      string `gorm:"size:50" json:"action"`   // create, read, update, delete
 }
 
-// ProcessDefinition represents a business process definition
+// ProcessDefinition represents a business process definition. Each deployment of
+// a key is stored as a new, immutable version.
 type ProcessDefinition struct {
 	BaseModel
 	Name        string `gorm:"not null;size:255" json:"name"`
-	Key         string `gorm:"uniqueIndex;not null;size:100" json:"key"`
-	Version     int    `gorm:"not null;default:1" json:"version"`
+	Key         string `gorm:"uniqueIndex:idx_process_definitions_key_version;not null;size:100" json:"key"`
+	Version     int    `gorm:"uniqueIndex:idx_process_definitions_key_version;not null;default:1" json:"version"`
 	Description string `gorm:"type:text" json:"description"`
 
 	// Process definition data
@@ -73,9 +74,9 @@ type ProcessDefinition struct {
 	Variables  string `gorm:"type:jsonb" json:"variables"`   // Process variables
 
 	// Process settings
-	IsActive bool     `gorm:"default:true" json:"is_active"`
-	Category string   `gorm:"size:100" json:"category"`
-	Tags     []string `gorm:"type:text[]" json:"tags"`
+	IsActive bool        `gorm:"default:true" json:"is_active"`
+	Category string      `gorm:"size:100" json:"category"`
+	Tags     StringArray `gorm:"type:text[]" json:"tags"`
 
 	// AI enhancement
 	AIEnabled bool   `gorm:"default:false" json:"ai_enabled"`
@@ -95,7 +96,7 @@ type ProcessInstance struct {
 	ProcessDefinitionID uuid.UUID         `gorm:"type:uuid;not null" json:"process_definition_id"`
 	ProcessDefinition   ProcessDefinition `gorm:"foreignKey:ProcessDefinitionID" json:"process_definition"`
 
-	BusinessKey string `gorm:"size:255" json:"business_key"`
+	BusinessKey string `gorm:"size:255;index" json:"business_key"`
 	Status      string `gorm:"size:50;not null" json:"status"` // active, completed, suspended, terminated
 
 	// Instance data
@@ -107,12 +108,20 @@ type ProcessInstance struct {
 	EndedAt   *time.Time `json:"ended_at"`
 	Duration  *int64     `json:"duration"` // milliseconds
 
+	// Call activity hierarchy: the instance and execution that called this one
+	ParentInstanceID  *uuid.UUID `gorm:"type:uuid;index" json:"parent_instance_id"`
+	ParentExecutionID *uuid.UUID `gorm:"type:uuid;index" json:"parent_execution_id"`
+	// SuspendedByParent marks an instance suspended along with the instance that called it
+	SuspendedByParent bool `gorm:"default:false" json:"suspended_by_parent"`
+
 	// Relationships
-	Tasks []TaskInstance `gorm:"foreignKey:ProcessInstanceID" json:"tasks"`
+	Tasks     []TaskInstance `gorm:"foreignKey:ProcessInstanceID" json:"tasks"`
+	Incidents []Incident     `gorm:"foreignKey:ProcessInstanceID" json:"incidents,omitempty"`
 
 	// Audit fields
 	StartedBy *uuid.UUID `gorm:"type:uuid" json:"started_by"`
 	EndedBy   *uuid.UUID `gorm:"type:uuid" json:"ended_by"`
+	EndReason string     `gorm:"type:text" json:"end_reason"`
 }
 
 // TaskInstance represents a task within a process instance
@@ -120,6 +129,7 @@ type TaskInstance struct {
 	BaseModel
 	ProcessInstanceID uuid.UUID       `gorm:"type:uuid;not null" json:"process_instance_id"`
 	ProcessInstance   ProcessInstance `gorm:"foreignKey:ProcessInstanceID" json:"-"`
+	ExecutionID       *uuid.UUID      `gorm:"type:uuid;index" json:"execution_id"`
 
 	TaskDefinitionKey string `gorm:"size:100;not null" json:"task_definition_key"`
 	Name              string `gorm:"size:255" json:"name"`
@@ -130,15 +140,37 @@ type TaskInstance struct {
 	Assignee       *User      `gorm:"foreignKey:AssigneeID" json:"assignee"`
 	CandidateGroup string     `gorm:"size:100" json:"candidate_group"`
 
+	// Delegation: the owner gets the task back once the delegate resolves it
+	OwnerID         *uuid.UUID `gorm:"type:uuid;index" json:"owner_id"`
+	Owner           *User      `gorm:"foreignKey:OwnerID" json:"owner,omitempty"`
+	DelegationState string     `gorm:"size:20" json:"delegation_state,omitempty"` // pending, resolved
+
+	// Substitution: the user the task was meant for when an out-of-office rule
+	// routed it to the assignee, and the last rule applied
+	OriginalAssigneeID *uuid.UUID `gorm:"type:uuid" json:"original_assignee_id,omitempty"`
+	OriginalAssignee   *User      `gorm:"foreignKey:OriginalAssigneeID" json:"original_assignee,omitempty"`
+	SubstitutionID     *uuid.UUID `gorm:"type:uuid" json:"substitution_id,omitempty"`
+
 	// Task state
 	Status       string     `gorm:"size:50;not null" json:"status"` // created, assigned, completed, cancelled
 	Priority     int        `gorm:"default:50" json:"priority"`
 	DueDate      *time.Time `json:"due_date"`
 	FollowUpDate *time.Time `json:"follow_up_date"`
 
-	// Task data
-	FormData  string `gorm:"type:jsonb" json:"form_data"`
-	Variables string `gorm:"type:jsonb" json:"variables"`
+	// Service level: the policy the task is tracked under, when it becomes at risk
+	// and how far it has slipped
+	SLAPolicyID  *uuid.UUID `gorm:"type:uuid" json:"sla_policy_id,omitempty"`
+	SLAWarningAt *time.Time `json:"sla_warning_at,omitempty"`
+	SLAStatus    string     `gorm:"size:20" json:"sla_status,omitempty"` // at_risk, breached
+
+	// Task data. FormKey is the key of the form schema the task is bound to,
+	// FormSchemaID the version it was created with and FormRefs the versions
+	// the unversioned $refs of that schema resolved to, by form key.
+	FormKey      string     `gorm:"size:100" json:"form_key,omitempty"`
+	FormSchemaID *uuid.UUID `gorm:"type:uuid" json:"form_schema_id,omitempty"`
+	FormRefs     string     `gorm:"type:jsonb;default:'{}'" json:"form_refs"`
+	FormData     string     `gorm:"type:jsonb" json:"form_data"`
+	Variables    string     `gorm:"type:jsonb" json:"variables"`
 
 	// Timing
 	CreatedAt   time.Time  `json:"created_at"`
@@ -151,6 +183,207 @@ type TaskInstance struct {
 	CompletedBy *uuid.UUID `gorm:"type:uuid" json:"completed_by"`
 }
 
+// Execution represents a token of a process instance sitting on a single flow element.
+// A new row is written every time a token moves, so completed rows form the activity history.
+type Execution struct {
+	BaseModel
+	ProcessInstanceID uuid.UUID  `gorm:"type:uuid;not null;index" json:"process_instance_id"`
+	ParentID          *uuid.UUID `gorm:"type:uuid;index" json:"parent_id"` // enclosing scope execution
+
+	ElementID   string `gorm:"size:100;not null" json:"element_id"`
+	ElementType string `gorm:"size:50;not null" json:"element_type"`
+	Status      string `gorm:"size:50;not null;index" json:"status"` // active, waiting, completed, cancelled
+
+	// Local variables, such as multi-instance loop counters
+	Variables string `gorm:"type:jsonb" json:"variables"`
+
+	// Timing
+	StartedAt time.Time  `json:"started_at"`
+	EndedAt   *time.Time `json:"ended_at"`
+}
+
+// Incident records a failure that stopped a token and needs operator attention
+type Incident struct {
+	BaseModel
+	ProcessInstanceID uuid.UUID  `gorm:"type:uuid;not null;index" json:"process_instance_id"`
+	ExecutionID       *uuid.UUID `gorm:"type:uuid" json:"execution_id"`
+	TaskInstanceID    *uuid.UUID `gorm:"type:uuid" json:"task_instance_id"`
+	ElementID         string     `gorm:"size:100" json:"element_id"`
+
+	Type    string `gorm:"size:50;not null;index" json:"type"` // no-matching-flow, expression-failed, service-task-failed, ...
+	Message string `gorm:"type:text" json:"message"`
+	Stack   string `gorm:"type:jsonb" json:"stack"`              // scopes of the failed token, innermost first
+	Status  string `gorm:"size:50;not null;index" json:"status"` // open, resolved
+
+	// Resolution
+	Resolution string     `gorm:"size:50" json:"resolution"` // retried, skipped, resolved
+	Note       string     `gorm:"type:text" json:"note"`
+	ResolvedAt *time.Time `json:"resolved_at"`
+	ResolvedBy *uuid.UUID `gorm:"type:uuid" json:"resolved_by"`
+}
+
+// Job is a unit of deferred engine work, such as a due timer, picked up by the scheduler
+type Job struct {
+	BaseModel
+	Type   string    `gorm:"size:50;not null" json:"type"`   // timer-start, timer-catch, timer-boundary, service-retry
+	Status string    `gorm:"size:50;not null" json:"status"` // pending, suspended, completed, cancelled, failed
+	DueAt  time.Time `gorm:"not null" json:"due_at"`
+
+	// Target of the job
+	ProcessDefinitionID *uuid.UUID `gorm:"type:uuid;index" json:"process_definition_id"`
+	ProcessInstanceID   *uuid.UUID `gorm:"type:uuid;index" json:"process_instance_id"`
+	ExecutionID         *uuid.UUID `gorm:"type:uuid;index" json:"execution_id"`
+	ElementID           string     `gorm:"size:100" json:"element_id"`
+	Repeat              string     `gorm:"size:255" json:"repeat"`   // remaining timer cycle
+	Retries             int        `gorm:"default:0" json:"retries"` // service task retries left after this one
+
+	// Execution bookkeeping
+	Attempts   int        `gorm:"default:0" json:"attempts"`
+	LastError  string     `gorm:"type:text" json:"last_error"`
+	LockedBy   string     `gorm:"size:255" json:"locked_by"`
+	ExecutedAt *time.Time `json:"executed_at"`
+}
+
+// EventSubscription records a process element waiting for a message or signal.
+// Start event subscriptions belong to a definition and have no instance.
+type EventSubscription struct {
+	BaseModel
+	Type      string `gorm:"size:50;not null" json:"type"` // message, signal
+	EventName string `gorm:"size:255;not null" json:"event_name"`
+
+	ProcessDefinitionID uuid.UUID  `gorm:"type:uuid;not null;index" json:"process_definition_id"`
+	ProcessInstanceID   *uuid.UUID `gorm:"type:uuid;index" json:"process_instance_id"`
+	ExecutionID         *uuid.UUID `gorm:"type:uuid;index" json:"execution_id"`
+	ElementID           string     `gorm:"size:100;not null" json:"element_id"`
+}
+
+// SLAPolicy sets the service level of the tasks of one user task definition. Tasks
+// are due TargetDuration after creation unless the model sets a due date, become at
+// risk WarningThreshold before they are due, and Actions escalate them.
+type SLAPolicy struct {
+	BaseModel
+	ProcessDefinitionKey string `gorm:"size:100;not null;uniqueIndex:idx_sla_policies_task" json:"process_definition_key"`
+	TaskDefinitionKey    string `gorm:"size:100;not null;uniqueIndex:idx_sla_policies_task" json:"task_definition_key"`
+	Name                 string `gorm:"size:255" json:"name"`
+
+	TargetDuration   string `gorm:"size:50" json:"target_duration"`   // ISO 8601 duration
+	WarningThreshold string `gorm:"size:50" json:"warning_threshold"` // ISO 8601 duration before the due date
+	Calendar         string `gorm:"size:100" json:"calendar"`         // business calendar the durations count in, if any
+	Actions          string `gorm:"type:jsonb" json:"actions"`        // escalation actions, see engine.SLAAction
+	IsActive         bool   `gorm:"default:true" json:"is_active"`
+
+	// Audit fields
+	CreatedBy *uuid.UUID `gorm:"type:uuid" json:"created_by"`
+	UpdatedBy *uuid.UUID `gorm:"type:uuid" json:"updated_by"`
+}
+
+// BusinessCalendar defines when work happens in a region: the working hours of
+// each weekday in its time zone and its holidays. A calendar applies to the
+// processes and departments it lists; the default calendar applies to the rest.
+type BusinessCalendar struct {
+	BaseModel
+	Name        string `gorm:"uniqueIndex;not null;size:100" json:"name"`
+	Description string `gorm:"type:text" json:"description"`
+	Region      string `gorm:"size:100" json:"region"`
+	TimeZone    string `gorm:"size:64;not null;default:'UTC'" json:"time_zone"`
+
+	WorkingHours string `gorm:"type:jsonb;not null" json:"working_hours"` // weekday name to list of {start, end}
+	Holidays     string `gorm:"type:jsonb" json:"holidays"`               // list of {date, name}
+
+	// Assignment
+	ProcessDefinitionKeys StringArray `gorm:"type:text[]" json:"process_definition_keys"`
+	Departments           StringArray `gorm:"type:text[]" json:"departments"`
+	IsDefault             bool        `gorm:"default:false" json:"is_default"`
+
+	// Audit fields
+	CreatedBy *uuid.UUID `gorm:"type:uuid" json:"created_by"`
+	UpdatedBy *uuid.UUID `gorm:"type:uuid" json:"updated_by"`
+}
+
+// TaskEscalation records an escalation action taken on a task that is at risk or overdue
+type TaskEscalation struct {
+	BaseModel
+	TaskInstanceID    uuid.UUID  `gorm:"type:uuid;not null;index" json:"task_instance_id"`
+	ProcessInstanceID uuid.UUID  `gorm:"type:uuid;not null;index" json:"process_instance_id"`
+	SLAPolicyID       *uuid.UUID `gorm:"type:uuid" json:"sla_policy_id"`
+
+	Level  string `gorm:"size:20;not null" json:"level"`  // warning, breach
+	Action string `gorm:"size:50;not null" json:"action"` // raise-priority, reassign-group, notify, escalation
+	Detail string `gorm:"type:text" json:"detail"`
+}
+
+// Notification is a message for a user, or for every member of a group
+type Notification struct {
+	BaseModel
+	UserID *uuid.UUID `gorm:"type:uuid;index" json:"user_id"`
+	Group  string     `gorm:"size:100;index" json:"group,omitempty"`
+
+	Type       string     `gorm:"size:50;not null" json:"type"` // sla-warning, sla-breach, ...
+	Title      string     `gorm:"size:255;not null" json:"title"`
+	Message    string     `gorm:"type:text" json:"message"`
+	Resource   string     `gorm:"size:100" json:"resource"`
+	ResourceID *uuid.UUID `gorm:"type:uuid" json:"resource_id"`
+	ReadAt     *time.Time `json:"read_at"`
+}
+
+// HistoryEvent records a change to a process instance or one of its tasks: a
+// status change, an assignment or a variable update
+type HistoryEvent struct {
+	BaseModel
+	ProcessInstanceID uuid.UUID  `gorm:"type:uuid;not null;index" json:"process_instance_id"`
+	TaskInstanceID    *uuid.UUID `gorm:"type:uuid;index" json:"task_instance_id"`
+
+	Type   string     `gorm:"size:50;not null" json:"type"` // instance-started, task-claimed, variable-updated, ...
+	UserID *uuid.UUID `gorm:"type:uuid" json:"user_id"`
+	Detail string     `gorm:"type:jsonb" json:"detail"`
+}
+
+// Comment is a note left on a process instance, or on one of its tasks. Users
+// mentioned in it are notified.
+type Comment struct {
+	BaseModel
+	ProcessInstanceID uuid.UUID  `gorm:"type:uuid;not null;index" json:"process_instance_id"`
+	TaskInstanceID    *uuid.UUID `gorm:"type:uuid;index" json:"task_instance_id"`
+
+	AuthorID uuid.UUID  `gorm:"type:uuid;not null" json:"author_id"`
+	Author   *User      `gorm:"foreignKey:AuthorID" json:"author,omitempty"`
+	Body     string     `gorm:"type:text;not null" json:"body"`
+	Mentions []User     `gorm:"many2many:comment_mentions;" json:"mentions"`
+	EditedAt *time.Time `json:"edited_at"`
+}
+
+// Attachment is a file attached to a process instance, or to one of its tasks.
+// Its content is kept in the attachment storage under StorageKey.
+type Attachment struct {
+	BaseModel
+	ProcessInstanceID uuid.UUID  `gorm:"type:uuid;not null;index" json:"process_instance_id"`
+	TaskInstanceID    *uuid.UUID `gorm:"type:uuid;index" json:"task_instance_id"`
+	FormField         string     `gorm:"size:255" json:"form_field,omitempty"` // JSON pointer of the form field that references it
+
+	FileName    string `gorm:"size:255;not null" json:"file_name"`
+	ContentType string `gorm:"size:255;not null" json:"content_type"`
+	Size        int64  `gorm:"not null" json:"size"`
+	Checksum    string `gorm:"size:64;not null" json:"checksum"` // SHA-256, hex encoded
+	StorageKey  string `gorm:"size:500;not null" json:"-"`
+
+	// Audit fields
+	UploadedBy *uuid.UUID `gorm:"type:uuid" json:"uploaded_by"`
+}
+
+// Substitution is an out-of-office rule: while it is in effect, tasks assigned
+// to the user go to the substitute instead. A rule with a category only applies
+// to processes of that category.
+type Substitution struct {
+	BaseModel
+	UserID       uuid.UUID `gorm:"type:uuid;not null;index" json:"user_id"`
+	SubstituteID uuid.UUID `gorm:"type:uuid;not null" json:"substitute_id"`
+	Substitute   *User     `gorm:"foreignKey:SubstituteID" json:"substitute,omitempty"`
+	StartsAt     time.Time `gorm:"not null" json:"starts_at"`
+	EndsAt       time.Time `gorm:"not null" json:"ends_at"`
+	Category     string    `gorm:"size:100" json:"category"`
+	Reason       string    `gorm:"size:255" json:"reason"`
+}
+
 // BusinessRule represents a business rule
 type BusinessRule struct {
 	BaseModel
@@ -180,18 +413,19 @@ type BusinessRule struct {
 type FormSchema struct {
 	BaseModel
 	Name        string `gorm:"not null;size:255" json:"name"`
-	Key         string `gorm:"uniqueIndex;not null;size:100" json:"key"`
-	Version     int    `gorm:"not null;default:1" json:"version"`
+	Key         string `gorm:"uniqueIndex:idx_form_schemas_key_version;not null;size:100" json:"key"`
+	Version     int    `gorm:"uniqueIndex:idx_form_schemas_key_version;not null;default:1" json:"version"`
 	Description string `gorm:"type:text" json:"description"`
 
-	// Schema definition
+	// Schema definition, immutable once the version is published. The x-logic
+	// keyword of the UI schema holds the conditional and computed fields.
 	JSONSchema string `gorm:"type:jsonb;not null" json:"json_schema"`
 	UISchema   string `gorm:"type:jsonb" json:"ui_schema"`
 
 	// Schema metadata
-	Category string   `gorm:"size:100" json:"category"`
-	Tags     []string `gorm:"type:text[]" json:"tags"`
-	IsActive bool     `gorm:"default:true" json:"is_active"`
+	Category string      `gorm:"size:100" json:"category"`
+	Tags     StringArray `gorm:"type:text[]" json:"tags"`
+	IsActive bool        `gorm:"default:true" json:"is_active"`
 
 	// AI enhancement
 	AIGenerated bool `gorm:"default:false" json:"ai_generated"`
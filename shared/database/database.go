@@ -28,6 +28,8 @@ func Connect(cfg *config.Config) (*gorm.DB, error) {
 		NowFunc: func() time.Time {
 			return time.Now().UTC()
 		},
+		// Map driver errors such as unique violations to gorm.ErrDuplicatedKey
+		TranslateError: true,
 	}
 
 	// Connect to database
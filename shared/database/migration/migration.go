@@ -157,6 +157,120 @@ func getMigrations() []Migration {
 			Up:          migration005Up,
 			Down:        migration005Down,
 		},
+		{
+			Version:     "006_process_executions",
+			Description: "Create execution token table for the process engine",
+			Up:          migration006Up,
+			Down:        migration006Down,
+		},
+		{
+			Version:     "007_incidents",
+			Description: "Create incident table for failed process instances",
+			Up:          migration007Up,
+			Down:        migration007Down,
+		},
+		{
+			Version:     "008_jobs",
+			Description: "Create job table for timers and the scheduler",
+			Up:          migration008Up,
+			Down:        migration008Down,
+		},
+		{
+			Version:     "009_event_subscriptions",
+			Description: "Create event subscriptions for message and signal correlation",
+			Up:          migration009Up,
+			Down:        migration009Down,
+		},
+		{
+			Version:     "010_instance_hierarchy",
+			Description: "Link called process instances to their parent",
+			Up:          migration010Up,
+			Down:        migration010Down,
+		},
+		{
+			Version:     "011_execution_variables",
+			Description: "Add local variables to executions for multi-instance loops",
+			Up:          migration011Up,
+			Down:        migration011Down,
+		},
+		{
+			Version:     "012_incident_management",
+			Description: "Add stack, resolution and retry columns for incident management",
+			Up:          migration012Up,
+			Down:        migration012Down,
+		},
+		{
+			Version:     "013_instance_end_reason",
+			Description: "Record why a process instance was terminated",
+			Up:          migration013Up,
+			Down:        migration013Down,
+		},
+		{
+			Version:     "014_process_definition_versions",
+			Description: "Make process definitions unique per key and version",
+			Up:          migration014Up,
+			Down:        migration014Down,
+		},
+		{
+			Version:     "015_task_inbox_indexes",
+			Description: "Add indexes for the task inbox sort orders and candidate group lookups",
+			Up:          migration015Up,
+			Down:        migration015Down,
+		},
+		{
+			Version:     "016_task_delegation",
+			Description: "Add task owner and delegation state",
+			Up:          migration016Up,
+			Down:        migration016Down,
+		},
+		{
+			Version:     "017_task_form_binding",
+			Description: "Record the form schema a task is bound to",
+			Up:          migration017Up,
+			Down:        migration017Down,
+		},
+		{
+			Version:     "018_task_sla",
+			Description: "Add SLA policies, task escalations and notifications",
+			Up:          migration018Up,
+			Down:        migration018Down,
+		},
+		{
+			Version:     "019_business_calendars",
+			Description: "Add business calendars and the calendar of SLA policies",
+			Up:          migration019Up,
+			Down:        migration019Down,
+		},
+		{
+			Version:     "020_comments_history",
+			Description: "Add comments with mentions and the history of instance changes",
+			Up:          migration020Up,
+			Down:        migration020Down,
+		},
+		{
+			Version:     "021_attachments",
+			Description: "Add file attachments of process instances and tasks",
+			Up:          migration021Up,
+			Down:        migration021Down,
+		},
+		{
+			Version:     "022_substitutions",
+			Description: "Add out-of-office substitution rules and the original assignee of tasks",
+			Up:          migration022Up,
+			Down:        migration022Down,
+		},
+		{
+			Version:     "023_form_versions",
+			Description: "Make form schemas unique per key and version and bind tasks to the form versions they use",
+			Up:          migration023Up,
+			Down:        migration023Down,
+		},
+		{
+			Version:     "024_instance_suspended_by_parent",
+			Description: "Record which called instances were suspended along with their parent",
+			Up:          migration024Up,
+			Down:        migration024Down,
+		},
 	}
 }
 
@@ -328,3 +442,283 @@ func migration005Down(db *gorm.DB) error {
 
 	return nil
 }
+
+// migration006Up - Execution tokens
+func migration006Up(db *gorm.DB) error {
+	if err := db.AutoMigrate(&models.Execution{}, &models.TaskInstance{}); err != nil {
+		return err
+	}
+
+	return db.Exec("CREATE INDEX IF NOT EXISTS idx_executions_instance_status ON executions(process_instance_id, status)").Error
+}
+
+func migration006Down(db *gorm.DB) error {
+	if err := db.Migrator().DropColumn(&models.TaskInstance{}, "ExecutionID"); err != nil {
+		return err
+	}
+	return db.Migrator().DropTable(&models.Execution{})
+}
+
+// migration007Up - Incidents
+func migration007Up(db *gorm.DB) error {
+	return db.AutoMigrate(&models.Incident{})
+}
+
+func migration007Down(db *gorm.DB) error {
+	return db.Migrator().DropTable(&models.Incident{})
+}
+
+// migration008Up - Scheduler jobs
+func migration008Up(db *gorm.DB) error {
+	if err := db.AutoMigrate(&models.Job{}); err != nil {
+		return err
+	}
+
+	return db.Exec("CREATE INDEX IF NOT EXISTS idx_jobs_status_due_at ON jobs(status, due_at)").Error
+}
+
+func migration008Down(db *gorm.DB) error {
+	return db.Migrator().DropTable(&models.Job{})
+}
+
+// migration009Up - Message and signal subscriptions
+func migration009Up(db *gorm.DB) error {
+	if err := db.AutoMigrate(&models.EventSubscription{}); err != nil {
+		return err
+	}
+
+	indexes := []string{
+		"CREATE INDEX IF NOT EXISTS idx_event_subscriptions_type_name ON event_subscriptions(type, event_name)",
+		"CREATE INDEX IF NOT EXISTS idx_process_instances_business_key ON process_instances(business_key)",
+	}
+	for _, index := range indexes {
+		if err := db.Exec(index).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func migration009Down(db *gorm.DB) error {
+	if err := db.Exec("DROP INDEX IF EXISTS idx_process_instances_business_key").Error; err != nil {
+		return err
+	}
+	return db.Migrator().DropTable(&models.EventSubscription{})
+}
+
+// migration010Up - Call activity parent links
+func migration010Up(db *gorm.DB) error {
+	return db.AutoMigrate(&models.ProcessInstance{})
+}
+
+func migration010Down(db *gorm.DB) error {
+	for _, column := range []string{"ParentInstanceID", "ParentExecutionID"} {
+		if err := db.Migrator().DropColumn(&models.ProcessInstance{}, column); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// migration011Up - Execution local variables
+func migration011Up(db *gorm.DB) error {
+	return db.AutoMigrate(&models.Execution{})
+}
+
+func migration011Down(db *gorm.DB) error {
+	return db.Migrator().DropColumn(&models.Execution{}, "Variables")
+}
+
+// migration012Up - Incident management and service task retries
+func migration012Up(db *gorm.DB) error {
+	return db.AutoMigrate(&models.Incident{}, &models.Job{})
+}
+
+func migration012Down(db *gorm.DB) error {
+	for _, column := range []string{"Stack", "Resolution", "Note"} {
+		if err := db.Migrator().DropColumn(&models.Incident{}, column); err != nil {
+			return err
+		}
+	}
+	if err := db.Exec("DROP INDEX IF EXISTS idx_incidents_type").Error; err != nil {
+		return err
+	}
+	return db.Migrator().DropColumn(&models.Job{}, "Retries")
+}
+
+// migration013Up - Termination reason
+func migration013Up(db *gorm.DB) error {
+	return db.AutoMigrate(&models.ProcessInstance{})
+}
+
+func migration013Down(db *gorm.DB) error {
+	return db.Migrator().DropColumn(&models.ProcessInstance{}, "EndReason")
+}
+
+// migration014Up - Process definition versions
+func migration014Up(db *gorm.DB) error {
+	if err := db.Exec("DROP INDEX IF EXISTS idx_process_definitions_key").Error; err != nil {
+		return err
+	}
+	return db.AutoMigrate(&models.ProcessDefinition{})
+}
+
+func migration014Down(db *gorm.DB) error {
+	if err := db.Exec("DROP INDEX IF EXISTS idx_process_definitions_key_version").Error; err != nil {
+		return err
+	}
+	return db.Exec("CREATE UNIQUE INDEX IF NOT EXISTS idx_process_definitions_key ON process_definitions(key)").Error
+}
+
+// migration015Up - Task inbox indexes
+func migration015Up(db *gorm.DB) error {
+	indexes := []string{
+		"CREATE INDEX IF NOT EXISTS idx_task_instances_created_at ON task_instances(created_at, id)",
+		"CREATE INDEX IF NOT EXISTS idx_task_instances_priority ON task_instances(priority, id)",
+		"CREATE INDEX IF NOT EXISTS idx_task_instances_candidate_group ON task_instances(candidate_group) WHERE assignee_id IS NULL",
+	}
+
+	for _, indexSQL := range indexes {
+		if err := db.Exec(indexSQL).Error; err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func migration015Down(db *gorm.DB) error {
+	indexes := []string{
+		"DROP INDEX IF EXISTS idx_task_instances_created_at",
+		"DROP INDEX IF EXISTS idx_task_instances_priority",
+		"DROP INDEX IF EXISTS idx_task_instances_candidate_group",
+	}
+
+	for _, indexSQL := range indexes {
+		if err := db.Exec(indexSQL).Error; err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// migration016Up - Task delegation
+func migration016Up(db *gorm.DB) error {
+	return db.AutoMigrate(&models.TaskInstance{})
+}
+
+func migration016Down(db *gorm.DB) error {
+	for _, column := range []string{"OwnerID", "DelegationState"} {
+		if err := db.Migrator().DropColumn(&models.TaskInstance{}, column); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// migration017Up - Task form binding
+func migration017Up(db *gorm.DB) error {
+	return db.AutoMigrate(&models.TaskInstance{})
+}
+
+func migration017Down(db *gorm.DB) error {
+	return db.Migrator().DropColumn(&models.TaskInstance{}, "FormKey")
+}
+
+// migration018Up - Task SLA tracking and escalation
+func migration018Up(db *gorm.DB) error {
+	if err := db.AutoMigrate(&models.SLAPolicy{}, &models.TaskEscalation{}, &models.Notification{}, &models.TaskInstance{}); err != nil {
+		return err
+	}
+
+	return db.Exec("CREATE INDEX IF NOT EXISTS idx_task_instances_sla ON task_instances(sla_warning_at, due_date) WHERE sla_policy_id IS NOT NULL").Error
+}
+
+func migration018Down(db *gorm.DB) error {
+	if err := db.Exec("DROP INDEX IF EXISTS idx_task_instances_sla").Error; err != nil {
+		return err
+	}
+	for _, column := range []string{"SLAPolicyID", "SLAWarningAt", "SLAStatus"} {
+		if err := db.Migrator().DropColumn(&models.TaskInstance{}, column); err != nil {
+			return err
+		}
+	}
+	return db.Migrator().DropTable(&models.Notification{}, &models.TaskEscalation{}, &models.SLAPolicy{})
+}
+
+// migration019Up - Business calendars
+func migration019Up(db *gorm.DB) error {
+	return db.AutoMigrate(&models.BusinessCalendar{}, &models.SLAPolicy{})
+}
+
+func migration019Down(db *gorm.DB) error {
+	if err := db.Migrator().DropColumn(&models.SLAPolicy{}, "Calendar"); err != nil {
+		return err
+	}
+	return db.Migrator().DropTable(&models.BusinessCalendar{})
+}
+
+// migration020Up - Comments and instance history
+func migration020Up(db *gorm.DB) error {
+	return db.AutoMigrate(&models.HistoryEvent{}, &models.Comment{})
+}
+
+func migration020Down(db *gorm.DB) error {
+	if err := db.Migrator().DropTable("comment_mentions"); err != nil {
+		return err
+	}
+	return db.Migrator().DropTable(&models.Comment{}, &models.HistoryEvent{})
+}
+
+// migration021Up - Attachments
+func migration021Up(db *gorm.DB) error {
+	return db.AutoMigrate(&models.Attachment{})
+}
+
+func migration021Down(db *gorm.DB) error {
+	return db.Migrator().DropTable(&models.Attachment{})
+}
+
+// migration022Up - Out-of-office substitution rules
+func migration022Up(db *gorm.DB) error {
+	return db.AutoMigrate(&models.Substitution{}, &models.TaskInstance{})
+}
+
+func migration022Down(db *gorm.DB) error {
+	for _, column := range []string{"SubstitutionID", "OriginalAssigneeID"} {
+		if err := db.Migrator().DropColumn(&models.TaskInstance{}, column); err != nil {
+			return err
+		}
+	}
+	return db.Migrator().DropTable(&models.Substitution{})
+}
+
+// migration023Up - Form schema versions
+func migration023Up(db *gorm.DB) error {
+	if err := db.Exec("DROP INDEX IF EXISTS idx_form_schemas_key").Error; err != nil {
+		return err
+	}
+	return db.AutoMigrate(&models.FormSchema{}, &models.TaskInstance{})
+}
+
+func migration023Down(db *gorm.DB) error {
+	for _, column := range []string{"FormSchemaID", "FormRefs"} {
+		if err := db.Migrator().DropColumn(&models.TaskInstance{}, column); err != nil {
+			return err
+		}
+	}
+	if err := db.Exec("DROP INDEX IF EXISTS idx_form_schemas_key_version").Error; err != nil {
+		return err
+	}
+	return db.Exec("CREATE UNIQUE INDEX IF NOT EXISTS idx_form_schemas_key ON form_schemas(key)").Error
+}
+
+// migration024Up - Suspension cascade
+func migration024Up(db *gorm.DB) error {
+	return db.AutoMigrate(&models.ProcessInstance{})
+}
+
+func migration024Down(db *gorm.DB) error {
+	return db.Migrator().DropColumn(&models.ProcessInstance{}, "SuspendedByParent")
+}
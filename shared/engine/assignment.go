@@ -0,0 +1,237 @@
+package engine
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+
+	"github.com/tvolodi/ai-bpms-backend/shared/common/models"
+)
+
+// Built-in assignment strategies
+const (
+	StrategyRoundRobin     = "round-robin"
+	StrategyLeastLoaded    = "least-loaded"
+	StrategyAttributeMatch = "attribute-match"
+)
+
+// AssignmentStrategy picks the assignee of a new user task among the members of
+// its candidate groups. Strategies are named in the process definition and
+// registered with RegisterAssignmentStrategy.
+type AssignmentStrategy interface {
+	// Assign returns the candidate the task goes to, or nil to leave the task
+	// for the candidate groups to claim
+	Assign(ctx context.Context, a Assignment) (*uuid.UUID, error)
+}
+
+// Assignment is the context passed to an AssignmentStrategy
+type Assignment struct {
+	// Tx is the transaction the task is created in
+	Tx *gorm.DB
+
+	ProcessDefinitionID uuid.UUID
+	Task                *models.TaskInstance
+
+	// Candidates are the active members of the candidate groups, by ID
+	Candidates []models.User
+
+	// Parameters are the strategy parameters of the task definition with their
+	// expressions evaluated
+	Parameters map[string]string
+}
+
+// AssignmentFunc adapts a function to the AssignmentStrategy interface
+type AssignmentFunc func(ctx context.Context, a Assignment) (*uuid.UUID, error)
+
+// Assign calls f
+func (f AssignmentFunc) Assign(ctx context.Context, a Assignment) (*uuid.UUID, error) {
+	return f(ctx, a)
+}
+
+// RegisterAssignmentStrategy registers a strategy under the name user tasks
+// refer to it by, replacing a built-in strategy of the same name
+func (e *Engine) RegisterAssignmentStrategy(name string, strategy AssignmentStrategy) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.strategies[name] = strategy
+}
+
+func (e *Engine) assignmentStrategy(name string) (AssignmentStrategy, bool) {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	strategy, ok := e.strategies[name]
+	return strategy, ok
+}
+
+// builtinStrategies returns the strategies every engine starts with
+func builtinStrategies() map[string]AssignmentStrategy {
+	return map[string]AssignmentStrategy{
+		StrategyRoundRobin:     AssignmentFunc(roundRobin),
+		StrategyLeastLoaded:    AssignmentFunc(leastLoaded),
+		StrategyAttributeMatch: AssignmentFunc(attributeMatch),
+	}
+}
+
+// autoAssign assigns a new user task with the strategy of its task definition.
+// The task is left unassigned when no candidate qualifies.
+func (r *run) autoAssign(task *models.TaskInstance, name string, params map[string]string, vars map[string]interface{}) error {
+	strategy, ok := r.e.assignmentStrategy(name)
+	if !ok {
+		return raise(IncidentAssignmentFailed, "user task %s: unknown assignment strategy %q", task.TaskDefinitionKey, name)
+	}
+
+	resolved := make(map[string]string, len(params))
+	for key, value := range params {
+		v, err := resolveValue(value, vars)
+		if err != nil {
+			return raise(IncidentExpressionFailed, "user task %s assignment parameter %s: %v", task.TaskDefinitionKey, key, err)
+		}
+		if v != nil {
+			resolved[key] = fmt.Sprint(v)
+		}
+	}
+
+	candidates, err := groupMembers(r.tx, task.CandidateGroup)
+	if err != nil {
+		return err
+	}
+	if len(candidates) == 0 {
+		return nil
+	}
+
+	assignee, err := strategy.Assign(r.ctx, Assignment{
+		Tx:                  r.tx,
+		ProcessDefinitionID: r.inst.ProcessDefinitionID,
+		Task:                task,
+		Candidates:          candidates,
+		Parameters:          resolved,
+	})
+	if err != nil {
+		return raise(IncidentAssignmentFailed, "user task %s: assignment strategy %s: %v", task.TaskDefinitionKey, name, err)
+	}
+	if assignee != nil {
+		assign(task, assignee, nil)
+	}
+	return nil
+}
+
+// groupMembers returns the active users who hold a role named in a
+// comma-separated candidate group or belong to one of its process groups
+func groupMembers(tx *gorm.DB, candidateGroup string) ([]models.User, error) {
+	var groups []string
+	for _, group := range strings.Split(candidateGroup, ",") {
+		if group = strings.TrimSpace(group); group != "" {
+			groups = append(groups, group)
+		}
+	}
+	if len(groups) == 0 {
+		return nil, nil
+	}
+
+	var users []models.User
+	err := tx.Where("is_active").
+		Where(tx.Where("EXISTS (SELECT 1 FROM user_roles JOIN roles ON roles.id = user_roles.role_id WHERE user_roles.user_id = users.id AND roles.deleted_at IS NULL AND roles.name IN ?)", groups).
+			Or("EXISTS (SELECT 1 FROM unnest(users.process_groups) AS g(name) WHERE g.name IN ?)", groups)).
+		Order("id").
+		Find(&users).Error
+	return users, err
+}
+
+// candidateIDs returns the IDs of the users
+func candidateIDs(users []models.User) []uuid.UUID {
+	ids := make([]uuid.UUID, len(users))
+	for i, user := range users {
+		ids[i] = user.ID
+	}
+	return ids
+}
+
+// roundRobin gives the task to the candidate after the one who got the previous
+// task of the same task definition, in any version of the process, in the order
+// of their IDs. Tasks routed to a substitute count for their original assignee.
+func roundRobin(ctx context.Context, a Assignment) (*uuid.UUID, error) {
+	tx := a.Tx.WithContext(ctx)
+	// Serialize the assignments of the task definition, so that concurrent
+	// instances do not pick the same candidate
+	err := tx.Exec("SELECT pg_advisory_xact_lock(hashtext((SELECT key FROM process_definitions WHERE id = ?) || '/' || ?))",
+		a.ProcessDefinitionID, a.Task.TaskDefinitionKey).Error
+	if err != nil {
+		return nil, err
+	}
+
+	var last []uuid.UUID
+	err = tx.Model(&models.TaskInstance{}).
+		Joins("JOIN process_instances ON process_instances.id = task_instances.process_instance_id").
+		Joins("JOIN process_definitions ON process_definitions.id = process_instances.process_definition_id").
+		Where("process_definitions.key = (SELECT key FROM process_definitions WHERE id = ?)", a.ProcessDefinitionID).
+		Where("task_instances.task_definition_key = ?", a.Task.TaskDefinitionKey).
+		Where("COALESCE(task_instances.original_assignee_id, task_instances.assignee_id) IN ?", candidateIDs(a.Candidates)).
+		Order("task_instances.created_at DESC").
+		Limit(1).
+		Pluck("COALESCE(task_instances.original_assignee_id, task_instances.assignee_id)", &last).Error
+	if err != nil {
+		return nil, err
+	}
+
+	next := a.Candidates[0].ID
+	if len(last) > 0 {
+		i := sort.Search(len(a.Candidates), func(i int) bool {
+			return strings.Compare(a.Candidates[i].ID.String(), last[0].String()) > 0
+		})
+		if i < len(a.Candidates) {
+			next = a.Candidates[i].ID
+		}
+	}
+	return &next, nil
+}
+
+// leastLoaded gives the task to the candidate with the fewest open tasks,
+// preferring the one who was assigned a task longest ago
+func leastLoaded(ctx context.Context, a Assignment) (*uuid.UUID, error) {
+	return fewestOpenTasks(a.Tx.WithContext(ctx), candidateIDs(a.Candidates))
+}
+
+// fewestOpenTasks returns the user with the fewest open tasks among the users
+func fewestOpenTasks(tx *gorm.DB, userIDs []uuid.UUID) (*uuid.UUID, error) {
+	var picked []uuid.UUID
+	err := tx.Table("users").
+		Joins("LEFT JOIN task_instances ON task_instances.assignee_id = users.id AND task_instances.status IN ? AND task_instances.deleted_at IS NULL", []string{TaskCreated, TaskAssigned}).
+		Where("users.id IN ?", userIDs).
+		Group("users.id").
+		Order("count(task_instances.id), max(task_instances.assigned_at) NULLS FIRST, users.id").
+		Limit(1).
+		Pluck("users.id", &picked).Error
+	if err != nil || len(picked) == 0 {
+		return nil, err
+	}
+	return &picked[0], nil
+}
+
+// attributeMatch gives the task to the least loaded candidate whose department
+// and position match the department and position parameters; parameters that
+// are not given match every candidate
+func attributeMatch(ctx context.Context, a Assignment) (*uuid.UUID, error) {
+	department, position := a.Parameters["department"], a.Parameters["position"]
+	if department == "" && position == "" {
+		return nil, fmt.Errorf("needs a department or position parameter")
+	}
+
+	var matching []uuid.UUID
+	for _, user := range a.Candidates {
+		if department != "" && !strings.EqualFold(user.Department, department) {
+			continue
+		}
+		if position != "" && !strings.EqualFold(user.Position, position) {
+			continue
+		}
+		matching = append(matching, user.ID)
+	}
+	if len(matching) == 0 {
+		return nil, nil
+	}
+	return fewestOpenTasks(a.Tx.WithContext(ctx), matching)
+}
@@ -0,0 +1,69 @@
+package engine
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+
+	"github.com/tvolodi/ai-bpms-backend/shared/calendar"
+	"github.com/tvolodi/ai-bpms-backend/shared/common/models"
+)
+
+// CalendarBusiness refers to the business calendar assigned to a process
+const CalendarBusiness = "business"
+
+// ErrCalendarNotFound is returned when a duration refers to a business calendar that does not exist
+var ErrCalendarNotFound = errors.New("business calendar not found")
+
+// calendar returns the business calendar a duration of the instance counts in
+func (r *run) calendar(ref string) (*calendar.Calendar, error) {
+	return resolveCalendar(r.tx, ref, r.inst.ProcessDefinitionID, r.inst.StartedBy)
+}
+
+// resolveCalendar loads a business calendar by name, or for "business" the
+// calendar assigned to the process key, then the one assigned to the department
+// of the user who started the instance, then the default calendar. An empty
+// reference counts plain calendar time and returns nil.
+func resolveCalendar(tx *gorm.DB, ref string, definitionID uuid.UUID, startedBy *uuid.UUID) (*calendar.Calendar, error) {
+	if ref == "" {
+		return nil, nil
+	}
+
+	var cal models.BusinessCalendar
+	find := func(query string, args ...interface{}) error {
+		if cal.ID != uuid.Nil {
+			return nil
+		}
+		return tx.Where(query, args...).Order("name").Limit(1).Find(&cal).Error
+	}
+	if ref != CalendarBusiness {
+		if err := find("name = ?", ref); err != nil {
+			return nil, err
+		}
+	} else {
+		err := find("EXISTS (SELECT 1 FROM process_definitions WHERE process_definitions.id = ? AND process_definitions.key = ANY(business_calendars.process_definition_keys))", definitionID)
+		if err != nil {
+			return nil, err
+		}
+		if startedBy != nil {
+			err := find("EXISTS (SELECT 1 FROM users WHERE users.id = ? AND users.department <> '' AND users.department = ANY(business_calendars.departments))", *startedBy)
+			if err != nil {
+				return nil, err
+			}
+		}
+		if err := find("is_default = ?", true); err != nil {
+			return nil, err
+		}
+	}
+	if cal.ID == uuid.Nil {
+		return nil, fmt.Errorf("%w: %s", ErrCalendarNotFound, ref)
+	}
+
+	parsed, err := calendar.Parse(cal.TimeZone, cal.WorkingHours, cal.Holidays)
+	if err != nil {
+		return nil, fmt.Errorf("business calendar %s: %w", cal.Name, err)
+	}
+	return parsed, nil
+}
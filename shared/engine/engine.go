@@ -0,0 +1,361 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"github.com/tvolodi/ai-bpms-backend/shared/bpmn"
+	"github.com/tvolodi/ai-bpms-backend/shared/common/models"
+)
+
+// Process instance statuses
+const (
+	InstanceActive     = "active"
+	InstanceCompleted  = "completed"
+	InstanceSuspended  = "suspended"
+	InstanceTerminated = "terminated"
+)
+
+// Task instance statuses
+const (
+	TaskCreated   = "created"
+	TaskAssigned  = "assigned"
+	TaskCompleted = "completed"
+	TaskCancelled = "cancelled"
+)
+
+// Execution statuses
+const (
+	ExecutionActive    = "active"
+	ExecutionWaiting   = "waiting"
+	ExecutionCompleted = "completed"
+	ExecutionCancelled = "cancelled"
+	ExecutionFailed    = "failed"
+
+	// ExecutionCompensated marks a completed activity whose compensation handler has run
+	ExecutionCompensated = "compensated"
+)
+
+// openStatuses are the execution statuses of tokens that still hold their scope open
+var openStatuses = []string{ExecutionActive, ExecutionWaiting, ExecutionFailed}
+
+// Engine errors
+var (
+	ErrDefinitionNotFound = errors.New("process definition not found")
+	ErrDefinitionInactive = errors.New("process definition is not active")
+	ErrInstanceNotFound   = errors.New("process instance not found")
+	ErrTaskNotFound       = errors.New("task not found")
+	ErrTaskNotActive      = errors.New("task is not active")
+	ErrInstanceNotActive  = errors.New("process instance is not active")
+	ErrTaskCompleted      = errors.New("task is already completed")
+	ErrTaskCancelled      = errors.New("task is cancelled")
+	ErrFormNotFound       = errors.New("form schema not found")
+)
+
+// ServiceHandler executes the work of a service task. The returned
+// variables are merged into the process instance variables.
+type ServiceHandler func(ctx context.Context, task ServiceTask) (map[string]interface{}, error)
+
+// ServiceTask is the context passed to a ServiceHandler
+type ServiceTask struct {
+	ProcessInstanceID uuid.UUID
+	ElementID         string
+	BusinessKey       string
+	Variables         map[string]interface{}
+}
+
+// StartOptions holds the optional parameters of a new process instance
+type StartOptions struct {
+	BusinessKey string
+	Variables   map[string]interface{}
+	StartedBy   *uuid.UUID
+}
+
+// CompleteOptions holds the parameters used to complete a user task. FormData
+// is the submitted form; when the task is bound to a form schema it is
+// validated and only the fields the schema declares become process variables.
+// CompletedBy must be the assignee of the task or, when it is unassigned, a
+// candidate through one of Groups, the roles and process groups of the user.
+type CompleteOptions struct {
+	FormData    map[string]interface{}
+	CompletedBy *uuid.UUID
+	Groups      []string
+}
+
+// Engine executes BPMN process definitions by moving tokens through the parsed graph.
+// Every state transition runs inside a single database transaction that holds
+// a row lock on the process instance.
+type Engine struct {
+	db *gorm.DB
+
+	mu         sync.RWMutex
+	services   map[string]ServiceHandler
+	strategies map[string]AssignmentStrategy
+	cache      map[uuid.UUID]cachedDefinition
+}
+
+type cachedDefinition struct {
+	updatedAt time.Time
+	defs      *bpmn.Definitions
+}
+
+// New creates a new process engine
+func New(db *gorm.DB) *Engine {
+	return &Engine{
+		db:         db,
+		services:   make(map[string]ServiceHandler),
+		strategies: builtinStrategies(),
+		cache:      make(map[uuid.UUID]cachedDefinition),
+	}
+}
+
+// RegisterService registers the handler invoked for service tasks whose implementation matches name
+func (e *Engine) RegisterService(name string, handler ServiceHandler) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.services[name] = handler
+}
+
+func (e *Engine) service(name string) (ServiceHandler, bool) {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	handler, ok := e.services[name]
+	return handler, ok
+}
+
+// StartInstance creates a process instance of the given definition and runs it
+// until every token is waiting on a user task or the instance has completed.
+func (e *Engine) StartInstance(ctx context.Context, definitionID uuid.UUID, opts StartOptions) (*models.ProcessInstance, error) {
+	var inst *models.ProcessInstance
+	err := e.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		def, defs, err := e.definition(tx, definitionID)
+		if err != nil {
+			return err
+		}
+		if !def.IsActive {
+			return ErrDefinitionInactive
+		}
+
+		proc := defs.ExecutableProcess()
+		start := proc.NoneStartEvent()
+		if start == nil {
+			return fmt.Errorf("process %s has no start event without an event definition", proc.ID)
+		}
+		inst, err = e.start(ctx, tx, def, defs, start, opts, nil)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return inst, nil
+}
+
+// start creates a process instance and places its first token on the given start event.
+// Instances started by a call activity are linked to the waiting execution of the caller.
+func (e *Engine) start(ctx context.Context, tx *gorm.DB, def *models.ProcessDefinition, defs *bpmn.Definitions, start *bpmn.Node, opts StartOptions, caller *callContext) (*models.ProcessInstance, error) {
+	vars := opts.Variables
+	if vars == nil {
+		vars = make(map[string]interface{})
+	}
+	encoded, err := encodeVariables(vars)
+	if err != nil {
+		return nil, err
+	}
+
+	inst := &models.ProcessInstance{
+		ProcessDefinitionID: def.ID,
+		BusinessKey:         opts.BusinessKey,
+		Status:              InstanceActive,
+		Variables:           encoded,
+		Context:             "{}",
+		StartedAt:           time.Now().UTC(),
+		StartedBy:           opts.StartedBy,
+	}
+	if caller != nil {
+		inst.ParentInstanceID = &caller.run.inst.ID
+		inst.ParentExecutionID = &caller.execution.ID
+	}
+	if err := tx.Omit(clause.Associations).Create(inst).Error; err != nil {
+		return nil, fmt.Errorf("failed to create process instance: %w", err)
+	}
+
+	r := newRun(ctx, e, tx, inst, defs, vars)
+	if caller != nil {
+		r.caller = caller.run
+	}
+	// The start variables are recorded as the first updates of the instance
+	r.actor = opts.StartedBy
+	r.recorded = make(map[string][]byte)
+	detail := map[string]interface{}{"business_key": inst.BusinessKey, "definition_version": def.Version}
+	if caller != nil {
+		detail["parent_instance_id"] = inst.ParentInstanceID
+	}
+	if err := r.record(EventInstanceStarted, nil, detail); err != nil {
+		return nil, err
+	}
+	if _, err := r.spawn(nil, start); err != nil {
+		return nil, err
+	}
+	if err := r.drain(); err != nil {
+		return nil, err
+	}
+	return inst, nil
+}
+
+// CompleteTask completes an open user task, validates and stores its form data,
+// merges the form outputs into the process instance and continues token
+// execution from the task, all in one transaction. An invalid form is reported
+// as forms.ValidationErrors.
+func (e *Engine) CompleteTask(ctx context.Context, taskID uuid.UUID, opts CompleteOptions) (*models.TaskInstance, error) {
+	var task models.TaskInstance
+	err := e.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.First(&task, "id = ?", taskID).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return ErrTaskNotFound
+			}
+			return err
+		}
+
+		r, err := e.load(ctx, tx, task.ProcessInstanceID)
+		if err != nil {
+			return err
+		}
+		r.actor = opts.CompletedBy
+
+		// Re-read and lock the task now that the instance is locked, so that
+		// reassignments and priority changes cannot be overwritten
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&task, "id = ?", taskID).Error; err != nil {
+			return err
+		}
+		switch task.Status {
+		case TaskCreated, TaskAssigned:
+		case TaskCompleted:
+			return ErrTaskCompleted
+		case TaskCancelled:
+			return ErrTaskCancelled
+		default:
+			return ErrTaskNotActive
+		}
+		if task.DelegationState == DelegationPending {
+			return ErrTaskDelegated
+		}
+		switch {
+		case opts.CompletedBy == nil:
+			return ErrNotAssignee
+		case task.AssigneeID != nil && *task.AssigneeID != *opts.CompletedBy:
+			return ErrNotAssignee
+		case task.AssigneeID == nil && !isCandidate(task.CandidateGroup, opts.Groups):
+			return ErrNotCandidate
+		}
+		if r.inst.Status != InstanceActive {
+			return ErrInstanceNotActive
+		}
+
+		outputs, err := formOutputs(tx, &task, opts.FormData)
+		if err != nil {
+			return err
+		}
+		formData, err := encodeVariables(opts.FormData)
+		if err != nil {
+			return err
+		}
+
+		now := time.Now().UTC()
+		duration := now.Sub(task.CreatedAt).Milliseconds()
+		task.Status = TaskCompleted
+		task.FormData = formData
+		task.CompletedAt = &now
+		task.CompletedBy = opts.CompletedBy
+		task.Duration = &duration
+		if err := tx.Omit(clause.Associations).Save(&task).Error; err != nil {
+			return err
+		}
+		if err := r.record(EventTaskCompleted, &task.ID, map[string]interface{}{"name": task.Name}); err != nil {
+			return err
+		}
+
+		for k, v := range outputs {
+			r.vars[k] = v
+		}
+
+		if task.ExecutionID == nil {
+			return fmt.Errorf("task %s is not bound to an execution", task.ID)
+		}
+		ex, err := r.execution(*task.ExecutionID)
+		if err != nil {
+			return err
+		}
+		node, err := r.node(ex.ElementID)
+		if err != nil {
+			return err
+		}
+		if err := r.taskCompleted(ex, node); err != nil {
+			return err
+		}
+		return r.drain()
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &task, nil
+}
+
+// load locks a process instance for update and prepares a run over it
+func (e *Engine) load(ctx context.Context, tx *gorm.DB, instanceID uuid.UUID) (*run, error) {
+	var inst models.ProcessInstance
+	err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&inst, "id = ?", instanceID).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrInstanceNotFound
+		}
+		return nil, err
+	}
+
+	_, defs, err := e.definition(tx, inst.ProcessDefinitionID)
+	if err != nil {
+		return nil, err
+	}
+
+	vars, err := decodeVariables(inst.Variables)
+	if err != nil {
+		return nil, err
+	}
+
+	return newRun(ctx, e, tx, &inst, defs, vars), nil
+}
+
+// definition loads a process definition together with its parsed BPMN graph
+func (e *Engine) definition(tx *gorm.DB, id uuid.UUID) (*models.ProcessDefinition, *bpmn.Definitions, error) {
+	var def models.ProcessDefinition
+	if err := tx.First(&def, "id = ?", id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil, ErrDefinitionNotFound
+		}
+		return nil, nil, err
+	}
+
+	e.mu.RLock()
+	cached, ok := e.cache[id]
+	e.mu.RUnlock()
+	if ok && cached.updatedAt.Equal(def.UpdatedAt) {
+		return &def, cached.defs, nil
+	}
+
+	defs, err := bpmn.ParseString(def.BPMN)
+	if err != nil {
+		return nil, nil, fmt.Errorf("process definition %s: %w", def.Key, err)
+	}
+
+	e.mu.Lock()
+	e.cache[id] = cachedDefinition{updatedAt: def.UpdatedAt, defs: defs}
+	e.mu.Unlock()
+
+	return &def, defs, nil
+}
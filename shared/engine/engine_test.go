@@ -0,0 +1,98 @@
+package engine
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+
+	"github.com/tvolodi/ai-bpms-backend/shared/common/models"
+	"github.com/tvolodi/ai-bpms-backend/shared/database/migration"
+)
+
+// testDB connects to the database named by TEST_DATABASE_URL and migrates it.
+// Tests that need a database are skipped when it is not set.
+func testDB(t *testing.T) *gorm.DB {
+	t.Helper()
+	dsn := os.Getenv("TEST_DATABASE_URL")
+	if dsn == "" {
+		t.Skip("TEST_DATABASE_URL is not set")
+	}
+	db, err := gorm.Open(postgres.Open(dsn), &gorm.Config{
+		Logger:         logger.Default.LogMode(logger.Silent),
+		NowFunc:        func() time.Time { return time.Now().UTC() },
+		TranslateError: true,
+	})
+	if err != nil {
+		t.Fatalf("failed to connect to the test database: %v", err)
+	}
+	if err := migration.NewMigrator(db).Run(); err != nil {
+		t.Fatalf("failed to migrate the test database: %v", err)
+	}
+	return db
+}
+
+// reviewProcess is a process with a single user task for the reviewers group
+const reviewProcess = `
+<startEvent id="start"/>
+<sequenceFlow id="f1" sourceRef="start" targetRef="review"/>
+<userTask id="review" name="Review" candidateGroups="reviewers"/>
+<sequenceFlow id="f2" sourceRef="review" targetRef="end"/>
+<endEvent id="end"/>`
+
+// document wraps the elements of an executable process into a BPMN document
+func document(id, process string) string {
+	return fmt.Sprintf(`<?xml version="1.0" encoding="UTF-8"?>
+<definitions xmlns="http://www.omg.org/spec/BPMN/20100524/MODEL" id="defs">
+  <process id="%s" isExecutable="true">%s</process>
+</definitions>`, id, process)
+}
+
+// deploy stores a process definition with the given process elements under a
+// key of its own
+func deploy(t *testing.T, db *gorm.DB, process string) *models.ProcessDefinition {
+	t.Helper()
+	key := "test-" + uuid.NewString()
+	def := &models.ProcessDefinition{
+		Name:     key,
+		Key:      key,
+		BPMN:     document(key, process),
+		IsActive: true,
+	}
+	if err := db.Create(def).Error; err != nil {
+		t.Fatalf("failed to deploy %s: %v", key, err)
+	}
+	return def
+}
+
+// newUser creates an active user
+func newUser(t *testing.T, db *gorm.DB) uuid.UUID {
+	t.Helper()
+	user := &models.User{Email: uuid.NewString() + "@example.com", IsActive: true}
+	if err := db.Create(user).Error; err != nil {
+		t.Fatalf("failed to create a user: %v", err)
+	}
+	return user.ID
+}
+
+// openTask starts an instance of the definition and returns its only open task
+func openTask(t *testing.T, e *Engine, def *models.ProcessDefinition) *models.TaskInstance {
+	t.Helper()
+	inst, err := e.StartInstance(context.Background(), def.ID, StartOptions{})
+	if err != nil {
+		t.Fatalf("failed to start %s: %v", def.Key, err)
+	}
+	var task models.TaskInstance
+	err = e.db.Where("process_instance_id = ? AND status IN ?", inst.ID, []string{TaskCreated, TaskAssigned}).
+		First(&task).Error
+	if err != nil {
+		t.Fatalf("no open task in %s: %v", def.Key, err)
+	}
+	return &task
+}
@@ -0,0 +1,403 @@
+package engine
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+
+	"github.com/tvolodi/ai-bpms-backend/shared/bpmn"
+	"github.com/tvolodi/ai-bpms-backend/shared/common/models"
+)
+
+// Event subscription types
+const (
+	SubscriptionMessage = "message"
+	SubscriptionSignal  = "signal"
+)
+
+// Correlation errors
+var (
+	ErrMessageNotCorrelated = errors.New("no process is waiting for the message")
+	ErrAmbiguousCorrelation = errors.New("message correlates to more than one process")
+	ErrEventNameRequired    = errors.New("event name is required")
+)
+
+// MessageOptions describes a message sent to waiting or startable processes.
+// A message correlates with an instance when the business key (if given) and
+// every correlation key match the instance's business key and variables.
+type MessageOptions struct {
+	Name            string
+	BusinessKey     string
+	CorrelationKeys map[string]interface{}
+	Variables       map[string]interface{}
+	// All delivers the message to every matching subscription instead of exactly one
+	All bool
+}
+
+// SignalOptions describes a signal broadcast to every process waiting for it
+type SignalOptions struct {
+	Name      string
+	Variables map[string]interface{}
+}
+
+// EventResult reports a process element an event was delivered to
+type EventResult struct {
+	ProcessInstanceID uuid.UUID `json:"process_instance_id"`
+	ElementID         string    `json:"element_id"`
+	Started           bool      `json:"started"`
+}
+
+// RegisterStartEvents replaces the timer jobs and message and signal subscriptions of
+// the start events of every version of a process key. It is called whenever a version
+// is stored; only the latest active version can be started by events, the others
+// end up with none.
+func (e *Engine) RegisterStartEvents(tx *gorm.DB, key string) error {
+	var versions []models.ProcessDefinition
+	if err := tx.Where("key = ?", key).Order("version DESC").Find(&versions).Error; err != nil {
+		return err
+	}
+	latest := true
+	for i := range versions {
+		startable := latest && versions[i].IsActive
+		if versions[i].IsActive {
+			latest = false
+		}
+		if err := e.registerStartEvents(tx, &versions[i], startable); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// registerStartEvents replaces the start event timers and subscriptions of one version
+func (e *Engine) registerStartEvents(tx *gorm.DB, def *models.ProcessDefinition, startable bool) error {
+	if err := e.scheduleStartTimers(tx, def, startable); err != nil {
+		return err
+	}
+
+	err := tx.Unscoped().
+		Where("process_definition_id = ? AND process_instance_id IS NULL", def.ID).
+		Delete(&models.EventSubscription{}).Error
+	if err != nil {
+		return err
+	}
+	if !startable {
+		return nil
+	}
+
+	defs, err := bpmn.ParseString(def.BPMN)
+	if err != nil {
+		return err
+	}
+	for _, start := range defs.ExecutableProcess().StartEvents() {
+		kind, name := subscriptionOf(start)
+		if kind == "" {
+			continue
+		}
+		sub := models.EventSubscription{
+			Type:                kind,
+			EventName:           name,
+			ProcessDefinitionID: def.ID,
+			ElementID:           start.ID,
+		}
+		if err := tx.Create(&sub).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// CorrelateMessage delivers a message. Waiting instances take precedence over message
+// start events; without All, exactly one subscription must match.
+func (e *Engine) CorrelateMessage(ctx context.Context, opts MessageOptions) ([]EventResult, error) {
+	if opts.Name == "" {
+		return nil, ErrEventNameRequired
+	}
+
+	var results []EventResult
+	err := e.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var subs []models.EventSubscription
+		q := tx.Model(&models.EventSubscription{}).
+			Joins("JOIN process_instances ON process_instances.id = event_subscriptions.process_instance_id").
+			Where("event_subscriptions.type = ? AND event_subscriptions.event_name = ?", SubscriptionMessage, opts.Name).
+			Where("process_instances.status = ?", InstanceActive)
+		if opts.BusinessKey != "" {
+			q = q.Where("process_instances.business_key = ?", opts.BusinessKey)
+		}
+		if err := q.Order("event_subscriptions.created_at").Find(&subs).Error; err != nil {
+			return err
+		}
+
+		runs, matched, err := e.matchSubscriptions(ctx, tx, subs, opts.CorrelationKeys)
+		if err != nil {
+			return err
+		}
+		if len(matched) > 1 && !opts.All {
+			return ErrAmbiguousCorrelation
+		}
+		for _, sub := range matched {
+			r := runs[*sub.ProcessInstanceID]
+			for k, v := range opts.Variables {
+				r.vars[k] = v
+			}
+			if err := r.trigger(sub); err != nil {
+				return err
+			}
+			results = append(results, EventResult{ProcessInstanceID: r.inst.ID, ElementID: sub.ElementID})
+		}
+		if err := drainAll(runs); err != nil {
+			return err
+		}
+		if len(matched) > 0 {
+			return nil
+		}
+
+		// Nothing is waiting, so the message may start new instances
+		starts, err := e.startSubscriptions(tx, SubscriptionMessage, opts.Name)
+		if err != nil {
+			return err
+		}
+		if len(starts) == 0 {
+			return ErrMessageNotCorrelated
+		}
+		if len(starts) > 1 && !opts.All {
+			return ErrAmbiguousCorrelation
+		}
+		vars := make(map[string]interface{}, len(opts.CorrelationKeys)+len(opts.Variables))
+		for k, v := range opts.CorrelationKeys {
+			vars[k] = v
+		}
+		for k, v := range opts.Variables {
+			vars[k] = v
+		}
+		for _, sub := range starts {
+			inst, err := e.startAt(ctx, tx, sub, StartOptions{BusinessKey: opts.BusinessKey, Variables: copyVariables(vars)})
+			if err != nil {
+				return err
+			}
+			results = append(results, EventResult{ProcessInstanceID: inst.ID, ElementID: sub.ElementID, Started: true})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+// BroadcastSignal wakes every instance waiting for the signal and starts an
+// instance of every definition with a matching signal start event.
+func (e *Engine) BroadcastSignal(ctx context.Context, opts SignalOptions) ([]EventResult, error) {
+	if opts.Name == "" {
+		return nil, ErrEventNameRequired
+	}
+
+	results := []EventResult{}
+	err := e.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var subs []models.EventSubscription
+		err := tx.Model(&models.EventSubscription{}).
+			Joins("JOIN process_instances ON process_instances.id = event_subscriptions.process_instance_id").
+			Where("event_subscriptions.type = ? AND event_subscriptions.event_name = ?", SubscriptionSignal, opts.Name).
+			Where("process_instances.status = ?", InstanceActive).
+			Order("event_subscriptions.created_at").
+			Find(&subs).Error
+		if err != nil {
+			return err
+		}
+
+		runs, matched, err := e.matchSubscriptions(ctx, tx, subs, nil)
+		if err != nil {
+			return err
+		}
+		for _, sub := range matched {
+			r := runs[*sub.ProcessInstanceID]
+			for k, v := range opts.Variables {
+				r.vars[k] = v
+			}
+			if err := r.trigger(sub); err != nil {
+				return err
+			}
+			results = append(results, EventResult{ProcessInstanceID: r.inst.ID, ElementID: sub.ElementID})
+		}
+		if err := drainAll(runs); err != nil {
+			return err
+		}
+
+		starts, err := e.startSubscriptions(tx, SubscriptionSignal, opts.Name)
+		if err != nil {
+			return err
+		}
+		for _, sub := range starts {
+			inst, err := e.startAt(ctx, tx, sub, StartOptions{Variables: copyVariables(opts.Variables)})
+			if err != nil {
+				return err
+			}
+			results = append(results, EventResult{ProcessInstanceID: inst.ID, ElementID: sub.ElementID, Started: true})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+// matchSubscriptions locks the instances of the candidate subscriptions, in ID order to
+// avoid deadlocks between concurrent deliveries, and keeps the subscriptions that are
+// still live and whose instance variables match the correlation keys.
+func (e *Engine) matchSubscriptions(ctx context.Context, tx *gorm.DB, subs []models.EventSubscription, keys map[string]interface{}) (map[uuid.UUID]*run, []models.EventSubscription, error) {
+	var ids []uuid.UUID
+	seen := make(map[uuid.UUID]bool)
+	for _, sub := range subs {
+		if id := *sub.ProcessInstanceID; !seen[id] {
+			seen[id] = true
+			ids = append(ids, id)
+		}
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i].String() < ids[j].String() })
+
+	runs := make(map[uuid.UUID]*run, len(ids))
+	for _, id := range ids {
+		r, err := e.load(ctx, tx, id)
+		if err != nil {
+			return nil, nil, err
+		}
+		runs[id] = r
+	}
+
+	var matched []models.EventSubscription
+	for _, sub := range subs {
+		r := runs[*sub.ProcessInstanceID]
+		if r.inst.Status != InstanceActive || !correlates(r.vars, keys) {
+			continue
+		}
+		// Re-read the subscription now that the instance is locked
+		var live int64
+		if err := tx.Model(&models.EventSubscription{}).Where("id = ?", sub.ID).Count(&live).Error; err != nil {
+			return nil, nil, err
+		}
+		if live > 0 {
+			matched = append(matched, sub)
+		}
+	}
+	return runs, matched, nil
+}
+
+// startSubscriptions returns the start event subscriptions of active definitions for an event
+func (e *Engine) startSubscriptions(tx *gorm.DB, kind, name string) ([]models.EventSubscription, error) {
+	var subs []models.EventSubscription
+	err := tx.Model(&models.EventSubscription{}).
+		Joins("JOIN process_definitions ON process_definitions.id = event_subscriptions.process_definition_id").
+		Where("event_subscriptions.type = ? AND event_subscriptions.event_name = ?", kind, name).
+		Where("event_subscriptions.process_instance_id IS NULL AND process_definitions.is_active = ?", true).
+		Order("event_subscriptions.created_at").
+		Find(&subs).Error
+	return subs, err
+}
+
+// startAt starts an instance at the start event of a subscription
+func (e *Engine) startAt(ctx context.Context, tx *gorm.DB, sub models.EventSubscription, opts StartOptions) (*models.ProcessInstance, error) {
+	def, defs, err := e.definition(tx, sub.ProcessDefinitionID)
+	if err != nil {
+		return nil, err
+	}
+	start := defs.Node(sub.ElementID)
+	if start == nil {
+		return nil, fmt.Errorf("start event %s not found", sub.ElementID)
+	}
+	return e.start(ctx, tx, def, defs, start, opts, nil)
+}
+
+// drainAll continues every run an event was delivered to
+func drainAll(runs map[uuid.UUID]*run) error {
+	for _, r := range runs {
+		if err := r.drain(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// correlates reports whether every correlation key equals the instance variable of the same name
+func correlates(vars, keys map[string]interface{}) bool {
+	for k, want := range keys {
+		got, ok := vars[k]
+		if !ok {
+			return false
+		}
+		// Compare the JSON forms so that numbers match regardless of their Go type
+		a, errA := json.Marshal(got)
+		b, errB := json.Marshal(want)
+		if errA != nil || errB != nil || string(a) != string(b) {
+			return false
+		}
+	}
+	return true
+}
+
+// subscriptionOf returns the subscription type and event name a node waits for, if any
+func subscriptionOf(node *bpmn.Node) (string, string) {
+	switch {
+	case node.Type == bpmn.ReceiveTask, node.Event == bpmn.MessageEvent:
+		return SubscriptionMessage, node.Message
+	case node.Event == bpmn.SignalEvent:
+		return SubscriptionSignal, node.Signal
+	}
+	return "", ""
+}
+
+// subscribe registers execution ex as waiting for the message or signal of node
+func (r *run) subscribe(ex *models.Execution, node *bpmn.Node) error {
+	kind, name := subscriptionOf(node)
+	sub := models.EventSubscription{
+		Type:                kind,
+		EventName:           name,
+		ProcessDefinitionID: r.inst.ProcessDefinitionID,
+		ProcessInstanceID:   &r.inst.ID,
+		ExecutionID:         &ex.ID,
+		ElementID:           node.ID,
+	}
+	return r.tx.Create(&sub).Error
+}
+
+// unsubscribe removes the event subscriptions held by an execution
+func (r *run) unsubscribe(ex *models.Execution) error {
+	return r.tx.Unscoped().
+		Where("execution_id = ?", ex.ID).
+		Delete(&models.EventSubscription{}).Error
+}
+
+// trigger moves the token waiting on a subscription past the event
+func (r *run) trigger(sub models.EventSubscription) error {
+	if sub.ExecutionID == nil {
+		return fmt.Errorf("event subscription %s has no execution", sub.ID)
+	}
+	ex, err := r.execution(*sub.ExecutionID)
+	if err != nil {
+		return err
+	}
+	node, err := r.node(sub.ElementID)
+	if err != nil {
+		return err
+	}
+
+	if node.Type == bpmn.BoundaryEvent {
+		if ex.Status != ExecutionWaiting {
+			return nil
+		}
+		return r.fireBoundary(ex, node, nil)
+	}
+
+	if err := r.unsubscribe(ex); err != nil {
+		return err
+	}
+	if ex.Status != ExecutionWaiting {
+		return nil
+	}
+	return r.leave(ex, node.Outgoing)
+}
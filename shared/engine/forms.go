@@ -0,0 +1,155 @@
+package engine
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+
+	"github.com/tvolodi/ai-bpms-backend/shared/common/models"
+	"github.com/tvolodi/ai-bpms-backend/shared/forms"
+)
+
+// TaskForm returns the form schema version a task was created with. Tasks
+// created before forms were versioned get the latest active version of their key.
+func (e *Engine) TaskForm(ctx context.Context, taskID uuid.UUID) (*models.FormSchema, error) {
+	db := e.db.WithContext(ctx)
+	var task models.TaskInstance
+	if err := db.Select("id", "form_key", "form_schema_id").First(&task, "id = ?", taskID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrTaskNotFound
+		}
+		return nil, err
+	}
+	if task.FormKey == "" {
+		return nil, fmt.Errorf("%w: the task has no form", ErrFormNotFound)
+	}
+	return taskForm(db, &task)
+}
+
+// CompileForm compiles a version of a form, or its latest active version when
+// version is 0, together with the forms it refers to and its form logic
+func (e *Engine) CompileForm(ctx context.Context, key string, version int) (*forms.Schema, error) {
+	db := e.db.WithContext(ctx)
+	form, err := formVersion(db, key, version)
+	if err != nil {
+		return nil, err
+	}
+	return compileForm(db, form, nil)
+}
+
+// CompileSchema compiles a form schema that is not stored yet, under the key
+// it will be published with, if any, together with the form logic of its UI
+// schema; its $ref may refer to stored forms
+func (e *Engine) CompileSchema(ctx context.Context, key, source, uiSchema string) (*forms.Schema, error) {
+	schema, err := forms.Compile(key, source, formLoader(e.db.WithContext(ctx), nil))
+	if err != nil {
+		return nil, err
+	}
+	return schema.WithUISchema(uiSchema)
+}
+
+// formVersion finds the given version of a form, or its latest active version
+// when version is 0. Deactivated versions are not bound to new tasks.
+func formVersion(tx *gorm.DB, key string, version int) (*models.FormSchema, error) {
+	var form models.FormSchema
+	q := tx.Where("key = ? AND is_active = ?", key, true)
+	if version > 0 {
+		q = q.Where("version = ?", version)
+	}
+	err := q.Order("version DESC").First(&form).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		if version > 0 {
+			return nil, fmt.Errorf("%w: %s version %d", ErrFormNotFound, key, version)
+		}
+		return nil, fmt.Errorf("%w: %s", ErrFormNotFound, key)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &form, nil
+}
+
+// taskForm returns the form schema version a task is bound to
+func taskForm(tx *gorm.DB, task *models.TaskInstance) (*models.FormSchema, error) {
+	if task.FormSchemaID == nil {
+		return formVersion(tx, task.FormKey, 0)
+	}
+	var form models.FormSchema
+	if err := tx.First(&form, "id = ?", task.FormSchemaID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, fmt.Errorf("%w: %s", ErrFormNotFound, task.FormKey)
+		}
+		return nil, err
+	}
+	return &form, nil
+}
+
+// compileForm compiles a form schema version together with the forms it refers
+// to and the form logic of its UI schema. Unversioned $refs resolve through
+// refs; see formLoader.
+func compileForm(tx *gorm.DB, form *models.FormSchema, refs map[string]int) (*forms.Schema, error) {
+	schema, err := forms.Compile(form.Key, form.JSONSchema, formLoader(tx, refs))
+	if err != nil {
+		return nil, err
+	}
+	return schema.WithUISchema(form.UISchema)
+}
+
+// formLoader reads the schemas of the forms a $ref refers to: the version given
+// after an @ as in "address@2", or else the version refs pins for the key. Keys
+// refs does not pin resolve to their latest active version, which is recorded
+// in refs unless it is nil, so a task keeps the versions it was created with.
+func formLoader(tx *gorm.DB, refs map[string]int) forms.Loader {
+	return func(ref string) (string, error) {
+		key, version := ref, 0
+		if k, v, ok := strings.Cut(ref, "@"); ok {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 1 {
+				return "", fmt.Errorf("%q is not a form version", v)
+			}
+			key, version = k, n
+		} else if pinned, ok := refs[key]; ok {
+			return pinnedForm(tx, key, pinned)
+		}
+		form, err := formVersion(tx, key, version)
+		if err != nil {
+			return "", err
+		}
+		if version == 0 && refs != nil {
+			refs[key] = form.Version
+		}
+		return form.JSONSchema, nil
+	}
+}
+
+// pinnedForm reads the schema of a form version a task was created with, even
+// when the version has been deactivated since
+func pinnedForm(tx *gorm.DB, key string, version int) (string, error) {
+	var form models.FormSchema
+	err := tx.Select("json_schema").First(&form, "key = ? AND version = ?", key, version).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return "", fmt.Errorf("%w: %s version %d", ErrFormNotFound, key, version)
+	}
+	if err != nil {
+		return "", err
+	}
+	return form.JSONSchema, nil
+}
+
+// decodeFormRefs parses the form versions a task's $refs are pinned to
+func decodeFormRefs(raw string) (map[string]int, error) {
+	refs := make(map[string]int)
+	if raw == "" || raw == "null" {
+		return refs, nil
+	}
+	if err := json.Unmarshal([]byte(raw), &refs); err != nil {
+		return nil, fmt.Errorf("invalid form references: %w", err)
+	}
+	return refs, nil
+}
@@ -0,0 +1,172 @@
+package engine
+
+import (
+	"github.com/tvolodi/ai-bpms-backend/shared/bpmn"
+	"github.com/tvolodi/ai-bpms-backend/shared/common/models"
+	"github.com/tvolodi/ai-bpms-backend/shared/expression"
+)
+
+// conditionMet reports whether a sequence flow may be taken
+func (r *run) conditionMet(f *bpmn.SequenceFlow) (bool, error) {
+	if f.Condition == "" {
+		return true, nil
+	}
+	ok, err := expression.EvaluateBool(f.Condition, r.vars)
+	if err != nil {
+		return false, raise(IncidentExpressionFailed, "sequence flow %s: %v", f.ID, err)
+	}
+	return ok, nil
+}
+
+// exclusiveGateway takes the first outgoing flow whose condition holds, falling back to the default flow
+func (r *run) exclusiveGateway(ex *models.Execution, node *bpmn.Node) error {
+	for _, f := range node.Outgoing {
+		if f.ID == node.DefaultFlow {
+			continue
+		}
+		ok, err := r.conditionMet(f)
+		if err != nil {
+			return err
+		}
+		if ok {
+			return r.leave(ex, []*bpmn.SequenceFlow{f})
+		}
+	}
+	if def := node.Default(); def != nil {
+		return r.leave(ex, []*bpmn.SequenceFlow{def})
+	}
+	return raise(IncidentNoMatchingFlow, "exclusive gateway %s: no outgoing sequence flow condition matched and no default flow is defined", node.ID)
+}
+
+// parallelGateway waits for a token on every incoming flow, then forks one token per outgoing flow
+func (r *run) parallelGateway(ex *models.Execution, node *bpmn.Node) error {
+	if len(node.Incoming) > 1 {
+		joined, err := r.join(ex, node, func(waiting []models.Execution) (bool, error) {
+			return len(waiting) >= len(node.Incoming), nil
+		})
+		if err != nil || !joined {
+			return err
+		}
+	}
+	return r.leave(ex, node.Outgoing)
+}
+
+// inclusiveGateway waits until no other token can still arrive, then forks a
+// token on every outgoing flow whose condition holds, or on the default flow.
+func (r *run) inclusiveGateway(ex *models.Execution, node *bpmn.Node) error {
+	if len(node.Incoming) > 1 {
+		joined, err := r.join(ex, node, func(waiting []models.Execution) (bool, error) {
+			return r.nothingUpstream(ex, node)
+		})
+		if err != nil || !joined {
+			return err
+		}
+	}
+
+	var flows []*bpmn.SequenceFlow
+	for _, f := range node.Outgoing {
+		if f.ID == node.DefaultFlow {
+			continue
+		}
+		ok, err := r.conditionMet(f)
+		if err != nil {
+			return err
+		}
+		if ok {
+			flows = append(flows, f)
+		}
+	}
+	if len(flows) == 0 {
+		if def := node.Default(); def != nil {
+			flows = append(flows, def)
+		}
+	}
+	if len(flows) == 0 {
+		return raise(IncidentNoMatchingFlow, "inclusive gateway %s: no outgoing sequence flow condition matched and no default flow is defined", node.ID)
+	}
+	return r.leave(ex, flows)
+}
+
+// join parks the token at a converging gateway. Once ready reports that enough
+// tokens have arrived, the other waiting tokens are consumed and true is
+// returned so the caller can move ex onwards.
+func (r *run) join(ex *models.Execution, node *bpmn.Node, ready func([]models.Execution) (bool, error)) (bool, error) {
+	if err := r.setStatus(ex, ExecutionWaiting); err != nil {
+		return false, err
+	}
+
+	var waiting []models.Execution
+	err := r.scope(ex.ParentID).
+		Where("element_id = ? AND status = ?", node.ID, ExecutionWaiting).
+		Order("started_at").
+		Find(&waiting).Error
+	if err != nil {
+		return false, err
+	}
+
+	ok, err := ready(waiting)
+	if err != nil || !ok {
+		return false, err
+	}
+
+	// Consume one token per incoming flow; ex itself continues
+	consumed := 1
+	for i := range waiting {
+		if consumed >= len(node.Incoming) && node.Type == bpmn.ParallelGateway {
+			break
+		}
+		if waiting[i].ID == ex.ID {
+			continue
+		}
+		if err := r.setStatus(&waiting[i], ExecutionCompleted); err != nil {
+			return false, err
+		}
+		consumed++
+	}
+
+	ex.Status = ExecutionActive
+	return true, nil
+}
+
+// nothingUpstream reports whether no open token other than those waiting at
+// node can still reach it, which is when an inclusive join may fire.
+func (r *run) nothingUpstream(ex *models.Execution, node *bpmn.Node) (bool, error) {
+	var open []models.Execution
+	err := r.scope(ex.ParentID).
+		Where("status IN ? AND element_id <> ?", openStatuses, node.ID).
+		Find(&open).Error
+	if err != nil {
+		return false, err
+	}
+
+	for _, other := range open {
+		from := r.defs.Node(other.ElementID)
+		if from != nil && reachable(from, node) {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// reachable reports whether target can be reached from source by following sequence flows
+func reachable(source, target *bpmn.Node) bool {
+	visited := map[string]bool{}
+	queue := []*bpmn.Node{source}
+	for len(queue) > 0 {
+		n := queue[0]
+		queue = queue[1:]
+		if visited[n.ID] {
+			continue
+		}
+		visited[n.ID] = true
+		for _, f := range n.Outgoing {
+			if f.Target == target {
+				return true
+			}
+			if f.Target != nil {
+				queue = append(queue, f.Target)
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,118 @@
+package engine
+
+import (
+	"bytes"
+	"encoding/json"
+	"sort"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+
+	"github.com/tvolodi/ai-bpms-backend/shared/common/models"
+)
+
+// History event types
+const (
+	EventInstanceStarted    = "instance-started"
+	EventInstanceSuspended  = "instance-suspended"
+	EventInstanceResumed    = "instance-resumed"
+	EventInstanceCompleted  = "instance-completed"
+	EventInstanceTerminated = "instance-terminated"
+
+	EventTaskCreated         = "task-created"
+	EventTaskClaimed         = "task-claimed"
+	EventTaskUnclaimed       = "task-unclaimed"
+	EventTaskAssigned        = "task-assigned"
+	EventTaskDelegated       = "task-delegated"
+	EventTaskResolved        = "task-resolved"
+	EventTaskCompleted       = "task-completed"
+	EventTaskCancelled       = "task-cancelled"
+	EventTaskPriorityChanged = "task-priority-changed"
+	EventTaskSubstituted     = "task-substituted"
+
+	EventVariableUpdated = "variable-updated"
+)
+
+// instanceEvents maps the status an instance moves to onto the event recorded for it
+var instanceEvents = map[string]string{
+	InstanceSuspended:  EventInstanceSuspended,
+	InstanceActive:     EventInstanceResumed,
+	InstanceCompleted:  EventInstanceCompleted,
+	InstanceTerminated: EventInstanceTerminated,
+}
+
+// recordEvent adds an event to the history of a process instance
+func recordEvent(tx *gorm.DB, instanceID uuid.UUID, taskID *uuid.UUID, eventType string, userID *uuid.UUID, detail map[string]interface{}) error {
+	encoded, err := json.Marshal(detail)
+	if err != nil {
+		return err
+	}
+	if detail == nil {
+		encoded = []byte("{}")
+	}
+	return tx.Create(&models.HistoryEvent{
+		ProcessInstanceID: instanceID,
+		TaskInstanceID:    taskID,
+		Type:              eventType,
+		UserID:            userID,
+		Detail:            string(encoded),
+	}).Error
+}
+
+// record adds an event to the history of the instance on behalf of the user driving the run
+func (r *run) record(eventType string, taskID *uuid.UUID, detail map[string]interface{}) error {
+	return recordEvent(r.tx, r.inst.ID, taskID, eventType, r.actor, detail)
+}
+
+// recordChanges records how the status and variables of the instance changed
+// since it was loaded or last saved
+func (r *run) recordChanges() error {
+	if r.inst.Status != r.status {
+		detail := map[string]interface{}{"from": r.status, "to": r.inst.Status}
+		if r.inst.EndReason != "" && r.inst.Status == InstanceTerminated {
+			detail["reason"] = r.inst.EndReason
+		}
+		if eventType, ok := instanceEvents[r.inst.Status]; ok {
+			if err := r.record(eventType, nil, detail); err != nil {
+				return err
+			}
+		}
+		r.status = r.inst.Status
+	}
+
+	names := make([]string, 0, len(r.vars))
+	for name := range r.vars {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		updated, err := json.Marshal(r.vars[name])
+		if err != nil {
+			return err
+		}
+		detail := map[string]interface{}{"name": name, "value": json.RawMessage(updated)}
+		if previous, ok := r.recorded[name]; ok {
+			if bytes.Equal(previous, updated) {
+				continue
+			}
+			detail["previous_value"] = json.RawMessage(previous)
+		}
+		if err := r.record(EventVariableUpdated, nil, detail); err != nil {
+			return err
+		}
+		r.recorded[name] = updated
+	}
+	return nil
+}
+
+// snapshot remembers the encoded variables of the instance, so that only
+// later changes are recorded in its history
+func snapshot(vars map[string]interface{}) map[string][]byte {
+	recorded := make(map[string][]byte, len(vars))
+	for name, value := range vars {
+		if encoded, err := json.Marshal(value); err == nil {
+			recorded[name] = encoded
+		}
+	}
+	return recorded
+}
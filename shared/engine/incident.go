@@ -0,0 +1,294 @@
+package engine
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+
+	"github.com/tvolodi/ai-bpms-backend/shared/bpmn"
+	"github.com/tvolodi/ai-bpms-backend/shared/common/models"
+)
+
+// Incident types
+const (
+	IncidentNoMatchingFlow     = "no-matching-flow"
+	IncidentExpressionFailed   = "expression-failed"
+	IncidentCallActivityFailed = "call-activity-failed"
+	IncidentServiceFailed      = "service-task-failed"
+	IncidentUnhandledError     = "unhandled-error"
+	IncidentCalendarFailed     = "calendar-failed"
+	IncidentAssignmentFailed   = "assignment-failed"
+	IncidentFormNotFound       = "form-not-found"
+)
+
+// Incident statuses
+const (
+	IncidentOpen     = "open"
+	IncidentResolved = "resolved"
+)
+
+// Incident resolutions
+const (
+	ResolutionRetried  = "retried"
+	ResolutionSkipped  = "skipped"
+	ResolutionResolved = "resolved"
+)
+
+// Incident errors
+var (
+	ErrIncidentNotFound = errors.New("incident not found")
+	ErrIncidentNotOpen  = errors.New("incident is not open")
+	ErrTokenNotFailed   = errors.New("the token of the incident is no longer failed")
+	ErrTokenStillFailed = errors.New("the token of the incident is still failed, retry or skip the incident instead")
+	ErrFlowRequired     = errors.New("a flow must be chosen to skip a gateway")
+	ErrFlowNotOutgoing  = errors.New("flow is not an outgoing sequence flow of the element")
+)
+
+// StackFrame is one scope of the token an incident stopped. Incident.Stack holds
+// the frames from the failed element outwards, through enclosing sub-processes
+// and the call activities of parent instances.
+type StackFrame struct {
+	ProcessInstanceID uuid.UUID `json:"process_instance_id"`
+	ExecutionID       uuid.UUID `json:"execution_id"`
+	ElementID         string    `json:"element_id"`
+	ElementType       string    `json:"element_type"`
+}
+
+// ResolveOptions holds the parameters of an operator action on an incident.
+// Variables are merged into the process instance before the token moves; FlowID
+// picks the flow a skipped element leaves through.
+type ResolveOptions struct {
+	Variables  map[string]interface{}
+	FlowID     string
+	Note       string
+	ResolvedBy *uuid.UUID
+}
+
+// incidentError is returned by element behaviors for failures that should stop
+// the token with an incident rather than abort the whole transition.
+type incidentError struct {
+	kind string
+	err  error
+}
+
+func (e *incidentError) Error() string { return e.err.Error() }
+func (e *incidentError) Unwrap() error { return e.err }
+
+// raise wraps err as an incident of the given type
+func raise(kind string, format string, args ...interface{}) error {
+	return &incidentError{kind: kind, err: fmt.Errorf(format, args...)}
+}
+
+// asIncident reports whether err should become an incident
+func asIncident(err error) (*incidentError, bool) {
+	var ie *incidentError
+	if errors.As(err, &ie) {
+		return ie, true
+	}
+	return nil, false
+}
+
+// createIncident parks the execution as failed and records an open incident for it
+func (r *run) createIncident(ex *models.Execution, ie *incidentError) error {
+	if err := r.setStatus(ex, ExecutionFailed); err != nil {
+		return err
+	}
+
+	stack, err := r.stack(ex)
+	if err != nil {
+		return err
+	}
+	var tasks []uuid.UUID
+	err = r.tx.Model(&models.TaskInstance{}).
+		Where("execution_id = ?", ex.ID).
+		Order("created_at DESC").
+		Limit(1).
+		Pluck("id", &tasks).Error
+	if err != nil {
+		return err
+	}
+
+	incident := models.Incident{
+		ProcessInstanceID: r.inst.ID,
+		ExecutionID:       &ex.ID,
+		ElementID:         ex.ElementID,
+		Type:              ie.kind,
+		Message:           ie.Error(),
+		Stack:             stack,
+		Status:            IncidentOpen,
+	}
+	if len(tasks) > 0 {
+		incident.TaskInstanceID = &tasks[0]
+	}
+	if err := r.tx.Create(&incident).Error; err != nil {
+		return fmt.Errorf("failed to create incident for %s: %w", ex.ElementID, err)
+	}
+	return nil
+}
+
+// stack records the scopes of ex as JSON, following enclosing executions and
+// then the call activity that started each instance
+func (r *run) stack(ex *models.Execution) (string, error) {
+	var frames []StackFrame
+	cur := ex
+	for {
+		frames = append(frames, StackFrame{
+			ProcessInstanceID: cur.ProcessInstanceID,
+			ExecutionID:       cur.ID,
+			ElementID:         cur.ElementID,
+			ElementType:       cur.ElementType,
+		})
+
+		next := cur.ParentID
+		if next == nil {
+			var inst models.ProcessInstance
+			if err := r.tx.Select("parent_execution_id").First(&inst, "id = ?", cur.ProcessInstanceID).Error; err != nil {
+				return "", err
+			}
+			next = inst.ParentExecutionID
+		}
+		if next == nil {
+			break
+		}
+		var parent models.Execution
+		if err := r.tx.First(&parent, "id = ?", *next).Error; err != nil {
+			return "", err
+		}
+		cur = &parent
+	}
+
+	data, err := json.Marshal(frames)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// RetryIncident resolves an incident and runs its failed element again. A new
+// incident is raised if the element fails once more.
+func (e *Engine) RetryIncident(ctx context.Context, id uuid.UUID, opts ResolveOptions) (*models.Incident, error) {
+	return e.resolveIncident(ctx, id, ResolutionRetried, opts, func(r *run, ex *models.Execution) error {
+		// Boundary events are armed again when the element runs
+		if err := r.detachBoundaryEvents(ex); err != nil {
+			return err
+		}
+		if err := r.setStatus(ex, ExecutionActive); err != nil {
+			return err
+		}
+		r.agenda = append(r.agenda, ex)
+		return nil
+	})
+}
+
+// SkipIncident resolves an incident and moves its token on as if the failed
+// element had completed. Gateways with several outgoing flows need opts.FlowID.
+func (e *Engine) SkipIncident(ctx context.Context, id uuid.UUID, opts ResolveOptions) (*models.Incident, error) {
+	return e.resolveIncident(ctx, id, ResolutionSkipped, opts, func(r *run, ex *models.Execution) error {
+		node, err := r.node(ex.ElementID)
+		if err != nil {
+			return err
+		}
+		flows := node.Outgoing
+		switch {
+		case opts.FlowID != "":
+			flows = nil
+			for _, f := range node.Outgoing {
+				if f.ID == opts.FlowID {
+					flows = []*bpmn.SequenceFlow{f}
+				}
+			}
+			if flows == nil {
+				return ErrFlowNotOutgoing
+			}
+		case node.Type.IsGateway() && len(node.Outgoing) > 1:
+			return ErrFlowRequired
+		}
+		return r.leave(ex, flows)
+	})
+}
+
+// ResolveIncident marks an incident as resolved without moving its token, for
+// failures an operator has dealt with outside the engine. The token must no
+// longer be failed, for example because it was moved or its instance cancelled;
+// a failed token would hold its scope open for good.
+func (e *Engine) ResolveIncident(ctx context.Context, id uuid.UUID, opts ResolveOptions) (*models.Incident, error) {
+	return e.resolveIncident(ctx, id, ResolutionResolved, opts, nil)
+}
+
+// resolveIncident closes an open incident with the given resolution and lets
+// next move its failed token, if given, before the instance continues
+func (e *Engine) resolveIncident(ctx context.Context, id uuid.UUID, resolution string, opts ResolveOptions, next func(r *run, ex *models.Execution) error) (*models.Incident, error) {
+	var incident models.Incident
+	err := e.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.First(&incident, "id = ?", id).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return ErrIncidentNotFound
+			}
+			return err
+		}
+
+		r, err := e.load(ctx, tx, incident.ProcessInstanceID)
+		if err != nil {
+			return err
+		}
+
+		// Re-read the incident now that the instance is locked
+		if err := tx.First(&incident, "id = ?", id).Error; err != nil {
+			return err
+		}
+		if incident.Status != IncidentOpen {
+			return ErrIncidentNotOpen
+		}
+
+		now := time.Now().UTC()
+		incident.Status = IncidentResolved
+		incident.Resolution = resolution
+		incident.Note = opts.Note
+		incident.ResolvedAt = &now
+		incident.ResolvedBy = opts.ResolvedBy
+		if err := tx.Save(&incident).Error; err != nil {
+			return err
+		}
+
+		for k, v := range opts.Variables {
+			r.vars[k] = v
+		}
+		if next == nil && incident.ExecutionID != nil {
+			ex, err := r.execution(*incident.ExecutionID)
+			if err != nil {
+				return err
+			}
+			if ex.Status == ExecutionFailed {
+				return ErrTokenStillFailed
+			}
+		}
+		if next != nil {
+			if r.inst.Status != InstanceActive {
+				return ErrInstanceNotActive
+			}
+			if incident.ExecutionID == nil {
+				return fmt.Errorf("incident %s is not bound to an execution", incident.ID)
+			}
+			ex, err := r.execution(*incident.ExecutionID)
+			if err != nil {
+				return err
+			}
+			if ex.Status != ExecutionFailed {
+				return ErrTokenNotFailed
+			}
+			if err := next(r, ex); err != nil {
+				return err
+			}
+		}
+		return r.drain()
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &incident, nil
+}
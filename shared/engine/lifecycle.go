@@ -0,0 +1,207 @@
+package engine
+
+import (
+	"context"
+	"errors"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"github.com/tvolodi/ai-bpms-backend/shared/common/models"
+)
+
+// JobSuspended marks a job paused because its process instance is suspended
+const JobSuspended = "suspended"
+
+// Lifecycle errors
+var (
+	ErrInstanceNotSuspended = errors.New("process instance is not suspended")
+	ErrInstanceEnded        = errors.New("process instance has already ended")
+)
+
+// TerminateOptions holds the parameters used to terminate a process instance
+type TerminateOptions struct {
+	Reason       string
+	TerminatedBy *uuid.UUID
+}
+
+// SuspendInstance suspends an active process instance and the instances its call
+// activities started. Its timers are paused and none of its tokens move until it
+// is resumed.
+func (e *Engine) SuspendInstance(ctx context.Context, id uuid.UUID) (*models.ProcessInstance, error) {
+	return e.transition(ctx, id, func(r *run) error {
+		if r.inst.Status != InstanceActive {
+			return ErrInstanceNotActive
+		}
+		return r.suspend()
+	})
+}
+
+// ResumeInstance resumes a suspended process instance and the called instances
+// that were suspended along with it. Called instances suspended on their own
+// stay suspended. Timers that fell due in the meantime fire right away.
+func (e *Engine) ResumeInstance(ctx context.Context, id uuid.UUID) (*models.ProcessInstance, error) {
+	return e.transition(ctx, id, func(r *run) error {
+		if r.inst.Status != InstanceSuspended {
+			return ErrInstanceNotSuspended
+		}
+		return r.resume()
+	})
+}
+
+// TerminateInstance ends an active or suspended process instance for good,
+// cancelling its open tokens and tasks and those of the instances it called.
+func (e *Engine) TerminateInstance(ctx context.Context, id uuid.UUID, opts TerminateOptions) (*models.ProcessInstance, error) {
+	return e.transition(ctx, id, func(r *run) error {
+		if r.inst.Status != InstanceActive && r.inst.Status != InstanceSuspended {
+			return ErrInstanceEnded
+		}
+		r.actor = opts.TerminatedBy
+		if err := r.terminate(); err != nil {
+			return err
+		}
+		r.inst.EndedBy = opts.TerminatedBy
+		r.inst.EndReason = opts.Reason
+		return nil
+	})
+}
+
+// SuspendInstances suspends every active instance of a process definition and
+// reports how many were suspended
+func (e *Engine) SuspendInstances(ctx context.Context, definitionID uuid.UUID) (int, error) {
+	return e.transitionAll(ctx, definitionID, InstanceActive, (*run).suspend)
+}
+
+// ResumeInstances resumes every suspended instance of a process definition and
+// reports how many were resumed
+func (e *Engine) ResumeInstances(ctx context.Context, definitionID uuid.UUID) (int, error) {
+	return e.transitionAll(ctx, definitionID, InstanceSuspended, (*run).resume)
+}
+
+// transition locks a process instance, applies change to it and persists the result
+func (e *Engine) transition(ctx context.Context, id uuid.UUID, change func(r *run) error) (*models.ProcessInstance, error) {
+	var inst *models.ProcessInstance
+	err := e.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		r, err := e.load(ctx, tx, id)
+		if err != nil {
+			return err
+		}
+		if err := change(r); err != nil {
+			return err
+		}
+		inst = r.inst
+		return r.save()
+	})
+	if err != nil {
+		return nil, err
+	}
+	return inst, nil
+}
+
+// transitionAll applies change to every instance of a definition in the given
+// status. Instances are locked in ID order to avoid deadlocks with other bulk runs.
+func (e *Engine) transitionAll(ctx context.Context, definitionID uuid.UUID, status string, change func(r *run) error) (int, error) {
+	count := 0
+	err := e.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var def models.ProcessDefinition
+		if err := tx.Select("id").First(&def, "id = ?", definitionID).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return ErrDefinitionNotFound
+			}
+			return err
+		}
+
+		var ids []uuid.UUID
+		err := tx.Model(&models.ProcessInstance{}).
+			Where("process_definition_id = ? AND status = ?", definitionID, status).
+			Order("id").
+			Pluck("id", &ids).Error
+		if err != nil {
+			return err
+		}
+		for _, id := range ids {
+			r, err := e.load(ctx, tx, id)
+			if err != nil {
+				return err
+			}
+			// A called instance may have changed along with its parent
+			if r.inst.Status != status {
+				continue
+			}
+			if err := change(r); err != nil {
+				return err
+			}
+			if err := r.save(); err != nil {
+				return err
+			}
+			count++
+		}
+		return nil
+	})
+	return count, err
+}
+
+// suspend marks the instance suspended, pauses its pending jobs and suspends
+// the active instances it called, marking them as suspended by their parent
+func (r *run) suspend() error {
+	r.inst.Status = InstanceSuspended
+	if err := r.setJobStatus(JobPending, JobSuspended); err != nil {
+		return err
+	}
+	return r.eachCalled(models.ProcessInstance{Status: InstanceActive}, func(child *run) error {
+		child.inst.SuspendedByParent = true
+		return child.suspend()
+	})
+}
+
+// resume reactivates the instance, its paused jobs and the instances its
+// suspension suspended
+func (r *run) resume() error {
+	r.inst.Status = InstanceActive
+	r.inst.SuspendedByParent = false
+	if err := r.setJobStatus(JobSuspended, JobPending); err != nil {
+		return err
+	}
+	return r.eachCalled(models.ProcessInstance{Status: InstanceSuspended, SuspendedByParent: true}, (*run).resume)
+}
+
+// setJobStatus moves the instance's jobs from one status to another. Jobs
+// currently locked by the scheduler are skipped; they park themselves once
+// they see the instance is suspended.
+func (r *run) setJobStatus(from, to string) error {
+	jobs := r.tx.Model(&models.Job{}).
+		Select("id").
+		Where("process_instance_id = ? AND status = ?", r.inst.ID, from).
+		Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"})
+	return r.tx.Model(&models.Job{}).
+		Where("id IN (?)", jobs).
+		Update("status", to).Error
+}
+
+// eachCalled applies change to the instances called by this one that match the
+// non-zero fields of match
+func (r *run) eachCalled(match models.ProcessInstance, change func(r *run) error) error {
+	var ids []uuid.UUID
+	err := r.tx.Model(&models.ProcessInstance{}).
+		Where("parent_instance_id = ?", r.inst.ID).
+		Where(&match).
+		Order("id").
+		Pluck("id", &ids).Error
+	if err != nil {
+		return err
+	}
+	for _, id := range ids {
+		child, err := r.e.load(r.ctx, r.tx, id)
+		if err != nil {
+			return err
+		}
+		if err := change(child); err != nil {
+			return err
+		}
+		if err := child.save(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,366 @@
+package engine
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+
+	"github.com/tvolodi/ai-bpms-backend/shared/bpmn"
+	"github.com/tvolodi/ai-bpms-backend/shared/common/models"
+)
+
+// ErrInvalidMigrationPlan is returned for a migration plan that cannot apply to any instance
+var ErrInvalidMigrationPlan = errors.New("invalid migration plan")
+
+// MigrationPlan moves running instances from one version of a process to another.
+// Mappings gives the target element of a source element; elements that are not
+// mapped keep their ID. Without InstanceIDs every running instance of the source
+// version is migrated.
+type MigrationPlan struct {
+	SourceDefinitionID uuid.UUID
+	TargetDefinitionID uuid.UUID
+	Mappings           map[string]string
+	InstanceIDs        []uuid.UUID
+	MigratedBy         *uuid.UUID
+}
+
+// MigrationResult reports whether an instance can be, or was, migrated and why not
+type MigrationResult struct {
+	ProcessInstanceID uuid.UUID `json:"process_instance_id"`
+	Migrated          bool      `json:"migrated"`
+	Problems          []string  `json:"problems,omitempty"`
+}
+
+// ValidateMigration reports, without changing anything, which instances of the
+// plan can be migrated and what prevents the others.
+func (e *Engine) ValidateMigration(ctx context.Context, plan MigrationPlan) ([]MigrationResult, error) {
+	return e.migrate(ctx, plan, false)
+}
+
+// MigrateInstances migrates every instance of the plan that can be migrated, each
+// in its own transaction, and reports the problems of those left on the source
+// version. Open tasks, waiting
+// events and incidents move to the mapped elements; boundary events are armed
+// again from the target version. Each migration is recorded in the audit log.
+func (e *Engine) MigrateInstances(ctx context.Context, plan MigrationPlan) ([]MigrationResult, error) {
+	return e.migrate(ctx, plan, true)
+}
+
+// instanceMigration holds the parsed versions a plan moves instances between
+type instanceMigration struct {
+	plan   MigrationPlan
+	target *models.ProcessDefinition
+	from   *bpmn.Definitions
+	to     *bpmn.Definitions
+}
+
+func (e *Engine) migrate(ctx context.Context, plan MigrationPlan, apply bool) ([]MigrationResult, error) {
+	db := e.db.WithContext(ctx)
+	m, err := e.migration(db, plan)
+	if err != nil {
+		return nil, err
+	}
+
+	ids := plan.InstanceIDs
+	if len(ids) == 0 {
+		err := db.Model(&models.ProcessInstance{}).
+			Where("process_definition_id = ? AND status IN ?", plan.SourceDefinitionID, []string{InstanceActive, InstanceSuspended}).
+			Order("id").
+			Pluck("id", &ids).Error
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// Each instance is checked and migrated in a transaction of its own, so a
+	// large plan holds one instance lock at a time and a failure leaves the
+	// others alone
+	results := make([]MigrationResult, 0, len(ids))
+	for _, id := range ids {
+		result := MigrationResult{ProcessInstanceID: id}
+		err := db.Transaction(func(tx *gorm.DB) error {
+			r, err := e.load(ctx, tx, id)
+			if err != nil {
+				return err
+			}
+			executions, problems, err := m.check(r)
+			if err != nil {
+				return err
+			}
+			result.Problems = problems
+			if apply && len(problems) == 0 {
+				if err := m.apply(r, executions); err != nil {
+					return err
+				}
+				result.Migrated = true
+			}
+			return nil
+		})
+		switch {
+		case errors.Is(err, ErrInstanceNotFound):
+			result.Problems = []string{"process instance not found"}
+		case err != nil:
+			result.Problems = []string{err.Error()}
+			result.Migrated = false
+		}
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+// migration loads both versions of a plan and checks that its mappings name real elements
+func (e *Engine) migration(tx *gorm.DB, plan MigrationPlan) (*instanceMigration, error) {
+	source, from, err := e.definition(tx, plan.SourceDefinitionID)
+	if err != nil {
+		return nil, err
+	}
+	target, to, err := e.definition(tx, plan.TargetDefinitionID)
+	if err != nil {
+		return nil, err
+	}
+	if source.Key != target.Key {
+		return nil, fmt.Errorf("%w: source and target are not versions of the same process", ErrInvalidMigrationPlan)
+	}
+	if source.ID == target.ID {
+		return nil, fmt.Errorf("%w: source and target are the same version", ErrInvalidMigrationPlan)
+	}
+	for src, dst := range plan.Mappings {
+		if from.Node(src) == nil {
+			return nil, fmt.Errorf("%w: element %s does not exist in version %d", ErrInvalidMigrationPlan, src, source.Version)
+		}
+		if to.Node(dst) == nil {
+			return nil, fmt.Errorf("%w: element %s does not exist in version %d", ErrInvalidMigrationPlan, dst, target.Version)
+		}
+	}
+	return &instanceMigration{plan: plan, target: target, from: from, to: to}, nil
+}
+
+// targetOf returns the ID an element of the source version maps to
+func (m *instanceMigration) targetOf(elementID string) string {
+	if target, ok := m.plan.Mappings[elementID]; ok {
+		return target
+	}
+	return elementID
+}
+
+// check returns the open tokens of an instance together with the reasons it cannot be migrated
+func (m *instanceMigration) check(r *run) ([]models.Execution, []string, error) {
+	if problems := m.checkInstance(r.inst); len(problems) > 0 {
+		return nil, problems, nil
+	}
+
+	var open []models.Execution
+	err := r.tx.Where("process_instance_id = ? AND status IN ?", r.inst.ID, openStatuses).Find(&open).Error
+	if err != nil {
+		return nil, nil, err
+	}
+	return open, m.checkTokens(open), nil
+}
+
+// checkInstance reports why an instance cannot be migrated whatever its tokens
+func (m *instanceMigration) checkInstance(inst *models.ProcessInstance) []string {
+	var problems []string
+	if inst.ProcessDefinitionID != m.plan.SourceDefinitionID {
+		problems = append(problems, "process instance is not running on the source version")
+	}
+	if inst.Status != InstanceActive && inst.Status != InstanceSuspended {
+		problems = append(problems, fmt.Sprintf("process instance is %s", inst.Status))
+	}
+	return problems
+}
+
+// checkTokens reports the open tokens of an instance that have no place in the target version
+func (m *instanceMigration) checkTokens(open []models.Execution) []string {
+	var problems []string
+	byID := make(map[uuid.UUID]*models.Execution, len(open))
+	for i := range open {
+		byID[open[i].ID] = &open[i]
+	}
+
+	for i := range open {
+		ex := &open[i]
+		src := m.from.Node(ex.ElementID)
+		if src == nil {
+			problems = append(problems, fmt.Sprintf("element %s is not part of the source version", ex.ElementID))
+			continue
+		}
+		targetID := m.targetOf(ex.ElementID)
+		dst := m.to.Node(targetID)
+		switch {
+		case dst == nil:
+			problems = append(problems, fmt.Sprintf("element %s has no counterpart in the target version", ex.ElementID))
+			continue
+		case dst.Type != src.Type:
+			problems = append(problems, fmt.Sprintf("%s %s cannot be mapped to %s %s", src.Type, src.ID, dst.Type, dst.ID))
+			continue
+		case (src.MultiInstance == nil) != (dst.MultiInstance == nil):
+			problems = append(problems, fmt.Sprintf("%s and %s do not both have multi-instance loops", src.ID, dst.ID))
+			continue
+		}
+
+		if problem := m.checkScope(ex, dst, byID); problem != "" {
+			problems = append(problems, problem)
+		}
+	}
+	return problems
+}
+
+// checkScope verifies that a token lands in the target counterpart of its current scope
+func (m *instanceMigration) checkScope(ex *models.Execution, dst *bpmn.Node, byID map[uuid.UUID]*models.Execution) string {
+	if ex.ParentID == nil {
+		if dst.Process.Parent != nil {
+			return fmt.Sprintf("%s would move into sub-process %s", ex.ElementID, dst.Process.Parent.ID)
+		}
+		return ""
+	}
+	parent, ok := byID[*ex.ParentID]
+	if !ok {
+		return fmt.Sprintf("the scope of %s is no longer open", ex.ElementID)
+	}
+	// Iterations share the element of their multi-instance body
+	if parent.ElementID == ex.ElementID {
+		return ""
+	}
+
+	scope := m.to.Node(m.targetOf(parent.ElementID))
+	if scope == nil {
+		return ""
+	}
+	if scope.SubProcess != nil {
+		if dst.Process != scope.SubProcess {
+			return fmt.Sprintf("%s would leave sub-process %s", ex.ElementID, scope.ID)
+		}
+		return ""
+	}
+	// Compensation handlers run in the scope of the event that threw compensation
+	if dst.Process != scope.Process {
+		return fmt.Sprintf("%s would leave the scope of %s", ex.ElementID, scope.ID)
+	}
+	return ""
+}
+
+// apply moves the open tokens of a checked instance and everything bound to them to the target version
+func (m *instanceMigration) apply(r *run, open []models.Execution) error {
+	moved := make(map[string]string)
+	r.inst.ProcessDefinitionID = m.target.ID
+	r.defs = m.to
+
+	for i := range open {
+		ex := &open[i]
+		source := ex.ElementID
+		src := m.from.Node(source)
+		dst := m.to.Node(m.targetOf(source))
+		moved[source] = dst.ID
+
+		ex.ElementID = dst.ID
+		if ex.ElementType != ElementTypeMultiInstanceBody {
+			ex.ElementType = string(dst.Type)
+		}
+		if err := r.tx.Save(ex).Error; err != nil {
+			return err
+		}
+
+		name := dst.Name
+		if name == "" {
+			name = dst.ID
+		}
+		err := r.tx.Model(&models.TaskInstance{}).
+			Where("execution_id = ? AND status IN ?", ex.ID, []string{TaskCreated, TaskAssigned}).
+			Updates(map[string]interface{}{"task_definition_key": dst.ID, "name": name}).Error
+		if err != nil {
+			return err
+		}
+
+		err = r.tx.Model(&models.Incident{}).
+			Where("execution_id = ? AND status = ?", ex.ID, IncidentOpen).
+			Update("element_id", dst.ID).Error
+		if err != nil {
+			return err
+		}
+
+		// The element's own timer, retry job and message or signal wait carry over
+		err = r.tx.Model(&models.Job{}).
+			Where("execution_id = ? AND element_id = ? AND status IN ?", ex.ID, source, []string{JobPending, JobSuspended}).
+			Updates(map[string]interface{}{"element_id": dst.ID, "process_definition_id": m.target.ID}).Error
+		if err != nil {
+			return err
+		}
+		updates := map[string]interface{}{"element_id": dst.ID, "process_definition_id": m.target.ID}
+		if kind, name := subscriptionOf(dst); kind != "" {
+			updates["type"] = kind
+			updates["event_name"] = name
+		}
+		err = r.tx.Model(&models.EventSubscription{}).
+			Where("execution_id = ? AND element_id = ?", ex.ID, source).
+			Updates(updates).Error
+		if err != nil {
+			return err
+		}
+
+		// Boundary events differ between versions, so they are armed again
+		if len(src.Boundaries) > 0 || len(dst.Boundaries) > 0 {
+			if err := m.rearmBoundaryEvents(r, ex, dst); err != nil {
+				return err
+			}
+		}
+	}
+
+	if err := r.save(); err != nil {
+		return err
+	}
+	return m.audit(r, moved)
+}
+
+// rearmBoundaryEvents replaces the boundary events of a migrated activity with those of its target
+func (m *instanceMigration) rearmBoundaryEvents(r *run, ex *models.Execution, dst *bpmn.Node) error {
+	err := r.tx.Model(&models.Job{}).
+		Where("execution_id = ? AND type = ? AND status IN ?", ex.ID, JobTimerBoundary, []string{JobPending, JobSuspended}).
+		Update("status", JobCancelled).Error
+	if err != nil {
+		return err
+	}
+	err = r.tx.Unscoped().
+		Where("execution_id = ? AND element_id <> ?", ex.ID, dst.ID).
+		Delete(&models.EventSubscription{}).Error
+	if err != nil {
+		return err
+	}
+	if ex.Status != ExecutionWaiting {
+		return nil
+	}
+	if err := r.attachBoundaryEvents(ex, dst); err != nil {
+		return err
+	}
+	if r.inst.Status == InstanceSuspended {
+		return r.setJobStatus(JobPending, JobSuspended)
+	}
+	return nil
+}
+
+// audit records the migration of an instance in the audit log
+func (m *instanceMigration) audit(r *run, moved map[string]string) error {
+	details, err := json.Marshal(map[string]interface{}{
+		"source_definition_id": m.plan.SourceDefinitionID,
+		"target_definition_id": m.target.ID,
+		"target_version":       m.target.Version,
+		"elements":             moved,
+	})
+	if err != nil {
+		return err
+	}
+	entry := models.AuditLog{
+		Timestamp:  time.Now().UTC(),
+		UserID:     m.plan.MigratedBy,
+		Action:     "migrate",
+		Resource:   "process_instance",
+		ResourceID: &r.inst.ID,
+		Details:    string(details),
+		Success:    true,
+	}
+	return r.tx.Omit("User").Create(&entry).Error
+}
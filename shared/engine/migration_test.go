@@ -0,0 +1,176 @@
+package engine
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/google/uuid"
+
+	"github.com/tvolodi/ai-bpms-backend/shared/bpmn"
+	"github.com/tvolodi/ai-bpms-backend/shared/common/models"
+)
+
+// orderV1 reviews an order, has it approved in a sub-process and notifies the customer
+const orderV1 = `
+<startEvent id="start"/>
+<sequenceFlow id="f1" sourceRef="start" targetRef="review"/>
+<userTask id="review"/>
+<sequenceFlow id="f2" sourceRef="review" targetRef="approval"/>
+<subProcess id="approval">
+  <startEvent id="approvalStart"/>
+  <sequenceFlow id="a1" sourceRef="approvalStart" targetRef="approve"/>
+  <userTask id="approve"/>
+  <sequenceFlow id="a2" sourceRef="approve" targetRef="approvalEnd"/>
+  <endEvent id="approvalEnd"/>
+</subProcess>
+<sequenceFlow id="f3" sourceRef="approval" targetRef="notify"/>
+<serviceTask id="notify" implementation="mail"/>
+<sequenceFlow id="f4" sourceRef="notify" targetRef="end"/>
+<endEvent id="end"/>`
+
+// orderV2 renames the review to a check and adds a signature to the approval
+const orderV2 = `
+<startEvent id="start"/>
+<sequenceFlow id="f1" sourceRef="start" targetRef="check"/>
+<userTask id="check"/>
+<sequenceFlow id="f2" sourceRef="check" targetRef="approval"/>
+<subProcess id="approval">
+  <startEvent id="approvalStart"/>
+  <sequenceFlow id="a1" sourceRef="approvalStart" targetRef="approve"/>
+  <userTask id="approve"/>
+  <sequenceFlow id="a2" sourceRef="approve" targetRef="sign"/>
+  <userTask id="sign"/>
+  <sequenceFlow id="a3" sourceRef="sign" targetRef="approvalEnd"/>
+  <endEvent id="approvalEnd"/>
+</subProcess>
+<sequenceFlow id="f3" sourceRef="approval" targetRef="notify"/>
+<serviceTask id="notify" implementation="mail"/>
+<sequenceFlow id="f4" sourceRef="notify" targetRef="end"/>
+<endEvent id="end"/>`
+
+func parseOrder(t *testing.T, process string) *bpmn.Definitions {
+	t.Helper()
+	defs, err := bpmn.ParseString(document("order", process))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return defs
+}
+
+// token returns an open execution of an element, inside the given scope
+func token(elementID string, scope *models.Execution) models.Execution {
+	ex := models.Execution{ElementID: elementID, Status: ExecutionWaiting}
+	ex.ID = uuid.New()
+	if scope != nil {
+		ex.ParentID = &scope.ID
+	}
+	return ex
+}
+
+func TestMigrationCheckInstance(t *testing.T) {
+	source := uuid.New()
+	m := &instanceMigration{plan: MigrationPlan{SourceDefinitionID: source}}
+	tests := []struct {
+		name string
+		inst models.ProcessInstance
+		want []string
+	}{
+		{name: "active", inst: models.ProcessInstance{ProcessDefinitionID: source, Status: InstanceActive}},
+		{name: "suspended", inst: models.ProcessInstance{ProcessDefinitionID: source, Status: InstanceSuspended}},
+		{
+			name: "completed",
+			inst: models.ProcessInstance{ProcessDefinitionID: source, Status: InstanceCompleted},
+			want: []string{"process instance is completed"},
+		},
+		{
+			name: "another version",
+			inst: models.ProcessInstance{ProcessDefinitionID: uuid.New(), Status: InstanceTerminated},
+			want: []string{"process instance is not running on the source version", "process instance is terminated"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := m.checkInstance(&tt.inst); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMigrationCheckTokens(t *testing.T) {
+	from, to := parseOrder(t, orderV1), parseOrder(t, orderV2)
+	approval := token("approval", nil)
+	tests := []struct {
+		name     string
+		mappings map[string]string
+		open     []models.Execution
+		want     []string
+	}{
+		{
+			name:     "mapped user task",
+			mappings: map[string]string{"review": "check"},
+			open:     []models.Execution{token("review", nil)},
+		},
+		{
+			name: "unmapped element missing from the target",
+			open: []models.Execution{token("review", nil)},
+			want: []string{"element review has no counterpart in the target version"},
+		},
+		{
+			name: "element missing from the source",
+			open: []models.Execution{token("sign", nil)},
+			want: []string{"element sign is not part of the source version"},
+		},
+		{
+			name:     "different element types",
+			mappings: map[string]string{"review": "notify"},
+			open:     []models.Execution{token("review", nil)},
+			want:     []string{"userTask review cannot be mapped to serviceTask notify"},
+		},
+		{
+			name: "token inside a sub-process",
+			open: []models.Execution{approval, token("approve", &approval)},
+		},
+		{
+			name:     "token moved to another task of its sub-process",
+			mappings: map[string]string{"approve": "sign"},
+			open:     []models.Execution{approval, token("approve", &approval)},
+		},
+		{
+			name:     "token moved into a sub-process",
+			mappings: map[string]string{"review": "sign"},
+			open:     []models.Execution{token("review", nil)},
+			want:     []string{"review would move into sub-process approval"},
+		},
+		{
+			name:     "token moved out of its sub-process",
+			mappings: map[string]string{"approve": "check"},
+			open:     []models.Execution{approval, token("approve", &approval)},
+			want:     []string{"approve would leave sub-process approval"},
+		},
+		{
+			name: "scope no longer open",
+			open: []models.Execution{token("approve", &approval)},
+			want: []string{"the scope of approve is no longer open"},
+		},
+		{
+			name:     "every problem of the instance",
+			mappings: map[string]string{"approve": "check"},
+			open:     []models.Execution{token("review", nil), approval, token("approve", &approval)},
+			want: []string{
+				"element review has no counterpart in the target version",
+				"approve would leave sub-process approval",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := &instanceMigration{plan: MigrationPlan{Mappings: tt.mappings}, from: from, to: to}
+			if got := m.checkTokens(tt.open); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,262 @@
+package engine
+
+import (
+	"fmt"
+	"reflect"
+	"time"
+
+	"github.com/tvolodi/ai-bpms-backend/shared/bpmn"
+	"github.com/tvolodi/ai-bpms-backend/shared/common/models"
+	"github.com/tvolodi/ai-bpms-backend/shared/expression"
+)
+
+// ElementTypeMultiInstanceBody marks the execution that holds the iterations of a
+// multi-instance activity. The iterations are its child executions.
+const ElementTypeMultiInstanceBody = "multiInstanceBody"
+
+// Multi-instance loop variables
+const (
+	varLoopCounter            = "loopCounter"
+	varNrOfInstances          = "nrOfInstances"
+	varNrOfActiveInstances    = "nrOfActiveInstances"
+	varNrOfCompletedInstances = "nrOfCompletedInstances"
+
+	// Bookkeeping kept on the body execution
+	varLoopItems   = "loopItems"
+	varLoopOutputs = "loopOutputs"
+)
+
+// multiInstance turns the arriving token into the body of a multi-instance
+// activity and starts its iterations: all at once, or the first of a sequence.
+func (r *run) multiInstance(body *models.Execution, node *bpmn.Node) error {
+	mi := node.MultiInstance
+	items, err := r.loopItems(mi)
+	if err != nil {
+		return raise(IncidentExpressionFailed, "multi-instance %s: %v", node.ID, err)
+	}
+
+	n := len(items)
+	if mi.Collection == "" {
+		items = nil
+	}
+	local := map[string]interface{}{
+		varNrOfInstances:          n,
+		varNrOfActiveInstances:    0,
+		varNrOfCompletedInstances: 0,
+		varLoopOutputs:            make([]interface{}, n),
+	}
+	if items != nil {
+		local[varLoopItems] = items
+	}
+
+	body.ElementType = ElementTypeMultiInstanceBody
+	if n == 0 {
+		if mi.OutputCollection != "" {
+			r.vars[mi.OutputCollection] = []interface{}{}
+		}
+		return r.leave(body, node.Outgoing)
+	}
+
+	iterations := n
+	if mi.Sequential {
+		iterations = 1
+	}
+	local[varNrOfActiveInstances] = iterations
+	if err := r.setLocal(body, local); err != nil {
+		return err
+	}
+	if err := r.setStatus(body, ExecutionWaiting); err != nil {
+		return err
+	}
+	for i := 0; i < iterations; i++ {
+		if err := r.iteration(body, node, local, i); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// loopItems evaluates the collection of a multi-instance activity, or returns
+// one placeholder per iteration for a loop cardinality
+func (r *run) loopItems(mi *bpmn.MultiInstance) ([]interface{}, error) {
+	if mi.Collection != "" {
+		value, err := expression.Evaluate(mi.Collection, r.vars)
+		if err != nil {
+			return nil, err
+		}
+		if value == nil {
+			return nil, nil
+		}
+		v := reflect.ValueOf(value)
+		if v.Kind() != reflect.Slice && v.Kind() != reflect.Array {
+			return nil, fmt.Errorf("collection %s is a %T, not a list", mi.Collection, value)
+		}
+		items := make([]interface{}, v.Len())
+		for i := range items {
+			items[i] = v.Index(i).Interface()
+		}
+		return items, nil
+	}
+
+	value, err := expression.Evaluate(mi.Cardinality, r.vars)
+	if err != nil {
+		return nil, err
+	}
+	n, ok := toInt(value)
+	if !ok || n < 0 {
+		return nil, fmt.Errorf("loop cardinality %v is not a non-negative number", value)
+	}
+	return make([]interface{}, n), nil
+}
+
+// iteration starts the i-th iteration of a multi-instance user task
+func (r *run) iteration(body *models.Execution, node *bpmn.Node, bodyLocal map[string]interface{}, i int) error {
+	local := map[string]interface{}{varLoopCounter: i}
+	if items, ok := bodyLocal[varLoopItems].([]interface{}); ok && node.MultiInstance.ElementVariable != "" {
+		local[node.MultiInstance.ElementVariable] = items[i]
+	}
+
+	encoded, err := encodeVariables(local)
+	if err != nil {
+		return err
+	}
+	ex := &models.Execution{
+		ProcessInstanceID: r.inst.ID,
+		ParentID:          &body.ID,
+		ElementID:         node.ID,
+		ElementType:       string(node.Type),
+		Status:            ExecutionActive,
+		Variables:         encoded,
+		StartedAt:         time.Now().UTC(),
+	}
+	if err := r.tx.Create(ex).Error; err != nil {
+		return fmt.Errorf("failed to create iteration of %s: %w", node.ID, err)
+	}
+
+	vars := r.iterationScope(bodyLocal, local)
+	return r.userTask(ex, node, vars, local)
+}
+
+// iterationCompleted records a finished iteration. The loop ends once every
+// iteration has completed or the completion condition holds; remaining
+// iterations are then cancelled and the body token moves on.
+func (r *run) iterationCompleted(ex *models.Execution, node *bpmn.Node) error {
+	mi := node.MultiInstance
+	if ex.ParentID == nil {
+		return fmt.Errorf("iteration %s of %s has no multi-instance body", ex.ID, node.ID)
+	}
+	body, err := r.execution(*ex.ParentID)
+	if err != nil {
+		return err
+	}
+	bodyLocal, err := decodeVariables(body.Variables)
+	if err != nil {
+		return err
+	}
+	local, err := decodeVariables(ex.Variables)
+	if err != nil {
+		return err
+	}
+	if err := r.setStatus(ex, ExecutionCompleted); err != nil {
+		return err
+	}
+
+	n, _ := toInt(bodyLocal[varNrOfInstances])
+	completed, _ := toInt(bodyLocal[varNrOfCompletedInstances])
+	active, _ := toInt(bodyLocal[varNrOfActiveInstances])
+	counter, _ := toInt(local[varLoopCounter])
+	bodyLocal[varNrOfCompletedInstances] = completed + 1
+	bodyLocal[varNrOfActiveInstances] = active - 1
+
+	scope := r.iterationScope(bodyLocal, local)
+	outputs, _ := bodyLocal[varLoopOutputs].([]interface{})
+	if mi.OutputCollection != "" && counter < len(outputs) {
+		var out interface{}
+		if mi.OutputElement != "" {
+			if out, err = expression.Evaluate(mi.OutputElement, scope); err != nil {
+				return raise(IncidentExpressionFailed, "multi-instance %s output: %v", node.ID, err)
+			}
+		} else if mi.ElementVariable != "" {
+			out = scope[mi.ElementVariable]
+		}
+		outputs[counter] = out
+		bodyLocal[varLoopOutputs] = outputs
+		r.vars[mi.OutputCollection] = outputs
+	}
+
+	done := completed+1 >= n
+	if !done && mi.CompletionCondition != "" {
+		if done, err = expression.EvaluateBool(mi.CompletionCondition, scope); err != nil {
+			return raise(IncidentExpressionFailed, "multi-instance %s completion condition: %v", node.ID, err)
+		}
+	}
+
+	if !done {
+		if mi.Sequential && counter+1 < n {
+			bodyLocal[varNrOfActiveInstances] = active
+			if err := r.setLocal(body, bodyLocal); err != nil {
+				return err
+			}
+			return r.iteration(body, node, bodyLocal, counter+1)
+		}
+		return r.setLocal(body, bodyLocal)
+	}
+
+	var open []models.Execution
+	if err := r.scope(&body.ID).Where("status IN ?", openStatuses).Find(&open).Error; err != nil {
+		return err
+	}
+	for i := range open {
+		if err := r.cancelActivity(&open[i]); err != nil {
+			return err
+		}
+	}
+	bodyLocal[varNrOfActiveInstances] = 0
+	if err := r.setLocal(body, bodyLocal); err != nil {
+		return err
+	}
+	return r.leave(body, node.Outgoing)
+}
+
+// iterationScope returns the variables visible to an iteration: the instance
+// variables overlaid with the loop counters of the body and the iteration
+func (r *run) iterationScope(bodyLocal, local map[string]interface{}) map[string]interface{} {
+	vars := copyVariables(r.vars)
+	for _, k := range []string{varNrOfInstances, varNrOfActiveInstances, varNrOfCompletedInstances} {
+		vars[k] = bodyLocal[k]
+	}
+	for k, v := range local {
+		vars[k] = v
+	}
+	return vars
+}
+
+// setLocal stores the local variables of an execution
+func (r *run) setLocal(ex *models.Execution, local map[string]interface{}) error {
+	encoded, err := encodeVariables(local)
+	if err != nil {
+		return err
+	}
+	ex.Variables = encoded
+	return r.tx.Save(ex).Error
+}
+
+// toInt converts a numeric variable, which may have been decoded from JSON, to an int
+func toInt(v interface{}) (int, bool) {
+	switch n := v.(type) {
+	case int:
+		return n, true
+	case int64:
+		return int(n), true
+	case float64:
+		return int(n), n == float64(int(n))
+	}
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return int(rv.Int()), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return int(rv.Uint()), true
+	}
+	return 0, false
+}
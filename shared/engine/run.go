@@ -0,0 +1,495 @@
+package engine
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"github.com/tvolodi/ai-bpms-backend/shared/bpmn"
+	"github.com/tvolodi/ai-bpms-backend/shared/common/models"
+	"github.com/tvolodi/ai-bpms-backend/shared/expression"
+	"github.com/tvolodi/ai-bpms-backend/shared/timer"
+)
+
+// run is a single state transition of a process instance. Tokens queued on
+// the agenda are executed one by one until each of them waits or ends; all
+// changes are written through the transaction the run was created with.
+type run struct {
+	ctx    context.Context
+	e      *Engine
+	tx     *gorm.DB
+	inst   *models.ProcessInstance
+	defs   *bpmn.Definitions
+	vars   map[string]interface{}
+	agenda []*models.Execution
+
+	// caller is the run of the parent instance when this instance was started by
+	// a call activity within the same transition
+	caller *run
+
+	// actor is the user whose request drives the run, if any. status and
+	// recorded hold the instance status and encoded variables as last written
+	// to the instance history.
+	actor    *uuid.UUID
+	status   string
+	recorded map[string][]byte
+}
+
+func newRun(ctx context.Context, e *Engine, tx *gorm.DB, inst *models.ProcessInstance, defs *bpmn.Definitions, vars map[string]interface{}) *run {
+	return &run{ctx: ctx, e: e, tx: tx, inst: inst, defs: defs, vars: vars, status: inst.Status, recorded: snapshot(vars)}
+}
+
+// node returns the flow node with the given ID from the instance's definition
+func (r *run) node(id string) (*bpmn.Node, error) {
+	n := r.defs.Node(id)
+	if n == nil {
+		return nil, fmt.Errorf("element %s not found in process definition", id)
+	}
+	return n, nil
+}
+
+// execution loads an execution of the current instance
+func (r *run) execution(id uuid.UUID) (*models.Execution, error) {
+	var ex models.Execution
+	if err := r.tx.First(&ex, "id = ? AND process_instance_id = ?", id, r.inst.ID).Error; err != nil {
+		return nil, err
+	}
+	return &ex, nil
+}
+
+// scope restricts a query to executions of the instance within the given parent scope
+func (r *run) scope(parent *uuid.UUID) *gorm.DB {
+	q := r.tx.Model(&models.Execution{}).Where("process_instance_id = ?", r.inst.ID)
+	if parent == nil {
+		return q.Where("parent_id IS NULL")
+	}
+	return q.Where("parent_id = ?", *parent)
+}
+
+// spawn places a new token on node inside the given scope and queues it for execution
+func (r *run) spawn(parent *uuid.UUID, node *bpmn.Node) (*models.Execution, error) {
+	ex := &models.Execution{
+		ProcessInstanceID: r.inst.ID,
+		ParentID:          parent,
+		ElementID:         node.ID,
+		ElementType:       string(node.Type),
+		Status:            ExecutionActive,
+		Variables:         "{}",
+		StartedAt:         time.Now().UTC(),
+	}
+	if err := r.tx.Create(ex).Error; err != nil {
+		return nil, fmt.Errorf("failed to create execution for %s: %w", node.ID, err)
+	}
+	r.agenda = append(r.agenda, ex)
+	return ex, nil
+}
+
+// setStatus updates the status of an execution, stamping EndedAt for final states.
+// Queued copies of the execution are updated too, so cancelled tokens are not run.
+func (r *run) setStatus(ex *models.Execution, status string) error {
+	ex.Status = status
+	if status == ExecutionCompleted || status == ExecutionCancelled {
+		now := time.Now().UTC()
+		ex.EndedAt = &now
+	}
+	for _, queued := range r.agenda {
+		if queued.ID == ex.ID && queued != ex {
+			queued.Status = status
+		}
+	}
+	return r.tx.Save(ex).Error
+}
+
+// drain executes queued tokens until all of them wait or end, then persists the instance
+func (r *run) drain() error {
+	for len(r.agenda) > 0 && r.inst.Status == InstanceActive {
+		ex := r.agenda[0]
+		r.agenda = r.agenda[1:]
+		if ex.Status != ExecutionActive {
+			continue
+		}
+		if err := r.step(ex, func() error { return r.execute(ex) }); err != nil {
+			return err
+		}
+	}
+	return r.save()
+}
+
+// step runs the behavior of one token inside a savepoint. When the element fails
+// with an incident its partial changes are rolled back and the token is parked as
+// failed, while the rest of the transition goes on.
+func (r *run) step(ex *models.Execution, behavior func() error) error {
+	savepoint := "exec_" + strings.ReplaceAll(ex.ID.String(), "-", "")
+	if err := r.tx.SavePoint(savepoint).Error; err != nil {
+		return err
+	}
+	agenda := len(r.agenda)
+	vars := copyVariables(r.vars)
+	inst := *r.inst
+	saved := *ex
+
+	err := behavior()
+	ie, ok := asIncident(err)
+	if !ok {
+		return err
+	}
+
+	if err := r.tx.RollbackTo(savepoint).Error; err != nil {
+		return err
+	}
+	r.agenda = r.agenda[:agenda]
+	r.vars = vars
+	*r.inst = inst
+	*ex = saved
+	return r.createIncident(ex, ie)
+}
+
+// save writes the instance variables and state
+func (r *run) save() error {
+	encoded, err := encodeVariables(r.vars)
+	if err != nil {
+		return err
+	}
+	r.inst.Variables = encoded
+	if err := r.recordChanges(); err != nil {
+		return err
+	}
+	return r.tx.Omit(clause.Associations).Save(r.inst).Error
+}
+
+// execute runs the behavior of the element a token has just arrived at
+func (r *run) execute(ex *models.Execution) error {
+	node, err := r.node(ex.ElementID)
+	if err != nil {
+		return err
+	}
+
+	if len(node.Boundaries) > 0 {
+		if err := r.attachBoundaryEvents(ex, node); err != nil {
+			return err
+		}
+	}
+
+	switch node.Type {
+	case bpmn.StartEvent, bpmn.Task, bpmn.BoundaryEvent:
+		return r.leave(ex, node.Outgoing)
+	case bpmn.EndEvent:
+		switch node.Event {
+		case bpmn.ErrorEvent:
+			return r.throwError(ex, node.ErrorCode, "")
+		case bpmn.CompensateEvent:
+			return r.compensate(ex, node)
+		}
+		return r.end(ex)
+	case bpmn.IntermediateThrowEvent:
+		if node.Event == bpmn.CompensateEvent {
+			return r.compensate(ex, node)
+		}
+		return r.leave(ex, node.Outgoing)
+	case bpmn.UserTask:
+		if node.MultiInstance != nil {
+			return r.multiInstance(ex, node)
+		}
+		return r.userTask(ex, node, r.vars, nil)
+	case bpmn.ServiceTask:
+		return r.serviceTask(ex, node, node.Retries)
+	case bpmn.ScriptTask:
+		return r.scriptTask(ex, node)
+	case bpmn.IntermediateCatchEvent:
+		return r.intermediateCatchEvent(ex, node)
+	case bpmn.ReceiveTask:
+		return r.receiveTask(ex, node)
+	case bpmn.SubProcess:
+		return r.subProcess(ex, node)
+	case bpmn.CallActivity:
+		return r.callActivity(ex, node)
+	case bpmn.ExclusiveGateway:
+		return r.exclusiveGateway(ex, node)
+	case bpmn.ParallelGateway:
+		return r.parallelGateway(ex, node)
+	case bpmn.InclusiveGateway:
+		return r.inclusiveGateway(ex, node)
+	default:
+		return fmt.Errorf("element %s: %s is not supported by the engine", node.ID, node.Type)
+	}
+}
+
+// leave completes the execution and moves one new token along each of the given flows.
+// A node without outgoing flows ends the token, as an implicit end event would.
+func (r *run) leave(ex *models.Execution, flows []*bpmn.SequenceFlow) error {
+	if node := r.defs.Node(ex.ElementID); node != nil && len(node.Boundaries) > 0 {
+		if err := r.detachBoundaryEvents(ex); err != nil {
+			return err
+		}
+	}
+	if len(flows) == 0 {
+		return r.end(ex)
+	}
+	if err := r.setStatus(ex, ExecutionCompleted); err != nil {
+		return err
+	}
+	for _, f := range flows {
+		if _, err := r.spawn(ex.ParentID, f.Target); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// taskCompleted moves a token on once the work of its task is done
+func (r *run) taskCompleted(ex *models.Execution, node *bpmn.Node) error {
+	if node.MultiInstance != nil {
+		return r.iterationCompleted(ex, node)
+	}
+	return r.leave(ex, node.Outgoing)
+}
+
+// end consumes a token and completes its scope once no other token is left in it
+func (r *run) end(ex *models.Execution) error {
+	if err := r.setStatus(ex, ExecutionCompleted); err != nil {
+		return err
+	}
+	return r.scopeEnded(ex.ParentID)
+}
+
+// scopeEnded completes the embedded sub-process, or the process instance at the top
+// level, when the given scope has no open tokens left
+func (r *run) scopeEnded(parent *uuid.UUID) error {
+	var open int64
+	if err := r.scope(parent).Where("status IN ?", openStatuses).Count(&open).Error; err != nil {
+		return err
+	}
+	if open > 0 {
+		return nil
+	}
+	if parent != nil {
+		return r.leaveScope(*parent)
+	}
+	return r.completeInstance()
+}
+
+// completeInstance marks the process instance as completed and hands control
+// back to the call activity that started it, if any
+func (r *run) completeInstance() error {
+	now := time.Now().UTC()
+	duration := now.Sub(r.inst.StartedAt).Milliseconds()
+	r.inst.Status = InstanceCompleted
+	r.inst.EndedAt = &now
+	r.inst.Duration = &duration
+	if r.inst.ParentExecutionID != nil {
+		return r.returnToCaller()
+	}
+	return nil
+}
+
+// userTask creates the task instance for a user task and parks the token until it is completed.
+// Expressions in the task attributes are evaluated against vars; local holds the variables
+// of a multi-instance iteration, which are recorded on the task.
+func (r *run) userTask(ex *models.Execution, node *bpmn.Node, vars, local map[string]interface{}) error {
+	name := node.Name
+	if name == "" {
+		name = node.ID
+	}
+
+	taskVars, err := encodeVariables(local)
+	if err != nil {
+		return err
+	}
+	task := models.TaskInstance{
+		ProcessInstanceID: r.inst.ID,
+		ExecutionID:       &ex.ID,
+		TaskDefinitionKey: node.ID,
+		Name:              name,
+		CandidateGroup:    node.CandidateGroups,
+		FormKey:           node.FormKey,
+		Status:            TaskCreated,
+		Priority:          50,
+		FormData:          "{}",
+		Variables:         taskVars,
+	}
+	now := time.Now().UTC()
+	cal, err := r.calendar(node.Calendar)
+	if err != nil {
+		return raise(IncidentCalendarFailed, "user task %s: %v", node.ID, err)
+	}
+	dueDate, err := resolveDate(node.DueDate, vars, now, cal)
+	if err != nil {
+		return raise(IncidentExpressionFailed, "user task %s due date: %v", node.ID, err)
+	}
+	followUpDate, err := resolveDate(node.FollowUpDate, vars, now, cal)
+	if err != nil {
+		return raise(IncidentExpressionFailed, "user task %s follow-up date: %v", node.ID, err)
+	}
+	task.DueDate = dueDate
+	task.FollowUpDate = followUpDate
+	if node.FormKey != "" {
+		form, err := formVersion(r.tx, node.FormKey, node.FormVersion)
+		if errors.Is(err, ErrFormNotFound) {
+			return raise(IncidentFormNotFound, "user task %s: %v", node.ID, err)
+		}
+		if err != nil {
+			return err
+		}
+		refs := make(map[string]int)
+		if _, err := compileForm(r.tx, form, refs); err != nil {
+			if errors.Is(err, ErrFormNotFound) {
+				return raise(IncidentFormNotFound, "user task %s: %v", node.ID, err)
+			}
+			return err
+		}
+		formRefs, err := json.Marshal(refs)
+		if err != nil {
+			return err
+		}
+		task.FormSchemaID = &form.ID
+		task.FormRefs = string(formRefs)
+	}
+	if err := r.applySLA(&task, now); err != nil {
+		return err
+	}
+
+	if node.Assignee != "" {
+		value, err := resolveValue(node.Assignee, vars)
+		if err != nil {
+			return raise(IncidentExpressionFailed, "user task %s assignee: %v", node.ID, err)
+		}
+		assignee, err := uuid.Parse(fmt.Sprint(value))
+		if err != nil {
+			return raise(IncidentExpressionFailed, "user task %s: assignee %q is not a user ID", node.ID, fmt.Sprint(value))
+		}
+		task.AssigneeID = &assignee
+		task.AssignedAt = &now
+		task.Status = TaskAssigned
+	} else if node.AssignmentStrategy != "" {
+		if err := r.autoAssign(&task, node.AssignmentStrategy, node.AssignmentParameters, vars); err != nil {
+			return err
+		}
+	}
+	applied, err := substitute(r.tx, &task, now)
+	if err != nil {
+		return err
+	}
+	if err := r.tx.Omit(clause.Associations).Create(&task).Error; err != nil {
+		return fmt.Errorf("failed to create task for %s: %w", node.ID, err)
+	}
+	detail := map[string]interface{}{"name": task.Name, "candidate_group": task.CandidateGroup}
+	if task.AssigneeID != nil {
+		detail["assignee_id"] = task.AssigneeID
+		if node.AssignmentStrategy != "" {
+			detail["assignment_strategy"] = node.AssignmentStrategy
+		}
+	}
+	if task.OriginalAssigneeID != nil {
+		detail["original_assignee_id"] = task.OriginalAssigneeID
+	}
+	if err := r.record(EventTaskCreated, &task.ID, detail); err != nil {
+		return err
+	}
+	if err := recordSubstitutions(r.tx, &task, applied, r.actor); err != nil {
+		return err
+	}
+
+	return r.setStatus(ex, ExecutionWaiting)
+}
+
+// receiveTask parks the token until the task's message is correlated to it
+func (r *run) receiveTask(ex *models.Execution, node *bpmn.Node) error {
+	if err := r.subscribe(ex, node); err != nil {
+		return err
+	}
+	return r.setStatus(ex, ExecutionWaiting)
+}
+
+// serviceTask invokes the registered handler for the task's implementation.
+// Service tasks without an implementation are treated as pass-through. A failed
+// call is retried later while retries are left, then raises an incident.
+func (r *run) serviceTask(ex *models.Execution, node *bpmn.Node, retries int) error {
+	if node.Implementation == "" || node.Implementation == "##WebService" {
+		return r.leave(ex, node.Outgoing)
+	}
+
+	handler, ok := r.e.service(node.Implementation)
+	if !ok {
+		return raise(IncidentServiceFailed, "service task %s: no service handler registered for %q", node.ID, node.Implementation)
+	}
+
+	out, err := invoke(r.ctx, handler, ServiceTask{
+		ProcessInstanceID: r.inst.ID,
+		ElementID:         node.ID,
+		BusinessKey:       r.inst.BusinessKey,
+		Variables:         copyVariables(r.vars),
+	})
+	var bpmnErr *BPMNError
+	if errors.As(err, &bpmnErr) {
+		return r.throwError(ex, bpmnErr.Code, bpmnErr.Message)
+	}
+	if err != nil && retries > 0 {
+		return r.retryLater(ex, node, retries-1, err)
+	}
+	if err != nil {
+		return raise(IncidentServiceFailed, "service task %s failed after %d attempts: %v", node.ID, node.Retries+1, err)
+	}
+	for k, v := range out {
+		r.vars[k] = v
+	}
+
+	return r.leave(ex, node.Outgoing)
+}
+
+// retryLater parks a failed service task token until a retry job runs the task
+// again after the task's retry backoff
+func (r *run) retryLater(ex *models.Execution, node *bpmn.Node, retries int, cause error) error {
+	due := time.Now().UTC()
+	if node.RetryBackoff != "" {
+		backoff, err := timer.ParseDuration(node.RetryBackoff)
+		if err != nil {
+			return raise(IncidentServiceFailed, "service task %s retry backoff: %v", node.ID, err)
+		}
+		due = backoff.AddTo(due)
+	}
+	job := models.Job{
+		Type:                JobServiceRetry,
+		Status:              JobPending,
+		DueAt:               due,
+		ProcessDefinitionID: &r.inst.ProcessDefinitionID,
+		ProcessInstanceID:   &r.inst.ID,
+		ExecutionID:         &ex.ID,
+		ElementID:           node.ID,
+		Retries:             retries,
+		LastError:           cause.Error(),
+	}
+	if err := r.tx.Create(&job).Error; err != nil {
+		return err
+	}
+	return r.setStatus(ex, ExecutionWaiting)
+}
+
+// invoke calls a service handler, turning a panic into an error
+func invoke(ctx context.Context, handler ServiceHandler, task ServiceTask) (out map[string]interface{}, err error) {
+	defer func() {
+		if p := recover(); p != nil {
+			err = fmt.Errorf("service handler panicked: %v", p)
+		}
+	}()
+	return handler(ctx, task)
+}
+
+// scriptTask evaluates the task's script as an expression, storing the result
+// in the result variable when one is declared
+func (r *run) scriptTask(ex *models.Execution, node *bpmn.Node) error {
+	result, err := expression.Evaluate(node.Script, r.vars)
+	if err != nil {
+		return raise(IncidentExpressionFailed, "script task %s: %v", node.ID, err)
+	}
+	if node.ResultVariable != "" {
+		r.vars[node.ResultVariable] = result
+	}
+	return r.leave(ex, node.Outgoing)
+}
@@ -0,0 +1,434 @@
+package engine
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"github.com/tvolodi/ai-bpms-backend/shared/bpmn"
+	"github.com/tvolodi/ai-bpms-backend/shared/common/models"
+	"github.com/tvolodi/ai-bpms-backend/shared/timer"
+)
+
+// SLA statuses of a task
+const (
+	SLAAtRisk   = "at_risk"
+	SLABreached = "breached"
+)
+
+// Escalation levels: a task reaches the warning level when it becomes at risk
+// and the breach level when it is overdue
+const (
+	EscalationWarning = "warning"
+	EscalationBreach  = "breach"
+)
+
+// SLA escalation actions
+const (
+	ActionRaisePriority = "raise-priority"
+	ActionReassignGroup = "reassign-group"
+	ActionNotify        = "notify"
+	ActionEscalation    = "escalation"
+)
+
+// maxPriority is the highest task priority
+const maxPriority = 100
+
+// ErrInvalidSLAPolicy is returned for an SLA policy whose durations or actions cannot be used
+var ErrInvalidSLAPolicy = errors.New("invalid SLA policy")
+
+// SLAAction is an escalation action of an SLA policy, taken when a task reaches
+// the level given by On (breach when empty).
+//
+//   - raise-priority raises the task priority to Priority, or by 20 when it is not set
+//   - reassign-group takes the task from its assignee and offers it to Group
+//   - notify notifies Group, or the assignee or candidate groups when it is not set
+//   - escalation throws EscalationCode at the task, to be caught by an escalation
+//     boundary event of the task or an enclosing sub-process
+type SLAAction struct {
+	Type           string `json:"type"`
+	On             string `json:"on,omitempty"`
+	Priority       int    `json:"priority,omitempty"`
+	Group          string `json:"group,omitempty"`
+	EscalationCode string `json:"escalation_code,omitempty"`
+}
+
+// level returns the escalation level the action is taken at
+func (a SLAAction) level() string {
+	if a.On == "" {
+		return EscalationBreach
+	}
+	return a.On
+}
+
+// ValidateSLAPolicy checks the durations and actions of an SLA policy
+func ValidateSLAPolicy(policy *models.SLAPolicy) error {
+	for name, value := range map[string]string{
+		"target_duration":   policy.TargetDuration,
+		"warning_threshold": policy.WarningThreshold,
+	} {
+		if value == "" {
+			continue
+		}
+		if _, err := timer.ParseDuration(value); err != nil {
+			return fmt.Errorf("%w: %s: %v", ErrInvalidSLAPolicy, name, err)
+		}
+	}
+	_, err := parseSLAActions(policy.Actions)
+	return err
+}
+
+// parseSLAActions decodes and checks the actions of an SLA policy
+func parseSLAActions(raw string) ([]SLAAction, error) {
+	if strings.TrimSpace(raw) == "" {
+		return nil, nil
+	}
+	var actions []SLAAction
+	if err := json.Unmarshal([]byte(raw), &actions); err != nil {
+		return nil, fmt.Errorf("%w: actions: %v", ErrInvalidSLAPolicy, err)
+	}
+	for i, action := range actions {
+		if level := action.level(); level != EscalationWarning && level != EscalationBreach {
+			return nil, fmt.Errorf("%w: action %d: unknown level %q", ErrInvalidSLAPolicy, i, action.On)
+		}
+		switch action.Type {
+		case ActionRaisePriority:
+			if action.Priority < 0 || action.Priority > maxPriority {
+				return nil, fmt.Errorf("%w: action %d: priority must be between 0 and %d", ErrInvalidSLAPolicy, i, maxPriority)
+			}
+		case ActionReassignGroup:
+			if action.Group == "" {
+				return nil, fmt.Errorf("%w: action %d: group is required", ErrInvalidSLAPolicy, i)
+			}
+		case ActionNotify:
+		case ActionEscalation:
+			if action.EscalationCode == "" {
+				return nil, fmt.Errorf("%w: action %d: escalation_code is required", ErrInvalidSLAPolicy, i)
+			}
+		default:
+			return nil, fmt.Errorf("%w: action %d: unknown type %q", ErrInvalidSLAPolicy, i, action.Type)
+		}
+	}
+	return actions, nil
+}
+
+// applySLA puts a new task under the SLA policy of its task definition, if there
+// is one. A task without a due date from the model is due the policy's target
+// duration after it was created, counted in the policy's business calendar.
+func (r *run) applySLA(task *models.TaskInstance, from time.Time) error {
+	var policy models.SLAPolicy
+	err := r.tx.Model(&models.SLAPolicy{}).
+		Joins("JOIN process_definitions ON process_definitions.key = sla_policies.process_definition_key").
+		Where("process_definitions.id = ? AND sla_policies.task_definition_key = ? AND sla_policies.is_active = ?", r.inst.ProcessDefinitionID, task.TaskDefinitionKey, true).
+		Limit(1).
+		Find(&policy).Error
+	if err != nil || policy.ID == uuid.Nil {
+		return err
+	}
+
+	cal, err := r.calendar(policy.Calendar)
+	if err != nil {
+		return raise(IncidentCalendarFailed, "SLA policy %s: %v", policy.ID, err)
+	}
+	if task.DueDate == nil && policy.TargetDuration != "" {
+		target, err := timer.ParseDuration(policy.TargetDuration)
+		if err != nil {
+			return fmt.Errorf("SLA policy %s: %w", policy.ID, err)
+		}
+		due := cal.Add(from, target)
+		task.DueDate = &due
+	}
+	if task.DueDate == nil {
+		return nil
+	}
+
+	task.SLAPolicyID = &policy.ID
+	warnAt := *task.DueDate
+	if policy.WarningThreshold != "" {
+		threshold, err := timer.ParseDuration(policy.WarningThreshold)
+		if err != nil {
+			return fmt.Errorf("SLA policy %s: %w", policy.ID, err)
+		}
+		warnAt = cal.Subtract(warnAt, threshold)
+	}
+	task.SLAWarningAt = &warnAt
+	return nil
+}
+
+// EvaluateSLAs escalates up to limit open tasks that have become at risk or
+// overdue by now and reports how many were escalated. Each task is escalated in
+// its own transaction; a failing task does not hold up the others.
+func (e *Engine) EvaluateSLAs(ctx context.Context, now time.Time, limit int) (int, error) {
+	var due []models.TaskInstance
+	err := e.db.WithContext(ctx).
+		Select("task_instances.id, task_instances.process_instance_id").
+		Joins("JOIN process_instances ON process_instances.id = task_instances.process_instance_id").
+		Where("task_instances.sla_policy_id IS NOT NULL AND task_instances.status IN ? AND process_instances.status = ?", []string{TaskCreated, TaskAssigned}, InstanceActive).
+		Where("(COALESCE(task_instances.sla_status, '') = '' AND task_instances.sla_warning_at <= ?) OR (COALESCE(task_instances.sla_status, '') <> ? AND task_instances.due_date <= ?)", now, SLABreached, now).
+		Order("task_instances.due_date").
+		Limit(limit).
+		Find(&due).Error
+	if err != nil {
+		return 0, err
+	}
+
+	count := 0
+	var errs []error
+	for _, task := range due {
+		escalated, err := e.escalateTask(ctx, task.ProcessInstanceID, task.ID, now)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("task %s: %w", task.ID, err))
+			continue
+		}
+		if escalated {
+			count++
+		}
+	}
+	return count, errors.Join(errs...)
+}
+
+// escalateTask moves a task to the SLA status it has reached and takes the
+// actions of every level it passed
+func (e *Engine) escalateTask(ctx context.Context, instanceID, taskID uuid.UUID, now time.Time) (bool, error) {
+	escalated := false
+	err := e.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		r, err := e.load(ctx, tx, instanceID)
+		if err != nil {
+			return err
+		}
+		if r.inst.Status != InstanceActive {
+			return nil
+		}
+
+		var task models.TaskInstance
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&task, "id = ?", taskID).Error; err != nil {
+			return err
+		}
+		if (task.Status != TaskCreated && task.Status != TaskAssigned) || task.SLAPolicyID == nil {
+			return nil
+		}
+
+		var levels []string
+		if task.SLAStatus == "" && task.SLAWarningAt != nil && !task.SLAWarningAt.After(now) {
+			levels = append(levels, EscalationWarning)
+			task.SLAStatus = SLAAtRisk
+		}
+		if task.SLAStatus != SLABreached && task.DueDate != nil && !task.DueDate.After(now) {
+			levels = append(levels, EscalationBreach)
+			task.SLAStatus = SLABreached
+		}
+		if len(levels) == 0 {
+			return nil
+		}
+		escalated = true
+
+		var policy models.SLAPolicy
+		err = tx.Where("id = ?", *task.SLAPolicyID).Limit(1).Find(&policy).Error
+		if err != nil {
+			return err
+		}
+		var actions []SLAAction
+		if policy.ID != uuid.Nil && policy.IsActive {
+			if actions, err = parseSLAActions(policy.Actions); err != nil {
+				return err
+			}
+		}
+		if err := r.escalate(&task, actions, levels); err != nil {
+			return err
+		}
+		if err := r.save(); err != nil {
+			return err
+		}
+		return r.drain()
+	})
+	return escalated, err
+}
+
+// escalate takes the actions of the given levels on a task. Escalation events
+// are thrown last, once the task is saved, as an interrupting one cancels it.
+func (r *run) escalate(task *models.TaskInstance, actions []SLAAction, levels []string) error {
+	var records []models.TaskEscalation
+	var throws []SLAAction
+	record := func(level, action, detail string) {
+		records = append(records, models.TaskEscalation{
+			TaskInstanceID:    task.ID,
+			ProcessInstanceID: task.ProcessInstanceID,
+			SLAPolicyID:       task.SLAPolicyID,
+			Level:             level,
+			Action:            action,
+			Detail:            detail,
+		})
+	}
+
+	for _, level := range levels {
+		for _, action := range actions {
+			if action.level() != level {
+				continue
+			}
+			switch action.Type {
+			case ActionRaisePriority:
+				priority := action.Priority
+				if priority == 0 {
+					priority = task.Priority + 20
+				}
+				if priority > maxPriority {
+					priority = maxPriority
+				}
+				if priority > task.Priority {
+					record(level, action.Type, fmt.Sprintf("priority raised from %d to %d", task.Priority, priority))
+					task.Priority = priority
+				}
+			case ActionReassignGroup:
+				err := r.record(EventTaskAssigned, &task.ID, map[string]interface{}{
+					"assignee_id":          nil,
+					"previous_assignee_id": task.AssigneeID,
+					"candidate_group":      action.Group,
+				})
+				if err != nil {
+					return err
+				}
+				assign(task, nil, nil)
+				task.CandidateGroup = action.Group
+				task.OwnerID = nil
+				task.DelegationState = ""
+				record(level, action.Type, "offered to group "+action.Group)
+			case ActionNotify:
+				recipients, err := r.notifyEscalation(task, level, action.Group)
+				if err != nil {
+					return err
+				}
+				record(level, action.Type, "notified "+strings.Join(recipients, ", "))
+			case ActionEscalation:
+				throws = append(throws, action)
+			}
+		}
+	}
+
+	if err := r.tx.Omit(clause.Associations).Save(task).Error; err != nil {
+		return err
+	}
+
+	for _, action := range throws {
+		detail, err := r.throwEscalation(task, action.EscalationCode)
+		if err != nil {
+			return err
+		}
+		record(action.level(), action.Type, detail)
+	}
+
+	if len(records) == 0 {
+		return nil
+	}
+	return r.tx.Create(&records).Error
+}
+
+// notifyEscalation notifies group, or the people working on the task when group
+// is empty, that the task is at risk or overdue. It returns who was notified.
+func (r *run) notifyEscalation(task *models.TaskInstance, level, group string) ([]string, error) {
+	kind, title := "sla-warning", fmt.Sprintf("Task %q is at risk of missing its due date", task.Name)
+	if level == EscalationBreach {
+		kind, title = "sla-breach", fmt.Sprintf("Task %q is overdue", task.Name)
+	}
+	message := ""
+	if task.DueDate != nil {
+		message = "Due " + task.DueDate.UTC().Format(time.RFC3339)
+	}
+
+	var notifications []models.Notification
+	var recipients []string
+	add := func(userID *uuid.UUID, group string) {
+		notifications = append(notifications, models.Notification{
+			UserID:     userID,
+			Group:      group,
+			Type:       kind,
+			Title:      title,
+			Message:    message,
+			Resource:   "task_instance",
+			ResourceID: &task.ID,
+		})
+		if userID != nil {
+			recipients = append(recipients, "user "+userID.String())
+		} else {
+			recipients = append(recipients, "group "+group)
+		}
+	}
+
+	switch {
+	case group != "":
+		add(nil, group)
+	case task.AssigneeID != nil:
+		add(task.AssigneeID, "")
+		if task.OwnerID != nil && *task.OwnerID != *task.AssigneeID {
+			add(task.OwnerID, "")
+		}
+	default:
+		for _, candidate := range strings.Split(task.CandidateGroup, ",") {
+			if candidate = strings.TrimSpace(candidate); candidate != "" {
+				add(nil, candidate)
+			}
+		}
+	}
+	if len(notifications) == 0 {
+		return []string{"nobody"}, nil
+	}
+	return recipients, r.tx.Create(&notifications).Error
+}
+
+// throwEscalation throws an escalation at the activity of a task. It is caught
+// by the innermost escalation boundary event whose code matches, or by one
+// without a code; an escalation nobody catches is only recorded.
+func (r *run) throwEscalation(task *models.TaskInstance, code string) (string, error) {
+	if task.ExecutionID == nil {
+		return fmt.Sprintf("escalation %s not thrown: task is not bound to an execution", code), nil
+	}
+	cur, err := r.execution(*task.ExecutionID)
+	if err != nil {
+		return "", err
+	}
+	if cur.Status != ExecutionWaiting {
+		return fmt.Sprintf("escalation %s not thrown: task has already left its activity", code), nil
+	}
+
+	for {
+		node, err := r.node(cur.ElementID)
+		if err != nil {
+			return "", err
+		}
+		if boundary := escalationBoundary(node, code); boundary != nil {
+			if err := r.fireBoundary(cur, boundary, nil); err != nil {
+				return "", err
+			}
+			return fmt.Sprintf("escalation %s caught by %s", code, boundary.ID), nil
+		}
+		if cur.ParentID == nil {
+			return fmt.Sprintf("escalation %s was not caught by any escalation boundary event", code), nil
+		}
+		if cur, err = r.execution(*cur.ParentID); err != nil {
+			return "", err
+		}
+	}
+}
+
+// escalationBoundary returns the escalation boundary event of node that catches the given code
+func escalationBoundary(node *bpmn.Node, code string) *bpmn.Node {
+	var catchAll *bpmn.Node
+	for _, b := range node.Boundaries {
+		if b.Event != bpmn.EscalationEvent {
+			continue
+		}
+		if b.EscalationCode == code {
+			return b
+		}
+		if b.EscalationCode == "" && catchAll == nil {
+			catchAll = b
+		}
+	}
+	return catchAll
+}
@@ -0,0 +1,221 @@
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+
+	"github.com/tvolodi/ai-bpms-backend/shared/bpmn"
+	"github.com/tvolodi/ai-bpms-backend/shared/common/models"
+	"github.com/tvolodi/ai-bpms-backend/shared/expression"
+)
+
+// callContext identifies the call activity execution a new instance reports back to
+type callContext struct {
+	run       *run
+	execution *models.Execution
+}
+
+// subProcess opens a scope for an embedded sub-process: the sub-process token
+// waits while a child token runs the nested flow from its start event.
+func (r *run) subProcess(ex *models.Execution, node *bpmn.Node) error {
+	if err := r.setStatus(ex, ExecutionWaiting); err != nil {
+		return err
+	}
+	start := node.SubProcess.NoneStartEvent()
+	if start == nil {
+		return fmt.Errorf("sub-process %s has no start event", node.ID)
+	}
+	_, err := r.spawn(&ex.ID, start)
+	return err
+}
+
+// leaveScope moves on the execution that owns a scope once no open tokens are
+// left in it: an embedded sub-process, or a throw event waiting for compensation
+// once its last compensation handler has finished
+func (r *run) leaveScope(scopeID uuid.UUID) error {
+	ex, err := r.execution(scopeID)
+	if err != nil {
+		return err
+	}
+	node, err := r.node(ex.ElementID)
+	if err != nil {
+		return err
+	}
+	if node.Event == bpmn.CompensateEvent {
+		started, err := r.nextCompensation(ex, node)
+		if err != nil || started {
+			return err
+		}
+	}
+	return r.leave(ex, node.Outgoing)
+}
+
+// callActivity starts an instance of the called process with the mapped input
+// variables. The call activity token waits until that instance completes.
+func (r *run) callActivity(ex *models.Execution, node *bpmn.Node) error {
+	def, defs, err := r.calledDefinition(node)
+	if err != nil {
+		return err
+	}
+	start := defs.ExecutableProcess().NoneStartEvent()
+	if start == nil {
+		return raise(IncidentCallActivityFailed, "call activity %s: process %s has no start event without an event definition", node.ID, def.Key)
+	}
+
+	vars, err := mapVariables(node.Inputs, r.vars)
+	if err != nil {
+		return raise(IncidentExpressionFailed, "call activity %s input: %v", node.ID, err)
+	}
+
+	if err := r.setStatus(ex, ExecutionWaiting); err != nil {
+		return err
+	}
+	_, err = r.e.start(r.ctx, r.tx, def, defs, start, StartOptions{
+		BusinessKey: r.inst.BusinessKey,
+		Variables:   vars,
+		StartedBy:   r.inst.StartedBy,
+	}, &callContext{run: r, execution: ex})
+	return err
+}
+
+// calledDefinition resolves the process definition a call activity refers to:
+// the given version of the key, or its latest active version.
+func (r *run) calledDefinition(node *bpmn.Node) (*models.ProcessDefinition, *bpmn.Definitions, error) {
+	var def models.ProcessDefinition
+	q := r.tx.Where("key = ? AND is_active = ?", node.CalledElement, true)
+	if node.CalledElementVersion > 0 {
+		q = q.Where("version = ?", node.CalledElementVersion)
+	}
+	err := q.Order("version DESC").First(&def).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		if node.CalledElementVersion > 0 {
+			return nil, nil, raise(IncidentCallActivityFailed, "call activity %s: no active process definition %s version %d", node.ID, node.CalledElement, node.CalledElementVersion)
+		}
+		return nil, nil, raise(IncidentCallActivityFailed, "call activity %s: no active process definition %s", node.ID, node.CalledElement)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	_, defs, err := r.e.definition(r.tx, def.ID)
+	if err != nil {
+		return nil, nil, raise(IncidentCallActivityFailed, "call activity %s: %v", node.ID, err)
+	}
+	return &def, defs, nil
+}
+
+// returnToCaller copies the output variables of a completed called instance into
+// its parent and moves the waiting call activity token on
+func (r *run) returnToCaller() error {
+	parent := r.caller
+	if parent == nil {
+		if r.inst.ParentInstanceID == nil {
+			return nil
+		}
+		loaded, err := r.e.load(r.ctx, r.tx, *r.inst.ParentInstanceID)
+		if err != nil {
+			return err
+		}
+		parent = loaded
+	}
+
+	ex, err := parent.execution(*r.inst.ParentExecutionID)
+	if err != nil {
+		return err
+	}
+	if parent.inst.Status != InstanceActive || ex.Status != ExecutionWaiting {
+		return nil
+	}
+	node, err := parent.node(ex.ElementID)
+	if err != nil {
+		return err
+	}
+
+	out, err := mapVariables(node.Outputs, r.vars)
+	if err != nil {
+		return raise(IncidentExpressionFailed, "call activity %s output: %v", node.ID, err)
+	}
+	for k, v := range out {
+		parent.vars[k] = v
+	}
+	if err := parent.leave(ex, node.Outgoing); err != nil {
+		return err
+	}
+
+	// A caller run in the same transition drains its own agenda
+	if r.caller != nil {
+		return nil
+	}
+	return parent.drain()
+}
+
+// terminateCalled terminates the instances started by a call activity execution
+func (r *run) terminateCalled(ex *models.Execution) error {
+	var ids []uuid.UUID
+	err := r.tx.Model(&models.ProcessInstance{}).
+		Where("parent_execution_id = ? AND status IN ?", ex.ID, []string{InstanceActive, InstanceSuspended}).
+		Pluck("id", &ids).Error
+	if err != nil {
+		return err
+	}
+	for _, id := range ids {
+		child, err := r.e.load(r.ctx, r.tx, id)
+		if err != nil {
+			return err
+		}
+		if err := child.terminate(); err != nil {
+			return err
+		}
+		if err := child.save(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// terminate cancels every open token of the instance, including those of
+// instances it called, and marks it terminated
+func (r *run) terminate() error {
+	var open []models.Execution
+	if err := r.scope(nil).Where("status IN ?", openStatuses).Find(&open).Error; err != nil {
+		return err
+	}
+	for i := range open {
+		if err := r.cancelActivity(&open[i]); err != nil {
+			return err
+		}
+	}
+
+	now := time.Now().UTC()
+	duration := now.Sub(r.inst.StartedAt).Milliseconds()
+	r.inst.Status = InstanceTerminated
+	r.inst.EndedAt = &now
+	r.inst.Duration = &duration
+	return nil
+}
+
+// mapVariables builds the variables of another scope from explicit mappings
+func mapVariables(mappings []bpmn.VariableMapping, from map[string]interface{}) (map[string]interface{}, error) {
+	out := make(map[string]interface{})
+	for _, m := range mappings {
+		switch {
+		case m.All:
+			for k, v := range from {
+				out[k] = v
+			}
+		case m.SourceExpression != "":
+			v, err := expression.Evaluate(m.SourceExpression, from)
+			if err != nil {
+				return nil, err
+			}
+			out[m.Target] = v
+		default:
+			out[m.Target] = from[m.Source]
+		}
+	}
+	return out, nil
+}
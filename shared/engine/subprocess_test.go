@@ -0,0 +1,75 @@
+package engine
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/tvolodi/ai-bpms-backend/shared/bpmn"
+)
+
+func TestMapVariables(t *testing.T) {
+	from := map[string]interface{}{"amount": 150, "customer": "ACME", "region": "EU"}
+	tests := []struct {
+		name     string
+		mappings []bpmn.VariableMapping
+		want     map[string]interface{}
+		wantErr  string
+	}{
+		{
+			name: "no mappings",
+			want: map[string]interface{}{},
+		},
+		{
+			name:     "source to target",
+			mappings: []bpmn.VariableMapping{{Source: "customer", Target: "client"}},
+			want:     map[string]interface{}{"client": "ACME"},
+		},
+		{
+			name:     "missing source",
+			mappings: []bpmn.VariableMapping{{Source: "discount", Target: "discount"}},
+			want:     map[string]interface{}{"discount": nil},
+		},
+		{
+			name:     "source expression",
+			mappings: []bpmn.VariableMapping{{SourceExpression: "${amount * 2}", Target: "doubled"}},
+			want:     map[string]interface{}{"doubled": 300},
+		},
+		{
+			name:     "all variables",
+			mappings: []bpmn.VariableMapping{{All: true}},
+			want:     map[string]interface{}{"amount": 150, "customer": "ACME", "region": "EU"},
+		},
+		{
+			name: "later mappings override all variables",
+			mappings: []bpmn.VariableMapping{
+				{All: true},
+				{Source: "region", Target: "customer"},
+			},
+			want: map[string]interface{}{"amount": 150, "customer": "EU", "region": "EU"},
+		},
+		{
+			name:     "failing expression",
+			mappings: []bpmn.VariableMapping{{SourceExpression: "${customer / 2}", Target: "half"}},
+			wantErr:  "failed to evaluate",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := mapVariables(tt.mappings, from)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("got %v, want an error containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
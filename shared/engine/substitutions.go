@@ -0,0 +1,107 @@
+package engine
+
+import (
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+
+	"github.com/tvolodi/ai-bpms-backend/shared/common/models"
+)
+
+// maxSubstitutions bounds a chain of substitutes who are away themselves
+const maxSubstitutions = 5
+
+// substitute routes a task assigned to an absent user to the substitute named
+// in their out-of-office rule, following substitutes who are away as well. A
+// rule for the category of the process wins over a rule for every process.
+// The original assignee is kept on the task; the rules applied are returned.
+func substitute(tx *gorm.DB, task *models.TaskInstance, now time.Time) ([]models.Substitution, error) {
+	if task.AssigneeID == nil {
+		return nil, nil
+	}
+
+	var category *string
+	seen := map[uuid.UUID]bool{*task.AssigneeID: true}
+	var applied []models.Substitution
+	for len(applied) < maxSubstitutions {
+		var rules []models.Substitution
+		err := tx.Where("user_id = ? AND starts_at <= ? AND ends_at > ?", *task.AssigneeID, now, now).
+			Order("starts_at DESC").
+			Find(&rules).Error
+		if err != nil {
+			return nil, err
+		}
+		if len(rules) == 0 {
+			break
+		}
+		if category == nil {
+			var c string
+			err := tx.Table("process_instances").
+				Joins("JOIN process_definitions ON process_definitions.id = process_instances.process_definition_id").
+				Where("process_instances.id = ?", task.ProcessInstanceID).
+				Pluck("process_definitions.category", &c).Error
+			if err != nil {
+				return nil, err
+			}
+			category = &c
+		}
+
+		rule := matchSubstitution(rules, *category)
+		if rule == nil || seen[rule.SubstituteID] {
+			break
+		}
+		var active int64
+		err = tx.Model(&models.User{}).Where("id = ? AND is_active", rule.SubstituteID).Count(&active).Error
+		if err != nil {
+			return nil, err
+		}
+		if active == 0 {
+			break
+		}
+
+		seen[rule.SubstituteID] = true
+		if task.OriginalAssigneeID == nil {
+			task.OriginalAssigneeID = task.AssigneeID
+		}
+		substituteID := rule.SubstituteID
+		task.AssigneeID = &substituteID
+		task.SubstitutionID = &rule.ID
+		applied = append(applied, *rule)
+	}
+	return applied, nil
+}
+
+// matchSubstitution picks the rule for the category from the rules in effect,
+// falling back to a rule without category
+func matchSubstitution(rules []models.Substitution, category string) *models.Substitution {
+	var general *models.Substitution
+	for i := range rules {
+		switch {
+		case rules[i].Category == "":
+			if general == nil {
+				general = &rules[i]
+			}
+		case category != "" && strings.EqualFold(rules[i].Category, category):
+			return &rules[i]
+		}
+	}
+	return general
+}
+
+// recordSubstitutions records in the instance history how out-of-office rules
+// routed a task
+func recordSubstitutions(tx *gorm.DB, task *models.TaskInstance, applied []models.Substitution, actor *uuid.UUID) error {
+	for _, rule := range applied {
+		err := recordEvent(tx, task.ProcessInstanceID, &task.ID, EventTaskSubstituted, actor, map[string]interface{}{
+			"substitution_id": rule.ID,
+			"user_id":         rule.UserID,
+			"substitute_id":   rule.SubstituteID,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,303 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"github.com/tvolodi/ai-bpms-backend/shared/common/models"
+	"github.com/tvolodi/ai-bpms-backend/shared/forms"
+)
+
+// Delegation states of a user task
+const (
+	DelegationPending  = "pending"
+	DelegationResolved = "resolved"
+)
+
+// Task assignment errors
+var (
+	ErrTaskClaimed     = errors.New("task is already claimed")
+	ErrNotCandidate    = errors.New("user is not a candidate for the task")
+	ErrNotAssignee     = errors.New("task is not assigned to the user")
+	ErrTaskDelegated   = errors.New("task is delegated and must be resolved first")
+	ErrTaskNotDelegate = errors.New("task is not delegated")
+	ErrInvalidPriority = fmt.Errorf("task priority must be between 0 and %d", maxPriority)
+)
+
+// ClaimTask assigns an unassigned task to a user in one of its candidate groups.
+// The task row is locked, so of two concurrent claims only the first succeeds.
+func (e *Engine) ClaimTask(ctx context.Context, taskID, userID uuid.UUID, groups []string) (*models.TaskInstance, error) {
+	return e.updateTask(ctx, taskID, EventTaskClaimed, &userID, func(task *models.TaskInstance) error {
+		if task.Status != TaskCreated || task.AssigneeID != nil {
+			return ErrTaskClaimed
+		}
+		if !isCandidate(task.CandidateGroup, groups) {
+			return ErrNotCandidate
+		}
+		assign(task, &userID, &userID)
+		return nil
+	})
+}
+
+// UnclaimTask releases a task claimed by the user back to its candidate groups
+func (e *Engine) UnclaimTask(ctx context.Context, taskID, userID uuid.UUID) (*models.TaskInstance, error) {
+	return e.updateTask(ctx, taskID, EventTaskUnclaimed, &userID, func(task *models.TaskInstance) error {
+		if task.AssigneeID == nil || *task.AssigneeID != userID {
+			return ErrNotAssignee
+		}
+		if task.DelegationState == DelegationPending {
+			return ErrTaskDelegated
+		}
+		assign(task, nil, &userID)
+		return nil
+	})
+}
+
+// DelegateTask hands a task assigned to the user over to another user. The user
+// stays its owner and gets it back when the delegate resolves it.
+func (e *Engine) DelegateTask(ctx context.Context, taskID, userID, delegateID uuid.UUID) (*models.TaskInstance, error) {
+	return e.updateTask(ctx, taskID, EventTaskDelegated, &userID, func(task *models.TaskInstance) error {
+		if task.AssigneeID == nil || *task.AssigneeID != userID {
+			return ErrNotAssignee
+		}
+		if task.DelegationState == DelegationPending {
+			return ErrTaskDelegated
+		}
+		assign(task, &delegateID, &userID)
+		task.OwnerID = &userID
+		task.DelegationState = DelegationPending
+		return nil
+	})
+}
+
+// ResolveTask returns a delegated task from its delegate to its owner
+func (e *Engine) ResolveTask(ctx context.Context, taskID, userID uuid.UUID) (*models.TaskInstance, error) {
+	return e.updateTask(ctx, taskID, EventTaskResolved, &userID, func(task *models.TaskInstance) error {
+		if task.DelegationState != DelegationPending {
+			return ErrTaskNotDelegate
+		}
+		if task.AssigneeID == nil || *task.AssigneeID != userID {
+			return ErrNotAssignee
+		}
+		assign(task, task.OwnerID, &userID)
+		task.DelegationState = DelegationResolved
+		return nil
+	})
+}
+
+// ReassignTask assigns an open task to another user, or back to its candidate
+// groups when assigneeID is nil, whoever holds it. A pending delegation is dropped.
+func (e *Engine) ReassignTask(ctx context.Context, taskID uuid.UUID, assigneeID, assignedBy *uuid.UUID) (*models.TaskInstance, error) {
+	return e.updateTask(ctx, taskID, EventTaskAssigned, assignedBy, func(task *models.TaskInstance) error {
+		assign(task, assigneeID, assignedBy)
+		if task.DelegationState == DelegationPending {
+			task.OwnerID = nil
+			task.DelegationState = ""
+		}
+		return nil
+	})
+}
+
+// SetTaskPriority changes the priority of an open task
+func (e *Engine) SetTaskPriority(ctx context.Context, taskID uuid.UUID, priority int, changedBy *uuid.UUID) (*models.TaskInstance, error) {
+	if priority < 0 || priority > maxPriority {
+		return nil, ErrInvalidPriority
+	}
+	return e.updateTask(ctx, taskID, EventTaskPriorityChanged, changedBy, func(task *models.TaskInstance) error {
+		task.Priority = priority
+		return nil
+	})
+}
+
+// updateTask locks an open task, applies change to it, saves it and records the
+// change of assignee and priority in the instance history. A task handed to an
+// absent user goes to their substitute.
+func (e *Engine) updateTask(ctx context.Context, taskID uuid.UUID, eventType string, actor *uuid.UUID, change func(task *models.TaskInstance) error) (*models.TaskInstance, error) {
+	var task models.TaskInstance
+	err := e.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&task, "id = ?", taskID).Error
+		if err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return ErrTaskNotFound
+			}
+			return err
+		}
+		if task.Status != TaskCreated && task.Status != TaskAssigned {
+			return ErrTaskNotActive
+		}
+		previous, previousPriority := task.AssigneeID, task.Priority
+		if err := change(&task); err != nil {
+			return err
+		}
+		var applied []models.Substitution
+		if handedOver(eventType, task.AssigneeID, actor) {
+			var err error
+			if applied, err = substitute(tx, &task, time.Now().UTC()); err != nil {
+				return err
+			}
+		}
+		if err := tx.Omit(clause.Associations).Save(&task).Error; err != nil {
+			return err
+		}
+		detail := map[string]interface{}{
+			"assignee_id":          task.AssigneeID,
+			"previous_assignee_id": previous,
+		}
+		if task.OriginalAssigneeID != nil {
+			detail["original_assignee_id"] = task.OriginalAssigneeID
+		}
+		if task.Priority != previousPriority {
+			detail["priority"] = task.Priority
+			detail["previous_priority"] = previousPriority
+		}
+		if err := recordEvent(tx, task.ProcessInstanceID, &task.ID, eventType, actor, detail); err != nil {
+			return err
+		}
+		return recordSubstitutions(tx, &task, applied, actor)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &task, nil
+}
+
+// handedOver reports whether a task update gives the task to a user other than
+// the one making it, so out-of-office rules apply to it
+func handedOver(eventType string, assignee, actor *uuid.UUID) bool {
+	switch eventType {
+	case EventTaskAssigned, EventTaskDelegated, EventTaskResolved:
+		return assignee != nil && (actor == nil || *actor != *assignee)
+	}
+	return false
+}
+
+// assign sets the assignee of a task and the matching status; a nil assignee
+// returns the task to its candidate groups
+func assign(task *models.TaskInstance, assigneeID, assignedBy *uuid.UUID) {
+	task.AssigneeID = assigneeID
+	task.AssignedBy = assignedBy
+	task.OriginalAssigneeID = nil
+	task.SubstitutionID = nil
+	if assigneeID == nil {
+		task.Status = TaskCreated
+		task.AssignedAt = nil
+		return
+	}
+	now := time.Now().UTC()
+	task.Status = TaskAssigned
+	task.AssignedAt = &now
+}
+
+// isCandidate reports whether one of the groups is listed in a comma-separated candidate group
+func isCandidate(candidateGroup string, groups []string) bool {
+	for _, candidate := range strings.Split(candidateGroup, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "" {
+			continue
+		}
+		for _, group := range groups {
+			if group == candidate {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// formOutputs validates the form data of a task against the form schema it is
+// bound to and returns the values that become process variables. Tasks without
+// a form pass all of their data on.
+func formOutputs(tx *gorm.DB, task *models.TaskInstance, data map[string]interface{}) (map[string]interface{}, error) {
+	if task.FormKey == "" {
+		return data, nil
+	}
+
+	form, err := taskForm(tx, task)
+	if err != nil {
+		return nil, err
+	}
+	refs, err := decodeFormRefs(task.FormRefs)
+	if err != nil {
+		return nil, err
+	}
+	schema, err := compileForm(tx, form, refs)
+	if err != nil {
+		return nil, err
+	}
+	if data == nil {
+		data = map[string]interface{}{}
+	}
+	if err := schema.Validate(data); err != nil {
+		return nil, err
+	}
+	if err := attachFiles(tx, task, schema.Files(data)); err != nil {
+		return nil, err
+	}
+	return schema.Outputs(data), nil
+}
+
+// attachFiles checks the attachments referenced by the file fields of a form
+// against the limits of their fields and binds them to the task. Attachments
+// must belong to the task's process instance and not to another task.
+func attachFiles(tx *gorm.DB, task *models.TaskInstance, refs []forms.FileRef) error {
+	var errs forms.ValidationErrors
+	for _, ref := range refs {
+		fail := func(message string) {
+			errs = append(errs, forms.FieldError{Pointer: ref.Pointer, Message: message})
+		}
+
+		var attachment models.Attachment
+		err := tx.Where("id = ? AND process_instance_id = ?", ref.ID, task.ProcessInstanceID).Limit(1).Find(&attachment).Error
+		if err != nil {
+			return err
+		}
+		switch {
+		case attachment.ID == uuid.Nil:
+			fail("refers to an unknown attachment")
+			continue
+		case attachment.TaskInstanceID != nil && *attachment.TaskInstanceID != task.ID:
+			fail("refers to an attachment of another task")
+			continue
+		case len(ref.Accept) > 0 && !acceptsType(ref.Accept, attachment.ContentType):
+			fail("must be a file of type " + strings.Join(ref.Accept, " or "))
+			continue
+		case ref.MaxSize > 0 && attachment.Size > ref.MaxSize:
+			fail(fmt.Sprintf("must be a file of at most %d bytes", ref.MaxSize))
+			continue
+		}
+
+		err = tx.Model(&attachment).Updates(map[string]interface{}{
+			"task_instance_id": task.ID,
+			"form_field":       ref.Pointer,
+		}).Error
+		if err != nil {
+			return err
+		}
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// acceptsType reports whether a content type matches one of the accepted
+// types, which may end in a "/*" wildcard
+func acceptsType(accept []string, contentType string) bool {
+	for _, t := range accept {
+		if prefix, ok := strings.CutSuffix(t, "/*"); ok {
+			if strings.HasPrefix(contentType, prefix+"/") {
+				return true
+			}
+		} else if strings.EqualFold(t, contentType) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,216 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/google/uuid"
+
+	"github.com/tvolodi/ai-bpms-backend/shared/common/models"
+)
+
+func TestIsCandidate(t *testing.T) {
+	tests := []struct {
+		name           string
+		candidateGroup string
+		groups         []string
+		want           bool
+	}{
+		{name: "single group", candidateGroup: "managers", groups: []string{"managers"}, want: true},
+		{name: "one of several groups", candidateGroup: "managers, finance", groups: []string{"sales", "finance"}, want: true},
+		{name: "no common group", candidateGroup: "managers,finance", groups: []string{"sales"}},
+		{name: "no groups", candidateGroup: "managers"},
+		{name: "no candidate group", candidateGroup: "", groups: []string{"managers"}},
+		{name: "empty entries are ignored", candidateGroup: "managers,,", groups: []string{""}},
+		{name: "case sensitive", candidateGroup: "Managers", groups: []string{"managers"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isCandidate(tt.candidateGroup, tt.groups); got != tt.want {
+				t.Errorf("isCandidate(%q, %q) = %v, want %v", tt.candidateGroup, tt.groups, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestClaimTask(t *testing.T) {
+	db := testDB(t)
+	e := New(db)
+	def := deploy(t, db, reviewProcess)
+	ctx := context.Background()
+
+	t.Run("not a candidate", func(t *testing.T) {
+		task := openTask(t, e, def)
+		_, err := e.ClaimTask(ctx, task.ID, newUser(t, db), []string{"sales"})
+		if !errors.Is(err, ErrNotCandidate) {
+			t.Errorf("got %v, want %v", err, ErrNotCandidate)
+		}
+	})
+
+	t.Run("already claimed", func(t *testing.T) {
+		task := openTask(t, e, def)
+		if _, err := e.ClaimTask(ctx, task.ID, newUser(t, db), []string{"reviewers"}); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		_, err := e.ClaimTask(ctx, task.ID, newUser(t, db), []string{"reviewers"})
+		if !errors.Is(err, ErrTaskClaimed) {
+			t.Errorf("got %v, want %v", err, ErrTaskClaimed)
+		}
+	})
+
+	t.Run("concurrent claims", func(t *testing.T) {
+		task := openTask(t, e, def)
+		users := []uuid.UUID{newUser(t, db), newUser(t, db), newUser(t, db), newUser(t, db)}
+		errs := make([]error, len(users))
+		var wg sync.WaitGroup
+		for i, user := range users {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				_, errs[i] = e.ClaimTask(ctx, task.ID, user, []string{"reviewers"})
+			}()
+		}
+		wg.Wait()
+
+		claimed := 0
+		for _, err := range errs {
+			switch {
+			case err == nil:
+				claimed++
+			case !errors.Is(err, ErrTaskClaimed):
+				t.Errorf("unexpected error: %v", err)
+			}
+		}
+		if claimed != 1 {
+			t.Errorf("%d claims succeeded, want 1", claimed)
+		}
+	})
+}
+
+func TestCompleteTask(t *testing.T) {
+	db := testDB(t)
+	e := New(db)
+	def := deploy(t, db, reviewProcess)
+	ctx := context.Background()
+	reviewers := []string{"reviewers"}
+
+	t.Run("not a candidate", func(t *testing.T) {
+		task := openTask(t, e, def)
+		user := newUser(t, db)
+		_, err := e.CompleteTask(ctx, task.ID, CompleteOptions{CompletedBy: &user, Groups: []string{"sales"}})
+		if !errors.Is(err, ErrNotCandidate) {
+			t.Errorf("got %v, want %v", err, ErrNotCandidate)
+		}
+	})
+
+	t.Run("assigned to another user", func(t *testing.T) {
+		task := openTask(t, e, def)
+		if _, err := e.ClaimTask(ctx, task.ID, newUser(t, db), reviewers); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		user := newUser(t, db)
+		_, err := e.CompleteTask(ctx, task.ID, CompleteOptions{CompletedBy: &user, Groups: reviewers})
+		if !errors.Is(err, ErrNotAssignee) {
+			t.Errorf("got %v, want %v", err, ErrNotAssignee)
+		}
+	})
+
+	t.Run("anonymous", func(t *testing.T) {
+		task := openTask(t, e, def)
+		_, err := e.CompleteTask(ctx, task.ID, CompleteOptions{Groups: reviewers})
+		if !errors.Is(err, ErrNotAssignee) {
+			t.Errorf("got %v, want %v", err, ErrNotAssignee)
+		}
+	})
+
+	t.Run("concurrent completions", func(t *testing.T) {
+		task := openTask(t, e, def)
+		users := []uuid.UUID{newUser(t, db), newUser(t, db), newUser(t, db)}
+		errs := make([]error, len(users))
+		var wg sync.WaitGroup
+		for i, user := range users {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				_, errs[i] = e.CompleteTask(ctx, task.ID, CompleteOptions{CompletedBy: &user, Groups: reviewers})
+			}()
+		}
+		wg.Wait()
+
+		completed := 0
+		for _, err := range errs {
+			switch {
+			case err == nil:
+				completed++
+			case !errors.Is(err, ErrTaskCompleted):
+				t.Errorf("unexpected error: %v", err)
+			}
+		}
+		if completed != 1 {
+			t.Errorf("%d completions succeeded, want 1", completed)
+		}
+
+		var history []models.HistoryEvent
+		err := db.Where("task_instance_id = ? AND type = ?", task.ID, EventTaskCompleted).Find(&history).Error
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if len(history) != 1 {
+			t.Errorf("task completed %d times in the history, want once", len(history))
+		}
+	})
+}
+
+func TestAcceptsType(t *testing.T) {
+	tests := []struct {
+		accept      []string
+		contentType string
+		want        bool
+	}{
+		{[]string{"application/pdf"}, "application/pdf", true},
+		{[]string{"application/pdf"}, "Application/PDF", true},
+		{[]string{"image/*"}, "image/png", true},
+		{[]string{"image/*"}, "imagex/png", false},
+		{[]string{"image/*"}, "image", false},
+		{[]string{"application/pdf", "image/*"}, "image/jpeg", true},
+		{[]string{"application/pdf"}, "text/plain", false},
+		{nil, "text/plain", false},
+	}
+
+	for _, tt := range tests {
+		if got := acceptsType(tt.accept, tt.contentType); got != tt.want {
+			t.Errorf("acceptsType(%q, %q) = %v, want %v", tt.accept, tt.contentType, got, tt.want)
+		}
+	}
+}
+
+func TestHandedOver(t *testing.T) {
+	alice, bob := uuid.New(), uuid.New()
+	tests := []struct {
+		name      string
+		eventType string
+		assignee  *uuid.UUID
+		actor     *uuid.UUID
+		want      bool
+	}{
+		{name: "assigned to another user", eventType: EventTaskAssigned, assignee: &bob, actor: &alice, want: true},
+		{name: "assigned by the system", eventType: EventTaskAssigned, assignee: &bob, want: true},
+		{name: "delegated", eventType: EventTaskDelegated, assignee: &bob, actor: &alice, want: true},
+		{name: "resolved back to the owner", eventType: EventTaskResolved, assignee: &alice, actor: &bob, want: true},
+		{name: "assigned to oneself", eventType: EventTaskAssigned, assignee: &alice, actor: &alice},
+		{name: "returned to the candidate groups", eventType: EventTaskAssigned, actor: &alice},
+		{name: "claimed", eventType: EventTaskClaimed, assignee: &alice, actor: &alice},
+		{name: "priority changed", eventType: EventTaskPriorityChanged, assignee: &bob, actor: &alice},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := handedOver(tt.eventType, tt.assignee, tt.actor); got != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,177 @@
+package engine
+
+import (
+	"fmt"
+
+	"github.com/tvolodi/ai-bpms-backend/shared/bpmn"
+	"github.com/tvolodi/ai-bpms-backend/shared/common/models"
+)
+
+// BPMNError is returned by a ServiceHandler to throw a business error. It is
+// caught by the innermost error boundary event whose error code matches, or by
+// one without an error code; any other handler error raises an incident.
+type BPMNError struct {
+	Code    string
+	Message string
+}
+
+func (e *BPMNError) Error() string {
+	if e.Message == "" {
+		return "BPMN error " + e.Code
+	}
+	return fmt.Sprintf("BPMN error %s: %s", e.Code, e.Message)
+}
+
+// throwError propagates a BPMN error from ex outwards through the enclosing
+// sub-processes and calling instances until an error boundary event catches it.
+// An error nobody catches becomes an incident on the throwing element.
+func (r *run) throwError(ex *models.Execution, code, message string) error {
+	cur := ex
+	for {
+		node, err := r.node(cur.ElementID)
+		if err != nil {
+			return err
+		}
+		if boundary := errorBoundary(node, code); boundary != nil {
+			if boundary.ErrorCodeVariable != "" {
+				r.vars[boundary.ErrorCodeVariable] = code
+			}
+			if boundary.ErrorMessageVariable != "" {
+				r.vars[boundary.ErrorMessageVariable] = message
+			}
+			return r.fireBoundary(cur, boundary, nil)
+		}
+		if cur.ParentID == nil {
+			break
+		}
+		if cur, err = r.execution(*cur.ParentID); err != nil {
+			return err
+		}
+	}
+
+	if r.inst.ParentExecutionID != nil {
+		return r.throwToCaller(code, message)
+	}
+	if message == "" {
+		return raise(IncidentUnhandledError, "error %s was not caught by any error boundary event", code)
+	}
+	return raise(IncidentUnhandledError, "error %s was not caught by any error boundary event: %s", code, message)
+}
+
+// errorBoundary returns the error boundary event of node that catches the given code
+func errorBoundary(node *bpmn.Node, code string) *bpmn.Node {
+	var catchAll *bpmn.Node
+	for _, b := range node.Boundaries {
+		if b.Event != bpmn.ErrorEvent {
+			continue
+		}
+		if b.ErrorCode == code {
+			return b
+		}
+		if b.ErrorCode == "" && catchAll == nil {
+			catchAll = b
+		}
+	}
+	return catchAll
+}
+
+// throwToCaller terminates an instance started by a call activity and rethrows
+// an uncaught error at that call activity in the parent instance
+func (r *run) throwToCaller(code, message string) error {
+	parent := r.caller
+	if parent == nil {
+		loaded, err := r.e.load(r.ctx, r.tx, *r.inst.ParentInstanceID)
+		if err != nil {
+			return err
+		}
+		parent = loaded
+	}
+	ex, err := parent.execution(*r.inst.ParentExecutionID)
+	if err != nil {
+		return err
+	}
+	if parent.inst.Status != InstanceActive || ex.Status != ExecutionWaiting {
+		return raise(IncidentUnhandledError, "error %s was not caught: the calling instance is no longer waiting", code)
+	}
+
+	// Terminate first, so that the caller does not find this instance still running
+	if err := r.terminate(); err != nil {
+		return err
+	}
+	if err := r.save(); err != nil {
+		return err
+	}
+	if err := parent.throwError(ex, code, message); err != nil {
+		return err
+	}
+
+	// A caller run in the same transition drains its own agenda
+	if r.caller != nil {
+		return nil
+	}
+	return parent.drain()
+}
+
+// compensate runs the compensation handlers of the completed activities in the
+// scope of a compensation throw event, or only those of its activityRef. The
+// handlers run one after another, most recently completed activity first, and
+// the event waits until the last one has finished.
+func (r *run) compensate(ex *models.Execution, node *bpmn.Node) error {
+	if err := r.setStatus(ex, ExecutionWaiting); err != nil {
+		return err
+	}
+	started, err := r.nextCompensation(ex, node)
+	if err != nil || started {
+		return err
+	}
+
+	// Nothing to compensate
+	ex.Status = ExecutionActive
+	if node.Type == bpmn.EndEvent {
+		return r.end(ex)
+	}
+	return r.leave(ex, node.Outgoing)
+}
+
+// nextCompensation starts the handler of the most recently completed activity
+// that a compensation throw event has yet to compensate. Only activities that
+// had completed when the event was reached are compensated. It reports false
+// once no such activity is left.
+func (r *run) nextCompensation(ex *models.Execution, node *bpmn.Node) (bool, error) {
+	q := r.scope(ex.ParentID).Where("status = ? AND ended_at <= ?", ExecutionCompleted, ex.StartedAt)
+	if node.ActivityRef != "" {
+		q = q.Where("element_id = ?", node.ActivityRef)
+	}
+	var done []models.Execution
+	if err := q.Order("ended_at DESC").Find(&done).Error; err != nil {
+		return false, err
+	}
+
+	for i := range done {
+		handler := compensationHandler(r.defs.Node(done[i].ElementID))
+		if handler == nil {
+			continue
+		}
+		if err := r.setStatus(&done[i], ExecutionCompensated); err != nil {
+			return false, err
+		}
+		if _, err := r.spawn(&ex.ID, handler); err != nil {
+			return false, err
+		}
+		return true, nil
+	}
+	return false, nil
+}
+
+// compensationHandler returns the handler associated with the compensation boundary event of node
+func compensationHandler(node *bpmn.Node) *bpmn.Node {
+	if node == nil {
+		return nil
+	}
+	for _, b := range node.Boundaries {
+		if b.Event == bpmn.CompensateEvent {
+			return b.CompensationHandler
+		}
+	}
+	return nil
+}
@@ -0,0 +1,392 @@
+package engine
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+
+	"github.com/tvolodi/ai-bpms-backend/shared/bpmn"
+	"github.com/tvolodi/ai-bpms-backend/shared/calendar"
+	"github.com/tvolodi/ai-bpms-backend/shared/common/models"
+	"github.com/tvolodi/ai-bpms-backend/shared/expression"
+	"github.com/tvolodi/ai-bpms-backend/shared/timer"
+)
+
+// Job types
+const (
+	JobTimerStart    = "timer-start"
+	JobTimerCatch    = "timer-catch"
+	JobTimerBoundary = "timer-boundary"
+	JobServiceRetry  = "service-retry"
+)
+
+// Job statuses
+const (
+	JobPending   = "pending"
+	JobCompleted = "completed"
+	JobCancelled = "cancelled"
+	JobFailed    = "failed"
+)
+
+// scheduleStartTimers replaces the pending timer start jobs of a process
+// definition. Definitions that cannot be started have their timers removed.
+func (e *Engine) scheduleStartTimers(tx *gorm.DB, def *models.ProcessDefinition, startable bool) error {
+	err := tx.Model(&models.Job{}).
+		Where("process_definition_id = ? AND type = ? AND status = ?", def.ID, JobTimerStart, JobPending).
+		Update("status", JobCancelled).Error
+	if err != nil {
+		return err
+	}
+	if !startable {
+		return nil
+	}
+
+	defs, err := bpmn.ParseString(def.BPMN)
+	if err != nil {
+		return err
+	}
+	now := time.Now().UTC()
+	for _, start := range defs.ExecutableProcess().StartEvents() {
+		if start.Timer == nil {
+			continue
+		}
+		cal, err := resolveCalendar(tx, start.Timer.Calendar, def.ID, nil)
+		if err != nil {
+			return fmt.Errorf("start event %s: %w", start.ID, err)
+		}
+		due, repeat, err := timerDue(start.Timer, nil, now, cal)
+		if err != nil {
+			return fmt.Errorf("start event %s: %w", start.ID, err)
+		}
+		job := models.Job{
+			Type:                JobTimerStart,
+			Status:              JobPending,
+			DueAt:               due,
+			ProcessDefinitionID: &def.ID,
+			ElementID:           start.ID,
+			Repeat:              repeat,
+		}
+		if err := tx.Create(&job).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ExecuteJob runs a due job inside the caller's transaction. Jobs whose
+// target has moved on in the meantime are treated as no-ops; jobs of a
+// suspended instance are parked until it is resumed.
+func (e *Engine) ExecuteJob(ctx context.Context, tx *gorm.DB, job *models.Job) error {
+	switch job.Type {
+	case JobTimerStart:
+		return e.fireStartTimer(ctx, tx, job)
+	case JobTimerCatch, JobTimerBoundary, JobServiceRetry:
+		if job.ProcessInstanceID == nil || job.ExecutionID == nil {
+			return fmt.Errorf("job %s has no execution", job.ID)
+		}
+		r, err := e.load(ctx, tx, *job.ProcessInstanceID)
+		if err != nil {
+			return err
+		}
+		if r.inst.Status == InstanceSuspended {
+			// Picked up while the instance was being suspended
+			job.Status = JobSuspended
+			return nil
+		}
+		if r.inst.Status != InstanceActive {
+			return nil
+		}
+		ex, err := r.execution(*job.ExecutionID)
+		if err != nil {
+			return err
+		}
+		if ex.Status != ExecutionWaiting {
+			return nil
+		}
+		node, err := r.node(job.ElementID)
+		if err != nil {
+			return err
+		}
+
+		switch job.Type {
+		case JobTimerCatch:
+			err = r.leave(ex, node.Outgoing)
+		case JobTimerBoundary:
+			err = r.fireBoundary(ex, node, job)
+		default:
+			err = r.step(ex, func() error {
+				if err := r.setStatus(ex, ExecutionActive); err != nil {
+					return err
+				}
+				return r.serviceTask(ex, node, job.Retries)
+			})
+		}
+		if err != nil {
+			return err
+		}
+		return r.drain()
+	default:
+		return fmt.Errorf("unknown job type %q", job.Type)
+	}
+}
+
+// fireStartTimer starts a new instance at a timer start event and schedules the next occurrence
+func (e *Engine) fireStartTimer(ctx context.Context, tx *gorm.DB, job *models.Job) error {
+	if job.ProcessDefinitionID == nil {
+		return fmt.Errorf("job %s has no process definition", job.ID)
+	}
+	def, defs, err := e.definition(tx, *job.ProcessDefinitionID)
+	if err != nil {
+		return err
+	}
+	if !def.IsActive {
+		return nil
+	}
+	start := defs.Node(job.ElementID)
+	if start == nil {
+		return fmt.Errorf("start event %s not found", job.ElementID)
+	}
+
+	if err := rescheduleCycle(tx, job); err != nil {
+		return err
+	}
+	_, err = e.start(ctx, tx, def, defs, start, StartOptions{}, nil)
+	return err
+}
+
+// rescheduleCycle creates the job for the next occurrence of a recurring timer.
+// The occurrence follows the one that was due rather than the moment the job
+// ran, so a late scheduler does not shift the cycle.
+func rescheduleCycle(tx *gorm.DB, job *models.Job) error {
+	if job.Repeat == "" {
+		return nil
+	}
+	cycle, err := timer.ParseCycle(job.Repeat)
+	if err != nil {
+		return err
+	}
+	remaining, err := timer.ParseCycle(cycle.Fired())
+	if err != nil {
+		return err
+	}
+	if remaining.Done() {
+		return nil
+	}
+
+	next := *job
+	next.ID = uuid.Nil
+	next.CreatedAt = time.Time{}
+	next.UpdatedAt = time.Time{}
+	next.Status = JobPending
+	next.DueAt = remaining.Next(job.DueAt).UTC()
+	next.Repeat = cycle.Fired()
+	next.Attempts = 0
+	next.LastError = ""
+	next.LockedBy = ""
+	next.ExecutedAt = nil
+	return tx.Create(&next).Error
+}
+
+// timerDue resolves a timer definition to its first due time after from.
+// Durations count working time when a business calendar is given; cycles
+// always run on calendar time. For cycles the cycle itself is returned so the
+// job can be repeated.
+func timerDue(t *bpmn.TimerDefinition, vars map[string]interface{}, from time.Time, cal *calendar.Calendar) (time.Time, string, error) {
+	switch {
+	case t.Date != "":
+		value, err := resolveValue(t.Date, vars)
+		if err != nil {
+			return time.Time{}, "", err
+		}
+		if at, ok := value.(time.Time); ok {
+			return at.UTC(), "", nil
+		}
+		at, err := timer.ParseDate(fmt.Sprint(value))
+		return at.UTC(), "", err
+	case t.Duration != "":
+		value, err := resolveValue(t.Duration, vars)
+		if err != nil {
+			return time.Time{}, "", err
+		}
+		d, err := timer.ParseDuration(fmt.Sprint(value))
+		if err != nil {
+			return time.Time{}, "", err
+		}
+		return cal.Add(from, d), "", nil
+	case t.Cycle != "":
+		value, err := resolveValue(t.Cycle, vars)
+		if err != nil {
+			return time.Time{}, "", err
+		}
+		cycle, err := timer.ParseCycle(fmt.Sprint(value))
+		if err != nil {
+			return time.Time{}, "", err
+		}
+		return cycle.Next(from), fmt.Sprint(value), nil
+	}
+	return time.Time{}, "", fmt.Errorf("timer has no value")
+}
+
+// resolveValue evaluates a ${...} attribute value against the given variables;
+// plain values are returned as they are
+func resolveValue(value string, vars map[string]interface{}) (interface{}, error) {
+	if expression.Strip(value) == value {
+		return value, nil
+	}
+	return expression.Evaluate(value, vars)
+}
+
+// resolveDate turns a user task date attribute (ISO date, ISO duration from
+// now, or an expression producing either) into a point in time. Durations
+// count working time when a business calendar is given.
+func resolveDate(value string, vars map[string]interface{}, from time.Time, cal *calendar.Calendar) (*time.Time, error) {
+	if value == "" {
+		return nil, nil
+	}
+	resolved, err := resolveValue(value, vars)
+	if err != nil {
+		return nil, err
+	}
+	if at, ok := resolved.(time.Time); ok {
+		at = at.UTC()
+		return &at, nil
+	}
+	text := fmt.Sprint(resolved)
+	if at, err := timer.ParseDate(text); err == nil {
+		at = at.UTC()
+		return &at, nil
+	}
+	d, err := timer.ParseDuration(text)
+	if err != nil {
+		return nil, fmt.Errorf("%q is neither an ISO 8601 date nor a duration", text)
+	}
+	at := cal.Add(from, d)
+	return &at, nil
+}
+
+// scheduleTimer creates the job that fires the timer of node for execution ex
+func (r *run) scheduleTimer(ex *models.Execution, node *bpmn.Node, jobType string) error {
+	cal, err := r.calendar(node.Timer.Calendar)
+	if err != nil {
+		return raise(IncidentCalendarFailed, "timer %s: %v", node.ID, err)
+	}
+	due, repeat, err := timerDue(node.Timer, r.vars, time.Now().UTC(), cal)
+	if err != nil {
+		return raise(IncidentExpressionFailed, "timer %s: %v", node.ID, err)
+	}
+	job := models.Job{
+		Type:                jobType,
+		Status:              JobPending,
+		DueAt:               due,
+		ProcessDefinitionID: &r.inst.ProcessDefinitionID,
+		ProcessInstanceID:   &r.inst.ID,
+		ExecutionID:         &ex.ID,
+		ElementID:           node.ID,
+		Repeat:              repeat,
+	}
+	return r.tx.Create(&job).Error
+}
+
+// intermediateCatchEvent parks the token until the event it waits for occurs
+func (r *run) intermediateCatchEvent(ex *models.Execution, node *bpmn.Node) error {
+	switch node.Event {
+	case bpmn.TimerEvent:
+		if err := r.scheduleTimer(ex, node, JobTimerCatch); err != nil {
+			return err
+		}
+	case bpmn.MessageEvent, bpmn.SignalEvent:
+		if err := r.subscribe(ex, node); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("element %s: %s catch events are not supported", node.ID, node.Event)
+	}
+	return r.setStatus(ex, ExecutionWaiting)
+}
+
+// attachBoundaryEvents arms the boundary events of an activity the token has entered
+func (r *run) attachBoundaryEvents(ex *models.Execution, node *bpmn.Node) error {
+	for _, boundary := range node.Boundaries {
+		var err error
+		switch boundary.Event {
+		case bpmn.TimerEvent:
+			err = r.scheduleTimer(ex, boundary, JobTimerBoundary)
+		case bpmn.MessageEvent, bpmn.SignalEvent:
+			err = r.subscribe(ex, boundary)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// detachBoundaryEvents disarms the boundary events of an activity that is being left.
+// The scheduler locks the instance before a job of it, so no job of the instance
+// can be locked by another transaction while the run holds the instance.
+func (r *run) detachBoundaryEvents(ex *models.Execution) error {
+	err := r.tx.Model(&models.Job{}).
+		Where("execution_id = ? AND status IN ?", ex.ID, []string{JobPending, JobSuspended}).
+		Update("status", JobCancelled).Error
+	if err != nil {
+		return err
+	}
+	return r.unsubscribe(ex)
+}
+
+// fireBoundary triggers a boundary event of the activity executed by ex. An interrupting
+// event cancels the activity; a non-interrupting one leaves it running.
+func (r *run) fireBoundary(ex *models.Execution, boundary *bpmn.Node, job *models.Job) error {
+	if boundary.CancelActivity {
+		if err := r.cancelActivity(ex); err != nil {
+			return err
+		}
+	} else if job != nil {
+		if err := rescheduleCycle(r.tx, job); err != nil {
+			return err
+		}
+	}
+	_, err := r.spawn(ex.ParentID, boundary)
+	return err
+}
+
+// cancelActivity stops an activity: the tokens inside an embedded sub-process and the
+// instances started by a call activity are cancelled with it, its open tasks are
+// cancelled and its boundary events disarmed
+func (r *run) cancelActivity(ex *models.Execution) error {
+	var children []models.Execution
+	if err := r.scope(&ex.ID).Where("status IN ?", openStatuses).Find(&children).Error; err != nil {
+		return err
+	}
+	for i := range children {
+		if err := r.cancelActivity(&children[i]); err != nil {
+			return err
+		}
+	}
+	if err := r.terminateCalled(ex); err != nil {
+		return err
+	}
+
+	var tasks []uuid.UUID
+	err := r.tx.Model(&models.TaskInstance{}).
+		Where("execution_id = ? AND status IN ?", ex.ID, []string{TaskCreated, TaskAssigned}).
+		Pluck("id", &tasks).Error
+	if err != nil {
+		return err
+	}
+	for i := range tasks {
+		if err := r.tx.Model(&models.TaskInstance{}).Where("id = ?", tasks[i]).Update("status", TaskCancelled).Error; err != nil {
+			return err
+		}
+		if err := r.record(EventTaskCancelled, &tasks[i], nil); err != nil {
+			return err
+		}
+	}
+	if err := r.detachBoundaryEvents(ex); err != nil {
+		return err
+	}
+	return r.setStatus(ex, ExecutionCancelled)
+}
@@ -0,0 +1,39 @@
+package engine
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// decodeVariables parses a jsonb variables column into a map
+func decodeVariables(raw string) (map[string]interface{}, error) {
+	vars := make(map[string]interface{})
+	if raw == "" || raw == "null" {
+		return vars, nil
+	}
+	if err := json.Unmarshal([]byte(raw), &vars); err != nil {
+		return nil, fmt.Errorf("invalid variables: %w", err)
+	}
+	return vars, nil
+}
+
+// encodeVariables serializes variables for a jsonb column
+func encodeVariables(vars map[string]interface{}) (string, error) {
+	if vars == nil {
+		return "{}", nil
+	}
+	data, err := json.Marshal(vars)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode variables: %w", err)
+	}
+	return string(data), nil
+}
+
+// copyVariables returns a shallow copy of a variables map
+func copyVariables(vars map[string]interface{}) map[string]interface{} {
+	out := make(map[string]interface{}, len(vars))
+	for k, v := range vars {
+		out[k] = v
+	}
+	return out
+}
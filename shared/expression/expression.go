@@ -0,0 +1,120 @@
+package expression
+
+import (
+	"container/list"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/expr-lang/expr"
+	"github.com/expr-lang/expr/vm"
+)
+
+// maxPrograms bounds the number of compiled expressions kept in the cache.
+// Expressions come from deployed definitions but also from API callers, so the
+// least recently used ones are dropped.
+const maxPrograms = 1024
+
+// programs caches compiled expressions by source text
+var programs = newProgramCache(maxPrograms)
+
+// programCache is a least recently used cache of compiled expressions
+type programCache struct {
+	mu      sync.Mutex
+	size    int
+	order   *list.List // most recently used first
+	entries map[string]*list.Element
+}
+
+// cachedProgram is an entry of the program cache
+type cachedProgram struct {
+	source  string
+	program *vm.Program
+}
+
+func newProgramCache(size int) *programCache {
+	return &programCache{size: size, order: list.New(), entries: make(map[string]*list.Element)}
+}
+
+// Load returns the cached program of an expression and marks it as used
+func (c *programCache) Load(source string) (*vm.Program, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.entries[source]
+	if !ok {
+		return nil, false
+	}
+	c.order.MoveToFront(e)
+	return e.Value.(*cachedProgram).program, true
+}
+
+// Store caches the program of an expression, evicting the least recently used
+// one when the cache is full
+func (c *programCache) Store(source string, program *vm.Program) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e, ok := c.entries[source]; ok {
+		c.order.MoveToFront(e)
+		return
+	}
+	c.entries[source] = c.order.PushFront(&cachedProgram{source: source, program: program})
+	if c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*cachedProgram).source)
+	}
+}
+
+// Strip removes the ${...} or #{...} wrapper that BPMN modelers put around expressions
+func Strip(source string) string {
+	s := strings.TrimSpace(source)
+	if (strings.HasPrefix(s, "${") || strings.HasPrefix(s, "#{")) && strings.HasSuffix(s, "}") {
+		return strings.TrimSpace(s[2 : len(s)-1])
+	}
+	return s
+}
+
+// Compile parses an expression, reusing a cached program when possible
+func Compile(source string) (*vm.Program, error) {
+	source = Strip(source)
+	if cached, ok := programs.Load(source); ok {
+		return cached, nil
+	}
+
+	program, err := expr.Compile(source)
+	if err != nil {
+		return nil, fmt.Errorf("invalid expression %q: %w", source, err)
+	}
+	programs.Store(source, program)
+	return program, nil
+}
+
+// Evaluate runs an expression against the given variables
+func Evaluate(source string, vars map[string]interface{}) (interface{}, error) {
+	program, err := Compile(source)
+	if err != nil {
+		return nil, err
+	}
+	if vars == nil {
+		vars = map[string]interface{}{}
+	}
+
+	result, err := expr.Run(program, vars)
+	if err != nil {
+		return nil, fmt.Errorf("failed to evaluate %q: %w", Strip(source), err)
+	}
+	return result, nil
+}
+
+// EvaluateBool runs an expression that must produce a boolean
+func EvaluateBool(source string, vars map[string]interface{}) (bool, error) {
+	result, err := Evaluate(source, vars)
+	if err != nil {
+		return false, err
+	}
+	b, ok := result.(bool)
+	if !ok {
+		return false, fmt.Errorf("expression %q returned %T, expected bool", Strip(source), result)
+	}
+	return b, nil
+}
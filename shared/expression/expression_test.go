@@ -0,0 +1,144 @@
+package expression
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/expr-lang/expr/vm"
+)
+
+func TestStrip(t *testing.T) {
+	tests := []struct {
+		source string
+		want   string
+	}{
+		{"${amount > 100}", "amount > 100"},
+		{"#{approved}", "approved"},
+		{"  ${ a + b }  ", "a + b"},
+		{"amount > 100", "amount > 100"},
+		{"${unclosed", "${unclosed"},
+		{"", ""},
+	}
+
+	for _, tt := range tests {
+		if got := Strip(tt.source); got != tt.want {
+			t.Errorf("Strip(%q) = %q, want %q", tt.source, got, tt.want)
+		}
+	}
+}
+
+func TestEvaluate(t *testing.T) {
+	vars := map[string]interface{}{
+		"amount":   250,
+		"customer": map[string]interface{}{"tier": "gold"},
+		"items":    []interface{}{"a", "b", "c"},
+	}
+	tests := []struct {
+		name    string
+		source  string
+		want    interface{}
+		wantErr string
+	}{
+		{name: "arithmetic", source: "${amount * 2}", want: 500},
+		{name: "nested field", source: "customer.tier", want: "gold"},
+		{name: "builtin", source: "${len(items)}", want: 3},
+		{name: "string concatenation", source: `customer.tier + "-" + "vip"`, want: "gold-vip"},
+		{name: "syntax error", source: "${amount >}", wantErr: `invalid expression "amount >"`},
+		{name: "runtime error", source: "amount / customer", wantErr: `failed to evaluate "amount / customer"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Evaluate(tt.source, vars)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("got %v, want an error containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEvaluateBool(t *testing.T) {
+	tests := []struct {
+		name    string
+		source  string
+		vars    map[string]interface{}
+		want    bool
+		wantErr string
+	}{
+		{name: "true", source: "${amount > 100}", vars: map[string]interface{}{"amount": 250}, want: true},
+		{name: "false", source: "${amount > 100}", vars: map[string]interface{}{"amount": 50}, want: false},
+		{name: "constant without variables", source: "1 < 2", want: true},
+		{name: "not a boolean", source: "${amount}", vars: map[string]interface{}{"amount": 50}, wantErr: "returned int, expected bool"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := EvaluateBool(tt.source, tt.vars)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("got %v, want an error containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCompileCaches(t *testing.T) {
+	first, err := Compile("${amount + 1}")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	second, err := Compile("amount + 1")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if first != second {
+		t.Error("got two programs for the same expression, want the cached one")
+	}
+}
+
+func TestProgramCacheEvicts(t *testing.T) {
+	c := newProgramCache(2)
+	a, b, d := &vm.Program{}, &vm.Program{}, &vm.Program{}
+	c.Store("a", a)
+	c.Store("b", b)
+	if _, ok := c.Load("a"); !ok {
+		t.Fatal("a is not cached")
+	}
+	c.Store("d", d) // evicts b, the least recently used
+
+	tests := []struct {
+		source string
+		want   *vm.Program
+	}{
+		{"a", a},
+		{"b", nil},
+		{"d", d},
+	}
+	for _, tt := range tests {
+		got, ok := c.Load(tt.source)
+		if ok != (tt.want != nil) || got != tt.want {
+			t.Errorf("Load(%q) = %p, %v, want %p", tt.source, got, ok, tt.want)
+		}
+	}
+	if len(c.entries) != 2 || c.order.Len() != 2 {
+		t.Errorf("got %d cached programs, want 2", len(c.entries))
+	}
+}
@@ -0,0 +1,633 @@
+package forms
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/url"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/tvolodi/ai-bpms-backend/shared/expression"
+)
+
+// formScheme prefixes the URI of a form schema that has no $id of its own
+const formScheme = "form:"
+
+// knownTypes are the names the type keyword accepts
+var knownTypes = map[string]bool{
+	"null": true, "boolean": true, "object": true, "array": true,
+	"number": true, "integer": true, "string": true, "file": true,
+}
+
+// schema is a compiled JSON Schema. A boolean schema only has always set.
+type schema struct {
+	always *bool
+
+	// base is the URI of the schema resource the schema belongs to
+	base string
+
+	ref        *schema
+	dynamicRef *dynamicRef
+
+	types    []string
+	enum     []interface{}
+	hasEnum  bool
+	constant interface{}
+	hasConst bool
+
+	multipleOf       *float64
+	maximum          *float64
+	exclusiveMaximum *float64
+	minimum          *float64
+	exclusiveMinimum *float64
+
+	maxLength *int
+	minLength *int
+	pattern   *regexp.Regexp
+	format    string
+
+	maxItems    *int
+	minItems    *int
+	uniqueItems bool
+	maxContains *int
+	minContains *int
+
+	maxProperties     *int
+	minProperties     *int
+	required          []string
+	dependentRequired map[string][]string
+
+	allOf            []*schema
+	anyOf            []*schema
+	oneOf            []*schema
+	not              *schema
+	ifSchema         *schema
+	thenSchema       *schema
+	elseSchema       *schema
+	dependentSchemas map[string]*schema
+
+	prefixItems      []*schema
+	items            *schema
+	contains         *schema
+	unevaluatedItems *schema
+
+	properties            map[string]*schema
+	patternProperties     []patternSchema
+	additionalProperties  *schema
+	propertyNames         *schema
+	unevaluatedProperties *schema
+
+	// File fields
+	accept  []string
+	maxSize int64
+
+	// Business rules and custom keywords
+	rules  []rule
+	custom []customKeyword
+}
+
+// patternSchema applies to the properties whose names match a pattern
+type patternSchema struct {
+	pattern *regexp.Regexp
+	schema  *schema
+}
+
+// dynamicRef is a $dynamicRef: the schema it resolves to statically, and the
+// anchor name looked up in the dynamic scope when that schema declares it
+type dynamicRef struct {
+	name    string
+	target  *schema
+	dynamic bool
+}
+
+// compiler turns schema documents into compiled schemas, loading the documents
+// of other forms as references to them are met
+type compiler struct {
+	loader Loader
+
+	// resources holds the raw subschemas by URI: resource URI plus a JSON
+	// pointer or anchor as fragment
+	resources map[string]interface{}
+	documents map[string]bool
+
+	// bases holds the resource URI of every indexed raw schema object
+	bases    map[uintptr]string
+	compiled map[uintptr]*schema
+
+	dynamicAnchors map[string]map[string]*schema
+	pending        []pendingAnchor
+}
+
+// pendingAnchor is a $dynamicAnchor found while indexing and not yet compiled
+type pendingAnchor struct {
+	base string
+	name string
+	raw  map[string]interface{}
+}
+
+func newCompiler(loader Loader) *compiler {
+	return &compiler{
+		loader:         loader,
+		resources:      make(map[string]interface{}),
+		documents:      make(map[string]bool),
+		bases:          make(map[uintptr]string),
+		compiled:       make(map[uintptr]*schema),
+		dynamicAnchors: make(map[string]map[string]*schema),
+	}
+}
+
+// formURI returns the URI of the schema of a form
+func formURI(key string) string {
+	return formScheme + key
+}
+
+// compileDocument indexes and compiles a schema document, then the dynamic
+// anchors of every document it loaded
+func (c *compiler) compileDocument(uri string, root interface{}) (*schema, error) {
+	c.addDocument(uri, root)
+	compiled, err := c.compile(root)
+	if err != nil {
+		return nil, err
+	}
+	for len(c.pending) > 0 {
+		anchor := c.pending[0]
+		c.pending = c.pending[1:]
+		s, err := c.compile(anchor.raw)
+		if err != nil {
+			return nil, err
+		}
+		if c.dynamicAnchors[anchor.base] == nil {
+			c.dynamicAnchors[anchor.base] = make(map[string]*schema)
+		}
+		c.dynamicAnchors[anchor.base][anchor.name] = s
+	}
+	return compiled, nil
+}
+
+// addDocument registers a schema document and its subschemas under their URIs
+func (c *compiler) addDocument(uri string, root interface{}) {
+	c.documents[uri] = true
+	c.resources[uri+"#"] = root
+	c.index(root, uri, "")
+}
+
+// index registers a raw schema and its subschemas. pointer is the location of
+// the schema within the resource identified by base.
+func (c *compiler) index(raw interface{}, base, pointer string) {
+	m, ok := raw.(map[string]interface{})
+	if !ok {
+		return
+	}
+	if id, ok := m["$id"].(string); ok && id != "" {
+		base = stripFragment(resolveURI(base, id))
+		pointer = ""
+	}
+	c.resources[base+"#"+pointer] = m
+	c.bases[identity(m)] = base
+	if anchor, ok := m["$anchor"].(string); ok && anchor != "" {
+		c.resources[base+"#"+anchor] = m
+	}
+	if anchor, ok := m["$dynamicAnchor"].(string); ok && anchor != "" {
+		c.resources[base+"#"+anchor] = m
+		c.pending = append(c.pending, pendingAnchor{base: base, name: anchor, raw: m})
+	}
+
+	for _, keyword := range []string{"items", "contains", "not", "if", "then", "else", "additionalProperties", "propertyNames", "unevaluatedItems", "unevaluatedProperties"} {
+		if sub, ok := m[keyword]; ok {
+			c.index(sub, base, pointer+"/"+keyword)
+		}
+	}
+	for _, keyword := range []string{"allOf", "anyOf", "oneOf", "prefixItems", "items"} {
+		if subs, ok := m[keyword].([]interface{}); ok {
+			for i, sub := range subs {
+				c.index(sub, base, pointer+"/"+keyword+"/"+strconv.Itoa(i))
+			}
+		}
+	}
+	for _, keyword := range []string{"$defs", "definitions", "properties", "patternProperties", "dependentSchemas"} {
+		if subs, ok := m[keyword].(map[string]interface{}); ok {
+			for name, sub := range subs {
+				c.index(sub, base, pointer+"/"+keyword+"/"+escape(name))
+			}
+		}
+	}
+}
+
+// lookup finds the raw schema a URI refers to, loading the schema of another
+// form when the URI names one
+func (c *compiler) lookup(uri string) (interface{}, error) {
+	doc, fragment, _ := strings.Cut(uri, "#")
+	if unescaped, err := url.PathUnescape(fragment); err == nil {
+		fragment = unescaped
+	}
+	if raw, ok := c.resources[doc+"#"+fragment]; ok {
+		return raw, nil
+	}
+
+	if _, ok := c.resources[doc+"#"]; !ok {
+		key, isForm := strings.CutPrefix(doc, formScheme)
+		if !isForm || key == "" || c.loader == nil || c.documents[doc] {
+			return nil, fmt.Errorf("$ref %q cannot be resolved", uri)
+		}
+		source, err := c.loader(key)
+		if err != nil {
+			return nil, fmt.Errorf("$ref %q: %w", uri, err)
+		}
+		var root interface{}
+		if err := json.Unmarshal([]byte(source), &root); err != nil {
+			return nil, fmt.Errorf("$ref %q: %w", uri, err)
+		}
+		c.addDocument(doc, root)
+		if raw, ok := c.resources[doc+"#"+fragment]; ok {
+			return raw, nil
+		}
+	}
+
+	// A pointer to a location that is not a known subschema
+	if strings.HasPrefix(fragment, "/") {
+		raw := c.resources[doc+"#"]
+		for _, token := range strings.Split(fragment[1:], "/") {
+			token = strings.NewReplacer("~1", "/", "~0", "~").Replace(token)
+			switch v := raw.(type) {
+			case map[string]interface{}:
+				raw = v[token]
+			case []interface{}:
+				i, err := strconv.Atoi(token)
+				if err != nil || i < 0 || i >= len(v) {
+					return nil, fmt.Errorf("$ref %q cannot be resolved", uri)
+				}
+				raw = v[i]
+			default:
+				raw = nil
+			}
+			if raw == nil {
+				return nil, fmt.Errorf("$ref %q cannot be resolved", uri)
+			}
+		}
+		if m, ok := raw.(map[string]interface{}); ok {
+			if _, known := c.bases[identity(m)]; !known {
+				c.index(m, doc, fragment)
+			}
+		}
+		return raw, nil
+	}
+	return nil, fmt.Errorf("$ref %q cannot be resolved", uri)
+}
+
+// compile compiles a raw schema. Schema objects are compiled once, so that
+// recursive references end up pointing at the same compiled schema.
+func (c *compiler) compile(raw interface{}) (*schema, error) {
+	switch v := raw.(type) {
+	case bool:
+		return &schema{always: &v}, nil
+	case map[string]interface{}:
+		id := identity(v)
+		if s, ok := c.compiled[id]; ok {
+			return s, nil
+		}
+		s := &schema{base: c.bases[id]}
+		c.compiled[id] = s
+		if err := c.fill(s, v); err != nil {
+			return nil, err
+		}
+		return s, nil
+	default:
+		return nil, fmt.Errorf("a schema must be an object or a boolean")
+	}
+}
+
+// fill compiles the keywords of a schema object
+func (c *compiler) fill(s *schema, m map[string]interface{}) error {
+	var err error
+	sub := func(keyword string) *schema {
+		raw, ok := m[keyword]
+		if !ok || err != nil {
+			return nil
+		}
+		var compiled *schema
+		if compiled, err = c.compile(raw); err != nil {
+			err = fmt.Errorf("%s: %w", keyword, err)
+		}
+		return compiled
+	}
+	list := func(keyword string) []*schema {
+		raw, ok := m[keyword]
+		if !ok || err != nil {
+			return nil
+		}
+		subs, isList := raw.([]interface{})
+		if !isList || len(subs) == 0 {
+			err = fmt.Errorf("%s must be a non-empty array of schemas", keyword)
+			return nil
+		}
+		compiled := make([]*schema, len(subs))
+		for i, raw := range subs {
+			if compiled[i], err = c.compile(raw); err != nil {
+				err = fmt.Errorf("%s/%d: %w", keyword, i, err)
+				return nil
+			}
+		}
+		return compiled
+	}
+	named := func(keyword string) map[string]*schema {
+		raw, ok := m[keyword]
+		if !ok || err != nil {
+			return nil
+		}
+		subs, isMap := raw.(map[string]interface{})
+		if !isMap {
+			err = fmt.Errorf("%s must be an object of schemas", keyword)
+			return nil
+		}
+		compiled := make(map[string]*schema, len(subs))
+		for name, raw := range subs {
+			if compiled[name], err = c.compile(raw); err != nil {
+				err = fmt.Errorf("%s/%s: %w", keyword, escape(name), err)
+				return nil
+			}
+		}
+		return compiled
+	}
+	number := func(keyword string) *float64 {
+		raw, ok := m[keyword]
+		if !ok || err != nil {
+			return nil
+		}
+		n, isNumber := raw.(float64)
+		if !isNumber {
+			err = fmt.Errorf("%s must be a number", keyword)
+			return nil
+		}
+		return &n
+	}
+	count := func(keyword string) *int {
+		n := number(keyword)
+		if n == nil {
+			return nil
+		}
+		if *n < 0 || *n != math.Trunc(*n) {
+			err = fmt.Errorf("%s must be a non-negative integer", keyword)
+			return nil
+		}
+		v := int(*n)
+		return &v
+	}
+
+	if ref, ok := m["$ref"].(string); ok {
+		raw, lookupErr := c.lookup(resolveURI(s.base, ref))
+		if lookupErr != nil {
+			return lookupErr
+		}
+		if s.ref, err = c.compile(raw); err != nil {
+			return err
+		}
+	}
+	if ref, ok := m["$dynamicRef"].(string); ok {
+		uri := resolveURI(s.base, ref)
+		raw, lookupErr := c.lookup(uri)
+		if lookupErr != nil {
+			return lookupErr
+		}
+		d := &dynamicRef{}
+		if d.target, err = c.compile(raw); err != nil {
+			return err
+		}
+		// Only a reference to an anchor that its target declares as dynamic
+		// is resolved in the dynamic scope
+		if _, fragment, _ := strings.Cut(uri, "#"); fragment != "" && !strings.HasPrefix(fragment, "/") {
+			if target, isMap := raw.(map[string]interface{}); isMap && target["$dynamicAnchor"] == fragment {
+				d.name = fragment
+				d.dynamic = true
+			}
+		}
+		s.dynamicRef = d
+	}
+
+	if raw, ok := m["type"]; ok {
+		switch t := raw.(type) {
+		case string:
+			s.types = []string{t}
+		case []interface{}:
+			for _, name := range t {
+				if name, isString := name.(string); isString {
+					s.types = append(s.types, name)
+				}
+			}
+		}
+		if len(s.types) == 0 {
+			return fmt.Errorf("type must be a type name or an array of type names")
+		}
+		for _, name := range s.types {
+			if !knownTypes[name] {
+				return fmt.Errorf("unknown type %q", name)
+			}
+		}
+	}
+	if raw, ok := m["enum"]; ok {
+		values, isList := raw.([]interface{})
+		if !isList {
+			return fmt.Errorf("enum must be an array")
+		}
+		s.enum, s.hasEnum = values, true
+	}
+	s.constant, s.hasConst = m["const"]
+
+	s.multipleOf = number("multipleOf")
+	if s.multipleOf != nil && *s.multipleOf <= 0 {
+		return fmt.Errorf("multipleOf must be greater than 0")
+	}
+	s.maximum = number("maximum")
+	s.exclusiveMaximum = number("exclusiveMaximum")
+	s.minimum = number("minimum")
+	s.exclusiveMinimum = number("exclusiveMinimum")
+
+	s.maxLength = count("maxLength")
+	s.minLength = count("minLength")
+	if raw, ok := m["pattern"]; ok {
+		pattern, isString := raw.(string)
+		if !isString {
+			return fmt.Errorf("pattern must be a string")
+		}
+		if s.pattern, err = regexp.Compile(pattern); err != nil {
+			return fmt.Errorf("pattern: %w", err)
+		}
+	}
+	if raw, ok := m["format"]; ok {
+		if s.format, ok = raw.(string); !ok {
+			return fmt.Errorf("format must be a string")
+		}
+	}
+
+	s.maxItems = count("maxItems")
+	s.minItems = count("minItems")
+	s.uniqueItems, _ = m["uniqueItems"].(bool)
+	s.maxContains = count("maxContains")
+	s.minContains = count("minContains")
+
+	s.maxProperties = count("maxProperties")
+	s.minProperties = count("minProperties")
+	if raw, ok := m["required"]; ok {
+		if s.required, ok = stringList(raw); !ok {
+			return fmt.Errorf("required must be an array of property names")
+		}
+	}
+	if raw, ok := m["dependentRequired"]; ok {
+		deps, isMap := raw.(map[string]interface{})
+		if !isMap {
+			return fmt.Errorf("dependentRequired must be an object")
+		}
+		s.dependentRequired = make(map[string][]string, len(deps))
+		for name, raw := range deps {
+			if s.dependentRequired[name], ok = stringList(raw); !ok {
+				return fmt.Errorf("dependentRequired/%s must be an array of property names", escape(name))
+			}
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	s.allOf = list("allOf")
+	s.anyOf = list("anyOf")
+	s.oneOf = list("oneOf")
+	s.not = sub("not")
+	s.ifSchema = sub("if")
+	s.thenSchema = sub("then")
+	s.elseSchema = sub("else")
+	s.dependentSchemas = named("dependentSchemas")
+
+	s.prefixItems = list("prefixItems")
+	if _, tuple := m["items"].([]interface{}); tuple {
+		// items as an array is the tuple form of earlier drafts
+		s.prefixItems = list("items")
+	} else {
+		s.items = sub("items")
+	}
+	s.contains = sub("contains")
+	s.unevaluatedItems = sub("unevaluatedItems")
+
+	s.properties = named("properties")
+	if patterns := named("patternProperties"); patterns != nil {
+		names := make([]string, 0, len(patterns))
+		for pattern := range patterns {
+			names = append(names, pattern)
+		}
+		sort.Strings(names)
+		for _, pattern := range names {
+			re, reErr := regexp.Compile(pattern)
+			if reErr != nil {
+				return fmt.Errorf("patternProperties: %w", reErr)
+			}
+			s.patternProperties = append(s.patternProperties, patternSchema{pattern: re, schema: patterns[pattern]})
+		}
+	}
+	s.additionalProperties = sub("additionalProperties")
+	s.propertyNames = sub("propertyNames")
+	s.unevaluatedProperties = sub("unevaluatedProperties")
+	if err != nil {
+		return err
+	}
+
+	if raw, ok := m["accept"]; ok {
+		if s.accept, ok = stringList(raw); !ok {
+			return fmt.Errorf("accept must be an array of content types")
+		}
+	}
+	if maxSize := number("maxSize"); maxSize != nil {
+		s.maxSize = int64(*maxSize)
+	}
+	if raw, ok := m["x-rules"]; ok {
+		if s.rules, err = parseRules(raw); err != nil {
+			return fmt.Errorf("x-rules: %w", err)
+		}
+	}
+	s.custom = customKeywords(m)
+	return err
+}
+
+// parseRules reads the x-rules keyword: a list of {rule, message, field}
+// where rule is an expression over the properties of the object
+func parseRules(raw interface{}) ([]rule, error) {
+	list, ok := raw.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("must be an array of rules")
+	}
+	rules := make([]rule, 0, len(list))
+	for i, item := range list {
+		m, ok := item.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("%d: must be an object", i)
+		}
+		r := rule{}
+		r.expression, _ = m["rule"].(string)
+		r.message, _ = m["message"].(string)
+		r.field, _ = m["field"].(string)
+		if r.expression == "" {
+			return nil, fmt.Errorf("%d: rule is required", i)
+		}
+		if _, err := expression.Compile(r.expression); err != nil {
+			return nil, fmt.Errorf("%d: %w", i, err)
+		}
+		if r.message == "" {
+			r.message = "does not satisfy " + expression.Strip(r.expression)
+		}
+		rules = append(rules, r)
+	}
+	return rules, nil
+}
+
+// stringList reads an array of strings
+func stringList(raw interface{}) ([]string, bool) {
+	list, ok := raw.([]interface{})
+	if !ok {
+		return nil, false
+	}
+	values := make([]string, len(list))
+	for i, item := range list {
+		if values[i], ok = item.(string); !ok {
+			return nil, false
+		}
+	}
+	return values, true
+}
+
+// resolveURI resolves a reference against the URI of the resource it appears
+// in. References that are neither absolute nor resolvable against a
+// hierarchical base name another form by its key.
+func resolveURI(base, ref string) string {
+	if strings.HasPrefix(ref, "#") {
+		return stripFragment(base) + ref
+	}
+	u, err := url.Parse(ref)
+	if err != nil {
+		return formScheme + ref
+	}
+	if u.IsAbs() {
+		return ref
+	}
+	b, err := url.Parse(base)
+	if err == nil && b.IsAbs() && b.Opaque == "" && !strings.HasPrefix(base, formScheme) {
+		return b.ResolveReference(u).String()
+	}
+	return formScheme + ref
+}
+
+// stripFragment removes the fragment of a URI
+func stripFragment(uri string) string {
+	doc, _, _ := strings.Cut(uri, "#")
+	return doc
+}
+
+// identity returns a key identifying a decoded JSON object
+func identity(m map[string]interface{}) uintptr {
+	return reflect.ValueOf(m).Pointer()
+}
+
+// escape escapes a property name for use in a JSON pointer
+func escape(name string) string {
+	return strings.NewReplacer("~", "~0", "/", "~1").Replace(name)
+}
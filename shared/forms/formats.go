@@ -0,0 +1,119 @@
+package forms
+
+import (
+	"net"
+	"net/mail"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// FormatFunc reports whether a string is valid in a format
+type FormatFunc func(value string) bool
+
+var (
+	formatsMu sync.RWMutex
+	formats   = map[string]FormatFunc{
+		"email":                 isEmail,
+		"date":                  layout("2006-01-02"),
+		"date-time":             isDateTime,
+		"time":                  isTime,
+		"duration":              matches(`^P(?:\d+W|(?:\d+Y)?(?:\d+M)?(?:\d+D)?(?:T(?:\d+H)?(?:\d+M)?(?:\d+(?:\.\d+)?S)?)?)$`),
+		"uuid":                  matches(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`),
+		"uri":                   isURI,
+		"uri-reference":         isURIReference,
+		"hostname":              isHostname,
+		"ipv4":                  isIPv4,
+		"ipv6":                  isIPv6,
+		"regex":                 isRegex,
+		"json-pointer":          matches(`^(?:/(?:[^~/]|~[01])*)*$`),
+		"relative-json-pointer": matches(`^(?:0|[1-9][0-9]*)(?:#|(?:/(?:[^~/]|~[01])*)*)$`),
+	}
+)
+
+// RegisterFormat adds a format the format keyword asserts, or replaces the
+// check of a built-in one. Unknown formats are not checked.
+func RegisterFormat(name string, check FormatFunc) {
+	formatsMu.Lock()
+	defer formatsMu.Unlock()
+	formats[name] = check
+}
+
+func lookupFormat(name string) (FormatFunc, bool) {
+	formatsMu.RLock()
+	defer formatsMu.RUnlock()
+	check, ok := formats[name]
+	return check, ok
+}
+
+// matches returns a format check for a regular expression
+func matches(pattern string) FormatFunc {
+	re := regexp.MustCompile(pattern)
+	return re.MatchString
+}
+
+// layout returns a format check for a time layout
+func layout(layout string) FormatFunc {
+	return func(value string) bool {
+		_, err := time.Parse(layout, value)
+		return err == nil
+	}
+}
+
+// isEmail accepts a bare address such as jane@example.com
+func isEmail(value string) bool {
+	addr, err := mail.ParseAddress(value)
+	return err == nil && addr.Name == "" && addr.Address == value
+}
+
+// isDateTime accepts an RFC 3339 date-time; the "t" and "z" may be lower case
+func isDateTime(value string) bool {
+	_, err := time.Parse(time.RFC3339Nano, strings.ToUpper(value))
+	return err == nil
+}
+
+// isTime accepts an RFC 3339 full-time with its offset, such as 08:30:00Z
+func isTime(value string) bool {
+	return isDateTime("1970-01-01T" + value)
+}
+
+func isURI(value string) bool {
+	u, err := url.Parse(value)
+	return err == nil && u.IsAbs()
+}
+
+func isURIReference(value string) bool {
+	_, err := url.Parse(value)
+	return err == nil
+}
+
+var hostnameLabel = regexp.MustCompile(`^[A-Za-z0-9](?:[A-Za-z0-9-]{0,61}[A-Za-z0-9])?$`)
+
+func isHostname(value string) bool {
+	value = strings.TrimSuffix(value, ".")
+	if value == "" || len(value) > 253 {
+		return false
+	}
+	for _, label := range strings.Split(value, ".") {
+		if !hostnameLabel.MatchString(label) {
+			return false
+		}
+	}
+	return true
+}
+
+func isIPv4(value string) bool {
+	ip := net.ParseIP(value)
+	return ip != nil && ip.To4() != nil && !strings.Contains(value, ":")
+}
+
+func isIPv6(value string) bool {
+	return net.ParseIP(value) != nil && strings.Contains(value, ":")
+}
+
+func isRegex(value string) bool {
+	_, err := regexp.Compile(value)
+	return err == nil
+}
@@ -0,0 +1,164 @@
+package forms
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+// ErrInvalidSchema is returned by Compile for a schema that is not valid JSON
+// Schema, or that refers to one that cannot be loaded
+var ErrInvalidSchema = errors.New("invalid form schema")
+
+// FieldError is a problem with one value of a submitted form. Pointer is the
+// JSON pointer (RFC 6901) of the value, empty for the form itself; Keyword is
+// the schema keyword the value violates.
+type FieldError struct {
+	Pointer string `json:"pointer"`
+	Keyword string `json:"keyword,omitempty"`
+	Message string `json:"message"`
+}
+
+func (e FieldError) Error() string {
+	if e.Pointer == "" {
+		return e.Message
+	}
+	return fmt.Sprintf("%s: %s", e.Pointer, e.Message)
+}
+
+// ValidationErrors is the list of problems found in a submitted form
+type ValidationErrors []FieldError
+
+func (e ValidationErrors) Error() string {
+	msgs := make([]string, len(e))
+	for i, err := range e {
+		msgs[i] = err.Error()
+	}
+	return "invalid form data: " + strings.Join(msgs, "; ")
+}
+
+// FileRef is a value of a form field of type "file": the ID of an uploaded
+// attachment, with the limits the field puts on it
+type FileRef struct {
+	Pointer string
+	ID      uuid.UUID
+	Accept  []string // allowed content types, "image/*" style wildcards included
+	MaxSize int64    // in bytes, 0 when unlimited
+}
+
+// Loader returns the JSON Schema of the form with the given key. It resolves
+// a $ref to another form, written as its key ("address" or
+// "address#/$defs/street") or as a form URI ("form:address"). How a key
+// selects a version of the form is up to the loader.
+type Loader func(key string) (string, error)
+
+// Schema is a compiled JSON Schema of a form. It implements JSON Schema draft
+// 2020-12 with format assertions, plus the form extensions: the "file" type
+// whose values are attachment IDs, "x-rules" business rules and the keywords
+// added with RegisterKeyword.
+type Schema struct {
+	root *schema
+
+	// dynamicAnchors holds the $dynamicAnchor subschemas by resource URI and name
+	dynamicAnchors map[string]map[string]*schema
+
+	// logic is the form logic of the UI schema, see WithUISchema
+	logic []fieldLogic
+}
+
+// Parse parses a self-contained JSON Schema of a form
+func Parse(source string) (*Schema, error) {
+	return Compile("", source, nil)
+}
+
+// Compile parses the JSON Schema of the form with the given key and the schemas
+// of the forms it refers to, which are read with loader. An invalid keyword or
+// a $ref that cannot be resolved fails compilation.
+func Compile(key, source string, loader Loader) (*Schema, error) {
+	var root interface{}
+	if err := json.Unmarshal([]byte(source), &root); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidSchema, err)
+	}
+	c := newCompiler(loader)
+	compiled, err := c.compileDocument(formURI(key), root)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidSchema, err)
+	}
+	return &Schema{root: compiled, dynamicAnchors: c.dynamicAnchors}, nil
+}
+
+// Validate checks form data against the schema and returns ValidationErrors
+// listing every offending value
+func (s *Schema) Validate(data map[string]interface{}) error {
+	_, result := s.evaluate(data)
+	if len(result.errs) > 0 {
+		return ValidationErrors(result.errs)
+	}
+	return nil
+}
+
+// Outputs returns the values of the properties the schema declares for the
+// form itself, directly or through $ref, allOf and the branches that matched,
+// with computed fields filled in; anything else in the data is dropped, and so
+// are hidden fields
+func (s *Schema) Outputs(data map[string]interface{}) map[string]interface{} {
+	value, result := s.evaluate(data)
+	outputs := make(map[string]interface{}, len(result.declared))
+	for name := range result.declared {
+		if v, ok := value[name]; ok {
+			outputs[name] = v
+		}
+	}
+	return outputs
+}
+
+// Files returns the attachments referenced by the file fields of valid form data
+func (s *Schema) Files(data map[string]interface{}) []FileRef {
+	_, result := s.evaluate(data)
+	return result.files
+}
+
+// evaluate runs the form logic and then the schema over normalized form data.
+// Problems with hidden fields are left out.
+func (s *Schema) evaluate(data map[string]interface{}) (map[string]interface{}, *evaluation) {
+	value, outcome := s.applyLogic(data)
+	v := &validator{dynamicAnchors: s.dynamicAnchors}
+	result := v.eval(s.root, value, "")
+	if len(s.logic) == 0 {
+		return value, result
+	}
+
+	errs := make([]FieldError, 0, len(outcome.errs)+len(result.errs))
+	for _, err := range append(outcome.errs, result.errs...) {
+		if !hiddenError(err, outcome.hidden) {
+			errs = append(errs, err)
+		}
+	}
+	result.errs = errs
+	return value, result
+}
+
+// normalize round-trips data through JSON so numbers and nested values have
+// the types the validator expects
+func normalize(data map[string]interface{}) interface{} {
+	if data == nil {
+		return map[string]interface{}{}
+	}
+	return normalizeValue(data)
+}
+
+// normalizeValue round-trips a value through JSON
+func normalizeValue(data interface{}) interface{} {
+	raw, err := json.Marshal(data)
+	if err != nil {
+		return data
+	}
+	var value interface{}
+	if err := json.Unmarshal(raw, &value); err != nil {
+		return data
+	}
+	return value
+}
@@ -0,0 +1,394 @@
+package forms
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// problems lists the pointer and keyword of every validation error, sorted
+func problems(t *testing.T, err error) []string {
+	t.Helper()
+	if err == nil {
+		return nil
+	}
+	var errs ValidationErrors
+	if !errors.As(err, &errs) {
+		t.Fatalf("got %v, want validation errors", err)
+	}
+	list := make([]string, len(errs))
+	for i, e := range errs {
+		list[i] = e.Pointer + " " + e.Keyword
+	}
+	sort.Strings(list)
+	return list
+}
+
+// loader serves form schemas from a map, as the engine does from the database
+func loader(forms map[string]string) Loader {
+	return func(key string) (string, error) {
+		source, ok := forms[key]
+		if !ok {
+			return "", fmt.Errorf("form %s not found", key)
+		}
+		return source, nil
+	}
+}
+
+const orderSchema = `{
+	"type": "object",
+	"required": ["customer", "amount"],
+	"properties": {
+		"customer": {"type": "string", "minLength": 2},
+		"email":    {"type": "string", "format": "email"},
+		"amount":   {"type": "number", "exclusiveMinimum": 0, "maximum": 10000},
+		"quantity": {"type": "integer", "multipleOf": 5},
+		"priority": {"enum": ["low", "normal", "high"]},
+		"items": {
+			"type": "array",
+			"minItems": 1,
+			"uniqueItems": true,
+			"items": {"type": "object", "required": ["sku"], "properties": {"sku": {"type": "string", "pattern": "^[A-Z]{3}-\\d+$"}}}
+		}
+	},
+	"additionalProperties": false
+}`
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		schema string
+		data   map[string]interface{}
+		want   []string
+	}{
+		{
+			name:   "valid",
+			schema: orderSchema,
+			data: map[string]interface{}{
+				"customer": "ACME", "email": "buyer@example.com", "amount": 250.5, "quantity": 10,
+				"priority": "high", "items": []interface{}{map[string]interface{}{"sku": "ABC-1"}},
+			},
+		},
+		{
+			name:   "missing required fields",
+			schema: orderSchema,
+			data:   map[string]interface{}{},
+			want:   []string{"/amount required", "/customer required"},
+		},
+		{
+			name:   "wrong type",
+			schema: orderSchema,
+			data:   map[string]interface{}{"customer": 42, "amount": "a lot"},
+			want:   []string{"/amount type", "/customer type"},
+		},
+		{
+			name:   "string, number and enum limits",
+			schema: orderSchema,
+			data: map[string]interface{}{
+				"customer": "A", "email": "not an email", "amount": 0, "quantity": 7, "priority": "urgent",
+			},
+			want: []string{"/amount exclusiveMinimum", "/customer minLength", "/email format", "/priority enum", "/quantity multipleOf"},
+		},
+		{
+			name:   "integer given as a fraction",
+			schema: orderSchema,
+			data:   map[string]interface{}{"customer": "ACME", "amount": 1, "quantity": 2.5},
+			want:   []string{"/quantity type"},
+		},
+		{
+			name:   "array items",
+			schema: orderSchema,
+			data: map[string]interface{}{
+				"customer": "ACME", "amount": 1,
+				"items": []interface{}{
+					map[string]interface{}{"sku": "abc"},
+					map[string]interface{}{},
+					map[string]interface{}{},
+				},
+			},
+			want: []string{"/items/0/sku pattern", "/items/1/sku required", "/items/2 uniqueItems", "/items/2/sku required"},
+		},
+		{
+			name:   "additional property",
+			schema: orderSchema,
+			data:   map[string]interface{}{"customer": "ACME", "amount": 1, "discount": 5},
+			want:   []string{"/discount additionalProperties"},
+		},
+		{
+			name: "conditional requirement",
+			schema: `{
+				"type": "object",
+				"properties": {"country": {"type": "string"}, "state": {"type": "string"}},
+				"if": {"properties": {"country": {"const": "US"}}, "required": ["country"]},
+				"then": {"required": ["state"]}
+			}`,
+			data: map[string]interface{}{"country": "US"},
+			want: []string{"/state required"},
+		},
+		{
+			name: "oneOf matching two branches",
+			schema: `{
+				"oneOf": [{"properties": {"a": {"type": "number"}}}, {"properties": {"a": {"minimum": 0}}}]
+			}`,
+			data: map[string]interface{}{"a": 1},
+			want: []string{" oneOf"},
+		},
+		{
+			name: "unevaluated properties after allOf",
+			schema: `{
+				"allOf": [{"properties": {"name": {"type": "string"}}}],
+				"properties": {"age": {"type": "integer"}},
+				"unevaluatedProperties": false
+			}`,
+			data: map[string]interface{}{"name": "Ann", "age": 30, "extra": true},
+			want: []string{"/extra unevaluatedProperties"},
+		},
+		{
+			name: "dependent required",
+			schema: `{
+				"dependentRequired": {"credit_card": ["billing_address"]}
+			}`,
+			data: map[string]interface{}{"credit_card": "4111"},
+			want: []string{"/billing_address dependentRequired"},
+		},
+		{
+			name: "business rule",
+			schema: `{
+				"properties": {"start": {"type": "number"}, "end": {"type": "number"}},
+				"x-rules": [{"rule": "end >= start", "message": "must not end before it starts", "field": "end"}]
+			}`,
+			data: map[string]interface{}{"start": 5, "end": 3},
+			want: []string{"/end x-rules"},
+		},
+		{
+			name: "business rule over a missing property",
+			schema: `{
+				"x-rules": [{"rule": "end >= start", "field": "end"}]
+			}`,
+			data: map[string]interface{}{"start": 5},
+		},
+		{
+			name:   "file field",
+			schema: `{"properties": {"invoice": {"type": "file"}}}`,
+			data:   map[string]interface{}{"invoice": "not-an-id"},
+			want:   []string{"/invoice type"},
+		},
+		{
+			name:   "false schema",
+			schema: `{"properties": {"legacy": false}}`,
+			data:   map[string]interface{}{"legacy": 1},
+			want:   []string{"/legacy false"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := Parse(tt.schema)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got := problems(t, s.Validate(tt.data)); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCompile(t *testing.T) {
+	stored := map[string]string{
+		"address": `{
+			"type": "object",
+			"required": ["city"],
+			"properties": {"city": {"type": "string"}, "zip": {"$ref": "#/$defs/zip"}},
+			"$defs": {"zip": {"type": "string", "pattern": "^\\d{5}$"}}
+		}`,
+	}
+	tests := []struct {
+		name    string
+		schema  string
+		data    map[string]interface{}
+		want    []string
+		wantErr string
+	}{
+		{
+			name:   "reference to another form",
+			schema: `{"properties": {"shipping": {"$ref": "address"}}}`,
+			data:   map[string]interface{}{"shipping": map[string]interface{}{"zip": "123"}},
+			want:   []string{"/shipping/city required", "/shipping/zip pattern"},
+		},
+		{
+			name:   "reference to a definition of another form",
+			schema: `{"properties": {"zip": {"$ref": "form:address#/$defs/zip"}}}`,
+			data:   map[string]interface{}{"zip": "12345"},
+		},
+		{
+			name:   "local definition",
+			schema: `{"properties": {"tags": {"$ref": "#/$defs/tags"}}, "$defs": {"tags": {"type": "array", "maxItems": 1}}}`,
+			data:   map[string]interface{}{"tags": []interface{}{"a", "b"}},
+			want:   []string{"/tags maxItems"},
+		},
+		{name: "malformed JSON", schema: `{"type": `, wantErr: "invalid form schema"},
+		{name: "unknown type", schema: `{"type": "decimal"}`, wantErr: "type"},
+		{name: "invalid pattern", schema: `{"pattern": "("}`, wantErr: "pattern"},
+		{name: "invalid business rule", schema: `{"x-rules": [{"rule": "end >="}]}`, wantErr: "x-rules: 0: invalid expression"},
+		{name: "missing form", schema: `{"$ref": "customer"}`, wantErr: "form customer not found"},
+		{name: "missing definition", schema: `{"$ref": "address#/$defs/street"}`, wantErr: "$ref"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := Compile("order", tt.schema, loader(stored))
+			if tt.wantErr != "" {
+				if !errors.Is(err, ErrInvalidSchema) || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("got %v, want an invalid schema error containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got := problems(t, s.Validate(tt.data)); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFormats(t *testing.T) {
+	tests := []struct {
+		format string
+		value  string
+		valid  bool
+	}{
+		{"email", "ann@example.com", true},
+		{"email", "Ann <ann@example.com>", false},
+		{"date", "2025-02-28", true},
+		{"date", "2025-02-30", false},
+		{"date-time", "2025-02-28T09:30:00Z", true},
+		{"date-time", "2025-02-28 09:30", false},
+		{"time", "09:30:00Z", true},
+		{"duration", "P3DT4H", true},
+		{"duration", "3 days", false},
+		{"uuid", "0b0f7d3c-6a0e-4f5e-9d57-0c6d2c7c9a11", true},
+		{"uuid", "0b0f7d3c", false},
+		{"uri", "https://example.com/a?b=c", true},
+		{"uri", "/relative/path", false},
+		{"uri-reference", "/relative/path", true},
+		{"hostname", "api.example.com", true},
+		{"hostname", "-bad-.example.com", false},
+		{"ipv4", "192.168.0.1", true},
+		{"ipv4", "::1", false},
+		{"ipv6", "::1", true},
+		{"regex", "^[a-z]+$", true},
+		{"regex", "(", false},
+		{"json-pointer", "/items/0", true},
+		{"json-pointer", "items", false},
+		{"unknown-format", "anything", true},
+	}
+
+	for _, tt := range tests {
+		s, err := Parse(fmt.Sprintf(`{"properties": {"value": {"type": "string", "format": %q}}}`, tt.format))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		err = s.Validate(map[string]interface{}{"value": tt.value})
+		if valid := err == nil; valid != tt.valid {
+			t.Errorf("%s %q: got valid %v, want %v (%v)", tt.format, tt.value, valid, tt.valid, err)
+		}
+	}
+}
+
+func TestRegisterKeyword(t *testing.T) {
+	RegisterKeyword("x-even", func(arg, value interface{}) []FieldError {
+		n, ok := value.(float64)
+		if ok && arg == true && int(n)%2 != 0 {
+			return []FieldError{{Message: "must be even"}}
+		}
+		return nil
+	})
+	s, err := Parse(`{"properties": {"seats": {"type": "integer", "x-even": true}}}`)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	want := []string{"/seats x-even"}
+	if got := problems(t, s.Validate(map[string]interface{}{"seats": 3})); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if err := s.Validate(map[string]interface{}{"seats": 4}); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestOutputs(t *testing.T) {
+	s, err := Compile("order", `{
+		"properties": {"customer": {"type": "string"}},
+		"allOf": [{"$ref": "address"}],
+		"if": {"properties": {"express": {"const": true}}, "required": ["express"]},
+		"then": {"properties": {"express": true, "deadline": {"type": "string"}}}
+	}`, loader(map[string]string{"address": `{"properties": {"city": {"type": "string"}}}`}))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	tests := []struct {
+		name string
+		data map[string]interface{}
+		want map[string]interface{}
+	}{
+		{
+			name: "undeclared values are dropped",
+			data: map[string]interface{}{"customer": "ACME", "city": "Oslo", "internal": "x"},
+			want: map[string]interface{}{"customer": "ACME", "city": "Oslo"},
+		},
+		{
+			name: "properties of a matching branch",
+			data: map[string]interface{}{"customer": "ACME", "express": true, "deadline": "today"},
+			want: map[string]interface{}{"customer": "ACME", "express": true, "deadline": "today"},
+		},
+		{
+			name: "properties of a branch that did not match",
+			data: map[string]interface{}{"customer": "ACME", "express": false, "deadline": "today"},
+			want: map[string]interface{}{"customer": "ACME"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := s.Outputs(tt.data); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFiles(t *testing.T) {
+	s, err := Parse(`{
+		"properties": {
+			"invoice": {"type": "file", "accept": ["application/pdf"], "maxSize": 1048576},
+			"photos": {"type": "array", "items": {"type": "file", "accept": ["image/*"]}}
+		}
+	}`)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	invoice := "0b0f7d3c-6a0e-4f5e-9d57-0c6d2c7c9a11"
+	photo := "5d1c2b7e-0a5f-4c55-8f5a-3e9b1e6f2d22"
+	files := s.Files(map[string]interface{}{"invoice": invoice, "photos": []interface{}{photo}})
+
+	got := make(map[string]string, len(files))
+	for _, f := range files {
+		got[f.Pointer] = fmt.Sprintf("%s %v %d", f.ID, f.Accept, f.MaxSize)
+	}
+	want := map[string]string{
+		"/invoice":  invoice + " [application/pdf] 1048576",
+		"/photos/0": photo + " [image/*] 0",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,89 @@
+package forms
+
+import (
+	"strings"
+	"sync"
+
+	"github.com/tvolodi/ai-bpms-backend/shared/expression"
+)
+
+// KeywordFunc checks a value against the argument of a custom schema keyword
+// and returns the problems found. Pointers of the returned errors are relative
+// to the value, empty for the value itself; an empty Keyword is filled in with
+// the name of the keyword.
+type KeywordFunc func(arg, value interface{}) []FieldError
+
+var (
+	keywordsMu sync.RWMutex
+	keywords   = map[string]KeywordFunc{}
+)
+
+// RegisterKeyword adds a custom keyword for business checks that JSON Schema
+// cannot express. It applies to schemas compiled afterwards.
+func RegisterKeyword(name string, check KeywordFunc) {
+	keywordsMu.Lock()
+	defer keywordsMu.Unlock()
+	keywords[name] = check
+}
+
+// customKeyword is a registered keyword used in a schema, with its argument
+type customKeyword struct {
+	name string
+	arg  interface{}
+	fn   KeywordFunc
+}
+
+// customKeywords returns the registered keywords a schema object uses
+func customKeywords(m map[string]interface{}) []customKeyword {
+	keywordsMu.RLock()
+	defer keywordsMu.RUnlock()
+	var custom []customKeyword
+	for _, name := range sortedKeys(m) {
+		if check, ok := keywords[name]; ok {
+			custom = append(custom, customKeyword{name: name, arg: m[name], fn: check})
+		}
+	}
+	return custom
+}
+
+func (k customKeyword) check(value interface{}, pointer string, e *evaluation) {
+	for _, err := range k.fn(k.arg, value) {
+		err.Pointer = pointer + err.Pointer
+		if err.Keyword == "" {
+			err.Keyword = k.name
+		}
+		e.errs = append(e.errs, err)
+	}
+}
+
+// rule is a business rule of the x-rules keyword: an expression over the
+// properties of an object that must hold, with the message reported against
+// field (a property name or a relative JSON pointer) when it does not
+type rule struct {
+	expression string
+	message    string
+	field      string
+}
+
+// check evaluates the rule against an object. A rule that cannot be evaluated,
+// for example because a property it compares is missing, does not apply;
+// presence is what required is for.
+func (r rule) check(value interface{}, pointer string, e *evaluation) {
+	object, ok := value.(map[string]interface{})
+	if !ok {
+		return
+	}
+	holds, err := expression.EvaluateBool(r.expression, object)
+	if err != nil || holds {
+		return
+	}
+
+	switch {
+	case r.field == "":
+	case strings.HasPrefix(r.field, "/"):
+		pointer += r.field
+	default:
+		pointer += "/" + escape(r.field)
+	}
+	e.fail(pointer, "x-rules", "%s", r.message)
+}
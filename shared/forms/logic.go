@@ -0,0 +1,453 @@
+package forms
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"strings"
+
+	"github.com/tvolodi/ai-bpms-backend/shared/expression"
+)
+
+// logicKeyword is the UI schema keyword holding the form logic
+const logicKeyword = "x-logic"
+
+// FieldState is how the form logic presents a field for the current data:
+// whether it is shown, whether it must be filled in and, for a dropdown, the
+// options it offers
+type FieldState struct {
+	Visible  bool          `json:"visible"`
+	Required bool          `json:"required"`
+	Options  []interface{} `json:"options,omitempty"`
+}
+
+// fieldLogic is the logic of one field of the x-logic keyword of a UI schema,
+// which maps fields to their rules:
+//
+//	"x-logic": {
+//	  "iban":            {"visibleIf": "payment_method == 'bank'", "requiredIf": "true"},
+//	  "/items/*/amount": {"compute": "row.quantity * row.price"},
+//	  "total":           {"compute": "sum(map(items, .amount))"},
+//	  "city":            {"dependsOn": "country", "options": {"KZ": ["Almaty", "Astana"]}}
+//	}
+//
+// A field is a property name or a JSON pointer in which * stands for every item
+// of an array. Conditions and computations are expressions over the form data;
+// for a field nested in an object or array item, row is the object holding it.
+// options is a list, an object of lists keyed by the value of the dependsOn
+// field, or an expression returning a list.
+type fieldLogic struct {
+	pointer    string
+	visibleIf  string
+	hiddenIf   string
+	requiredIf string
+	compute    string
+	options    interface{}
+	dependsOn  string
+}
+
+// field is a value the logic of a field applies to: its pointer in the form
+// data and the object holding it
+type field struct {
+	pointer string
+	row     map[string]interface{}
+	name    string
+}
+
+// logicOutcome is the result of applying the form logic to form data
+type logicOutcome struct {
+	errs   []FieldError
+	hidden []string
+	states map[string]FieldState
+}
+
+// WithUISchema returns a copy of the schema that also enforces the x-logic
+// keyword of a UI schema: hidden fields are dropped from the data and cannot
+// fail validation, conditionally required fields must be filled in, computed
+// fields are filled in and must match when submitted, and dropdown values must
+// be among the options offered.
+func (s *Schema) WithUISchema(source string) (*Schema, error) {
+	logic, err := parseLogic(source)
+	if err != nil {
+		return nil, fmt.Errorf("%w: ui schema: %w", ErrInvalidSchema, err)
+	}
+	return &Schema{root: s.root, dynamicAnchors: s.dynamicAnchors, logic: logic}, nil
+}
+
+// Apply runs the form logic of the UI schema over form data and returns the
+// data with computed fields filled in and hidden fields dropped, and the state
+// of every field the logic applies to by JSON pointer
+func (s *Schema) Apply(data map[string]interface{}) (map[string]interface{}, map[string]FieldState) {
+	value, outcome := s.applyLogic(data)
+	return value, outcome.states
+}
+
+// applyLogic normalizes form data and runs the form logic over it
+func (s *Schema) applyLogic(data map[string]interface{}) (map[string]interface{}, *logicOutcome) {
+	value, _ := normalize(data).(map[string]interface{})
+	if value == nil {
+		value = map[string]interface{}{}
+	}
+	outcome := &logicOutcome{states: map[string]FieldState{}}
+	if len(s.logic) == 0 {
+		return value, outcome
+	}
+
+	computeFields(s.logic, value, outcome)
+	var visible []field
+	var visibleLogic []fieldLogic
+	for _, l := range s.logic {
+		for _, f := range expand(l.pointer, value) {
+			env := environment(value, f)
+			shown := (l.visibleIf == "" || holds(l.visibleIf, env)) && (l.hiddenIf == "" || !holds(l.hiddenIf, env))
+			state := outcome.states[f.pointer]
+			state.Visible = shown
+			outcome.states[f.pointer] = state
+			if !shown {
+				outcome.hidden = append(outcome.hidden, f.pointer)
+				continue
+			}
+			visible = append(visible, f)
+			visibleLogic = append(visibleLogic, l)
+		}
+	}
+	for i, f := range visible {
+		l := visibleLogic[i]
+		env := environment(value, f)
+		state := outcome.states[f.pointer]
+		if l.requiredIf != "" && holds(l.requiredIf, env) {
+			state.Required = true
+			if isEmpty(f.row[f.name]) {
+				outcome.errs = append(outcome.errs, FieldError{Pointer: f.pointer, Keyword: "requiredIf", Message: "is required"})
+			}
+		}
+		if l.options != nil {
+			state.Options = optionsOf(l, value, f, env)
+			if current, ok := f.row[f.name]; ok && !offered(state.Options, current) {
+				outcome.errs = append(outcome.errs, FieldError{Pointer: f.pointer, Keyword: "options", Message: "is not one of the available options"})
+			}
+		}
+		outcome.states[f.pointer] = state
+	}
+
+	// Hidden fields are dropped only once every condition has seen the same data
+	for _, l := range s.logic {
+		for _, f := range expand(l.pointer, value) {
+			if !outcome.states[f.pointer].Visible {
+				delete(f.row, f.name)
+			}
+		}
+	}
+	return value, outcome
+}
+
+// computeFields fills in computed fields, reporting submitted values that do
+// not match. Computations may use other computed fields, so they run until the
+// values settle. A computation that cannot be evaluated leaves the field empty.
+func computeFields(logic []fieldLogic, value map[string]interface{}, outcome *logicOutcome) {
+	submitted := map[string]interface{}{}
+	for _, l := range logic {
+		if l.compute == "" {
+			continue
+		}
+		for _, f := range expand(l.pointer, value) {
+			if current, ok := f.row[f.name]; ok {
+				submitted[f.pointer] = current
+			}
+			delete(f.row, f.name)
+		}
+	}
+
+	computed := map[string]interface{}{}
+	for pass := 0; pass <= len(logic); pass++ {
+		changed := false
+		for _, l := range logic {
+			if l.compute == "" {
+				continue
+			}
+			for _, f := range expand(l.pointer, value) {
+				result, err := expression.Evaluate(l.compute, environment(value, f))
+				if err != nil {
+					continue
+				}
+				result = normalizeValue(result)
+				if previous, ok := computed[f.pointer]; ok && sameValue(previous, result) {
+					continue
+				}
+				computed[f.pointer] = result
+				f.row[f.name] = result
+				changed = true
+			}
+		}
+		if !changed {
+			break
+		}
+	}
+
+	for _, pointer := range sortedKeys(submitted) {
+		result, ok := computed[pointer]
+		if ok && sameValue(submitted[pointer], result) {
+			continue
+		}
+		outcome.errs = append(outcome.errs, FieldError{
+			Pointer: pointer,
+			Keyword: "compute",
+			Message: "does not match the computed value",
+		})
+	}
+}
+
+// optionsOf returns the options a dropdown offers for the current data
+func optionsOf(l fieldLogic, value map[string]interface{}, f field, env map[string]interface{}) []interface{} {
+	switch options := l.options.(type) {
+	case []interface{}:
+		return options
+	case map[string]interface{}:
+		var parent interface{}
+		if strings.HasPrefix(l.dependsOn, "/") {
+			parent, _ = lookupPointer(value, l.dependsOn)
+		} else {
+			parent = f.row[l.dependsOn]
+		}
+		if parent == nil {
+			return []interface{}{}
+		}
+		list, _ := options[fmt.Sprint(parent)].([]interface{})
+		if list == nil {
+			return []interface{}{}
+		}
+		return list
+	case string:
+		result, err := expression.Evaluate(options, env)
+		if err != nil {
+			return []interface{}{}
+		}
+		list, _ := normalizeValue(result).([]interface{})
+		if list == nil {
+			return []interface{}{}
+		}
+		return list
+	}
+	return []interface{}{}
+}
+
+// offered reports whether a dropdown value, or every value of a multiple
+// choice, is among the options
+func offered(options []interface{}, value interface{}) bool {
+	if value == nil {
+		return true
+	}
+	if values, ok := value.([]interface{}); ok {
+		for _, v := range values {
+			if !contains(options, v) {
+				return false
+			}
+		}
+		return true
+	}
+	return contains(options, value)
+}
+
+// holds evaluates a condition; one that cannot be evaluated does not hold
+func holds(condition string, env map[string]interface{}) bool {
+	ok, err := expression.EvaluateBool(condition, env)
+	return err == nil && ok
+}
+
+// environment returns the variables the expressions of a field see: the form
+// data, and row for a nested field
+func environment(value map[string]interface{}, f field) map[string]interface{} {
+	if strings.Count(f.pointer, "/") < 2 {
+		return value
+	}
+	env := make(map[string]interface{}, len(value)+1)
+	for k, v := range value {
+		env[k] = v
+	}
+	env["row"] = f.row
+	return env
+}
+
+// isEmpty reports whether a required value is missing
+func isEmpty(value interface{}) bool {
+	switch v := value.(type) {
+	case nil:
+		return true
+	case string:
+		return strings.TrimSpace(v) == ""
+	case []interface{}:
+		return len(v) == 0
+	}
+	return false
+}
+
+// sameValue compares computed values, allowing for floating point rounding
+func sameValue(a, b interface{}) bool {
+	x, okA := a.(float64)
+	y, okB := b.(float64)
+	if okA && okB {
+		return math.Abs(x-y) <= 1e-9*math.Max(1, math.Max(math.Abs(x), math.Abs(y)))
+	}
+	return equal(a, b)
+}
+
+// expand returns the values a field pointer designates in the form data, one
+// per array item for each *. Missing objects along the way designate nothing.
+func expand(pointer string, value map[string]interface{}) []field {
+	segments := strings.Split(pointer, "/")[1:]
+	var fields []field
+	var walk func(node interface{}, prefix string, rest []string)
+	walk = func(node interface{}, prefix string, rest []string) {
+		if len(rest) == 1 {
+			if row, ok := node.(map[string]interface{}); ok {
+				name := unescape(rest[0])
+				fields = append(fields, field{pointer: prefix + "/" + rest[0], row: row, name: name})
+			}
+			return
+		}
+		switch n := node.(type) {
+		case []interface{}:
+			if rest[0] != "*" {
+				return
+			}
+			for i, item := range n {
+				walk(item, fmt.Sprintf("%s/%d", prefix, i), rest[1:])
+			}
+		case map[string]interface{}:
+			if child, ok := n[unescape(rest[0])]; ok {
+				walk(child, prefix+"/"+rest[0], rest[1:])
+			}
+		}
+	}
+	walk(value, "", segments)
+	return fields
+}
+
+// lookupPointer returns the value at a JSON pointer in the form data
+func lookupPointer(value interface{}, pointer string) (interface{}, bool) {
+	for _, segment := range strings.Split(pointer, "/")[1:] {
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		if value, ok = object[unescape(segment)]; !ok {
+			return nil, false
+		}
+	}
+	return value, true
+}
+
+// hiddenError reports whether a validation error is about a hidden field or
+// something inside one
+func hiddenError(err FieldError, hidden []string) bool {
+	for _, pointer := range hidden {
+		if err.Pointer == pointer || strings.HasPrefix(err.Pointer, pointer+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// parseLogic reads the x-logic keyword of a UI schema
+func parseLogic(source string) ([]fieldLogic, error) {
+	if strings.TrimSpace(source) == "" {
+		return nil, nil
+	}
+	var ui map[string]interface{}
+	if err := json.Unmarshal([]byte(source), &ui); err != nil {
+		return nil, err
+	}
+	raw, ok := ui[logicKeyword]
+	if !ok {
+		return nil, nil
+	}
+	fields, ok := raw.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("%s must be an object of fields", logicKeyword)
+	}
+
+	logic := make([]fieldLogic, 0, len(fields))
+	for _, name := range sortedKeys(fields) {
+		l, err := parseFieldLogic(name, fields[name])
+		if err != nil {
+			return nil, fmt.Errorf("%s/%s: %w", logicKeyword, escape(name), err)
+		}
+		logic = append(logic, l)
+	}
+	return logic, nil
+}
+
+// parseFieldLogic reads the rules of one field
+func parseFieldLogic(name string, raw interface{}) (fieldLogic, error) {
+	l := fieldLogic{pointer: name}
+	if !strings.HasPrefix(name, "/") {
+		l.pointer = "/" + escape(name)
+	}
+	if strings.HasSuffix(l.pointer, "/*") {
+		return l, fmt.Errorf("a field must end with a property name")
+	}
+	m, ok := raw.(map[string]interface{})
+	if !ok {
+		return l, fmt.Errorf("must be an object")
+	}
+
+	for _, keyword := range sortedKeys(m) {
+		switch keyword {
+		case "visibleIf", "hiddenIf", "requiredIf", "compute":
+			source, ok := m[keyword].(string)
+			if !ok || source == "" {
+				return l, fmt.Errorf("%s must be an expression", keyword)
+			}
+			if _, err := expression.Compile(source); err != nil {
+				return l, fmt.Errorf("%s: %w", keyword, err)
+			}
+			switch keyword {
+			case "visibleIf":
+				l.visibleIf = source
+			case "hiddenIf":
+				l.hiddenIf = source
+			case "requiredIf":
+				l.requiredIf = source
+			case "compute":
+				l.compute = source
+			}
+		case "dependsOn":
+			if l.dependsOn, ok = m[keyword].(string); !ok || l.dependsOn == "" {
+				return l, fmt.Errorf("dependsOn must be a property name or a JSON pointer")
+			}
+		case "options":
+			switch options := m[keyword].(type) {
+			case []interface{}:
+			case map[string]interface{}:
+				for _, key := range sortedKeys(options) {
+					if _, ok := options[key].([]interface{}); !ok {
+						return l, fmt.Errorf("options/%s must be an array", escape(key))
+					}
+				}
+			case string:
+				if _, err := expression.Compile(options); err != nil {
+					return l, fmt.Errorf("options: %w", err)
+				}
+			default:
+				return l, fmt.Errorf("options must be an array, an object of arrays or an expression")
+			}
+			l.options = m[keyword]
+		default:
+			return l, fmt.Errorf("unknown keyword %q", keyword)
+		}
+	}
+
+	_, byParent := l.options.(map[string]interface{})
+	switch {
+	case byParent && l.dependsOn == "":
+		return l, fmt.Errorf("options keyed by value require dependsOn")
+	case !byParent && l.dependsOn != "":
+		return l, fmt.Errorf("dependsOn requires options keyed by value")
+	}
+	return l, nil
+}
+
+// unescape decodes a JSON pointer segment
+func unescape(segment string) string {
+	return strings.NewReplacer("~1", "/", "~0", "~").Replace(segment)
+}
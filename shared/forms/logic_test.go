@@ -0,0 +1,254 @@
+package forms
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const paymentSchema = `{
+	"type": "object",
+	"properties": {
+		"payment_method": {"enum": ["bank", "card"]},
+		"iban":           {"type": "string", "pattern": "^[A-Z]{2}\\d{2}[A-Z0-9]+$"},
+		"country":        {"type": "string"},
+		"city":           {"type": "string"},
+		"items": {
+			"type": "array",
+			"items": {
+				"type": "object",
+				"properties": {"quantity": {"type": "number"}, "price": {"type": "number"}, "amount": {"type": "number"}}
+			}
+		},
+		"total":    {"type": "number", "maximum": 1000},
+		"discount": {"type": "number"}
+	}
+}`
+
+const paymentLogic = `{
+	"x-logic": {
+		"iban":            {"visibleIf": "payment_method == 'bank'", "requiredIf": "true"},
+		"/items/*/amount": {"compute": "row.quantity * row.price"},
+		"total":           {"compute": "sum(map(items, .amount))"},
+		"discount":        {"hiddenIf": "total < 100", "options": "total >= 500 ? [5, 10] : [5]"},
+		"city":            {"dependsOn": "country", "options": {"KZ": ["Almaty", "Astana"], "NO": ["Oslo"]}}
+	}
+}`
+
+func paymentForm(t *testing.T) *Schema {
+	t.Helper()
+	s, err := Parse(paymentSchema)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if s, err = s.WithUISchema(paymentLogic); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return s
+}
+
+func TestFormLogic(t *testing.T) {
+	s := paymentForm(t)
+	tests := []struct {
+		name       string
+		data       map[string]interface{}
+		wantData   map[string]interface{}
+		wantFields map[string]FieldState // states of the fields to check, by pointer
+		want       []string
+	}{
+		{
+			name:     "required when shown",
+			data:     map[string]interface{}{"payment_method": "bank"},
+			wantData: map[string]interface{}{"payment_method": "bank"},
+			wantFields: map[string]FieldState{
+				"/iban": {Visible: true, Required: true},
+			},
+			want: []string{"/iban requiredIf"},
+		},
+		{
+			name:     "hidden fields are dropped and not validated",
+			data:     map[string]interface{}{"payment_method": "card", "iban": "not an iban"},
+			wantData: map[string]interface{}{"payment_method": "card"},
+			wantFields: map[string]FieldState{
+				"/iban": {Visible: false},
+			},
+		},
+		{
+			name: "computed fields",
+			data: map[string]interface{}{
+				"items": []interface{}{
+					map[string]interface{}{"quantity": 2, "price": 50},
+					map[string]interface{}{"quantity": 1, "price": 25.5},
+				},
+			},
+			wantData: map[string]interface{}{
+				"items": []interface{}{
+					map[string]interface{}{"quantity": 2.0, "price": 50.0, "amount": 100.0},
+					map[string]interface{}{"quantity": 1.0, "price": 25.5, "amount": 25.5},
+				},
+				"total": 125.5,
+			},
+			wantFields: map[string]FieldState{
+				"/iban":     {Visible: false},
+				"/discount": {Visible: true, Options: []interface{}{5.0}},
+			},
+		},
+		{
+			name: "submitted value that does not match the computation",
+			data: map[string]interface{}{
+				"items": []interface{}{map[string]interface{}{"quantity": 2, "price": 50, "amount": 90}},
+				"total": 100,
+			},
+			wantData: map[string]interface{}{
+				"items": []interface{}{map[string]interface{}{"quantity": 2.0, "price": 50.0, "amount": 100.0}},
+				"total": 100.0,
+			},
+			wantFields: map[string]FieldState{
+				"/iban":     {Visible: false},
+				"/discount": {Visible: true, Options: []interface{}{5.0}},
+			},
+			want: []string{"/items/0/amount compute"},
+		},
+		{
+			name: "computed value checked by the schema",
+			data: map[string]interface{}{
+				"items": []interface{}{map[string]interface{}{"quantity": 3, "price": 500}},
+			},
+			wantData: map[string]interface{}{
+				"items": []interface{}{map[string]interface{}{"quantity": 3.0, "price": 500.0, "amount": 1500.0}},
+				"total": 1500.0,
+			},
+			wantFields: map[string]FieldState{
+				"/iban":     {Visible: false},
+				"/discount": {Visible: true, Options: []interface{}{5.0, 10.0}},
+			},
+			want: []string{"/total maximum"},
+		},
+		{
+			name: "option outside an expression's list",
+			data: map[string]interface{}{
+				"items":    []interface{}{map[string]interface{}{"quantity": 1, "price": 200}},
+				"discount": 10,
+			},
+			wantData: map[string]interface{}{
+				"items":    []interface{}{map[string]interface{}{"quantity": 1.0, "price": 200.0, "amount": 200.0}},
+				"total":    200.0,
+				"discount": 10.0,
+			},
+			wantFields: map[string]FieldState{
+				"/iban":     {Visible: false},
+				"/discount": {Visible: true, Options: []interface{}{5.0}},
+			},
+			want: []string{"/discount options"},
+		},
+		{
+			name: "hidden by a computed value",
+			data: map[string]interface{}{
+				"items":    []interface{}{map[string]interface{}{"quantity": 1, "price": 50}},
+				"discount": 10,
+			},
+			wantData: map[string]interface{}{
+				"items": []interface{}{map[string]interface{}{"quantity": 1.0, "price": 50.0, "amount": 50.0}},
+				"total": 50.0,
+			},
+			wantFields: map[string]FieldState{
+				"/discount": {Visible: false},
+			},
+		},
+		{
+			name:     "cascading options",
+			data:     map[string]interface{}{"country": "NO", "city": "Almaty"},
+			wantData: map[string]interface{}{"country": "NO", "city": "Almaty"},
+			wantFields: map[string]FieldState{
+				"/iban": {Visible: false},
+				"/city": {Visible: true, Options: []interface{}{"Oslo"}},
+			},
+			want: []string{"/city options"},
+		},
+		{
+			name:     "cascading options without a parent value",
+			data:     map[string]interface{}{},
+			wantData: map[string]interface{}{},
+			wantFields: map[string]FieldState{
+				"/iban": {Visible: false},
+				"/city": {Visible: true, Options: []interface{}{}},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, fields := s.Apply(tt.data)
+			if !reflect.DeepEqual(data, tt.wantData) {
+				t.Errorf("got data %v, want %v", data, tt.wantData)
+			}
+			for pointer, want := range tt.wantFields {
+				if got, ok := fields[pointer]; !ok || !reflect.DeepEqual(got, want) {
+					t.Errorf("got %s %+v, want %+v", pointer, got, want)
+				}
+			}
+			if got := problems(t, s.Validate(tt.data)); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFormLogicOutputs(t *testing.T) {
+	s := paymentForm(t)
+	outputs := s.Outputs(map[string]interface{}{
+		"payment_method": "card",
+		"iban":           "NO9386011117947",
+		"items":          []interface{}{map[string]interface{}{"quantity": 4, "price": 10}},
+	})
+
+	want := map[string]interface{}{
+		"payment_method": "card",
+		"items":          []interface{}{map[string]interface{}{"quantity": 4.0, "price": 10.0, "amount": 40.0}},
+		"total":          40.0,
+	}
+	if !reflect.DeepEqual(outputs, want) {
+		t.Errorf("got %v, want %v", outputs, want)
+	}
+}
+
+func TestWithUISchema(t *testing.T) {
+	tests := []struct {
+		name    string
+		ui      string
+		wantErr string
+	}{
+		{name: "empty", ui: ""},
+		{name: "no form logic", ui: `{"ui:order": ["iban"]}`},
+		{name: "malformed JSON", ui: `{"x-logic": `, wantErr: "ui schema"},
+		{name: "not an object", ui: `{"x-logic": ["iban"]}`, wantErr: "x-logic must be an object of fields"},
+		{name: "unknown keyword", ui: `{"x-logic": {"iban": {"showIf": "true"}}}`, wantErr: `x-logic/iban: unknown keyword "showIf"`},
+		{name: "invalid condition", ui: `{"x-logic": {"iban": {"visibleIf": "payment_method =="}}}`, wantErr: "x-logic/iban: visibleIf: invalid expression"},
+		{name: "empty computation", ui: `{"x-logic": {"total": {"compute": ""}}}`, wantErr: "compute must be an expression"},
+		{name: "field ending in a wildcard", ui: `{"x-logic": {"/items/*": {"compute": "1"}}}`, wantErr: "a field must end with a property name"},
+		{name: "options of the wrong type", ui: `{"x-logic": {"city": {"options": 3}}}`, wantErr: "options must be an array, an object of arrays or an expression"},
+		{name: "keyed options that are not lists", ui: `{"x-logic": {"city": {"dependsOn": "country", "options": {"NO": "Oslo"}}}}`, wantErr: "options/NO must be an array"},
+		{name: "keyed options without dependsOn", ui: `{"x-logic": {"city": {"options": {"NO": ["Oslo"]}}}}`, wantErr: "options keyed by value require dependsOn"},
+		{name: "dependsOn with a list", ui: `{"x-logic": {"city": {"dependsOn": "country", "options": ["Oslo"]}}}`, wantErr: "dependsOn requires options keyed by value"},
+	}
+
+	s, err := Parse(paymentSchema)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := s.WithUISchema(tt.ui)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+			if !errors.Is(err, ErrInvalidSchema) || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("got %v, want an invalid schema error containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
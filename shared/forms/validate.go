@@ -0,0 +1,511 @@
+package forms
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+)
+
+// maxDepth bounds the nesting of schemas applied to one value, which stops
+// references that loop without descending into the value
+const maxDepth = 200
+
+// evaluation is the outcome of applying a schema to a value: the problems
+// found and the annotations that keywords like unevaluatedProperties and the
+// form outputs rely on
+type evaluation struct {
+	errs []FieldError
+
+	// props and items are the properties and array items that were evaluated;
+	// allItems is set once every item was
+	props    map[string]bool
+	items    map[int]bool
+	allItems bool
+
+	// declared are the properties named in the properties keyword
+	declared map[string]bool
+
+	files []FileRef
+}
+
+func (e *evaluation) valid() bool {
+	return len(e.errs) == 0
+}
+
+func (e *evaluation) fail(pointer, keyword, format string, args ...interface{}) {
+	e.errs = append(e.errs, FieldError{Pointer: pointer, Keyword: keyword, Message: fmt.Sprintf(format, args...)})
+}
+
+// inPlace takes over the outcome of a subschema applied to the same value.
+// Annotations of a subschema that failed are dropped.
+func (e *evaluation) inPlace(sub *evaluation) {
+	e.errs = append(e.errs, sub.errs...)
+	if sub.valid() {
+		e.annotate(sub)
+	}
+}
+
+// annotate merges the annotations of a successful subschema of the same value
+func (e *evaluation) annotate(sub *evaluation) {
+	for name := range sub.props {
+		e.evaluatedProperty(name)
+	}
+	for name := range sub.declared {
+		if e.declared == nil {
+			e.declared = make(map[string]bool)
+		}
+		e.declared[name] = true
+	}
+	for i := range sub.items {
+		e.evaluatedItem(i)
+	}
+	e.allItems = e.allItems || sub.allItems
+	e.files = append(e.files, sub.files...)
+}
+
+// child takes over the outcome of a schema applied to a property or item
+func (e *evaluation) child(sub *evaluation) {
+	e.errs = append(e.errs, sub.errs...)
+	e.files = append(e.files, sub.files...)
+}
+
+func (e *evaluation) evaluatedProperty(name string) {
+	if e.props == nil {
+		e.props = make(map[string]bool)
+	}
+	e.props[name] = true
+}
+
+func (e *evaluation) evaluatedItem(i int) {
+	if e.items == nil {
+		e.items = make(map[int]bool)
+	}
+	e.items[i] = true
+}
+
+// validator applies compiled schemas to a value, tracking the dynamic scope
+// that $dynamicRef is resolved in
+type validator struct {
+	dynamicAnchors map[string]map[string]*schema
+	scope          []string
+	depth          int
+}
+
+// eval applies a schema to the value at pointer
+func (v *validator) eval(s *schema, value interface{}, pointer string) *evaluation {
+	e := &evaluation{}
+	if s.always != nil {
+		if !*s.always {
+			e.fail(pointer, "false", "is not allowed")
+		}
+		return e
+	}
+
+	v.depth++
+	defer func() { v.depth-- }()
+	if v.depth > maxDepth {
+		e.fail(pointer, "$ref", "is nested too deeply for the schema")
+		return e
+	}
+	if len(v.scope) == 0 || v.scope[len(v.scope)-1] != s.base {
+		v.scope = append(v.scope, s.base)
+		defer func() { v.scope = v.scope[:len(v.scope)-1] }()
+	}
+
+	if s.ref != nil {
+		e.inPlace(v.eval(s.ref, value, pointer))
+	}
+	if s.dynamicRef != nil {
+		e.inPlace(v.eval(v.resolveDynamic(s.dynamicRef), value, pointer))
+	}
+
+	if len(s.types) > 0 && !hasType(value, s.types) {
+		e.fail(pointer, "type", "must be of type %s", strings.Join(s.types, " or "))
+		return e
+	}
+	if s.hasEnum && !contains(s.enum, value) {
+		e.fail(pointer, "enum", "must be one of the allowed values")
+	}
+	if s.hasConst && !equal(s.constant, value) {
+		e.fail(pointer, "const", "must be %v", s.constant)
+	}
+
+	v.applicators(s, value, pointer, e)
+
+	switch val := value.(type) {
+	case string:
+		v.evalString(s, val, pointer, e)
+	case float64:
+		evalNumber(s, val, pointer, e)
+	case []interface{}:
+		v.evalArray(s, val, pointer, e)
+	case map[string]interface{}:
+		v.evalObject(s, val, pointer, e)
+	}
+
+	for _, r := range s.rules {
+		r.check(value, pointer, e)
+	}
+	for _, keyword := range s.custom {
+		keyword.check(value, pointer, e)
+	}
+	return e
+}
+
+// resolveDynamic resolves a $dynamicRef to the outermost schema resource in
+// the dynamic scope that declares its anchor
+func (v *validator) resolveDynamic(d *dynamicRef) *schema {
+	if d.dynamic {
+		for _, base := range v.scope {
+			if s, ok := v.dynamicAnchors[base][d.name]; ok {
+				return s
+			}
+		}
+	}
+	return d.target
+}
+
+// applicators applies the subschemas that combine with the schema on the same value
+func (v *validator) applicators(s *schema, value interface{}, pointer string, e *evaluation) {
+	for _, sub := range s.allOf {
+		e.inPlace(v.eval(sub, value, pointer))
+	}
+
+	if s.anyOf != nil {
+		matched := false
+		for _, sub := range s.anyOf {
+			result := v.eval(sub, value, pointer)
+			if result.valid() {
+				matched = true
+				e.annotate(result)
+			}
+		}
+		if !matched {
+			e.fail(pointer, "anyOf", "must match at least one of the allowed schemas")
+		}
+	}
+
+	if s.oneOf != nil {
+		var match *evaluation
+		matches := 0
+		for _, sub := range s.oneOf {
+			if result := v.eval(sub, value, pointer); result.valid() {
+				matches++
+				match = result
+			}
+		}
+		if matches == 1 {
+			e.annotate(match)
+		} else {
+			e.fail(pointer, "oneOf", "must match exactly one of the allowed schemas")
+		}
+	}
+
+	if s.not != nil && v.eval(s.not, value, pointer).valid() {
+		e.fail(pointer, "not", "must not match the schema")
+	}
+
+	if s.ifSchema != nil {
+		condition := v.eval(s.ifSchema, value, pointer)
+		if condition.valid() {
+			e.annotate(condition)
+			if s.thenSchema != nil {
+				e.inPlace(v.eval(s.thenSchema, value, pointer))
+			}
+		} else if s.elseSchema != nil {
+			e.inPlace(v.eval(s.elseSchema, value, pointer))
+		}
+	}
+
+	if object, ok := value.(map[string]interface{}); ok {
+		for _, name := range sortedKeys(s.dependentSchemas) {
+			if _, present := object[name]; present {
+				e.inPlace(v.eval(s.dependentSchemas[name], value, pointer))
+			}
+		}
+	}
+}
+
+// evalString checks the length, pattern and format of a string, and collects
+// the attachment a file field refers to
+func (v *validator) evalString(s *schema, value, pointer string, e *evaluation) {
+	length := utf8.RuneCountInString(value)
+	if s.minLength != nil && length < *s.minLength {
+		e.fail(pointer, "minLength", "must be at least %d characters long", *s.minLength)
+	}
+	if s.maxLength != nil && length > *s.maxLength {
+		e.fail(pointer, "maxLength", "must be at most %d characters long", *s.maxLength)
+	}
+	if s.pattern != nil && !s.pattern.MatchString(value) {
+		e.fail(pointer, "pattern", "must match the pattern %s", s.pattern)
+	}
+	if s.format != "" {
+		if check, ok := lookupFormat(s.format); ok && !check(value) {
+			e.fail(pointer, "format", "must be a valid %s", s.format)
+		}
+	}
+
+	if containsType(s.types, "file") {
+		if id, err := uuid.Parse(value); err == nil {
+			e.files = append(e.files, FileRef{Pointer: pointer, ID: id, Accept: s.accept, MaxSize: s.maxSize})
+		}
+	}
+}
+
+// evalNumber checks the range and multiples of a number
+func evalNumber(s *schema, value float64, pointer string, e *evaluation) {
+	if s.multipleOf != nil {
+		q := value / *s.multipleOf
+		if math.IsInf(q, 0) || math.Abs(q-math.Round(q)) > 1e-9 {
+			e.fail(pointer, "multipleOf", "must be a multiple of %v", *s.multipleOf)
+		}
+	}
+	if s.minimum != nil && value < *s.minimum {
+		e.fail(pointer, "minimum", "must be at least %v", *s.minimum)
+	}
+	if s.exclusiveMinimum != nil && value <= *s.exclusiveMinimum {
+		e.fail(pointer, "exclusiveMinimum", "must be greater than %v", *s.exclusiveMinimum)
+	}
+	if s.maximum != nil && value > *s.maximum {
+		e.fail(pointer, "maximum", "must be at most %v", *s.maximum)
+	}
+	if s.exclusiveMaximum != nil && value >= *s.exclusiveMaximum {
+		e.fail(pointer, "exclusiveMaximum", "must be less than %v", *s.exclusiveMaximum)
+	}
+}
+
+// evalArray checks the items of an array
+func (v *validator) evalArray(s *schema, items []interface{}, pointer string, e *evaluation) {
+	if s.minItems != nil && len(items) < *s.minItems {
+		e.fail(pointer, "minItems", "must have at least %d items", *s.minItems)
+	}
+	if s.maxItems != nil && len(items) > *s.maxItems {
+		e.fail(pointer, "maxItems", "must have at most %d items", *s.maxItems)
+	}
+	if s.uniqueItems {
+	unique:
+		for i := range items {
+			for j := i + 1; j < len(items); j++ {
+				if equal(items[i], items[j]) {
+					e.fail(pointer+"/"+strconv.Itoa(j), "uniqueItems", "must not repeat item %d", i)
+					break unique
+				}
+			}
+		}
+	}
+
+	for i, sub := range s.prefixItems {
+		if i >= len(items) {
+			break
+		}
+		e.child(v.eval(sub, items[i], pointer+"/"+strconv.Itoa(i)))
+		e.evaluatedItem(i)
+	}
+	if s.items != nil {
+		for i := len(s.prefixItems); i < len(items); i++ {
+			e.child(v.eval(s.items, items[i], pointer+"/"+strconv.Itoa(i)))
+		}
+		e.allItems = true
+	}
+
+	if s.contains != nil {
+		matches := 0
+		for i, item := range items {
+			if v.eval(s.contains, item, pointer+"/"+strconv.Itoa(i)).valid() {
+				matches++
+				e.evaluatedItem(i)
+			}
+		}
+		min := 1
+		if s.minContains != nil {
+			min = *s.minContains
+		}
+		if matches < min {
+			e.fail(pointer, "contains", "must contain at least %d matching items", min)
+		}
+		if s.maxContains != nil && matches > *s.maxContains {
+			e.fail(pointer, "maxContains", "must contain at most %d matching items", *s.maxContains)
+		}
+	}
+
+	if s.unevaluatedItems != nil && !e.allItems {
+		for i, item := range items {
+			if e.items[i] {
+				continue
+			}
+			child := pointer + "/" + strconv.Itoa(i)
+			if isFalse(s.unevaluatedItems) {
+				e.fail(child, "unevaluatedItems", "is not allowed")
+				continue
+			}
+			e.child(v.eval(s.unevaluatedItems, item, child))
+		}
+		e.allItems = true
+	}
+}
+
+// evalObject checks the required, declared and undeclared properties of an object
+func (v *validator) evalObject(s *schema, object map[string]interface{}, pointer string, e *evaluation) {
+	for _, name := range s.required {
+		if _, ok := object[name]; !ok {
+			e.fail(pointer+"/"+escape(name), "required", "is required")
+		}
+	}
+	for _, name := range sortedKeys(s.dependentRequired) {
+		if _, present := object[name]; !present {
+			continue
+		}
+		for _, dependent := range s.dependentRequired[name] {
+			if _, ok := object[dependent]; !ok {
+				e.fail(pointer+"/"+escape(dependent), "dependentRequired", "is required when %s is present", name)
+			}
+		}
+	}
+	if s.minProperties != nil && len(object) < *s.minProperties {
+		e.fail(pointer, "minProperties", "must have at least %d properties", *s.minProperties)
+	}
+	if s.maxProperties != nil && len(object) > *s.maxProperties {
+		e.fail(pointer, "maxProperties", "must have at most %d properties", *s.maxProperties)
+	}
+
+	for name := range s.properties {
+		if e.declared == nil {
+			e.declared = make(map[string]bool)
+		}
+		e.declared[name] = true
+	}
+
+	names := sortedKeys(object)
+	for _, name := range names {
+		child := pointer + "/" + escape(name)
+		if s.propertyNames != nil {
+			if result := v.eval(s.propertyNames, name, child); !result.valid() {
+				e.fail(child, "propertyNames", "is not an allowed property name")
+			}
+		}
+
+		matched := false
+		if property, ok := s.properties[name]; ok {
+			e.child(v.eval(property, object[name], child))
+			matched = true
+		}
+		for _, p := range s.patternProperties {
+			if p.pattern.MatchString(name) {
+				e.child(v.eval(p.schema, object[name], child))
+				matched = true
+			}
+		}
+		if !matched && s.additionalProperties != nil {
+			if isFalse(s.additionalProperties) {
+				e.fail(child, "additionalProperties", "is not allowed")
+			} else {
+				e.child(v.eval(s.additionalProperties, object[name], child))
+			}
+			matched = true
+		}
+		if matched {
+			e.evaluatedProperty(name)
+		}
+	}
+
+	if s.unevaluatedProperties != nil {
+		for _, name := range names {
+			if e.props[name] {
+				continue
+			}
+			child := pointer + "/" + escape(name)
+			if isFalse(s.unevaluatedProperties) {
+				e.fail(child, "unevaluatedProperties", "is not allowed")
+			} else {
+				e.child(v.eval(s.unevaluatedProperties, object[name], child))
+			}
+			e.evaluatedProperty(name)
+		}
+	}
+}
+
+// isFalse reports whether a schema is the false schema
+func isFalse(s *schema) bool {
+	return s.always != nil && !*s.always
+}
+
+// hasType reports whether a decoded JSON value is of one of the JSON Schema types
+func hasType(value interface{}, types []string) bool {
+	for _, t := range types {
+		switch v := value.(type) {
+		case nil:
+			if t == "null" {
+				return true
+			}
+		case bool:
+			if t == "boolean" {
+				return true
+			}
+		case string:
+			if t == "string" {
+				return true
+			}
+			// A file field holds the ID of an uploaded attachment
+			if _, err := uuid.Parse(v); t == "file" && err == nil {
+				return true
+			}
+		case float64:
+			if t == "number" || (t == "integer" && v == math.Trunc(v)) {
+				return true
+			}
+		case []interface{}:
+			if t == "array" {
+				return true
+			}
+		case map[string]interface{}:
+			if t == "object" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// containsType reports whether a list of type names includes name
+func containsType(types []string, name string) bool {
+	for _, t := range types {
+		if t == name {
+			return true
+		}
+	}
+	return false
+}
+
+// contains reports whether one of the values equals value
+func contains(values []interface{}, value interface{}) bool {
+	for _, v := range values {
+		if equal(v, value) {
+			return true
+		}
+	}
+	return false
+}
+
+// equal compares two decoded JSON values
+func equal(a, b interface{}) bool {
+	ra, errA := json.Marshal(a)
+	rb, errB := json.Marshal(b)
+	return errA == nil && errB == nil && string(ra) == string(rb)
+}
+
+// sortedKeys returns the keys of a map in order, so that problems are reported
+// in a stable order
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,163 @@
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"github.com/tvolodi/ai-bpms-backend/shared/common/config"
+	"github.com/tvolodi/ai-bpms-backend/shared/common/models"
+)
+
+// Job statuses, mirrored from the engine
+const (
+	statusPending   = "pending"
+	statusCompleted = "completed"
+	statusFailed    = "failed"
+)
+
+// Executor runs a due job inside the transaction that holds its lock. An executor
+// may park the job by setting another status; otherwise it is marked completed.
+type Executor interface {
+	ExecuteJob(ctx context.Context, tx *gorm.DB, job *models.Job) error
+}
+
+// Scheduler polls the jobs table and runs due jobs. Each job is locked with
+// SELECT ... FOR UPDATE SKIP LOCKED, after the process instance it belongs to,
+// and executed in the same transaction that marks it completed, so a job fires
+// exactly once across all replicas and pending jobs survive restarts.
+type Scheduler struct {
+	db       *gorm.DB
+	executor Executor
+	cfg      config.SchedulerConfig
+	owner    string
+}
+
+// New creates a new scheduler
+func New(db *gorm.DB, executor Executor, cfg config.SchedulerConfig) *Scheduler {
+	host, err := os.Hostname()
+	if err != nil {
+		host = "unknown"
+	}
+	if cfg.PollInterval <= 0 {
+		cfg.PollInterval = 5 * time.Second
+	}
+	if cfg.BatchSize <= 0 {
+		cfg.BatchSize = 50
+	}
+	if cfg.MaxAttempts <= 0 {
+		cfg.MaxAttempts = 1
+	}
+	return &Scheduler{
+		db:       db,
+		executor: executor,
+		cfg:      cfg,
+		owner:    fmt.Sprintf("%s-%d", host, os.Getpid()),
+	}
+}
+
+// Run polls for due jobs until the context is cancelled
+func (s *Scheduler) Run(ctx context.Context) {
+	logrus.Infof("Job scheduler started (owner %s, poll interval %s)", s.owner, s.cfg.PollInterval)
+
+	ticker := time.NewTicker(s.cfg.PollInterval)
+	defer ticker.Stop()
+
+	for {
+		s.poll(ctx)
+		select {
+		case <-ctx.Done():
+			logrus.Info("Job scheduler stopped")
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// poll runs up to one batch of due jobs
+func (s *Scheduler) poll(ctx context.Context) {
+	for i := 0; i < s.cfg.BatchSize && ctx.Err() == nil; i++ {
+		ran, err := s.runNext(ctx)
+		if err != nil {
+			logrus.Errorf("Job scheduler: %v", err)
+			return
+		}
+		if !ran {
+			return
+		}
+	}
+}
+
+// runNext locks and executes the earliest due job. It reports false when no job is due.
+func (s *Scheduler) runNext(ctx context.Context) (bool, error) {
+	ran := false
+	err := s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		// The engine locks a process instance before its jobs, so the job is
+		// only looked up here and locked once its instance is
+		var due models.Job
+		err := tx.Select("id", "process_instance_id").
+			Where("status = ? AND due_at <= ?", statusPending, time.Now().UTC()).
+			Order("due_at").
+			Limit(1).
+			Take(&due).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		ran = true
+		if due.ProcessInstanceID != nil {
+			err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+				Select("id").
+				Take(&models.ProcessInstance{}, "id = ?", *due.ProcessInstanceID).Error
+			if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+				return err
+			}
+		}
+
+		var job models.Job
+		err = tx.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+			Where("id = ? AND status = ?", due.ID, statusPending).
+			Take(&job).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			// Run by another replica in the meantime
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		job.Attempts++
+		job.LockedBy = s.owner
+
+		// Failed work is rolled back to the savepoint so the attempt can still be recorded
+		execErr := tx.Transaction(func(inner *gorm.DB) error {
+			return s.executor.ExecuteJob(ctx, inner, &job)
+		})
+
+		now := time.Now().UTC()
+		if execErr != nil {
+			job.LastError = execErr.Error()
+			if job.Attempts >= s.cfg.MaxAttempts {
+				job.Status = statusFailed
+				logrus.Errorf("Job %s (%s) failed after %d attempts: %v", job.ID, job.Type, job.Attempts, execErr)
+			} else {
+				job.DueAt = now.Add(s.cfg.RetryBackoff * time.Duration(job.Attempts))
+				logrus.Warnf("Job %s (%s) failed, retrying at %s: %v", job.ID, job.Type, job.DueAt.Format(time.RFC3339), execErr)
+			}
+		} else if job.Status == statusPending {
+			job.Status = statusCompleted
+			job.ExecutedAt = &now
+			job.LastError = ""
+		}
+		return tx.Save(&job).Error
+	})
+	return ran, err
+}
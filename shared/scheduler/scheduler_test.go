@@ -0,0 +1,191 @@
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+
+	"github.com/tvolodi/ai-bpms-backend/shared/common/config"
+	"github.com/tvolodi/ai-bpms-backend/shared/common/models"
+	"github.com/tvolodi/ai-bpms-backend/shared/database/migration"
+)
+
+// testDB connects to the database named by TEST_DATABASE_URL and migrates it.
+// Tests that need a database are skipped when it is not set.
+func testDB(t *testing.T) *gorm.DB {
+	t.Helper()
+	dsn := os.Getenv("TEST_DATABASE_URL")
+	if dsn == "" {
+		t.Skip("TEST_DATABASE_URL is not set")
+	}
+	db, err := gorm.Open(postgres.Open(dsn), &gorm.Config{
+		Logger:         logger.Default.LogMode(logger.Silent),
+		NowFunc:        func() time.Time { return time.Now().UTC() },
+		TranslateError: true,
+	})
+	if err != nil {
+		t.Fatalf("failed to connect to the test database: %v", err)
+	}
+	if err := migration.NewMigrator(db).Run(); err != nil {
+		t.Fatalf("failed to migrate the test database: %v", err)
+	}
+	return db
+}
+
+// recorder is an executor that records the jobs it runs and checks that no two
+// jobs of the same process instance run at the same time
+type recorder struct {
+	mu       sync.Mutex
+	runs     map[uuid.UUID]int
+	running  map[uuid.UUID]bool
+	overlaps int
+	fail     error
+}
+
+func newRecorder() *recorder {
+	return &recorder{runs: make(map[uuid.UUID]int), running: make(map[uuid.UUID]bool)}
+}
+
+func (r *recorder) ExecuteJob(ctx context.Context, tx *gorm.DB, job *models.Job) error {
+	r.mu.Lock()
+	r.runs[job.ID]++
+	if job.ProcessInstanceID != nil {
+		if r.running[*job.ProcessInstanceID] {
+			r.overlaps++
+		}
+		r.running[*job.ProcessInstanceID] = true
+	}
+	r.mu.Unlock()
+
+	time.Sleep(10 * time.Millisecond)
+
+	r.mu.Lock()
+	if job.ProcessInstanceID != nil {
+		r.running[*job.ProcessInstanceID] = false
+	}
+	r.mu.Unlock()
+	return r.fail
+}
+
+// instance creates an active process instance for jobs to belong to
+func instance(t *testing.T, db *gorm.DB) uuid.UUID {
+	t.Helper()
+	key := "test-" + uuid.NewString()
+	def := models.ProcessDefinition{Name: key, Key: key, IsActive: true}
+	if err := db.Create(&def).Error; err != nil {
+		t.Fatalf("failed to create a process definition: %v", err)
+	}
+	inst := models.ProcessInstance{ProcessDefinitionID: def.ID, Status: "active", StartedAt: time.Now().UTC()}
+	if err := db.Omit("ProcessDefinition").Create(&inst).Error; err != nil {
+		t.Fatalf("failed to create a process instance: %v", err)
+	}
+	return inst.ID
+}
+
+// dueJobs creates pending jobs that are already due
+func dueJobs(t *testing.T, db *gorm.DB, instanceID *uuid.UUID, n int) []uuid.UUID {
+	t.Helper()
+	ids := make([]uuid.UUID, n)
+	for i := range ids {
+		job := models.Job{
+			Type:              "timer-catch",
+			Status:            statusPending,
+			DueAt:             time.Now().UTC().Add(-time.Minute),
+			ProcessInstanceID: instanceID,
+		}
+		if err := db.Create(&job).Error; err != nil {
+			t.Fatalf("failed to create a job: %v", err)
+		}
+		ids[i] = job.ID
+	}
+	return ids
+}
+
+func TestSchedulersRunEachJobOnce(t *testing.T) {
+	db := testDB(t)
+	inst := instance(t, db)
+	jobs := append(dueJobs(t, db, nil, 10), dueJobs(t, db, &inst, 10)...)
+
+	exec := newRecorder()
+	var wg sync.WaitGroup
+	for range 4 {
+		s := New(db, exec, config.SchedulerConfig{})
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				ran, err := s.runNext(context.Background())
+				if err != nil {
+					t.Errorf("unexpected error: %v", err)
+					return
+				}
+				if !ran {
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	for _, id := range jobs {
+		if n := exec.runs[id]; n != 1 {
+			t.Errorf("job %s ran %d times, want once", id, n)
+		}
+		var job models.Job
+		if err := db.First(&job, "id = ?", id).Error; err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if job.Status != statusCompleted || job.Attempts != 1 || job.ExecutedAt == nil {
+			t.Errorf("job %s is %s after %d attempts, want completed after one", id, job.Status, job.Attempts)
+		}
+	}
+	if exec.overlaps > 0 {
+		t.Errorf("jobs of the same instance ran concurrently %d times", exec.overlaps)
+	}
+}
+
+func TestSchedulerRetriesFailedJobs(t *testing.T) {
+	db := testDB(t)
+	exec := newRecorder()
+	exec.fail = errors.New("mail server unavailable")
+	s := New(db, exec, config.SchedulerConfig{MaxAttempts: 2})
+	id := dueJobs(t, db, nil, 1)[0]
+
+	tests := []struct {
+		wantStatus   string
+		wantAttempts int
+	}{
+		{statusPending, 1},
+		{statusFailed, 2},
+	}
+	for _, tt := range tests {
+		// Make the job due again whatever the backoff
+		if err := db.Model(&models.Job{}).Where("id = ?", id).Update("due_at", time.Now().UTC().Add(-time.Minute)).Error; err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		for {
+			ran, err := s.runNext(context.Background())
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			var job models.Job
+			if err := db.First(&job, "id = ?", id).Error; err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if job.Attempts == tt.wantAttempts || !ran {
+				if job.Status != tt.wantStatus || job.Attempts != tt.wantAttempts || job.LastError != exec.fail.Error() {
+					t.Errorf("got %s after %d attempts with %q, want %s after %d", job.Status, job.Attempts, job.LastError, tt.wantStatus, tt.wantAttempts)
+				}
+				break
+			}
+		}
+	}
+}
@@ -0,0 +1,69 @@
+package sla
+
+import (
+	"context"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/tvolodi/ai-bpms-backend/shared/common/config"
+)
+
+// Evaluator escalates the open tasks whose service level is at risk or breached
+type Evaluator interface {
+	EvaluateSLAs(ctx context.Context, now time.Time, limit int) (int, error)
+}
+
+// Monitor periodically checks the due dates of open tasks and escalates those
+// that have become at risk or overdue. Escalated tasks record their SLA status,
+// so every level is escalated once across all replicas.
+type Monitor struct {
+	evaluator Evaluator
+	cfg       config.SLAConfig
+}
+
+// New creates a new SLA monitor
+func New(evaluator Evaluator, cfg config.SLAConfig) *Monitor {
+	if cfg.PollInterval <= 0 {
+		cfg.PollInterval = time.Minute
+	}
+	if cfg.BatchSize <= 0 {
+		cfg.BatchSize = 100
+	}
+	return &Monitor{evaluator: evaluator, cfg: cfg}
+}
+
+// Run checks task due dates until the context is cancelled
+func (m *Monitor) Run(ctx context.Context) {
+	logrus.Infof("SLA monitor started (poll interval %s)", m.cfg.PollInterval)
+
+	ticker := time.NewTicker(m.cfg.PollInterval)
+	defer ticker.Stop()
+
+	for {
+		m.poll(ctx)
+		select {
+		case <-ctx.Done():
+			logrus.Info("SLA monitor stopped")
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// poll escalates due tasks batch by batch until none are left
+func (m *Monitor) poll(ctx context.Context) {
+	for ctx.Err() == nil {
+		count, err := m.evaluator.EvaluateSLAs(ctx, time.Now().UTC(), m.cfg.BatchSize)
+		if err != nil {
+			logrus.Errorf("SLA monitor: %v", err)
+			return
+		}
+		if count > 0 {
+			logrus.Infof("SLA monitor escalated %d tasks", count)
+		}
+		if count < m.cfg.BatchSize {
+			return
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLocalPath(t *testing.T) {
+	dir := t.TempDir()
+	l, err := NewLocal(dir)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	tests := []struct {
+		key     string
+		want    string // relative to the storage directory, empty when the key is rejected
+		wantErr bool
+	}{
+		{key: "attachments/a.pdf", want: "attachments/a.pdf"},
+		{key: "attachments/../b.pdf", want: "b.pdf"},
+		{key: "./c.pdf", want: "c.pdf"},
+		{key: "", wantErr: true},
+		{key: "..", wantErr: true},
+		{key: "../secret", wantErr: true},
+		{key: "attachments/../../secret", wantErr: true},
+		{key: "/etc/passwd", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		got, err := l.path(tt.key)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("path(%q) = %q, want an error", tt.key, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("path(%q): unexpected error: %v", tt.key, err)
+			continue
+		}
+		if want := filepath.Join(dir, filepath.FromSlash(tt.want)); got != want {
+			t.Errorf("path(%q) = %q, want %q", tt.key, got, want)
+		}
+	}
+}
+
+func TestLocal(t *testing.T) {
+	l, err := NewLocal(t.TempDir())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	ctx := context.Background()
+
+	content := "signed contract"
+	if err := l.Put(ctx, "tasks/1/contract.txt", strings.NewReader(content), int64(len(content)), "text/plain"); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	r, err := l.Get(ctx, "tasks/1/contract.txt")
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	data, err := io.ReadAll(r)
+	r.Close()
+	if err != nil || string(data) != content {
+		t.Errorf("got %q, %v, want %q", data, err, content)
+	}
+
+	if err := l.Delete(ctx, "tasks/1/contract.txt"); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if _, err := l.Get(ctx, "tasks/1/contract.txt"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("got %v after deleting, want %v", err, ErrNotFound)
+	}
+	if err := l.Delete(ctx, "tasks/1/contract.txt"); err != nil {
+		t.Errorf("deleting a missing object: %v", err)
+	}
+	if err := l.Put(ctx, "../outside.txt", strings.NewReader(content), int64(len(content)), "text/plain"); err == nil {
+		t.Error("Put outside the storage directory succeeded")
+	}
+}
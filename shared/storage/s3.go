@@ -0,0 +1,363 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tvolodi/ai-bpms-backend/shared/common/config"
+)
+
+// partSize is the size of the parts an upload of unknown or large size is sent
+// in. S3 requires every part but the last to be at least 5 MiB.
+const partSize = 8 << 20
+
+// unsignedPayload is the payload hash of requests whose body is streamed unsigned
+const unsignedPayload = "UNSIGNED-PAYLOAD"
+
+// S3 stores objects in a bucket of an S3-compatible object store. Requests are
+// signed with AWS Signature Version 4; objects larger than one part are sent
+// as multipart uploads, so memory use stays bounded by the part size.
+type S3 struct {
+	cfg      config.S3Config
+	endpoint *url.URL
+	client   *http.Client
+}
+
+// NewS3 creates a storage in the configured bucket
+func NewS3(cfg config.S3Config) (*S3, error) {
+	if cfg.Endpoint == "" || cfg.Bucket == "" {
+		return nil, errors.New("S3 storage needs an endpoint and a bucket")
+	}
+	endpoint, err := url.Parse(cfg.Endpoint)
+	if err != nil || endpoint.Host == "" {
+		return nil, fmt.Errorf("invalid S3 endpoint %q", cfg.Endpoint)
+	}
+	if cfg.Region == "" {
+		cfg.Region = "us-east-1"
+	}
+	return &S3{cfg: cfg, endpoint: endpoint, client: &http.Client{}}, nil
+}
+
+// objectURL returns the URL of an object, in path style or virtual-hosted style
+func (s *S3) objectURL(key string, query url.Values) *url.URL {
+	u := *s.endpoint
+	base := strings.TrimSuffix(u.Path, "/")
+	if s.cfg.UsePathStyle {
+		u.Path = base + "/" + s.cfg.Bucket + "/" + key
+	} else {
+		u.Host = s.cfg.Bucket + "." + u.Host
+		u.Path = base + "/" + key
+	}
+	u.RawPath = ""
+	u.RawQuery = canonicalQuery(query)
+	return &u
+}
+
+// Put uploads content in a single request when it fits in one part and as a
+// multipart upload otherwise
+func (s *S3) Put(ctx context.Context, key string, content io.Reader, size int64, contentType string) error {
+	content = contextReader{ctx: ctx, r: content}
+	if size >= 0 && size <= partSize {
+		return s.putObject(ctx, key, content, size, contentType)
+	}
+
+	first := make([]byte, partSize)
+	n, err := io.ReadFull(content, first)
+	if errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, io.EOF) {
+		return s.putObject(ctx, key, bytes.NewReader(first[:n]), int64(n), contentType)
+	}
+	if err != nil {
+		return err
+	}
+	return s.putMultipart(ctx, key, io.MultiReader(bytes.NewReader(first), content), contentType)
+}
+
+// putObject uploads an object of known size in one request
+func (s *S3) putObject(ctx context.Context, key string, content io.Reader, size int64, contentType string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, s.objectURL(key, nil).String(), content)
+	if err != nil {
+		return err
+	}
+	req.ContentLength = size
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	resp, err := s.do(req, unsignedPayload)
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+// completedPart lists an uploaded part when completing a multipart upload
+type completedPart struct {
+	PartNumber int    `xml:"PartNumber"`
+	ETag       string `xml:"ETag"`
+}
+
+// putMultipart streams content part by part and aborts the upload if any part fails
+func (s *S3) putMultipart(ctx context.Context, key string, content io.Reader, contentType string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.objectURL(key, url.Values{"uploads": {""}}).String(), nil)
+	if err != nil {
+		return err
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	resp, err := s.do(req, emptyHash)
+	if err != nil {
+		return err
+	}
+	var initiated struct {
+		UploadID string `xml:"UploadId"`
+	}
+	err = xml.NewDecoder(resp.Body).Decode(&initiated)
+	resp.Body.Close()
+	if err != nil {
+		return fmt.Errorf("failed to start multipart upload: %w", err)
+	}
+
+	parts, err := s.uploadParts(ctx, key, initiated.UploadID, content)
+	if err == nil {
+		err = s.completeMultipart(ctx, key, initiated.UploadID, parts)
+	}
+	if err != nil {
+		abort, abortErr := http.NewRequestWithContext(context.Background(), http.MethodDelete,
+			s.objectURL(key, url.Values{"uploadId": {initiated.UploadID}}).String(), nil)
+		if abortErr == nil {
+			if resp, abortErr := s.do(abort, emptyHash); abortErr == nil {
+				resp.Body.Close()
+			}
+		}
+		return err
+	}
+	return nil
+}
+
+// uploadParts sends content as consecutive parts of a multipart upload
+func (s *S3) uploadParts(ctx context.Context, key, uploadID string, content io.Reader) ([]completedPart, error) {
+	var parts []completedPart
+	buf := make([]byte, partSize)
+	for number := 1; ; number++ {
+		n, err := io.ReadFull(content, buf)
+		if errors.Is(err, io.EOF) && number > 1 {
+			return parts, nil
+		}
+		if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, io.EOF) {
+			return nil, err
+		}
+
+		query := url.Values{"partNumber": {strconv.Itoa(number)}, "uploadId": {uploadID}}
+		req, reqErr := http.NewRequestWithContext(ctx, http.MethodPut, s.objectURL(key, query).String(), bytes.NewReader(buf[:n]))
+		if reqErr != nil {
+			return nil, reqErr
+		}
+		req.ContentLength = int64(n)
+		resp, reqErr := s.do(req, unsignedPayload)
+		if reqErr != nil {
+			return nil, reqErr
+		}
+		resp.Body.Close()
+		parts = append(parts, completedPart{PartNumber: number, ETag: resp.Header.Get("ETag")})
+
+		if err != nil {
+			return parts, nil
+		}
+	}
+}
+
+// completeMultipart assembles the uploaded parts into the object
+func (s *S3) completeMultipart(ctx context.Context, key, uploadID string, parts []completedPart) error {
+	body, err := xml.Marshal(struct {
+		XMLName xml.Name        `xml:"CompleteMultipartUpload"`
+		Parts   []completedPart `xml:"Part"`
+	}{Parts: parts})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost,
+		s.objectURL(key, url.Values{"uploadId": {uploadID}}).String(), bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.ContentLength = int64(len(body))
+	resp, err := s.do(req, hashHex(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	// A failed completion may be reported with a 200 status and an error body
+	raw, err := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	if err != nil {
+		return err
+	}
+	if bytes.Contains(raw, []byte("<Error>")) {
+		return fmt.Errorf("failed to complete multipart upload: %s", raw)
+	}
+	return nil
+}
+
+// Get downloads an object
+func (s *S3) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.objectURL(key, nil).String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := s.do(req, emptyHash)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+// Delete removes an object
+func (s *S3) Delete(ctx context.Context, key string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodDelete, s.objectURL(key, nil).String(), nil)
+	if err != nil {
+		return err
+	}
+	resp, err := s.do(req, emptyHash)
+	if errors.Is(err, ErrNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+// PresignGet returns a URL that downloads an object as fileName until it expires
+func (s *S3) PresignGet(key, fileName string, expires time.Duration) (string, error) {
+	now := time.Now().UTC()
+	query := url.Values{
+		"X-Amz-Algorithm":     {"AWS4-HMAC-SHA256"},
+		"X-Amz-Credential":    {s.cfg.AccessKeyID + "/" + s.scope(now)},
+		"X-Amz-Date":          {now.Format("20060102T150405Z")},
+		"X-Amz-Expires":       {strconv.Itoa(int(expires.Seconds()))},
+		"X-Amz-SignedHeaders": {"host"},
+	}
+	if fileName != "" {
+		query.Set("response-content-disposition", mime.FormatMediaType("attachment", map[string]string{"filename": fileName}))
+	}
+	u := s.objectURL(key, query)
+
+	canonical := strings.Join([]string{
+		http.MethodGet,
+		u.EscapedPath(),
+		u.RawQuery,
+		"host:" + u.Host + "\n",
+		"host",
+		unsignedPayload,
+	}, "\n")
+	signature := s.signature(now, canonical)
+	u.RawQuery += "&X-Amz-Signature=" + signature
+	return u.String(), nil
+}
+
+// do signs and sends a request. Responses other than 2xx are turned into
+// errors, 404 into ErrNotFound.
+func (s *S3) do(req *http.Request, payloadHash string) (*http.Response, error) {
+	now := time.Now().UTC()
+	req.Header.Set("X-Amz-Date", now.Format("20060102T150405Z"))
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	canonical := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.RawQuery,
+		"host:" + req.URL.Host + "\n" +
+			"x-amz-content-sha256:" + payloadHash + "\n" +
+			"x-amz-date:" + req.Header.Get("X-Amz-Date") + "\n",
+		"host;x-amz-content-sha256;x-amz-date",
+		payloadHash,
+	}, "\n")
+	req.Header.Set("Authorization", fmt.Sprintf(
+		"AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=host;x-amz-content-sha256;x-amz-date, Signature=%s",
+		s.cfg.AccessKeyID, s.scope(now), s.signature(now, canonical)))
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return resp, nil
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, ErrNotFound
+	}
+	detail, _ := io.ReadAll(io.LimitReader(resp.Body, 4<<10))
+	return nil, fmt.Errorf("S3 %s %s: %s: %s", req.Method, req.URL.Path, resp.Status, bytes.TrimSpace(detail))
+}
+
+// scope is the credential scope of requests signed at t
+func (s *S3) scope(t time.Time) string {
+	return t.Format("20060102") + "/" + s.cfg.Region + "/s3/aws4_request"
+}
+
+// signature signs a canonical request made at t
+func (s *S3) signature(t time.Time, canonical string) string {
+	stringToSign := strings.Join([]string{
+		"AWS4-HMAC-SHA256",
+		t.Format("20060102T150405Z"),
+		s.scope(t),
+		hashHex([]byte(canonical)),
+	}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+s.cfg.SecretAccessKey), t.Format("20060102"))
+	key = hmacSHA256(key, s.cfg.Region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	return hex.EncodeToString(hmacSHA256(key, stringToSign))
+}
+
+// emptyHash is the SHA-256 of an empty payload
+var emptyHash = hashHex(nil)
+
+func hashHex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// canonicalQuery encodes query parameters sorted by name, with spaces as %20
+// as Signature Version 4 requires
+func canonicalQuery(query url.Values) string {
+	names := make([]string, 0, len(query))
+	for name := range query {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var pairs []string
+	for _, name := range names {
+		for _, value := range query[name] {
+			pairs = append(pairs, uriEscape(name)+"="+uriEscape(value))
+		}
+	}
+	return strings.Join(pairs, "&")
+}
+
+func uriEscape(s string) string {
+	return strings.ReplaceAll(url.QueryEscape(s), "+", "%20")
+}
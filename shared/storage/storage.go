@@ -0,0 +1,60 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/tvolodi/ai-bpms-backend/shared/common/config"
+)
+
+// ErrNotFound is returned when no object is stored under a key
+var ErrNotFound = errors.New("object not found")
+
+// Storage keeps the content of attachments under opaque keys. Content is
+// streamed in and out; implementations never hold a whole object in memory.
+type Storage interface {
+	// Put stores content under key. size is -1 when it is not known up front.
+	// When reading content fails the object is not stored.
+	Put(ctx context.Context, key string, content io.Reader, size int64, contentType string) error
+
+	// Get opens the object stored under key
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+
+	// Delete removes the object stored under key; a missing object is not an error
+	Delete(ctx context.Context, key string) error
+}
+
+// Presigner is implemented by storages that can hand out time-limited URLs
+// from which a client downloads an object directly
+type Presigner interface {
+	PresignGet(key, fileName string, expires time.Duration) (string, error)
+}
+
+// New creates the storage selected in the configuration
+func New(cfg config.StorageConfig) (Storage, error) {
+	switch cfg.Backend {
+	case "", "local":
+		return NewLocal(cfg.LocalPath)
+	case "s3":
+		return NewS3(cfg.S3)
+	default:
+		return nil, fmt.Errorf("unknown storage backend %q", cfg.Backend)
+	}
+}
+
+// contextReader stops reading once its context is cancelled, so an abandoned
+// upload does not keep writing
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (c contextReader) Read(p []byte) (int, error) {
+	if err := c.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return c.r.Read(p)
+}
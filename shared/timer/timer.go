@@ -0,0 +1,218 @@
+package timer
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/robfig/cron/v3"
+)
+
+// Duration is an ISO 8601 duration such as P3DT4H30M. Calendar components
+// (years, months, days) are kept apart from the clock part so that adding a
+// duration respects month lengths and daylight saving changes.
+type Duration struct {
+	Years  int
+	Months int
+	Days   int
+	Clock  time.Duration
+}
+
+var durationPattern = regexp.MustCompile(`^P(?:(\d+)Y)?(?:(\d+)M)?(?:(\d+)W)?(?:(\d+)D)?(?:T(?:(\d+)H)?(?:(\d+)M)?(?:(\d+(?:\.\d+)?)S)?)?$`)
+
+// ParseDuration parses an ISO 8601 duration
+func ParseDuration(s string) (Duration, error) {
+	s = strings.ToUpper(strings.TrimSpace(s))
+	m := durationPattern.FindStringSubmatch(s)
+	if m == nil || s == "P" || strings.HasSuffix(s, "T") {
+		return Duration{}, fmt.Errorf("invalid ISO 8601 duration %q", s)
+	}
+
+	num := func(v string) int {
+		n, _ := strconv.Atoi(v)
+		return n
+	}
+	d := Duration{
+		Years:  num(m[1]),
+		Months: num(m[2]),
+		Days:   num(m[3])*7 + num(m[4]),
+		Clock:  time.Duration(num(m[5]))*time.Hour + time.Duration(num(m[6]))*time.Minute,
+	}
+	if m[7] != "" {
+		seconds, _ := strconv.ParseFloat(m[7], 64)
+		d.Clock += time.Duration(seconds * float64(time.Second))
+	}
+	return d, nil
+}
+
+// AddTo returns t moved forward by the duration
+func (d Duration) AddTo(t time.Time) time.Time {
+	return t.AddDate(d.Years, d.Months, d.Days).Add(d.Clock)
+}
+
+// SubtractFrom returns t moved back by the duration
+func (d Duration) SubtractFrom(t time.Time) time.Time {
+	return t.Add(-d.Clock).AddDate(-d.Years, -d.Months, -d.Days)
+}
+
+// IsZero reports whether the duration is empty
+func (d Duration) IsZero() bool {
+	return d.Years == 0 && d.Months == 0 && d.Days == 0 && d.Clock == 0
+}
+
+// ParseDate parses an ISO 8601 date-time
+func ParseDate(s string) (time.Time, error) {
+	t, err := time.Parse(time.RFC3339, strings.TrimSpace(s))
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid ISO 8601 date %q", s)
+	}
+	return t, nil
+}
+
+// Cycle is a recurring timer, given either as an ISO 8601 repeating interval
+// (R5/PT10M, R/2025-01-01T09:00:00Z/P1D) or as a standard cron expression.
+type Cycle struct {
+	// Repetitions left; -1 repeats forever
+	Repetitions int
+	Start       *time.Time
+	Interval    Duration
+
+	cron cron.Schedule
+	spec string
+}
+
+var cronParser = cron.NewParser(cron.SecondOptional | cron.Minute | cron.Hour | cron.Dom | cron.Month | cron.Dow | cron.Descriptor)
+
+// ParseCycle parses a timer cycle
+func ParseCycle(s string) (*Cycle, error) {
+	s = strings.TrimSpace(s)
+	if !strings.HasPrefix(strings.ToUpper(s), "R") || !strings.Contains(s, "/") {
+		schedule, err := cronParser.Parse(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid timer cycle %q: %w", s, err)
+		}
+		return &Cycle{Repetitions: -1, cron: schedule, spec: s}, nil
+	}
+
+	parts := strings.Split(s, "/")
+	if len(parts) < 2 || len(parts) > 3 {
+		return nil, fmt.Errorf("invalid repeating interval %q", s)
+	}
+
+	c := &Cycle{Repetitions: -1}
+	if count := parts[0][1:]; count != "" {
+		n, err := strconv.Atoi(count)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("invalid repetition count in %q", s)
+		}
+		c.Repetitions = n
+	}
+	if len(parts) == 3 {
+		start, err := ParseDate(parts[1])
+		if err != nil {
+			return nil, err
+		}
+		c.Start = &start
+	}
+	interval, err := ParseDuration(parts[len(parts)-1])
+	if err != nil {
+		return nil, err
+	}
+	if interval.IsZero() {
+		return nil, fmt.Errorf("repeating interval %q has an empty period", s)
+	}
+	c.Interval = interval
+	return c, nil
+}
+
+// Done reports whether no repetitions are left
+func (c *Cycle) Done() bool {
+	return c.Repetitions == 0
+}
+
+// Next returns the first occurrence strictly after the given time. The
+// occurrences of a cycle with a start date are anchored to it, so they fall on
+// the start plus a whole number of intervals however late the cycle fires;
+// without a start date the next occurrence is one interval after the given time.
+func (c *Cycle) Next(after time.Time) time.Time {
+	if c.cron != nil {
+		return c.cron.Next(after)
+	}
+	if c.Start == nil {
+		return c.Interval.AddTo(after)
+	}
+	if c.Start.After(after) {
+		return *c.Start
+	}
+
+	k := 1
+	if c.Interval.Years == 0 && c.Interval.Months == 0 && c.Interval.Days == 0 {
+		k = int(after.Sub(*c.Start) / c.Interval.Clock)
+	}
+	for !c.occurrence(k).After(after) {
+		k++
+	}
+	return c.occurrence(k)
+}
+
+// occurrence returns the start moved forward by k intervals. It is computed
+// from the start rather than from the previous occurrence so that months of
+// different lengths do not shift the day.
+func (c *Cycle) occurrence(k int) time.Time {
+	d := c.Interval
+	return c.Start.AddDate(k*d.Years, k*d.Months, k*d.Days).Add(time.Duration(k) * d.Clock)
+}
+
+// Fired returns the cycle that remains after one occurrence has fired,
+// encoded in the same notation it was parsed from. The start date is kept so
+// later occurrences stay anchored to it. A cycle with no repetitions left stays
+// at R0 rather than turning into an unbounded one.
+func (c *Cycle) Fired() string {
+	if c.cron != nil {
+		return c.spec
+	}
+	repetitions := ""
+	if c.Repetitions >= 0 {
+		repetitions = strconv.Itoa(max(c.Repetitions-1, 0))
+	}
+	if c.Start != nil {
+		return "R" + repetitions + "/" + c.Start.Format(time.RFC3339) + "/" + c.Interval.String()
+	}
+	return "R" + repetitions + "/" + c.Interval.String()
+}
+
+// String formats the duration in ISO 8601 notation
+func (d Duration) String() string {
+	var b strings.Builder
+	b.WriteString("P")
+	if d.Years > 0 {
+		fmt.Fprintf(&b, "%dY", d.Years)
+	}
+	if d.Months > 0 {
+		fmt.Fprintf(&b, "%dM", d.Months)
+	}
+	if d.Days > 0 {
+		fmt.Fprintf(&b, "%dD", d.Days)
+	}
+	if d.Clock > 0 {
+		b.WriteString("T")
+		clock := d.Clock
+		if h := clock / time.Hour; h > 0 {
+			fmt.Fprintf(&b, "%dH", h)
+			clock -= h * time.Hour
+		}
+		if m := clock / time.Minute; m > 0 {
+			fmt.Fprintf(&b, "%dM", m)
+			clock -= m * time.Minute
+		}
+		if clock > 0 {
+			b.WriteString(strconv.FormatFloat(clock.Seconds(), 'f', -1, 64) + "S")
+		}
+	}
+	if b.Len() == 1 {
+		b.WriteString("T0S")
+	}
+	return b.String()
+}
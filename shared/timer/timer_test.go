@@ -0,0 +1,288 @@
+package timer
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseDuration(t *testing.T) {
+	tests := []struct {
+		source  string
+		want    Duration
+		wantErr bool
+	}{
+		{source: "PT10M", want: Duration{Clock: 10 * time.Minute}},
+		{source: "P3DT4H30M", want: Duration{Days: 3, Clock: 4*time.Hour + 30*time.Minute}},
+		{source: "P1Y2M", want: Duration{Years: 1, Months: 2}},
+		{source: "P2W", want: Duration{Days: 14}},
+		{source: "P1W2D", want: Duration{Days: 9}},
+		{source: "PT1.5S", want: Duration{Clock: 1500 * time.Millisecond}},
+		{source: " pt1h ", want: Duration{Clock: time.Hour}},
+		{source: "P", wantErr: true},
+		{source: "P1DT", wantErr: true},
+		{source: "PT1H30", wantErr: true},
+		{source: "10m", wantErr: true},
+		{source: "", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		got, err := ParseDuration(tt.source)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("ParseDuration(%q) = %+v, want an error", tt.source, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("ParseDuration(%q): unexpected error: %v", tt.source, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("ParseDuration(%q) = %+v, want %+v", tt.source, got, tt.want)
+		}
+	}
+}
+
+func TestDurationString(t *testing.T) {
+	tests := []struct {
+		duration Duration
+		want     string
+	}{
+		{Duration{Clock: 10 * time.Minute}, "PT10M"},
+		{Duration{Years: 1, Months: 2, Days: 3, Clock: 4*time.Hour + 5*time.Minute + 6*time.Second}, "P1Y2M3DT4H5M6S"},
+		{Duration{Clock: 1500 * time.Millisecond}, "PT1.5S"},
+		{Duration{}, "PT0S"},
+	}
+
+	for _, tt := range tests {
+		if got := tt.duration.String(); got != tt.want {
+			t.Errorf("%+v.String() = %q, want %q", tt.duration, got, tt.want)
+		}
+	}
+}
+
+func TestDurationAddTo(t *testing.T) {
+	base := time.Date(2025, time.January, 31, 9, 0, 0, 0, time.UTC)
+	tests := []struct {
+		source string
+		want   time.Time
+	}{
+		{"PT90M", time.Date(2025, time.January, 31, 10, 30, 0, 0, time.UTC)},
+		{"P1D", time.Date(2025, time.February, 1, 9, 0, 0, 0, time.UTC)},
+		{"P1M", time.Date(2025, time.March, 3, 9, 0, 0, 0, time.UTC)},
+		{"P1Y", time.Date(2026, time.January, 31, 9, 0, 0, 0, time.UTC)},
+	}
+
+	for _, tt := range tests {
+		d, err := ParseDuration(tt.source)
+		if err != nil {
+			t.Fatalf("ParseDuration(%q): %v", tt.source, err)
+		}
+		if got := d.AddTo(base); !got.Equal(tt.want) {
+			t.Errorf("%s after %s = %s, want %s", tt.source, base, got, tt.want)
+		}
+	}
+}
+
+func TestDurationSubtractFrom(t *testing.T) {
+	base := time.Date(2025, time.March, 1, 9, 0, 0, 0, time.UTC)
+	tests := []struct {
+		source string
+		want   time.Time
+	}{
+		{"PT90M", time.Date(2025, time.March, 1, 7, 30, 0, 0, time.UTC)},
+		{"P1D", time.Date(2025, time.February, 28, 9, 0, 0, 0, time.UTC)},
+		{"P1DT10H", time.Date(2025, time.February, 27, 23, 0, 0, 0, time.UTC)},
+		{"P1M", time.Date(2025, time.February, 1, 9, 0, 0, 0, time.UTC)},
+	}
+
+	for _, tt := range tests {
+		d, err := ParseDuration(tt.source)
+		if err != nil {
+			t.Fatalf("ParseDuration(%q): %v", tt.source, err)
+		}
+		if got := d.SubtractFrom(base); !got.Equal(tt.want) {
+			t.Errorf("%s before %s = %s, want %s", tt.source, base, got, tt.want)
+		}
+	}
+}
+
+func TestParseDate(t *testing.T) {
+	tests := []struct {
+		source  string
+		want    time.Time
+		wantErr bool
+	}{
+		{source: "2025-03-01T09:30:00Z", want: time.Date(2025, time.March, 1, 9, 30, 0, 0, time.UTC)},
+		{source: "2025-03-01T09:30:00+02:00", want: time.Date(2025, time.March, 1, 7, 30, 0, 0, time.UTC)},
+		{source: "2025-03-01", wantErr: true},
+		{source: "tomorrow", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		got, err := ParseDate(tt.source)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("ParseDate(%q) = %s, want an error", tt.source, got)
+			}
+			continue
+		}
+		if err != nil || !got.Equal(tt.want) {
+			t.Errorf("ParseDate(%q) = %s, %v, want %s", tt.source, got, err, tt.want)
+		}
+	}
+}
+
+func TestParseCycle(t *testing.T) {
+	after := time.Date(2025, time.January, 1, 8, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name            string
+		source          string
+		wantRepetitions int
+		wantNext        time.Time
+		wantErr         string
+	}{
+		{
+			name:            "bounded interval",
+			source:          "R5/PT10M",
+			wantRepetitions: 5,
+			wantNext:        time.Date(2025, time.January, 1, 8, 10, 0, 0, time.UTC),
+		},
+		{
+			name:            "unbounded interval",
+			source:          "R/P1D",
+			wantRepetitions: -1,
+			wantNext:        time.Date(2025, time.January, 2, 8, 0, 0, 0, time.UTC),
+		},
+		{
+			name:            "interval with a future start",
+			source:          "R3/2025-01-01T09:00:00Z/PT1H",
+			wantRepetitions: 3,
+			wantNext:        time.Date(2025, time.January, 1, 9, 0, 0, 0, time.UTC),
+		},
+		{
+			name:            "interval with a past start",
+			source:          "R3/2024-12-31T08:30:00Z/PT1H",
+			wantRepetitions: 3,
+			wantNext:        time.Date(2025, time.January, 1, 8, 30, 0, 0, time.UTC),
+		},
+		{
+			name:            "exhausted interval",
+			source:          "R0/PT1H",
+			wantRepetitions: 0,
+			wantNext:        time.Date(2025, time.January, 1, 9, 0, 0, 0, time.UTC),
+		},
+		{
+			name:            "cron expression",
+			source:          "0 9 * * MON-FRI",
+			wantRepetitions: -1,
+			wantNext:        time.Date(2025, time.January, 1, 9, 0, 0, 0, time.UTC),
+		},
+		{
+			name:            "cron descriptor",
+			source:          "@daily",
+			wantRepetitions: -1,
+			wantNext:        time.Date(2025, time.January, 2, 0, 0, 0, 0, time.UTC),
+		},
+		{name: "negative count", source: "R-1/PT1H", wantErr: "invalid repetition count"},
+		{name: "too many parts", source: "R1/2025-01-01T09:00:00Z/PT1H/PT1H", wantErr: "invalid repeating interval"},
+		{name: "empty period", source: "R1/PT0S", wantErr: "has an empty period"},
+		{name: "invalid start", source: "R1/yesterday/PT1H", wantErr: "invalid ISO 8601 date"},
+		{name: "invalid cron", source: "every monday", wantErr: "invalid timer cycle"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := ParseCycle(tt.source)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("got %v, want an error containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if c.Repetitions != tt.wantRepetitions {
+				t.Errorf("got %d repetitions, want %d", c.Repetitions, tt.wantRepetitions)
+			}
+			if got := c.Next(after); !got.Equal(tt.wantNext) {
+				t.Errorf("got next occurrence %s, want %s", got, tt.wantNext)
+			}
+		})
+	}
+}
+
+func TestCycleFired(t *testing.T) {
+	tests := []struct {
+		source   string
+		want     string
+		wantDone bool // whether the remaining cycle has no repetitions left
+	}{
+		{source: "R3/PT10M", want: "R2/PT10M"},
+		{source: "R1/PT10M", want: "R0/PT10M", wantDone: true},
+		{source: "R0/PT10M", want: "R0/PT10M", wantDone: true},
+		{source: "R/P1D", want: "R/P1D"},
+		{source: "R2/2025-01-01T09:00:00Z/PT1H", want: "R1/2025-01-01T09:00:00Z/PT1H"},
+		{source: "R/2025-01-01T12:00:00+06:00/P1D", want: "R/2025-01-01T12:00:00+06:00/P1D"},
+		{source: "0 9 * * *", want: "0 9 * * *"},
+	}
+
+	for _, tt := range tests {
+		c, err := ParseCycle(tt.source)
+		if err != nil {
+			t.Fatalf("ParseCycle(%q): %v", tt.source, err)
+		}
+		got := c.Fired()
+		if got != tt.want {
+			t.Errorf("%q fired = %q, want %q", tt.source, got, tt.want)
+		}
+
+		rest, err := ParseCycle(got)
+		if err != nil {
+			t.Fatalf("ParseCycle(%q): %v", got, err)
+		}
+		if rest.Done() != tt.wantDone {
+			t.Errorf("%q done = %v, want %v", got, rest.Done(), tt.wantDone)
+		}
+	}
+}
+
+func TestCycleLateFiring(t *testing.T) {
+	// The scheduler fired the 10:00 occurrence of an hourly cycle at 10:20
+	due := time.Date(2025, time.January, 1, 10, 0, 0, 0, time.UTC)
+	fired := time.Date(2025, time.January, 1, 10, 20, 0, 0, time.UTC)
+	tests := []struct {
+		name   string
+		source string
+		after  time.Time
+		want   time.Time
+	}{
+		{name: "from the due time", source: "R5/2025-01-01T09:00:00Z/PT1H", after: due, want: time.Date(2025, time.January, 1, 11, 0, 0, 0, time.UTC)},
+		{name: "from the firing time", source: "R5/2025-01-01T09:00:00Z/PT1H", after: fired, want: time.Date(2025, time.January, 1, 11, 0, 0, 0, time.UTC)},
+		{name: "unanchored from the due time", source: "R5/PT1H", after: due, want: time.Date(2025, time.January, 1, 11, 0, 0, 0, time.UTC)},
+		{
+			name:   "monthly from the end of a month",
+			source: "R/2025-01-31T09:00:00Z/P1M",
+			after:  time.Date(2025, time.March, 3, 9, 5, 0, 0, time.UTC),
+			want:   time.Date(2025, time.March, 31, 9, 0, 0, 0, time.UTC),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := ParseCycle(tt.source)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			rest, err := ParseCycle(c.Fired())
+			if err != nil {
+				t.Fatalf("ParseCycle(%q): %v", c.Fired(), err)
+			}
+			if got := rest.Next(tt.after); !got.Equal(tt.want) {
+				t.Errorf("next after %s = %s, want %s", tt.after, got, tt.want)
+			}
+		})
+	}
+}